@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/desired"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/schema"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// ageHeader starts every age file, telling an encrypted desired-state
+// file from plain YAML
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+// handleApply brings the vault to the state a file describes: its
+// environments, recipients and schema. Existing ciphertext is re-encrypted
+// when the recipients change.
+func handleApply() {
+	fs := newFlagSet("apply", "envault apply -f <desired.yaml|-> [--prune] [--dry-run]")
+	file := fs.String("f", "", "desired-state file, plain YAML or encrypted to the vault's keys (- for stdin)")
+	prune := fs.Bool("prune", false, "remove environments the file does not list from config.yaml (their ciphertext is kept)")
+	dryRun := dryRunFlag(fs)
+	args := parseFlags(fs, os.Args[2:])
+
+	if *file == "" || len(args) > 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	state := readDesiredState(*file)
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	// recipients: manages authorized_keys; key_sources are layered on top
+	current, err := keys.LoadFile(rootKeysPath(""))
+	if err != nil {
+		fatal("Failed to load keys: %v", err)
+	}
+	var currentSchema *schema.Schema
+	if state.Schema != nil {
+		if currentSchema, err = schema.Load(); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	plan, err := state.Plan(cfg, current, currentSchema, *prune)
+	if err != nil {
+		fatal("%s: %v", *file, err)
+	}
+	checkDesiredRecipients(plan)
+
+	for _, envName := range plan.Unmanaged {
+		fmt.Printf("%s %s is not in %s; kept (--prune removes it from config.yaml)\n", ui.Warn(), envName, *file)
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Printf("%s The vault already matches %s\n", ui.OK(), *file)
+		return
+	}
+
+	fmt.Printf("Changes from %s:\n", *file)
+	for _, change := range plan.Changes {
+		fmt.Printf("  %s\n", change)
+	}
+	reencrypt := []string{}
+	if plan.Keys != "" {
+		reencrypt = withCiphertext(plan.Config)
+	}
+
+	if *dryRun {
+		if plan.Keys != "" {
+			fmt.Println()
+			for _, envName := range reencrypt {
+				planLine("re-encrypt .envault/%s to the %d recipient(s) above", plan.Config.Environments[envName].EncryptedFile, len(plan.Recipients))
+			}
+			if plan.Schema == nil {
+				planReencryptSchema()
+			}
+		}
+		endDryRun()
+		return
+	}
+	fmt.Println()
+
+	if environmentsChanged(plan.Changes) {
+		if err := plan.Config.Save(); err != nil {
+			fatal("Failed to write config.yaml: %v", err)
+		}
+		fmt.Printf("%s Updated config.yaml\n", ui.OK())
+	}
+	if plan.Keys != "" {
+		added, removed, _, err := keys.AdoptKeys(plan.Keys, true)
+		if err != nil {
+			fatal("Failed to update authorized_keys: %v", err)
+		}
+		fmt.Printf("%s Updated authorized_keys (%d added, %d removed)\n", ui.OK(), len(added), len(removed))
+	}
+	if plan.Schema != nil {
+		if err := plan.Schema.Save(); err != nil {
+			fatal("Failed to write the schema: %v", err)
+		}
+		fmt.Printf("%s Updated the schema\n", ui.OK())
+	}
+
+	if len(reencrypt) > 0 {
+		for _, envName := range reencrypt {
+			if err := crypto.Reencrypt(envName); err != nil {
+				fatal("Failed to reencrypt %s: %v", envName, err)
+			}
+		}
+		fmt.Printf("%s Re-encrypted %s for the new recipients\n", ui.OK(), strings.Join(reencrypt, ", "))
+		notifyReencrypted(reencrypt)
+		if plan.Schema == nil {
+			reencryptSchema()
+		}
+		for _, envName := range reencrypt {
+			refreshEnvSubvaults(envName)
+		}
+		pruneGrants(reencrypt)
+	}
+
+	fmt.Println("\nNext steps:")
+	step := 1
+	if len(plan.Added) > 0 {
+		fmt.Printf("  %d. Encrypt the new environments:\n", step)
+		for _, envName := range plan.Added {
+			fmt.Printf("       envault encrypt %s <file>\n", envName)
+		}
+		step++
+	}
+	fmt.Printf("  %d. Commit: git add .envault && git commit -m 'chore: apply %s'\n", step, *file)
+}
+
+// readDesiredState reads and parses a desired-state file, decrypting it
+// first when it is an age file
+func readDesiredState(path string) *desired.State {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		fatal("Failed to read %s: %v", path, err)
+	}
+	if bytes.HasPrefix(data, ageHeader) {
+		if data, err = crypto.DecryptSharedData(data); err != nil {
+			fatal("Failed to decrypt %s: %v", path, err)
+		}
+		defer secmem.Wipe(data)
+	}
+	state, err := desired.Parse(data)
+	if err != nil {
+		fatal("%s: %v", path, err)
+	}
+	return state
+}
+
+// checkDesiredRecipients applies the add-key rules to the recipients a
+// plan adopts: no revoked keys, comments matching key_comment_patterns,
+// and keys every environment's backend can encrypt to
+func checkDesiredRecipients(plan *desired.Plan) {
+	if len(plan.Recipients) == 0 {
+		return
+	}
+	revoked, err := keys.LoadRevoked()
+	if err != nil {
+		fatal("%v", err)
+	}
+	if found := keys.FindRevoked(plan.Recipients, revoked); len(found) > 0 {
+		fatal("recipients include %s, which is listed in revoked_keys", found[0].String())
+	}
+	for _, k := range plan.Recipients {
+		if err := plan.Config.CheckKeyComment(k.Comment); err != nil {
+			fatal("Key %s: %v", k.Fingerprint, err)
+		}
+	}
+	for envName := range plan.Config.Environments {
+		backend, err := crypto.BackendFor(plan.Config, envName)
+		if err != nil {
+			fatal("%s: %v", envName, err)
+		}
+		if err := crypto.ValidateRecipients(backend, plan.Recipients); err != nil {
+			fatal("%s: %v", envName, err)
+		}
+	}
+}
+
+// withCiphertext lists the environments whose ciphertext exists, sorted
+func withCiphertext(cfg *config.Config) []string {
+	var names []string
+	for envName := range cfg.Environments {
+		if path, err := cfg.EncryptedPath(envName); err == nil {
+			if _, err := os.Stat(path); err == nil {
+				names = append(names, envName)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func environmentsChanged(changes []desired.Change) bool {
+	for _, change := range changes {
+		if change.Kind == "environment" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/orchard9/envault/internal/approval"
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleApproveChange() {
+	if len(os.Args) < 3 {
+		fatal("Usage: envault approve-change <environment>")
+	}
+
+	envName := os.Args[2]
+
+	a, err := approval.Approve(envName)
+	if err != nil {
+		fatal("Failed to approve %s: %v", envName, err)
+	}
+
+	fmt.Printf("%s Approved %s ciphertext %s as %s\n", ui.OK(), envName, a.CiphertextHash[:12], a.Fingerprint)
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Check approval status: envault verify", envName)
+	fmt.Println("  - Commit: git add .envault/manifest.json && git commit -m 'chore: approve secrets change'")
+}
+
+func handleVerify() {
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+
+	envNames := os.Args[2:]
+	if len(envNames) == 0 {
+		for name := range cfg.Environments {
+			envNames = append(envNames, name)
+		}
+		sort.Strings(envNames)
+	}
+
+	failed := false
+	for _, envName := range envNames {
+		ok, err := checkApprovals(cfg, envName, "")
+		if err != nil {
+			fatal("%v", err)
+		}
+		if !ok {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// checkApprovals prints the approval status of an environment and reports
+// whether its current ciphertext satisfies require_approvals
+func checkApprovals(cfg *config.Config, envName, indent string) (bool, error) {
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		return false, err
+	}
+
+	if env.RequireApprovals == 0 {
+		fmt.Printf("%s%s %s: no approvals required\n", indent, ui.OK(), envName)
+		return true, nil
+	}
+
+	valid, err := approval.Verify(envName)
+	if err != nil {
+		fmt.Printf("%s%s %s: cannot verify approvals: %v\n", indent, ui.Fail(), envName, err)
+		return false, nil
+	}
+
+	if len(valid) < env.RequireApprovals {
+		fmt.Printf("%s%s %s: %d/%d approvals for current ciphertext - run: envault approve-change %s\n", indent, ui.Fail(), envName, len(valid), env.RequireApprovals, envName)
+		return false, nil
+	}
+
+	fmt.Printf("%s%s %s: %d/%d approvals\n", indent, ui.OK(), envName, len(valid), env.RequireApprovals)
+	return true, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/orchard9/envault/internal/bot"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// Environment variables holding the bot's credentials, kept off the
+// command line
+const (
+	botSecretVar = "ENVAULT_BOT_SECRET" // webhook secret
+	botTokenVar  = "ENVAULT_BOT_TOKEN"  // HTTPS token for clone and push
+)
+
+func handleBot() {
+	if len(os.Args) < 3 || os.Args[2] != "serve" {
+		fmt.Println("Usage: envault bot serve --repo <owner/name,...> --identity <file> [--addr host:port]")
+		os.Exit(1)
+	}
+
+	fs := newFlagSet("bot serve", "envault bot serve --repo <owner/name,...> --identity <file> [--addr host:port]")
+	addr := fs.String("addr", ":8787", "address to receive webhooks on (POST /webhook)")
+	repos := fs.String("repo", "", "comma-separated repositories to re-encrypt (owner/name)")
+	identity := fs.String("identity", os.Getenv("ENVAULT_IDENTITY"), "the bot's identity file; its key must be in authorized_keys")
+	workDir := fs.String("workdir", defaultBotWorkDir(), "directory for the bot's clones")
+	subdir := fs.String("subdir", "", "directory holding .envault, relative to the repository root")
+	name := fs.String("git-name", "envault-bot", "commit author name")
+	email := fs.String("git-email", "envault-bot@users.noreply.github.com", "commit author email")
+	parseFlags(fs, os.Args[3:])
+
+	secret := os.Getenv(botSecretVar)
+	if secret == "" {
+		fatal("%s must hold the webhook secret", botSecretVar)
+	}
+	allowed := map[string]bool{}
+	for _, repo := range splitList(*repos) {
+		allowed[repo] = true
+	}
+	if len(allowed) == 0 {
+		fatal("--repo is required")
+	}
+	if *identity == "" {
+		fatal("--identity is required (or set ENVAULT_IDENTITY)")
+	}
+	identityPath, err := filepath.Abs(*identity)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if _, err := os.Stat(identityPath); err != nil {
+		fatal("Bot identity: %v", err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fatal("Failed to locate the envault binary: %v", err)
+	}
+
+	srv := &bot.Server{
+		Secret: []byte(secret),
+		Repos:  allowed,
+		Log:    log.New(os.Stdout, "", log.LstdFlags),
+		Runner: &bot.Runner{
+			WorkDir:     *workDir,
+			Executable:  executable,
+			Identity:    identityPath,
+			Token:       os.Getenv(botTokenVar),
+			Subdir:      *subdir,
+			AuthorName:  *name,
+			AuthorEmail: *email,
+		},
+	}
+	if srv.Runner.Token == "" {
+		fmt.Printf("%s %s is not set; clone and push use git's own credentials\n", ui.Warn(), botTokenVar)
+	}
+
+	fmt.Printf("%s Receiving webhooks on %s/webhook for %d repositories\n", ui.OK(), *addr, len(allowed))
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           srv.Start(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := httpServer.ListenAndServe(); err != nil {
+		fatal("Bot stopped: %v", err)
+	}
+}
+
+func defaultBotWorkDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "envault-bot")
+	}
+	return filepath.Join(cacheDir, "envault", "bot")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/sshsig"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleKeysBundle() {
+	if len(os.Args) < 4 {
+		fmt.Println("Usage: envault keys bundle export [--name <name>] [--out <file>] [--revocations <files>] | import <file|-> [--merge]")
+		os.Exit(1)
+	}
+
+	switch os.Args[3] {
+	case "export":
+		handleBundleExport()
+	case "import":
+		handleBundleImport()
+	default:
+		fatal("unknown keys bundle command %q (expected export or import)", os.Args[3])
+	}
+}
+
+// handleBundleExport signs the current recipient set with the local SSH
+// key, for other repositories to adopt with keys bundle import
+func handleBundleExport() {
+	fs := newFlagSet("keys bundle export", "envault keys bundle export [--name <name>] [--out <file>] [--revocations a.sig,b.sig]")
+	name := fs.String("name", "", "bundle name shown on import (default: the project directory name)")
+	out := fs.String("out", "", "write the bundle to a file instead of stdout")
+	revocations := fs.String("revocations", "", "comma-separated revocation certificates (remove-key --revoke-cert) to include")
+	parseFlags(fs, os.Args[4:])
+
+	if *name == "" {
+		if cwd, err := os.Getwd(); err == nil {
+			*name = filepath.Base(cwd)
+		}
+	}
+
+	bundle, err := keys.NewBundle(*name)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	for _, path := range splitList(*revocations) {
+		revocation, err := readRevocation(path)
+		if err != nil {
+			fatal("%v", err)
+		}
+		if err := sshsig.Verify(revocation.Signer, revocation.Message(), revocation.Signature); err != nil {
+			fatal("%s: %v", path, err)
+		}
+		bundle.Revocations = append(bundle.Revocations, revocation)
+	}
+
+	privateKey, signer := localSigner()
+	bundle.Signer = signer.Recipient()
+
+	if bundle.Signature, err = sshsig.Sign(privateKey, bundle.Message()); err != nil {
+		fatal("%v", err)
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		fatal("Failed to encode bundle: %v", err)
+	}
+	data = append(data, '\n')
+
+	if *out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fatal("Failed to write %s: %v", *out, err)
+	}
+	recipients, _ := bundle.Recipients()
+	fmt.Printf("%s Wrote bundle %s with %d keys to %s, signed by %s\n", ui.OK(), bundle.Name, len(recipients), *out, signer.Fingerprint)
+	if len(bundle.Revocations) > 0 {
+		fmt.Printf("%s Included %d revocation certificate(s)\n", ui.OK(), len(bundle.Revocations))
+	}
+}
+
+// localSigner returns the SSH private key that signs bundles and
+// revocation certificates, with its public key
+func localSigner() (string, *keys.Key) {
+	privateKey, err := crypto.FindSSHPrivateKey()
+	if err != nil {
+		fatal("%v", err)
+	}
+	pub, err := os.ReadFile(privateKey + ".pub")
+	if err != nil {
+		fatal("Failed to read public key for %s: %v", privateKey, err)
+	}
+	signer, err := keys.ParseKey(strings.TrimSpace(string(pub)))
+	if err != nil || signer.Type == "age" {
+		fatal("%s.pub is not an SSH public key", privateKey)
+	}
+	return privateKey, signer
+}
+
+// handleBundleImport verifies a bundle against bundle_signers and adopts
+// its recipient set
+func handleBundleImport() {
+	fs := newFlagSet("keys bundle import", "envault keys bundle import <file|-> [--merge]")
+	merge := fs.Bool("merge", false, "only add the bundle's keys, keeping keys it does not list")
+	args := parseFlags(fs, os.Args[4:])
+
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		fatal("Failed to read bundle: %v", err)
+	}
+
+	bundle, err := keys.ParseBundle(data)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	signer, err := trustedSigner(cfg, "bundle", bundle.Signer, bundle.Message(), bundle.Signature)
+	if err != nil {
+		fatal("%v", err)
+	}
+	// Every certificate is checked before anything is changed
+	revokedKeys := make([]*keys.Key, 0, len(bundle.Revocations))
+	for _, revocation := range bundle.Revocations {
+		key, err := revocation.Revoked()
+		if err == nil {
+			_, err = trustedSigner(cfg, "revocation certificate", revocation.Signer, revocation.Message(), revocation.Signature)
+		}
+		if err != nil {
+			fatal("Bundle revocation: %v", err)
+		}
+		revokedKeys = append(revokedKeys, key)
+	}
+
+	recipients, err := bundle.Recipients()
+	if err != nil {
+		fatal("%v", err)
+	}
+	for _, k := range recipients {
+		if err := cfg.CheckKeyComment(k.Comment); err != nil {
+			fatal("Bundle key %s: %v", k.Fingerprint, err)
+		}
+	}
+
+	var revokedChanged []*keys.Key
+	for _, key := range revokedKeys {
+		removedKey, revokedKey, err := keys.ApplyRevocation(*key)
+		if err != nil {
+			fatal("Failed to apply revocation of %s: %v", key.Fingerprint, err)
+		}
+		if removedKey || revokedKey {
+			revokedChanged = append(revokedChanged, key)
+		}
+	}
+
+	added, removed, skipped, err := bundle.Adopt(!*merge)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	fmt.Printf("%s Bundle %s (%s, %d keys) signed by %s\n", ui.OK(), bundle.Name, bundle.CreatedAt.Format("2006-01-02"), len(recipients), signer.Fingerprint)
+	for _, key := range revokedChanged {
+		fmt.Printf("  - %s (revoked)\n", key.String())
+	}
+	for _, k := range skipped {
+		fmt.Printf("%s Skipped %s: listed in revoked_keys\n", ui.Warn(), k.String())
+	}
+	if len(added)+len(removed)+len(revokedChanged) == 0 {
+		fmt.Printf("%s authorized_keys already matches the bundle\n", ui.OK())
+		return
+	}
+	for _, k := range added {
+		fmt.Printf("  + %s\n", k.String())
+	}
+	for _, k := range removed {
+		fmt.Printf("  - %s\n", k.String())
+		if cfg.IsRecoveryKey(k.Fingerprint) {
+			fmt.Printf("%s %s is a recovery key; add it back or import with --merge\n", ui.Warn(), k.Fingerprint)
+		}
+	}
+
+	fmt.Println("\nNext steps:")
+	fmt.Println("  1. Re-encrypt for the new recipient set: envault reencrypt")
+	fmt.Println("  2. Commit: git add .envault/ && git commit -m 'chore: adopt recipient bundle'")
+}
+
+// trustedSigner returns the bundle_signers key that signed a bundle or
+// revocation certificate (what), after checking the signature
+func trustedSigner(cfg *config.Config, what, signerLine string, message []byte, signature string) (*keys.Key, error) {
+	if len(cfg.BundleSigners) == 0 {
+		return nil, fmt.Errorf("no bundle_signers in config.yaml; add the maintainer keys you trust to sign bundles")
+	}
+	presented, err := keys.ParseKey(signerLine)
+	if err != nil {
+		return nil, fmt.Errorf("%s signer: %w", what, err)
+	}
+
+	for _, line := range cfg.BundleSigners {
+		trusted, err := keys.ParseKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("bundle_signers: %w", err)
+		}
+		if trusted.Type != presented.Type || trusted.Data != presented.Data {
+			continue
+		}
+		if err := sshsig.Verify(trusted.Recipient(), message, signature); err != nil {
+			return nil, fmt.Errorf("%s signature from %s is invalid: %w", what, trusted.Fingerprint, err)
+		}
+		return trusted, nil
+	}
+	return nil, fmt.Errorf("%s is signed by %s, which is not in bundle_signers", what, presented.Fingerprint)
+}
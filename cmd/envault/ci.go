@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/orchard9/envault/internal/ci"
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/notify"
+	"github.com/orchard9/envault/internal/ui"
+	"github.com/orchard9/envault/internal/vault"
+)
+
+func handleCI() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault ci init [--force] [--no-commit]")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "init":
+		handleCIInit()
+	default:
+		fatal("unknown ci command %q (expected init)", os.Args[2])
+	}
+}
+
+// handleCIInit creates a CI identity whose private key is committed
+// encrypted under a passphrase, so CI needs a single secret
+func handleCIInit() {
+	fs := newFlagSet("ci init", "envault ci init [--force] [--no-commit] [--comment <text>]")
+	force := fs.Bool("force", false, "replace an existing CI identity, removing its key")
+	noCommit := fs.Bool("no-commit", false, "leave the changes uncommitted")
+	comment := fs.String("comment", "", "comment for the CI public key (default envault-ci@<project>)")
+	parseFlags(fs, os.Args[3:])
+
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		fatal("Failed to determine .envault directory: %v", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+
+	identityPath := ci.Path(envaultDir)
+	if old, err := os.ReadFile(identityPath); err == nil {
+		if !*force {
+			fatal("%s already exists (use --force to replace it)", ci.FileName)
+		}
+		removeCIKey(old)
+	}
+
+	if *comment == "" {
+		cwd, _ := os.Getwd()
+		*comment = "envault-ci@" + filepath.Base(cwd)
+	}
+
+	// A passphrase already in the environment is reused, so an existing CI
+	// secret keeps working
+	passphrase := os.Getenv(ci.PassphraseEnv)
+	generated := passphrase == ""
+	if generated {
+		if passphrase, err = ci.NewPassphrase(); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	private, public, err := ci.Generate(*comment)
+	if err != nil {
+		fatal("Failed to generate CI identity: %v", err)
+	}
+	sealed, err := ci.Seal(private, public, passphrase)
+	if err != nil {
+		fatal("Failed to encrypt CI identity: %v", err)
+	}
+	if err := os.WriteFile(identityPath, sealed, 0644); err != nil {
+		fatal("Failed to write %s: %v", ci.FileName, err)
+	}
+	fmt.Printf("%s Wrote encrypted CI identity to .envault/%s\n", ui.OK(), ci.FileName)
+
+	if err := keys.Add(public); err != nil {
+		fatal("Failed to add CI key: %v", err)
+	}
+	key, _ := keys.ParseKey(public)
+	fmt.Printf("%s Added CI key %s\n", ui.OK(), key.String())
+	sendNotification(notify.Event{Operation: notify.OpAddKey, Key: key.Fingerprint})
+
+	// Only environments that already have ciphertext can be re-encrypted
+	var envNames []string
+	for name := range cfg.Environments {
+		if path, err := cfg.EncryptedPath(name); err == nil {
+			if _, err := os.Stat(path); err == nil {
+				envNames = append(envNames, name)
+			}
+		}
+	}
+	sort.Strings(envNames)
+	for _, name := range envNames {
+		if err := crypto.Reencrypt(name); err != nil {
+			fatal("Failed to re-encrypt %s: %v", name, err)
+		}
+		fmt.Printf("%s Re-encrypted %s for the CI key\n", ui.OK(), name)
+	}
+	notifyReencrypted(envNames)
+
+	// Show the passphrase before anything else can fail
+	if generated {
+		fmt.Printf("\nCI passphrase (shown once - store it as the CI secret %s):\n\n  %s\n\n", ci.PassphraseEnv, passphrase)
+	}
+
+	committed := false
+	if !*noCommit {
+		committed = commitCIIdentity()
+	}
+
+	fmt.Println("\nNext steps:")
+	fmt.Printf("  1. Add %s to your CI provider's secrets\n", ci.PassphraseEnv)
+	fmt.Println("  2. In CI, run envault as usual; the identity is unlocked automatically")
+	if !committed {
+		fmt.Println("  3. Commit: envault vault commit -m 'chore: add envault CI identity'")
+	}
+}
+
+// removeCIKey drops the key of a CI identity being replaced
+func removeCIKey(sealedData []byte) {
+	public, err := ci.PublicKey(sealedData)
+	if err != nil {
+		fatal("Failed to read existing CI identity: %v", err)
+	}
+	key, err := keys.ParseKey(public)
+	if err != nil {
+		fatal("Failed to read existing CI identity: %v", err)
+	}
+	if _, err := keys.RemoveKey(key.Fingerprint); err != nil {
+		fmt.Printf("%s Previous CI key %s was not in authorized_keys\n", ui.Warn(), key.Fingerprint)
+		return
+	}
+	fmt.Printf("%s Removed previous CI key %s\n", ui.OK(), key.Fingerprint)
+	sendNotification(notify.Event{Operation: notify.OpRemoveKey, Key: key.Fingerprint})
+}
+
+// commitCIIdentity commits the new identity and re-encrypted environments.
+// Failures are warnings: everything is in place, only uncommitted.
+func commitCIIdentity() bool {
+	info, err := vault.Locate()
+	if err != nil {
+		fmt.Printf("%s Not committed: %v\n", ui.Warn(), err)
+		return false
+	}
+	if info.Mode == vault.ModeUntracked {
+		fmt.Printf("%s .envault is not in a git repository; commit %s yourself\n", ui.Warn(), ci.FileName)
+		return false
+	}
+
+	committed, err := info.Commit("chore: add envault CI identity")
+	if err != nil {
+		fmt.Printf("%s Not committed: %v\n", ui.Warn(), err)
+		return false
+	}
+	if committed {
+		fmt.Printf("%s Committed the CI identity in %s\n", ui.OK(), info.VaultRepo)
+	}
+	return true
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/schema"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/shellenv"
+	"github.com/orchard9/envault/internal/state"
+)
+
+// completionCommands are the top-level commands offered by completion
+var completionCommands = []string{
+	"init", "dev", "staging", "prod", "load", "profile", "identity", "add-key", "remove-key", "grant", "list-keys", "keys",
+	"scan", "notes", "schema", "subvault", "config", "env", "vault", "sync", "review-diff", "ci", "bot",
+	"encrypt", "decrypt", "reencrypt", "apply", "check", "doctor", "approve-change", "verify", "verify-content",
+	"shell-init", "completion", "exec", "pipe", "session", "make", "task", "test-env", "docker", "k8s-init", "export", "embed", "devcontainer", "ide-server", "remote-decrypt",
+	"share", "receive", "status", "unload", "clean", "migrate", "serve", "tokens", "agent",
+	"version", "upgrade", "help",
+}
+
+// completionSubcommands are offered after a command that takes one
+var completionSubcommands = map[string][]string{
+	"profile":     {"list", "show"},
+	"identity":    {"import", "unlock", "lock", "status", "remove"},
+	"keys":        {"fmt", "merge", "setup-merge", "bundle", "revocation"},
+	"grant":       {"list", "remove"},
+	"keys bundle": {"export", "import"},
+	"notes":       {"show", "edit"},
+	"schema":      {"add", "rm", "check", "encrypt", "decrypt"},
+	"subvault":    {"create", "refresh", "list", "rm"},
+	"config":      {"lint"},
+	"env":         {"deprecate", "remove"},
+	"vault":       {"status", "link", "commit", "push", "pull"},
+	"sync":        {"status", "push"},
+	"ci":          {"init"},
+	"session":     {"start", "stop", "status"},
+	"bot":         {"serve"},
+	"docker":      {"run", "secrets"},
+	"tokens":      {"add", "list", "remove"},
+	"shell-init":  {"bash", "zsh", "fish"},
+	"completion":  {"bash", "zsh", "fish"},
+}
+
+// completionEnvArgs is how many leading arguments of a command are
+// environment names; -1 means all of them
+var completionEnvArgs = map[string]int{
+	"load": -1, "encrypt": 1, "decrypt": 1, "reencrypt": 1, "check": 1, "doctor": -1,
+	"approve-change": 1, "verify": -1, "verify-content": 1, "scan": -1,
+	"notes show": 1, "notes edit": 1, "env deprecate": 1, "env remove": 1,
+	"review-diff": -1, "schema check": -1, "export": 1, "exec": 1, "pipe": 1, "embed": 1,
+	"devcontainer": 1, "share": 1, "unload": 1, "serve": -1, "agent": -1,
+	"docker run": 1, "docker secrets": 1, "subvault create": 1, "subvault refresh": -1,
+	"subvault rm": 1, "make": 1, "task": 1, "k8s-init": 1, "session start": -1,
+}
+
+func handleCompletion() {
+	if len(os.Args) != 3 {
+		fatal("Usage: envault completion bash|zsh|fish")
+	}
+
+	script, err := shellenv.Completion(os.Args[2])
+	if err != nil {
+		fatal("%v", err)
+	}
+	fmt.Print(script)
+}
+
+// handleComplete prints the candidates for the last of the words typed
+// after envault, one per line. It is run by the completion scripts and
+// stays quiet on errors, so a broken vault never garbles the prompt.
+func handleComplete() {
+	words := os.Args[2:]
+	if len(words) > 0 && words[0] == "--" {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return
+	}
+	current := words[len(words)-1]
+	for _, candidate := range completeWords(words[:len(words)-1], current) {
+		if strings.HasPrefix(candidate, current) {
+			fmt.Println(candidate)
+		}
+	}
+}
+
+// completeWords returns the candidates for the word after done
+func completeWords(done []string, current string) []string {
+	if strings.HasPrefix(current, "-") {
+		return nil
+	}
+	if len(done) == 0 {
+		return completionCommands
+	}
+
+	command := done[0]
+	done = done[1:]
+	for {
+		subcommands, ok := completionSubcommands[command]
+		if !ok {
+			break
+		}
+		if len(done) == 0 {
+			return subcommands
+		}
+		command += " " + done[0]
+		done = done[1:]
+	}
+
+	var args []string
+	for _, word := range done {
+		if word == "--" {
+			return nil // the rest belongs to a child command
+		}
+		if !strings.HasPrefix(word, "-") {
+			args = append(args, word)
+		}
+	}
+
+	switch command {
+	case "share":
+		if len(args) == 1 {
+			return keyNames(args[0])
+		}
+	case "schema add", "schema rm":
+		return completeSchemaTarget(command, args)
+	case "subvault rm":
+		if len(args) == 1 {
+			return subvaultNames(args[0])
+		}
+	}
+
+	if n, ok := completionEnvArgs[command]; ok && (n < 0 || len(args) < n) {
+		return environmentNames()
+	}
+	return nil
+}
+
+// completeSchemaTarget completes [env] <VAR>: the first word is an
+// environment or a shared rule, the second a variable of that environment
+func completeSchemaTarget(command string, args []string) []string {
+	switch len(args) {
+	case 0:
+		candidates := environmentNames()
+		if command == "schema rm" {
+			candidates = append(candidates, ruleNames("")...)
+		}
+		return candidates
+	case 1:
+		if command == "schema rm" {
+			return ruleNames(args[0])
+		}
+		return keyNames(args[0])
+	}
+	return nil
+}
+
+func environmentNames() []string {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for envName := range cfg.Environments {
+		names = append(names, envName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func subvaultNames(envName string) []string {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil
+	}
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		return nil
+	}
+	return sortedSubvaults(env)
+}
+
+func ruleNames(envName string) []string {
+	sch, err := schema.Load()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for name := range sch.Rules(envName) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keyNames returns an environment's variable names. They are cached in
+// state.json, without values, until the ciphertext changes, so only the
+// first completion after a change decrypts.
+func keyNames(envName string) []string {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil
+	}
+	encryptedPath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		return nil
+	}
+	ciphertext, err := os.ReadFile(encryptedPath)
+	if err != nil {
+		return nil
+	}
+	hash := state.Hash(ciphertext)
+
+	st, err := state.Load()
+	if err != nil {
+		return nil
+	}
+	if cached := st.Names[envName]; cached != nil && cached.CiphertextHash == hash {
+		return cached.Keys
+	}
+
+	plaintext, err := crypto.Decrypt(envName)
+	if err != nil {
+		return nil
+	}
+	entries, err := dotenv.Parse(plaintext)
+	secmem.Wipe(plaintext)
+	if err != nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+	for _, e := range entries {
+		if !seen[e.Key] {
+			seen[e.Key] = true
+			names = append(names, e.Key)
+		}
+	}
+	sort.Strings(names)
+
+	if st.Names == nil {
+		st.Names = map[string]*state.Names{}
+	}
+	st.Names[envName] = &state.Names{CiphertextHash: hash, Keys: names}
+	st.Save()
+	return names
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+const devcontainerJSON = ".devcontainer/devcontainer.json"
+
+func handleDevcontainer() {
+	fs := newFlagSet("devcontainer", "envault devcontainer <env> [--out file] [--update-json] [--bootstrap]")
+	out := fs.String("out", ".devcontainer/devcontainer.env", "env file to write")
+	updateJSON := fs.Bool("update-json", false, "add --env-file to runArgs in "+devcontainerJSON)
+	bootstrap := fs.Bool("bootstrap", false, "also render the environment's targets (for postCreateCommand)")
+	args := parseFlags(fs, os.Args[2:])
+
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName := args[0]
+
+	if *bootstrap && os.Getenv("ENVAULT_IDENTITY_KEY") == "" && os.Getenv("ENVAULT_IDENTITY") == "" {
+		fmt.Fprintf(os.Stderr, "%s ENVAULT_IDENTITY_KEY is not set; falling back to local keys\n", ui.Err.Warn())
+		fmt.Fprintln(os.Stderr, "  In Codespaces, add your private key as a user secret named ENVAULT_IDENTITY_KEY")
+	}
+
+	target := config.Target{Type: "docker-env", Path: *out}
+	if err := env.WriteTarget(envName, target); err != nil {
+		fatal("Failed to write %s: %v", *out, err)
+	}
+	fmt.Printf("%s Wrote %s secrets to %s\n", ui.OK(), envName, *out)
+
+	if *bootstrap {
+		if err := env.Load(envName); err != nil {
+			fatal("Failed to load %s environment: %v", envName, err)
+		}
+		fmt.Printf("%s Rendered %s targets\n", ui.OK(), envName)
+	}
+
+	if *updateJSON {
+		changed, err := addEnvFileRunArg(devcontainerJSON, *out)
+		if err != nil {
+			fmt.Printf("%s Could not update %s: %v\n", ui.Warn(), devcontainerJSON, err)
+			fmt.Printf("  Add manually: \"runArgs\": [\"--env-file\", \"%s\"]\n", filepath.ToSlash(*out))
+		} else if changed {
+			fmt.Printf("%s Added --env-file to runArgs in %s\n", ui.OK(), devcontainerJSON)
+		}
+	}
+
+	fmt.Println("\nNext steps:")
+	fmt.Printf("  - Gitignore the env file: echo '%s' >> .gitignore\n", *out)
+	fmt.Printf("  - Bootstrap in Codespaces: \"postCreateCommand\": \"envault devcontainer %s --bootstrap\"\n", envName)
+}
+
+// addEnvFileRunArg ensures runArgs contains --env-file <out> (relative to
+// the workspace root, as devcontainers resolve it). Fails on
+// devcontainer.json files with comments, which encoding/json can't round-trip.
+func addEnvFileRunArg(jsonPath, out string) (bool, error) {
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return false, err
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return false, fmt.Errorf("failed to parse (comments are not supported): %w", err)
+	}
+
+	arg := filepath.ToSlash(out)
+	runArgs, _ := doc["runArgs"].([]any)
+	for i := 0; i+1 < len(runArgs); i++ {
+		if runArgs[i] == "--env-file" && runArgs[i+1] == arg {
+			return false, nil
+		}
+	}
+	doc["runArgs"] = append(runArgs, "--env-file", arg)
+
+	updated, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(jsonPath, append(updated, '\n'), 0644)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dockersecret"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleDocker() {
+	if len(os.Args) < 3 || (os.Args[2] != "run" && os.Args[2] != "secrets") {
+		fmt.Println("Usage: envault docker run <env> [--env-file] [--tag t] -- <docker run args...>")
+		fmt.Println("       envault docker secrets <env> [--engine docker|podman] [--bundle] [--prefix p] [--prune]")
+		os.Exit(1)
+	}
+	if os.Args[2] == "secrets" {
+		handleDockerSecrets()
+		return
+	}
+
+	fs := newFlagSet("docker run", "envault docker run <env> [--env-file] [--tag t] [--docker bin] -- <image> [args...]")
+	useEnvFile := fs.Bool("env-file", false, "pass secrets in a temporary env file on tmpfs instead of -e flags")
+	tags := fs.String("tag", "", "comma-separated tags; only pass variables carrying one of them")
+	dockerBin := fs.String("docker", "docker", "container CLI to run (e.g. podman)")
+	args := parseFlags(fs, os.Args[3:])
+
+	if len(args) < 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName, runArgs := args[0], args[1:]
+
+	entries, err := env.Entries(envName, splitList(*tags))
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	// Fail before any plaintext touches disk
+	if _, err := exec.LookPath(*dockerBin); err != nil {
+		fatal("%s not found: %v", *dockerBin, err)
+	}
+
+	if *useEnvFile {
+		os.Exit(dockerRunEnvFile(*dockerBin, entries, runArgs))
+	}
+
+	// "-e NAME" without a value makes docker copy the variable from its own
+	// environment, so values never appear in any process's arguments
+	secrets := map[string]string{}
+	command := []string{*dockerBin, "run"}
+	for _, e := range entries {
+		if dockerReads(e.Key) {
+			fatal("%s would also reconfigure %s itself - use --env-file for this environment", e.Key, *dockerBin)
+		}
+		secrets[e.Key] = e.Value
+		command = append(command, "-e", e.Key)
+	}
+	command = append(command, runArgs...)
+
+	environ, err := env.Environ(os.Environ(), secrets, env.ExecOptions{})
+	if err != nil {
+		fatal("%v", err)
+	}
+	os.Exit(runCommand(command, environ))
+}
+
+// handleDockerSecrets creates or replaces Swarm or Podman secrets from an
+// environment, one per variable or a single bundled env file
+func handleDockerSecrets() {
+	fs := newFlagSet("docker secrets", "envault docker secrets <env> [--engine docker|podman] [--bundle] [--prefix p] [--tag t] [--prune] [--force]")
+	engine := fs.String("engine", dockersecret.EngineDocker, "container engine API: docker (swarm mode) or podman")
+	bundle := fs.Bool("bundle", false, "create one secret holding the whole env file instead of one per variable")
+	prefix := fs.String("prefix", "", "secret name prefix (default <env>_)")
+	tags := fs.String("tag", "", "comma-separated tags; only export variables carrying one of them")
+	prune := fs.Bool("prune", false, "remove secrets envault created for this environment that are no longer wanted")
+	force := fs.Bool("force", false, "replace secrets even if the ciphertext is unchanged (e.g. after rotating a referenced secret)")
+	args := parseFlags(fs, os.Args[3:])
+
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName := args[0]
+	if *prefix == "" {
+		*prefix = envName + "_"
+	}
+
+	client, err := dockersecret.Connect(*engine)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	entries, err := env.Entries(envName, splitList(*tags))
+	if err != nil {
+		fatal("%v", err)
+	}
+	ciphertextHash, err := crypto.CiphertextHash(envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	want := map[string][]byte{}
+	if *bundle {
+		var b strings.Builder
+		for _, e := range entries {
+			fmt.Fprintf(&b, "%s=%s\n", e.Key, dotenv.Quote(e.Value))
+		}
+		want[*prefix+"env"] = []byte(b.String())
+	} else {
+		for _, e := range entries {
+			want[*prefix+e.Key] = []byte(e.Value)
+		}
+	}
+
+	res, err := dockersecret.Sync(client, envName, ciphertextHash, want, dockersecret.SyncOptions{Prune: *prune, Force: *force})
+	if res != nil {
+		for _, name := range res.Created {
+			fmt.Printf("%s Created %s\n", ui.OK(), name)
+		}
+		for _, name := range res.Replaced {
+			fmt.Printf("%s Replaced %s\n", ui.OK(), name)
+		}
+		for _, name := range res.Removed {
+			fmt.Printf("%s Removed %s\n", ui.OK(), name)
+		}
+		if len(res.Unchanged) > 0 {
+			fmt.Printf("%s %d secret(s) unchanged\n", ui.OK(), len(res.Unchanged))
+		}
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	fmt.Println("\nNext steps:")
+	if *bundle {
+		fmt.Printf("  - Mount it: docker service create --secret %senv ... (read /run/secrets/%senv)\n", *prefix, *prefix)
+	} else {
+		fmt.Printf("  - Mount them: docker service create --secret %s<KEY> ... (read /run/secrets/%s<KEY>)\n", *prefix, *prefix)
+	}
+}
+
+// dockerRunEnvFile passes secrets through a private env file on a memory
+// backed filesystem, removed as soon as docker exits
+func dockerRunEnvFile(dockerBin string, entries []dotenv.Entry, runArgs []string) int {
+	data, err := env.FormatDockerEntries(entries)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	dir, err := os.MkdirTemp(tmpfsDir(), "envault-docker-")
+	if err != nil {
+		fatal("Failed to create temp directory: %v", err)
+	}
+
+	envFile := filepath.Join(dir, "env")
+	if err := os.WriteFile(envFile, data, 0600); err != nil {
+		os.RemoveAll(dir)
+		fatal("Failed to write env file: %v", err)
+	}
+
+	command := append([]string{dockerBin, "run", "--env-file", envFile}, runArgs...)
+	code := runCommand(command, os.Environ())
+	os.RemoveAll(dir)
+	return code
+}
+
+// dockerReads reports whether the docker CLI itself is configured by a
+// variable, so exporting it would change where or how docker runs
+func dockerReads(name string) bool {
+	upper := strings.ToUpper(name)
+	return strings.HasPrefix(upper, "DOCKER_") || upper == "PATH" || upper == "HOME" || upper == "CONTAINER_HOST"
+}
+
+// tmpfsDir returns a memory-backed directory for short-lived plaintext
+// when the system has one
+func tmpfsDir() string {
+	if runtime.GOOS == "linux" {
+		if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+			return "/dev/shm"
+		}
+	}
+	return os.TempDir()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/session"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// handleDoctor reports, for each environment, which backend it needs and
+// whether this machine can encrypt and decrypt it, without decrypting
+// anything. envault check covers the vault itself; doctor covers the
+// machine.
+func handleDoctor() {
+	fs := newFlagSet("doctor", "envault doctor [env...]")
+	args := parseFlags(fs, os.Args[2:])
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	envNames := args
+	for _, envName := range envNames {
+		if _, err := cfg.GetEnvironment(envName); err != nil {
+			fatal("%v", err)
+		}
+	}
+	if len(envNames) == 0 {
+		for envName := range cfg.Environments {
+			envNames = append(envNames, envName)
+		}
+	}
+	sort.Strings(envNames)
+
+	fmt.Print("Checking this machine...\n\n")
+	if installed := crypto.InstalledPlugins(); len(installed) > 0 {
+		fmt.Printf("%s Age plugins on PATH: %s\n", ui.OK(), strings.Join(installed, ", "))
+	} else {
+		fmt.Println("  No age plugins on PATH")
+	}
+	doctorSockets()
+
+	healthy := true
+	var summary [][]string
+	for _, envName := range envNames {
+		d, err := crypto.Diagnose(cfg, envName)
+		if err != nil {
+			fatal("%v", err)
+		}
+		fmt.Printf("\nEnvironment: %s\n", envName)
+		fmt.Printf("  Backend: %s (%s)\n", d.Backend, d.BackendSource)
+
+		fmt.Println("  Encrypt needs:")
+		doctorProbes(d.Encrypt)
+
+		fmt.Println("  Decrypt needs:")
+		doctorProbes(d.Decrypt)
+		if d.AgeIdentities {
+			doctorIdentities(d)
+		}
+
+		encrypt, decrypt := "yes", "yes"
+		if !d.CanEncrypt() {
+			encrypt = "no"
+			healthy = false
+		}
+		if !d.CanDecrypt() {
+			decrypt = "no"
+			healthy = false
+		}
+		where := "here"
+		if d.DecryptVia != nil {
+			where = "via " + d.DecryptVia.SSH
+		}
+		summary = append(summary, []string{envName, d.Backend, where, encrypt, decrypt})
+	}
+
+	fmt.Println()
+	ui.Table(os.Stdout, ui.Out, []string{"ENVIRONMENT", "BACKEND", "DECRYPTS", "ENCRYPT", "DECRYPT"}, summary)
+	if !healthy {
+		fmt.Printf("\n%s This machine cannot encrypt or decrypt every environment; see above\n", ui.Fail())
+		os.Exit(1)
+	}
+	fmt.Printf("\n%s This machine can encrypt and decrypt every environment checked\n", ui.OK())
+}
+
+func doctorProbes(probes []crypto.Probe) {
+	for _, p := range probes {
+		switch {
+		case p.Err != nil:
+			// Keep multi-line errors, such as a list of bad keys, under the probe
+			fmt.Printf("    %s %s: %s\n", ui.Fail(), p.Name, strings.ReplaceAll(p.Err.Error(), "\n", "\n    "))
+		case p.Detail != "":
+			fmt.Printf("    %s %s: %s\n", ui.OK(), p.Name, p.Detail)
+		default:
+			fmt.Printf("    %s %s\n", ui.OK(), p.Name)
+		}
+	}
+}
+
+// doctorIdentities lists the identities decryption would try, marking the
+// ones that are recipients; one usable identity is enough
+func doctorIdentities(d *crypto.Diagnosis) {
+	order := "default order"
+	if d.ChainSource != "" {
+		order = "identities from " + d.ChainSource
+	}
+	fmt.Printf("    One identity that is a recipient (%s):\n", order)
+	if len(d.Identities) == 0 {
+		fmt.Printf("      %s No identity found - add your SSH key to ~/.ssh or set ENVAULT_IDENTITY\n", ui.Fail())
+		return
+	}
+	usable := false
+	for _, p := range d.Identities {
+		switch {
+		case p.Err != nil:
+			fmt.Printf("      %s %s: %v\n", ui.Warn(), p.Label, p.Err)
+		case p.Fingerprint == "":
+			fmt.Printf("      %s %s: public key unknown; it may decrypt\n", ui.Warn(), p.Label)
+			usable = true
+		case p.Recipient:
+			fmt.Printf("      %s %s: %s is a recipient\n", ui.OK(), p.Label, p.Fingerprint)
+			usable = true
+		default:
+			fmt.Printf("      %s %s: %s is not a recipient\n", ui.Warn(), p.Label, p.Fingerprint)
+		}
+	}
+	if !usable {
+		fmt.Printf("      %s None of these can decrypt %s - ask a teammate to run: envault add-key <your-public-key> && envault reencrypt %s\n", ui.Fail(), d.Env, d.Env)
+	}
+}
+
+// doctorSockets reports whether the session and agent sockets in
+// .envault answer, and what a running session holds
+func doctorSockets() {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return
+	}
+
+	info, err := session.Status(session.SocketPath(envaultDir))
+	switch {
+	case err == session.ErrNotRunning:
+		fmt.Println("  No session is running")
+	case err != nil:
+		fmt.Printf("%s Session socket .envault/%s does not answer: %v\n", ui.Warn(), session.SocketName, err)
+	default:
+		fmt.Printf("%s Session holds %s until %s (pid %d)\n", ui.OK(), strings.Join(info.Environments, ", "), info.Expires.Format("15:04"), info.PID)
+	}
+
+	agentSocket := filepath.Join(envaultDir, "agent.sock")
+	if _, err := os.Stat(agentSocket); err != nil {
+		return
+	}
+	conn, err := net.DialTimeout("unix", agentSocket, 2*time.Second)
+	if err != nil {
+		fmt.Printf("%s Agent socket .envault/agent.sock does not answer: %v\n", ui.Warn(), err)
+		return
+	}
+	conn.Close()
+	fmt.Printf("%s Agent is listening on .envault/agent.sock\n", ui.OK())
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/notify"
+	"github.com/orchard9/envault/internal/schema"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// A dry run prints each change a command would make and makes none.
+// Reads that only inform the preview, such as fetching a code host user's
+// keys or the vault's upstream, still run; remote ciphertext and webhooks
+// are named instead of contacted.
+
+// dryRunFlag adds the --dry-run flag shared by the mutating commands
+func dryRunFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("dry-run", false, "print what would change, and change nothing")
+}
+
+// planLine prints one change a dry run would make
+func planLine(format string, args ...any) {
+	fmt.Printf("Would %s\n", fmt.Sprintf(format, args...))
+}
+
+func endDryRun() {
+	fmt.Println("\nDry run: nothing was changed")
+}
+
+// planNotify names the webhooks an operation would be posted to
+func planNotify(operation, subject string) {
+	cfg, err := config.Load()
+	if err != nil {
+		return
+	}
+	for _, destination := range notify.Destinations(cfg, operation) {
+		planLine("notify %s (%s %s)", destination, operation, subject)
+	}
+}
+
+// planEncrypt prints what encrypting or re-encrypting an environment would
+// write and to whom, failing where the real run would refuse
+func planEncrypt(cfg *config.Config, envName, operation string) {
+	recipients, err := crypto.Recipients(cfg, envName)
+	if err != nil {
+		fatal("Failed to %s %s: %v", operation, envName, err)
+	}
+	path, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+	envCfg, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if r := cfg.RemoteFor(envName); r != nil && operation == notify.OpReencrypt {
+		planLine("fetch %s to decrypt it", r.URL)
+	}
+	planLine("write %s, encrypted to %d recipient(s):", displayPath(path), len(recipients))
+	for _, k := range recipients {
+		fmt.Printf("  - %s\n", k.String())
+	}
+	if notesPath, err := cfg.NotesPath(envName); err == nil && operation == notify.OpReencrypt && fileExists(notesPath) {
+		planLine("re-encrypt notes %s to the same recipients", displayPath(notesPath))
+	}
+	for _, name := range sortedSubvaults(envCfg) {
+		if svPath, err := cfg.SubvaultPath(envName, envCfg.Subvaults[name]); err == nil {
+			planLine("rewrite subvault %s (%s)", name, displayPath(svPath))
+		}
+	}
+	planLine("record the change in .envault/manifest.json")
+	if operation == notify.OpReencrypt {
+		now := time.Now()
+		for _, g := range cfg.Grants {
+			if g.Covers(envName) && g.Expired(now) {
+				fingerprint := g.Key
+				if k, err := keys.ParseKey(g.Key); err == nil {
+					fingerprint = k.Fingerprint
+				}
+				planLine("drop %s from the grant for %s, which ended %s, in config.yaml", envName, fingerprint, g.Until)
+			}
+		}
+	}
+	planNotify(operation, envName)
+}
+
+// planReencryptSchema prints the schema re-encryption reencrypt does
+// after every environment
+func planReencryptSchema() {
+	if encrypted, err := schema.Encrypted(); err == nil && encrypted {
+		planLine("re-encrypt .envault/%s", schema.EncryptedFileName)
+	}
+}
+
+// planLoad prints the targets loading an environment would write, without
+// decrypting it. It returns false if the load would fail.
+func planLoad(cfg *config.Config, envName string, opts env.Options) bool {
+	planned, err := env.Plan(envName, opts)
+	if err != nil {
+		fmt.Printf("%s %s: %v\n", ui.Fail(), envName, err)
+		return false
+	}
+
+	if r := cfg.RemoteFor(envName); r != nil {
+		planLine("fetch %s to decrypt %s", r.URL, envName)
+	}
+	ok := true
+	for _, p := range planned {
+		if p.Err != nil {
+			fmt.Printf("%s %s: %v\n", ui.Fail(), envName, p.Err)
+			ok = false
+			continue
+		}
+		where := p.Target.String()
+		if p.Path != "" {
+			where = displayPath(p.Path)
+		}
+		planLine("write %s from %s (%s)", where, envName, p.Status)
+		if len(p.AsFile) > 0 {
+			fmt.Printf("  with %s in their own files under .envault/files/%s\n", strings.Join(p.AsFile, ", "), envName)
+		}
+	}
+	return ok
+}
+
+// displayPath shows a path relative to the current directory when it is
+// inside it
+func displayPath(path string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(cwd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+var embedTemplate = template.Must(template.New("embed").Parse(`// Code generated by envault embed {{.Env}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import "github.com/orchard9/envault/pkg/envault"
+
+// Ciphertext is the age-encrypted {{.Env}} environment
+var Ciphertext = []byte({{.Ciphertext}})
+
+// Load decrypts the embedded {{.Env}} environment
+func Load(opts envault.Options) (map[string]string, error) {
+	return envault.Values(Ciphertext, opts)
+}
+
+// Setenv decrypts the embedded {{.Env}} environment into the process
+// environment, leaving variables that are already set unless overwrite is true
+func Setenv(opts envault.Options, overwrite bool) error {
+	return envault.Setenv(Ciphertext, opts, overwrite)
+}
+`))
+
+func handleEmbed() {
+	fs := newFlagSet("embed", "envault embed <env> [--package name] [--out file]")
+	pkg := fs.String("package", os.Getenv("GOPACKAGE"), "Go package name (default $GOPACKAGE, set by go generate)")
+	out := fs.String("out", "", "output file (default envault_<env>.go)")
+	args := parseFlags(fs, os.Args[2:])
+
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName := args[0]
+
+	if *pkg == "" {
+		*pkg = "secrets"
+	}
+	if !token.IsIdentifier(*pkg) {
+		fatal("%q is not a valid Go package name", *pkg)
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("envault_%s.go", envName)
+	}
+
+	// Resolve the output before moving to the project root
+	outPath, err := filepath.Abs(*out)
+	if err != nil {
+		fatal("Failed to resolve %s: %v", *out, err)
+	}
+
+	// go generate runs in the package directory, not the project root
+	if err := chdirProjectRoot(); err != nil {
+		fatal("%v", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+
+	encryptedPath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	ciphertext, err := os.ReadFile(encryptedPath)
+	if err != nil {
+		fatal("Failed to read %s: %v", encryptedPath, err)
+	}
+
+	var buf bytes.Buffer
+	err = embedTemplate.Execute(&buf, map[string]string{
+		"Env":        envName,
+		"Package":    *pkg,
+		"Ciphertext": strconv.Quote(string(ciphertext)),
+	})
+	if err != nil {
+		fatal("Failed to generate code: %v", err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		fatal("Failed to format generated code: %v", err)
+	}
+
+	if err := os.WriteFile(outPath, src, 0644); err != nil {
+		fatal("Failed to write %s: %v", outPath, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s Embedded %s ciphertext in %s (package %s)\n", ui.OK(), envName, outPath, *pkg)
+}
+
+// chdirProjectRoot moves to the nearest parent directory containing .envault
+func chdirProjectRoot() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	for {
+		if config.IsVault(dir) {
+			return os.Chdir(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return fmt.Errorf("no .envault directory found in this or any parent directory")
+		}
+		dir = parent
+	}
+}
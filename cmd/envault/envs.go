@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/branch"
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/manifest"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleEnv() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault env deprecate <env> --sunset YYYY-MM-DD [--reason text] | deprecate <env> --clear | remove <env> [--purge]")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "deprecate":
+		handleEnvDeprecate()
+	case "remove":
+		handleEnvRemove()
+	default:
+		fatal("unknown env command %q (expected deprecate or remove)", os.Args[2])
+	}
+}
+
+func handleEnvDeprecate() {
+	fs := newFlagSet("env deprecate", "envault env deprecate <env> --sunset YYYY-MM-DD [--reason text] | --clear")
+	sunset := fs.String("sunset", "", "last day the environment may be used (YYYY-MM-DD)")
+	reason := fs.String("reason", "", "shown in warnings, e.g. what to use instead")
+	clear := fs.Bool("clear", false, "remove the deprecation")
+	args := parseFlags(fs, os.Args[3:])
+
+	if len(args) != 1 || (*sunset == "") == !*clear {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName := args[0]
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if *clear {
+		if env.Deprecated == nil {
+			fmt.Printf("%s %s is not deprecated\n", ui.OK(), envName)
+			return
+		}
+		env.Deprecated = nil
+	} else {
+		d := &config.Deprecation{Sunset: *sunset, Reason: *reason}
+		end, err := d.SunsetTime()
+		if err != nil {
+			fatal("%v", err)
+		}
+		if !time.Now().Before(end) {
+			fmt.Printf("%s The sunset %s is already over; envault check fails from now on\n", ui.Warn(), *sunset)
+		}
+		env.Deprecated = d
+	}
+
+	cfg.Environments[envName] = *env
+	if err := cfg.Save(); err != nil {
+		fatal("%v", err)
+	}
+
+	if *clear {
+		fmt.Printf("%s %s is no longer deprecated\n", ui.OK(), envName)
+		return
+	}
+	fmt.Printf("%s %s is %s\n", ui.OK(), envName, env.Deprecated)
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Commit: envault vault commit -m 'chore: deprecate " + envName + "'")
+	fmt.Println("  - After the sunset: envault env remove " + envName + " --purge")
+}
+
+// handleEnvRemove drops an environment from config.yaml. With --purge its
+// ciphertext, notes and manifest entry are deleted and its rendered targets
+// are removed, as envault unload would.
+func handleEnvRemove() {
+	fs := newFlagSet("env remove", "envault env remove <env> [--purge] [--force]")
+	purge := fs.Bool("purge", false, "also delete the ciphertext and notes and remove rendered targets")
+	force := fs.Bool("force", false, "with --purge, also delete rendered targets with local changes")
+	args := parseFlags(fs, os.Args[3:])
+
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName := args[0]
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if len(cfg.Environments) == 1 {
+		fatal("%s is the only environment; config.yaml needs at least one", envName)
+	}
+
+	// Targets are scrubbed first: state.json only knows them by environment
+	// name, and a refusal should leave the environment in place
+	if *purge {
+		if skipped := removeRendered(envName, *force); len(skipped) > 0 {
+			fatal("%s is still in config.yaml; delete its modified targets or use --force", envName)
+		}
+	}
+
+	delete(cfg.Environments, envName)
+	if err := cfg.Save(); err != nil {
+		fatal("%v", err)
+	}
+	fmt.Printf("%s Removed %s from config.yaml\n", ui.OK(), envName)
+
+	if !*purge {
+		fmt.Printf("\nKept %s; delete it with: envault env remove %s --purge\n", env.EncryptedFile, envName)
+		return
+	}
+
+	envaultDir, err := cfg.RootDir(env.Root)
+	if err != nil {
+		fatal("%v", err)
+	}
+	files := []string{env.EncryptedFile, env.NotesFileName()}
+	for _, sv := range env.Subvaults {
+		files = append(files, sv.File)
+	}
+	for _, name := range files {
+		path := filepath.Join(envaultDir, name)
+		if err := os.Remove(path); err == nil {
+			fmt.Printf("%s Deleted %s\n", ui.OK(), name)
+		} else if !os.IsNotExist(err) {
+			fatal("Failed to delete %s: %v", name, err)
+		}
+		// A nested layout leaves an empty <env>/ directory behind
+		if dir := filepath.Dir(path); dir != envaultDir && strings.HasPrefix(dir, envaultDir) {
+			os.Remove(dir)
+		}
+	}
+
+	m, err := manifest.Load()
+	if err != nil {
+		fatal("%v", err)
+	}
+	if _, ok := m.Environments[envName]; ok {
+		delete(m.Environments, envName)
+		if err := m.Save(); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Commit: envault vault commit -m 'chore: retire " + envName + "'")
+	fmt.Println("  - The ciphertext stays in git history; rotate any secret that is still in use elsewhere")
+}
+
+// warnDeprecated tells the user, on stderr, that an environment is being
+// retired. It never fails: a broken config is reported by the caller.
+func warnDeprecated(envName string) {
+	cfg, err := config.Load()
+	if err != nil {
+		return
+	}
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil || env.Deprecated == nil {
+		return
+	}
+	if env.Deprecated.Expired(time.Now()) {
+		fmt.Fprintf(os.Stderr, "%s %s is %s, which is over - remove it with: envault env remove %s --purge\n", ui.Err.Warn(), envName, env.Deprecated, envName)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s %s is %s\n", ui.Err.Warn(), envName, env.Deprecated)
+}
+
+// autoEnvironment picks the environment mapped to the current branch by
+// branches: in config.yaml, for --auto. The choice is reported on stderr
+// so it stays out of export output.
+func autoEnvironment() string {
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	if len(cfg.Branches) == 0 {
+		fatal("--auto needs branches: in config.yaml mapping branches to environments")
+	}
+	name, source, err := branch.Current()
+	if err != nil {
+		fatal("%v", err)
+	}
+	envName, ok := cfg.EnvironmentForBranch(name)
+	if !ok {
+		fatal("no branches: rule matches branch %s (from %s)", name, source)
+	}
+	if _, err := cfg.GetEnvironment(envName); err != nil {
+		fatal("%v", err)
+	}
+	fmt.Fprintf(os.Stderr, "%s Using %s for branch %s (from %s)\n", ui.Err.OK(), envName, name, source)
+	return envName
+}
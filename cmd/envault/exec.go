@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleExec() {
+	fs := newFlagSet("exec", "envault exec <env>|--auto [flags] -- <command> [args...]")
+	cleanEnv := fs.Bool("clean-env", false, "start from an empty environment plus secrets")
+	inherit := fs.String("inherit", "", "comma-separated variables kept with --clean-env (e.g. PATH,HOME)")
+	tags := fs.String("tag", "", "comma-separated tags; only pass variables carrying one of them")
+	onCollision := fs.String("on-collision", env.CollisionOverride, "when a secret shadows an existing variable: override, skip, or error")
+	asFile := fs.String("as-file", "", "comma-separated variables to write to private temp files, passing the path instead")
+	noOverrides := fs.Bool("no-overrides", false, "ignore the overrides files (e.g. .env.local) of the environment's targets")
+	auto := fs.Bool("auto", false, "use the environment mapped to the current git branch instead of naming one")
+	redactOutput := fs.Bool("redact-output", false, "replace secret values in the command's stdout and stderr with [REDACTED:KEY]")
+	redactMin := fs.Int("redact-min-length", env.RedactMinLength, "with --redact-output, leave values shorter than this alone")
+	args := parseFlags(fs, os.Args[2:])
+
+	if *auto {
+		args = append([]string{""}, args...)
+	}
+	if len(args) < 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName, command := args[0], args[1:]
+	if *auto {
+		envName = autoEnvironment()
+	}
+	warnDeprecated(envName)
+
+	opts := env.ExecOptions{
+		CleanEnv:    *cleanEnv,
+		Inherit:     splitList(*inherit),
+		OnCollision: *onCollision,
+	}
+
+	entries, err := entriesFor(envName, splitList(*tags))
+	if err != nil {
+		fatal("%v", err)
+	}
+	secrets := make(map[string]string, len(entries))
+	for _, e := range entries {
+		secrets[e.Key] = e.Value
+	}
+	if !*noOverrides {
+		overrides, err := env.Overrides(envName)
+		if err != nil {
+			fatal("%v", err)
+		}
+		for name, value := range overrides {
+			secrets[name] = value
+		}
+	}
+
+	// Before --as-file swaps values for paths: the child may print a file
+	var redactor *env.Redactor
+	if *redactOutput {
+		redactor = env.NewRedactor(secrets, *redactMin)
+		if redactor.Empty() {
+			fmt.Fprintf(os.Stderr, "%s No value is %d or more characters long; output is not redacted\n", ui.Warn(), *redactMin)
+		}
+	}
+
+	cleanup, err := valuesAsFiles(secrets, splitList(*asFile))
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	environ, err := env.Environ(os.Environ(), secrets, opts)
+	if err != nil {
+		cleanup()
+		fatal("%v", err)
+	}
+
+	var code int
+	if redactor != nil {
+		stdout, stderr := redactor.Writer(os.Stdout), redactor.Writer(os.Stderr)
+		code = runCommandTo(command, environ, stdout, stderr)
+		stdout.Close()
+		stderr.Close()
+	} else {
+		code = runCommand(command, environ)
+	}
+	cleanup()
+	os.Exit(code)
+}
+
+// valuesAsFiles writes the named secrets to a private temp directory and
+// replaces their values with the file paths. cleanup removes the files.
+func valuesAsFiles(secrets map[string]string, names []string) (func(), error) {
+	if len(names) == 0 {
+		return func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp(tmpfsDir(), "envault-files-")
+	if err != nil {
+		return func() {}, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	paths, err := env.WriteValueFiles(dir, secrets, names)
+	if err != nil {
+		cleanup()
+		return func() {}, err
+	}
+	for name, path := range paths {
+		secrets[name] = path
+	}
+	return cleanup, nil
+}
+
+// runCommand runs a child process with the given environment, forwarding
+// interrupts, and returns its exit code
+func runCommand(command, environ []string) int {
+	return runCommandTo(command, environ, os.Stdout, os.Stderr)
+}
+
+// runCommandTo is runCommand sending the child's output to stdout and
+// stderr. Writers other than files are fed through pipes, which Wait
+// drains before returning.
+func runCommandTo(command, environ []string, stdout, stderr io.Writer) int {
+	cmd, err := commandFor(command, environ)
+	if err != nil {
+		fatal("Failed to start %s: %v", command[0], err)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		fatal("Failed to start %s: %v", command[0], err)
+	}
+
+	stop := forwardSignals(cmd)
+	defer stop()
+
+	return exitCode(command[0], cmd.Wait())
+}
+
+// exitCode turns the result of waiting for a child into its exit code
+func exitCode(name string, err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		// ExitCode is -1 when the child was killed by a signal
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		return signalExitCode(exitErr)
+	default:
+		fatal("%s failed: %v", name, err)
+		return 1
+	}
+}
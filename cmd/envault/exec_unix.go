@@ -0,0 +1,55 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// commandFor builds the child process. Arguments are passed to execve as
+// is; no shell is involved.
+func commandFor(command, environ []string) (*exec.Cmd, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = environ
+	return cmd, nil
+}
+
+// shellCommand runs a command line with sh, for filters given as one
+// string such as 'jq .foo'
+func shellCommand(line string, environ []string) (*exec.Cmd, error) {
+	return commandFor([]string{"sh", "-c", line}, environ)
+}
+
+// forwardSignals relays SIGINT and SIGTERM to the child until stop is called
+func forwardSignals(cmd *exec.Cmd) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// signalExitCode returns 128 plus the signal that killed the child, as a
+// shell reports it, so make and CI see an interrupted build as such
+func signalExitCode(exitErr *exec.ExitError) int {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return 1
+}
+
+// detach starts cmd in its own session, so it outlives the terminal that
+// started it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
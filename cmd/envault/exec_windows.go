@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// defaultPathExt is used when PATHEXT is unset, as with --clean-env
+const defaultPathExt = ".COM;.EXE;.BAT;.CMD"
+
+// commandFor builds the child process. The program is looked up on the
+// child's PATH and PATHEXT. Batch files cannot be started directly with
+// CreateProcess-style quoting, so they run through cmd.exe with arguments
+// quoted for cmd's parser.
+func commandFor(command, environ []string) (*exec.Cmd, error) {
+	path, err := lookPath(command[0], environ)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(path, command[1:]...)
+	cmd.Env = environ
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".bat", ".cmd":
+		cmd.Path = comspec(environ)
+		line, err := batchCommandLine(cmd.Path, path, command[1:])
+		if err != nil {
+			return nil, err
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: line}
+	}
+
+	return cmd, nil
+}
+
+// shellCommand runs a command line with cmd.exe, for filters given as one
+// string. The line is passed through unquoted, as typed.
+func shellCommand(line string, environ []string) (*exec.Cmd, error) {
+	shell := comspec(environ)
+	cmd := exec.Command(shell)
+	cmd.Env = environ
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: cmdQuote(shell) + ` /d /s /c "` + line + `"`}
+	return cmd, nil
+}
+
+// forwardSignals keeps Ctrl+C, Ctrl+Break and console close from ending
+// envault before the child. The console delivers these events to every
+// process attached to it, so the child receives them itself and envault
+// only waits for it to exit.
+func forwardSignals(cmd *exec.Cmd) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range signals {
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// signalExitCode is 1: Windows processes have no terminating signal
+func signalExitCode(exitErr *exec.ExitError) int {
+	return 1
+}
+
+// detach starts cmd in its own process group, so Ctrl+C in the console
+// that started it does not end it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP, HideWindow: true}
+}
+
+// lookPath resolves name like cmd.exe would, but against the child's
+// environment rather than envault's own
+func lookPath(name string, environ []string) (string, error) {
+	pathExt := envLookup(environ, "PATHEXT")
+	if pathExt == "" {
+		pathExt = defaultPathExt
+	}
+	var exts []string
+	for _, ext := range strings.Split(strings.ToLower(pathExt), ";") {
+		if ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+
+	if strings.ContainsAny(name, `\/:`) {
+		if path, ok := findExecutable(name, exts); ok {
+			return path, nil
+		}
+		return "", fmt.Errorf("%s: file not found", name)
+	}
+
+	pathVar := envLookup(environ, "PATH")
+	if pathVar == "" {
+		pathVar = os.Getenv("PATH")
+	}
+	for _, dir := range filepath.SplitList(pathVar) {
+		if dir == "" {
+			continue
+		}
+		if path, ok := findExecutable(filepath.Join(dir, name), exts); ok {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s: not found in PATH", name)
+}
+
+// findExecutable tries path as given when it already has a PATHEXT
+// extension, then with each extension appended
+func findExecutable(path string, exts []string) (string, bool) {
+	candidates := []string{}
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range exts {
+		if e == ext {
+			candidates = append(candidates, path)
+			break
+		}
+	}
+	for _, e := range exts {
+		candidates = append(candidates, path+e)
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// batchCommandLine builds the full cmd.exe command line (CmdLine replaces
+// argv, including argv[0]) for running a batch file. cmd
+// expands %VAR% even inside quotes and has no escape for it, so such
+// arguments are refused rather than passed on altered.
+func batchCommandLine(shell, path string, args []string) (string, error) {
+	parts := []string{cmdQuote(path)}
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "%\r\n\x00") {
+			return "", fmt.Errorf("cannot pass %q to batch file %s: %% and line breaks are not safe in cmd.exe arguments", arg, filepath.Base(path))
+		}
+		parts = append(parts, cmdQuote(arg))
+	}
+	// /s strips the outer quotes, leaving the inner line untouched
+	return cmdQuote(shell) + ` /d /s /c "` + strings.Join(parts, " ") + `"`, nil
+}
+
+// cmdQuote quotes an argument when cmd.exe would otherwise split it or
+// interpret metacharacters; embedded quotes are doubled
+func cmdQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"&|<>^(),;=!") {
+		return arg
+	}
+	return `"` + strings.ReplaceAll(arg, `"`, `""`) + `"`
+}
+
+// comspec returns the command interpreter for batch files
+func comspec(environ []string) string {
+	if path := envLookup(environ, "COMSPEC"); path != "" {
+		return path
+	}
+	if path := os.Getenv("COMSPEC"); path != "" {
+		return path
+	}
+	root := os.Getenv("SystemRoot")
+	if root == "" {
+		root = `C:\Windows`
+	}
+	return filepath.Join(root, "System32", "cmd.exe")
+}
+
+// envLookup finds a variable in KEY=value entries; Windows names are
+// case-insensitive
+func envLookup(environ []string, name string) string {
+	for i := len(environ) - 1; i >= 0; i-- {
+		key, value, ok := strings.Cut(environ[i], "=")
+		if ok && strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
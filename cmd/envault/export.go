@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/shellenv"
+)
+
+func handleExport() {
+	fs := newFlagSet("export", "envault export <env>|--auto [--shell posix|fish|powershell|cmd]")
+	shell := fs.String("shell", "posix", "output dialect: posix (bash, zsh), fish, powershell, cmd")
+	tags := fs.String("tag", "", "comma-separated tags; only export variables carrying one of them")
+	track := fs.Bool("track", false, "also set ENVAULT_ENV and ENVAULT_VARS (used by shell-init)")
+	auto := fs.Bool("auto", false, "export the environment mapped to the current git branch")
+	args := parseFlags(fs, os.Args[2:])
+
+	if len(args) != 1 && !(*auto && len(args) == 0) {
+		fs.Usage()
+		os.Exit(1)
+	}
+	var envName string
+	if *auto {
+		envName = autoEnvironment()
+	} else {
+		envName = args[0]
+	}
+
+	entries, err := env.Entries(envName, splitList(*tags))
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if *track {
+		entries = shellenv.Track(envName, entries)
+	}
+
+	out, err := shellenv.Export(*shell, entries)
+	if err != nil {
+		fatal("%v", err)
+	}
+	fmt.Print(out)
+}
+
+func handleShellInit() {
+	if len(os.Args) != 3 {
+		fatal("Usage: envault shell-init bash|zsh|fish")
+	}
+
+	script, err := shellenv.Init(os.Args[2])
+	if err != nil {
+		fatal("%v", err)
+	}
+	fmt.Print(script)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/schema"
+)
+
+// parseFlags parses args with fs, allowing flags to appear before, between,
+// or after positional arguments. A bare "--" ends flag parsing. Returns the
+// positional arguments in order.
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	fs.SetOutput(os.Stderr)
+
+	var positional []string
+	for {
+		// fs.Parse consumes "--" itself, so check before handing it over
+		if len(args) > 0 && args[0] == "--" {
+			return append(positional, args[1:]...)
+		}
+		if err := fs.Parse(args); err != nil {
+			os.Exit(2)
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional
+		}
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...)
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// newFlagSet creates a flag set whose usage line is shown on parse errors
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseDurationFlag parses a duration flag value such as "90d" or "12h".
+// "0" or "" yields zero.
+func parseDurationFlag(name, value string) time.Duration {
+	if value == "" || value == "0" {
+		return 0
+	}
+	d, err := schema.ParseDuration(value)
+	if err != nil {
+		fatal("Invalid --%s: %v", name, err)
+	}
+	return d
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// readYes reads a line from stdin and reports whether it was y or yes
+func readYes() bool {
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
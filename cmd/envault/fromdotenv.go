@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/scaffold"
+	"github.com/orchard9/envault/internal/schema"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// dotenvImport is what init --from-dotenv was told to take over
+type dotenvImport struct {
+	Environments []*scaffold.Found
+	Required     []string // names from .env.example to mark required
+}
+
+// detectDotenv offers the project's existing dotenv files as environments,
+// replacing the default dev environment in cfg with the accepted ones.
+// Nothing is written; init creates the files afterwards.
+func detectDotenv(cfg *config.Config, layout string, yes bool) *dotenvImport {
+	if !yes && (!ui.Out.TTY || !isTerminal(os.Stdin)) {
+		fatal("--from-dotenv asks before importing each file; pass --yes to accept everything detected")
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		fatal("%v", err)
+	}
+	detection, err := scaffold.Detect(cwd)
+	if err != nil {
+		fatal("Failed to search for dotenv files: %v", err)
+	}
+	if len(detection.Environments) == 0 && len(detection.ExampleKeys) == 0 {
+		fmt.Printf("%s No .env files or compose env_file references found; using the default configuration\n", ui.Warn())
+		return nil
+	}
+
+	fmt.Println("Detected:")
+	for _, found := range detection.Environments {
+		if found.Source != "" {
+			fmt.Printf("  - %s: %d variable(s), rendered to %s\n", found.Source, len(found.Keys), strings.Join(found.Targets, ", "))
+		} else {
+			fmt.Printf("  - %s (not created yet)\n", strings.Join(found.Targets, ", "))
+		}
+	}
+	for _, example := range detection.Examples {
+		fmt.Printf("  - %s: example only, not imported\n", example)
+	}
+	if len(detection.Compose) > 0 {
+		fmt.Printf("  env_file references read from %s\n", strings.Join(detection.Compose, ", "))
+	}
+	fmt.Println()
+
+	imported := &dotenvImport{}
+	environments := map[string]config.Environment{}
+	for _, found := range detection.Environments {
+		question := fmt.Sprintf("Import %s as environment %s?", found.Source, found.Env)
+		if found.Source == "" {
+			question = fmt.Sprintf("Create environment %s rendering to %s?", found.Env, strings.Join(found.Targets, ", "))
+		}
+		if !confirmImport(question, yes) {
+			continue
+		}
+		encryptedFile, err := config.LayoutFile(layout, found.Env)
+		if err != nil {
+			fatal("%v", err)
+		}
+		environment := config.Environment{EncryptedFile: encryptedFile}
+		for _, target := range found.Targets {
+			environment.Targets = append(environment.Targets, config.Target{Path: target})
+		}
+		environments[found.Env] = environment
+		imported.Environments = append(imported.Environments, found)
+	}
+	if len(detection.ExampleKeys) > 0 {
+		question := fmt.Sprintf("Require the %d variable(s) listed in %s in every environment (schema.yaml)?", len(detection.ExampleKeys), strings.Join(detection.Examples, ", "))
+		if confirmImport(question, yes) {
+			imported.Required = detection.ExampleKeys
+		}
+	}
+
+	if len(environments) > 0 {
+		cfg.Environments = environments
+	}
+	if len(imported.Environments) == 0 && len(imported.Required) == 0 {
+		return nil
+	}
+	return imported
+}
+
+func confirmImport(question string, yes bool) bool {
+	if yes {
+		return true
+	}
+	fmt.Printf("%s [y/N] ", question)
+	return readYes()
+}
+
+// finishDotenvImport writes the schema rules and prints how to encrypt
+// the imported files once keys are added
+func finishDotenvImport(imported *dotenvImport) {
+	if len(imported.Required) > 0 {
+		sch := &schema.Schema{}
+		rules := sch.Rules("")
+		for _, name := range imported.Required {
+			rules[name] = schema.Variable{Required: true}
+		}
+		if err := sch.Save(); err != nil {
+			fatal("Failed to create schema.yaml: %v", err)
+		}
+		fmt.Printf("%s Created schema.yaml requiring %d variable(s)\n", ui.OK(), len(imported.Required))
+	}
+	for _, found := range imported.Environments {
+		fmt.Printf("%s Environment %s renders to %s\n", ui.OK(), found.Env, strings.Join(found.Targets, ", "))
+		for _, target := range found.Targets {
+			if abs, err := filepath.Abs(target); err == nil && env.Tracked(abs) {
+				fmt.Printf("%s %s is committed to git; untrack it: git rm --cached %s && echo %s >> .gitignore\n", ui.Warn(), target, target, target)
+			}
+		}
+	}
+
+	fmt.Println("\nNext steps:")
+	fmt.Println("  1. Add SSH public keys: envault add-key <public-key>")
+	step := 2
+	var sources []*scaffold.Found
+	for _, found := range imported.Environments {
+		if found.Source != "" {
+			sources = append(sources, found)
+		}
+	}
+	if len(sources) > 0 {
+		fmt.Printf("  %d. Encrypt the imported files:\n", step)
+		for _, found := range sources {
+			fmt.Printf("       envault encrypt %s %s\n", found.Env, found.Source)
+		}
+		step++
+	}
+	fmt.Printf("  %d. Commit: git add .envault && git commit -m 'chore: add envault'\n", step)
+}
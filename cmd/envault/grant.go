@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleGrant() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault grant <ssh-pubkey|key-file|github-user|fingerprint> --env <env,...> --until YYYY-MM-DD | list | remove <fingerprint>")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "list":
+		handleGrantList()
+	case "remove":
+		handleGrantRemove()
+	default:
+		handleGrantAdd()
+	}
+}
+
+// handleGrantAdd gives a key access to some environments until a date
+// without adding it to authorized_keys. Granting an existing grant's key
+// again, e.g. by its fingerprint, replaces its environments and date.
+func handleGrantAdd() {
+	fs := newFlagSet("grant", "envault grant <ssh-pubkey|key-file|github-user|fingerprint> --env <env,...> --until YYYY-MM-DD [--reason text]")
+	envList := fs.String("env", "", "comma-separated environments to grant")
+	until := fs.String("until", "", "last day of access, YYYY-MM-DD")
+	reason := fs.String("reason", "", "why access was granted, e.g. an incident number")
+	args := parseFlags(fs, os.Args[2:])
+
+	envNames := splitList(*envList)
+	if len(args) != 1 || len(envNames) == 0 || *until == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	grant := config.Grant{Environments: envNames, Until: *until, Reason: *reason}
+	if grant.Expired(time.Now()) {
+		if _, err := grant.UntilTime(); err != nil {
+			fatal("%v", err)
+		}
+		fatal("--until %s is already over", *until)
+	}
+
+	var backends []crypto.Backend
+	for _, envName := range envNames {
+		if _, err := cfg.GetEnvironment(envName); err != nil {
+			fatal("%v", err)
+		}
+		backend, err := crypto.BackendFor(cfg, envName)
+		if err != nil {
+			fatal("%v", err)
+		}
+		backends = append(backends, backend)
+	}
+
+	var recipients []keys.Key
+	if i := findGrant(cfg, args[0]); i >= 0 {
+		k, err := keys.ParseKey(cfg.Grants[i].Key)
+		if err != nil {
+			fatal("%v", err)
+		}
+		recipients = []keys.Key{*k}
+	} else if recipients, err = resolveRecipients(args[0], backends[0]); err != nil {
+		fatal("Failed to resolve recipient: %v", err)
+	}
+	for i, backend := range backends {
+		if err := crypto.ValidateRecipients(backend, recipients); err != nil {
+			fatal("%s: %v", envNames[i], err)
+		}
+	}
+
+	revoked, err := keys.LoadRevoked()
+	if err != nil {
+		fatal("%v", err)
+	}
+	if found := keys.FindRevoked(recipients, revoked); len(found) > 0 {
+		fatal("Key %s is revoked", found[0].Fingerprint)
+	}
+	authorizedKeys, _ := keys.Load()
+	for _, k := range recipients {
+		for _, a := range authorizedKeys {
+			if a.Fingerprint == k.Fingerprint {
+				fatal("Key %s is already in authorized_keys; a grant would not limit it", k.Fingerprint)
+			}
+		}
+	}
+
+	for _, k := range recipients {
+		grant.Key = k.Line()
+		verb := "Granted"
+		if i := findGrant(cfg, k.Fingerprint); i >= 0 {
+			cfg.Grants[i] = grant
+			verb = "Updated grant for"
+		} else {
+			cfg.Grants = append(cfg.Grants, grant)
+		}
+		fmt.Printf("%s %s %s: %s until the end of %s\n", ui.OK(), verb, k.String(), strings.Join(envNames, ", "), *until)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal("%v", err)
+	}
+	if err := cfg.Save(); err != nil {
+		fatal("Failed to save config: %v", err)
+	}
+
+	fmt.Println("\nNext steps:")
+	fmt.Printf("  1. Re-encrypt: envault reencrypt %s\n", envNames[0])
+	for _, envName := range envNames[1:] {
+		fmt.Printf("                 envault reencrypt %s\n", envName)
+	}
+	fmt.Println("  2. Commit: git add .envault/ && git commit -m 'chore: grant envault access'")
+	fmt.Println("  3. After the grant ends, envault check flags the environments and envault reencrypt drops the key")
+}
+
+func handleGrantList() {
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	if len(cfg.Grants) == 0 {
+		fmt.Println("No grants")
+		return
+	}
+
+	now := time.Now()
+	var rows [][]string
+	for _, g := range cfg.Grants {
+		fingerprint := "invalid key"
+		if k, err := keys.ParseKey(g.Key); err == nil {
+			fingerprint = k.Fingerprint
+		}
+		status := "active"
+		if g.Expired(now) {
+			status = "expired"
+		}
+		rows = append(rows, []string{fingerprint, strings.Join(g.Environments, ","), g.Until, status, g.Reason})
+	}
+	ui.Table(os.Stdout, ui.Out, []string{"KEY", "ENVIRONMENTS", "UNTIL", "STATUS", "REASON"}, rows)
+}
+
+// handleGrantRemove ends a grant early
+func handleGrantRemove() {
+	fs := newFlagSet("grant remove", "envault grant remove <fingerprint>")
+	args := parseFlags(fs, os.Args[3:])
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	i := findGrant(cfg, args[0])
+	if i < 0 {
+		fatal("No grant for %s", args[0])
+	}
+	grant := cfg.Grants[i]
+	cfg.Grants = append(cfg.Grants[:i], cfg.Grants[i+1:]...)
+	if err := cfg.Save(); err != nil {
+		fatal("Failed to save config: %v", err)
+	}
+	fmt.Printf("%s Removed grant for %s\n", ui.OK(), args[0])
+
+	fmt.Println("\nNext steps:")
+	fmt.Printf("  1. Re-encrypt so the key can no longer decrypt: envault reencrypt %s\n", grant.Environments[0])
+	for _, envName := range grant.Environments[1:] {
+		fmt.Printf("                                                  envault reencrypt %s\n", envName)
+	}
+	fmt.Println("  2. Commit: git add .envault/ && git commit -m 'chore: remove envault grant'")
+}
+
+// findGrant returns the index of the grant whose key has a fingerprint,
+// or -1
+func findGrant(cfg *config.Config, fingerprint string) int {
+	for i, g := range cfg.Grants {
+		if k, err := keys.ParseKey(g.Key); err == nil && k.Fingerprint == fingerprint {
+			return i
+		}
+	}
+	return -1
+}
+
+// pruneGrants drops environments that were just re-encrypted from expired
+// grants, and grants left with none, so config.yaml stops naming keys that
+// can no longer decrypt
+func pruneGrants(envNames []string) {
+	cfg, err := config.Load()
+	if err != nil || len(cfg.Grants) == 0 {
+		return
+	}
+
+	done := map[string]bool{}
+	for _, envName := range envNames {
+		done[envName] = true
+	}
+
+	now := time.Now()
+	changed := false
+	var kept []config.Grant
+	for _, g := range cfg.Grants {
+		if !g.Expired(now) {
+			kept = append(kept, g)
+			continue
+		}
+		var remaining []string
+		for _, envName := range g.Environments {
+			if done[envName] {
+				changed = true
+			} else {
+				remaining = append(remaining, envName)
+			}
+		}
+		if len(remaining) > 0 {
+			g.Environments = remaining
+			kept = append(kept, g)
+			continue
+		}
+		fingerprint := g.Key
+		if k, err := keys.ParseKey(g.Key); err == nil {
+			fingerprint = k.Fingerprint
+		}
+		fmt.Printf("%s Dropped grant for %s, which ended %s\n", ui.OK(), fingerprint, g.Until)
+	}
+	if !changed {
+		return
+	}
+	cfg.Grants = kept
+	if err := cfg.Save(); err != nil {
+		fatal("Failed to save config: %v", err)
+	}
+}
+
+// checkGrants reports grants for an environment whose key is not yet a
+// recipient, and expired grants whose key still is. It returns false when
+// an expired key can still decrypt.
+func checkGrants(cfg *config.Config, envName string) bool {
+	now := time.Now()
+	ok := true
+	for _, g := range cfg.Grants {
+		if !g.Covers(envName) {
+			continue
+		}
+		k, err := keys.ParseKey(g.Key)
+		if err != nil {
+			fmt.Printf("  %s Grant: %v\n", ui.Fail(), err)
+			ok = false
+			continue
+		}
+		// Only SSH recipients are identifiable from the header
+		found, err := crypto.KeysInHeader(envName, []keys.Key{*k})
+		if err != nil || k.SSHTag() == "" {
+			continue
+		}
+		switch {
+		case g.Expired(now) && len(found) > 0:
+			fmt.Printf("  %s Grant for %s ended %s but it can still decrypt - run: envault reencrypt %s\n", ui.Fail(), k.Fingerprint, g.Until, envName)
+			ok = false
+		case g.Expired(now):
+			fmt.Printf("  %s Grant for %s ended %s and it can no longer decrypt\n", ui.OK(), k.Fingerprint, g.Until)
+		case len(found) == 0:
+			fmt.Printf("  %s Grant for %s (until %s) is not a recipient yet - run: envault reencrypt %s\n", ui.Warn(), k.Fingerprint, g.Until, envName)
+		default:
+			fmt.Printf("  %s Grant for %s until %s\n", ui.OK(), k.Fingerprint, g.Until)
+		}
+	}
+	return ok
+}
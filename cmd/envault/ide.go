@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+
+	"github.com/orchard9/envault/internal/ide"
+)
+
+// handleIDEServer speaks JSON-RPC on stdin and stdout for editor
+// extensions until the editor disconnects
+func handleIDEServer() {
+	fs := newFlagSet("ide-server", "envault ide-server [--environment env]")
+	environment := fs.String("environment", "", "environment whose schema rules apply to buffers that no target matches")
+	parseFlags(fs, os.Args[2:])
+
+	if err := ide.Serve(os.Stdin, os.Stdout, version, *environment); err != nil {
+		fatal("ide-server: %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/identity"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleIdentity() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault identity import | unlock | lock | status | remove")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "import":
+		handleIdentityImport()
+	case "unlock":
+		handleIdentityUnlock()
+	case "lock":
+		handleIdentityLock()
+	case "status":
+		handleIdentityStatus()
+	case "remove":
+		handleIdentityRemove()
+	default:
+		fatal("unknown identity command %q (expected import, unlock, lock, status or remove)", os.Args[2])
+	}
+}
+
+// handleIdentityImport stores a private key encrypted under a passphrase,
+// so the raw key need not stay on disk
+func handleIdentityImport() {
+	fs := newFlagSet("identity import", "envault identity import --protect <private-key> [--keychain] [--remove-original] [--force]")
+	protect := fs.Bool("protect", false, "encrypt the key under a passphrase")
+	keychain := fs.Bool("keychain", false, "cache the passphrase in the OS keychain, so no unlock is needed")
+	removeOriginal := fs.Bool("remove-original", false, "delete the private key file once it is stored")
+	force := fs.Bool("force", false, "replace a stored identity")
+	args := parseFlags(fs, os.Args[3:])
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if !*protect {
+		fatal("identity import only stores protected keys; add --protect (an unprotected key can be used where it is, with ENVAULT_IDENTITY or a profile)")
+	}
+
+	if _, err := identity.Load(); err == nil && !*force {
+		fatal("A protected identity is already stored (use --force to replace it)")
+	} else if err != nil && err != identity.ErrNotStored && !*force {
+		fatal("%v", err)
+	}
+
+	keyPath, err := filepath.Abs(args[0])
+	if err != nil {
+		fatal("%v", err)
+	}
+	key, err := crypto.IdentityKey(keyPath)
+	if err != nil {
+		fatal("%v", err)
+	}
+	private, err := os.ReadFile(keyPath)
+	if err != nil {
+		fatal("Failed to read %s: %v", args[0], err)
+	}
+	defer secmem.Wipe(private)
+
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		fatal("%v", err)
+	}
+	sealed, err := identity.Seal(private, key.Line(), keyPath, passphrase)
+	if err != nil {
+		fatal("Failed to protect identity: %v", err)
+	}
+	if *keychain {
+		if err := identity.KeychainStore(passphrase); err != nil {
+			fmt.Printf("%s Passphrase not cached: %v\n", ui.Warn(), err)
+		} else {
+			sealed.Keychain = true
+		}
+	}
+	if err := sealed.Save(); err != nil {
+		fatal("%v", err)
+	}
+	storePath, _ := identity.Path()
+	fmt.Printf("%s Stored %s (%s) in %s\n", ui.OK(), key.Fingerprint, key.Type, storePath)
+
+	// The passphrase was just typed, so this session needs no unlock
+	identity.Lock()
+	if expires, err := identity.Unlock(private, sealed.PublicKey, identity.DefaultTTL); err != nil {
+		fmt.Printf("%s Not unlocked: %v\n", ui.Warn(), err)
+	} else {
+		fmt.Printf("%s Unlocked until %s\n", ui.OK(), expires.Format("2006-01-02 15:04"))
+	}
+	if sealed.Keychain {
+		fmt.Printf("%s Passphrase cached in the keychain\n", ui.OK())
+	}
+
+	if *removeOriginal {
+		if err := os.Remove(keyPath); err != nil {
+			fatal("Failed to remove %s: %v", args[0], err)
+		}
+		fmt.Printf("%s Removed %s\n", ui.OK(), args[0])
+	}
+
+	fmt.Println("\nNext steps:")
+	fmt.Println("  1. In a new session, run: envault identity unlock")
+	if !*removeOriginal {
+		fmt.Printf("  2. Remove the unprotected key once you have a backup: rm %s\n", args[0])
+	}
+}
+
+// handleIdentityUnlock opens the stored identity for this login session
+func handleIdentityUnlock() {
+	fs := newFlagSet("identity unlock", "envault identity unlock [--ttl 8h] [--keychain]")
+	ttl := fs.String("ttl", "8h", "how long the identity stays unlocked")
+	keychain := fs.Bool("keychain", false, "also cache the passphrase in the OS keychain")
+	parseFlags(fs, os.Args[3:])
+	duration := parseDurationFlag("ttl", *ttl)
+
+	sealed, err := identity.Load()
+	if err != nil {
+		fatal("%v", err)
+	}
+	passphrase, err := readPassphrase("Passphrase for the protected identity: ")
+	if err != nil {
+		fatal("%v", err)
+	}
+	private, err := sealed.Open(passphrase)
+	if err != nil {
+		fatal("%v", err)
+	}
+	expires, err := identity.Unlock(private, sealed.PublicKey, duration)
+	secmem.Wipe(private)
+	if err != nil {
+		fatal("%v", err)
+	}
+	fmt.Printf("%s Unlocked %s until %s\n", ui.OK(), publicKeyFingerprint(sealed.PublicKey), expires.Format("2006-01-02 15:04"))
+
+	if *keychain {
+		if err := identity.KeychainStore(passphrase); err != nil {
+			fatal("Failed to cache passphrase: %v", err)
+		}
+		sealed.Keychain = true
+		if err := sealed.Save(); err != nil {
+			fatal("%v", err)
+		}
+		fmt.Printf("%s Passphrase cached in the keychain\n", ui.OK())
+	}
+}
+
+// handleIdentityLock removes the session's unlocked key and, with
+// --forget, the keychain's passphrase
+func handleIdentityLock() {
+	fs := newFlagSet("identity lock", "envault identity lock [--forget]")
+	forget := fs.Bool("forget", false, "also remove the passphrase from the keychain")
+	parseFlags(fs, os.Args[3:])
+
+	removed, err := identity.Lock()
+	if err != nil {
+		fatal("%v", err)
+	}
+	if removed {
+		fmt.Printf("%s Locked\n", ui.OK())
+	} else {
+		fmt.Println("Not unlocked")
+	}
+
+	if *forget {
+		forgetKeychain()
+	} else if sealed, err := identity.Load(); err == nil && sealed.Keychain {
+		fmt.Printf("%s The keychain still holds the passphrase (use --forget to remove it)\n", ui.Warn())
+	}
+}
+
+func handleIdentityStatus() {
+	sealed, err := identity.Load()
+	if err != nil {
+		fatal("%v", err)
+	}
+	storePath, _ := identity.Path()
+
+	fmt.Printf("Protected identity: %s\n", storePath)
+	if key, err := keys.ParseKey(sealed.PublicKey); err == nil {
+		fmt.Printf("  Key:      %s\n", key.String())
+	}
+	if sealed.Source != "" {
+		source := sealed.Source
+		if fileExists(source) {
+			source += " (still on disk)"
+		}
+		fmt.Printf("  Imported: %s\n", source)
+	}
+	if _, expires, ok := identity.Unlocked(sealed.PublicKey); ok {
+		fmt.Printf("  State:    unlocked until %s\n", expires.Format("2006-01-02 15:04"))
+	} else {
+		fmt.Println("  State:    locked")
+	}
+	if sealed.Keychain {
+		fmt.Println("  Keychain: passphrase cached")
+	}
+}
+
+// handleIdentityRemove deletes the stored identity, its unlock and its
+// keychain entry
+func handleIdentityRemove() {
+	sealed, err := identity.Load()
+	if err != nil {
+		fatal("%v", err)
+	}
+	identity.Lock()
+	if sealed.Keychain {
+		forgetKeychain()
+	}
+	if err := identity.Remove(); err != nil {
+		fatal("%v", err)
+	}
+	fmt.Printf("%s Removed protected identity %s\n", ui.OK(), publicKeyFingerprint(sealed.PublicKey))
+	fmt.Println("Its key is still in authorized_keys; remove it there with envault remove-key if it is no longer used")
+}
+
+func forgetKeychain() {
+	if err := identity.KeychainDelete(); err != nil {
+		fmt.Printf("%s Keychain: %v\n", ui.Warn(), err)
+	} else {
+		fmt.Printf("%s Removed the passphrase from the keychain\n", ui.OK())
+	}
+	if sealed, err := identity.Load(); err == nil && sealed.Keychain {
+		sealed.Keychain = false
+		sealed.Save()
+	}
+}
+
+func publicKeyFingerprint(line string) string {
+	if key, err := keys.ParseKey(line); err == nil {
+		return key.Fingerprint
+	}
+	return "(unknown key)"
+}
+
+// readNewPassphrase asks for a new passphrase twice
+func readNewPassphrase() (string, error) {
+	if passphrase := os.Getenv(identity.PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	again, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != again {
+		return "", fmt.Errorf("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// readPassphrase reads a passphrase from the terminal without echoing it,
+// or takes it from ENVAULT_IDENTITY_PASSPHRASE
+func readPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(identity.PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("no terminal to ask for the passphrase (set %s)", identity.PassphraseEnv)
+	}
+	defer tty.Close()
+
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = tty
+		return cmd.Run()
+	}
+	if err := stty("-echo"); err != nil {
+		return "", fmt.Errorf("failed to turn off terminal echo: %w", err)
+	}
+	defer stty("echo")
+
+	fmt.Fprint(tty, prompt)
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase is empty")
+	}
+	return passphrase, nil
+}
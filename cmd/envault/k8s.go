@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/orchard9/envault/internal/ci"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/fsutil"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// k8sIdentityPath is where k8s-init looks for a private key mounted from a
+// Kubernetes Secret when no identity is configured
+const k8sIdentityPath = "/var/run/secrets/envault/identity"
+
+// handleK8sInit decrypts an environment into a directory and exits, for an
+// init container that fills an emptyDir volume shared with the app
+// containers. There is no daemon and no cluster API access.
+func handleK8sInit() {
+	fs := newFlagSet("k8s-init", "envault k8s-init <env> --out <dir> [--identity path] [--project dir] [--format files|env|both] [--mode 0440] [--owner uid:gid] [--tag t]")
+	out := fs.String("out", "", "directory to write, usually an emptyDir volume (required)")
+	identityPath := fs.String("identity", "", "private key to decrypt with (default: ENVAULT_IDENTITY, ENVAULT_IDENTITY_KEY or "+k8sIdentityPath+")")
+	project := fs.String("project", "", "directory holding .envault, e.g. a ConfigMap mounted at <dir>/.envault (default: current directory)")
+	format := fs.String("format", "files", "files: one file per variable; env: a single env file; both")
+	envFileName := fs.String("env-file", ".env", "name of the env file written by --format env or both")
+	mode := fs.String("mode", "0440", "permissions of the written files")
+	owner := fs.String("owner", "", "uid:gid to give the written files (needs root)")
+	tags := fs.String("tag", "", "comma-separated tags; only write variables carrying one of them")
+	args := parseFlags(fs, os.Args[2:])
+	if len(args) != 1 || *out == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName := args[0]
+
+	if *format != "files" && *format != "env" && *format != "both" {
+		fatal("Invalid --format %q (expected files, env or both)", *format)
+	}
+	perm, err := strconv.ParseUint(*mode, 8, 32)
+	if err != nil || perm > 0777 {
+		fatal("Invalid --mode %q (expected octal permissions such as 0440)", *mode)
+	}
+	uid, gid := -1, -1
+	if *owner != "" {
+		if uid, gid, err = parseOwner(*owner); err != nil {
+			fatal("Invalid --owner: %v", err)
+		}
+	}
+	if *format != "files" && (*envFileName == "" || filepath.Base(*envFileName) != *envFileName) {
+		fatal("Invalid --env-file %q (expected a file name)", *envFileName)
+	}
+
+	outDir, err := filepath.Abs(*out)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if *project != "" {
+		if err := os.Chdir(*project); err != nil {
+			fatal("Failed to enter --project: %v", err)
+		}
+	}
+
+	switch {
+	case *identityPath != "":
+		os.Setenv("ENVAULT_IDENTITY", *identityPath)
+	case os.Getenv("ENVAULT_IDENTITY") == "" && os.Getenv("ENVAULT_IDENTITY_KEY") == "" && os.Getenv(ci.PassphraseEnv) == "":
+		if fileExists(k8sIdentityPath) {
+			os.Setenv("ENVAULT_IDENTITY", k8sIdentityPath)
+		}
+	}
+
+	entries, err := env.Entries(envName, splitList(*tags))
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if err := os.MkdirAll(outDir, 0750); err != nil {
+		fatal("Failed to create %s: %v", outDir, err)
+	}
+	if tmpfs, known := onTmpfs(outDir); known && !tmpfs {
+		fmt.Fprintf(os.Stderr, "%s %s is not on tmpfs, so secrets reach the node's disk (use an emptyDir with medium: Memory)\n", ui.Warn(), outDir)
+	}
+
+	files := map[string][]byte{}
+	if *format != "env" {
+		for _, e := range entries {
+			value := e.Value
+			if dotenv.IsMultiline(value) && !strings.HasSuffix(value, "\n") {
+				value += "\n"
+			}
+			files[e.Key] = []byte(value)
+		}
+	}
+	if *format != "files" {
+		var b strings.Builder
+		for _, e := range entries {
+			fmt.Fprintf(&b, "%s=%s\n", e.Key, dotenv.Quote(e.Value))
+		}
+		if _, clash := files[*envFileName]; clash {
+			fatal("--env-file %s is also a variable name", *envFileName)
+		}
+		files[*envFileName] = []byte(b.String())
+	}
+
+	for name, data := range files {
+		path := filepath.Join(outDir, name)
+		if err := fsutil.WriteFileAtomic(path, data, os.FileMode(perm)); err != nil {
+			fatal("%v", err)
+		}
+		if uid >= 0 {
+			if err := os.Lchown(path, uid, gid); err != nil {
+				fatal("Failed to set the owner of %s: %v", path, err)
+			}
+		}
+	}
+
+	fmt.Printf("%s Wrote %d file(s) for %s to %s (mode %04o)\n", ui.OK(), len(files), envName, outDir, perm)
+}
+
+// parseOwner parses uid:gid
+func parseOwner(value string) (int, int, error) {
+	uidText, gidText, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("%q is not uid:gid", value)
+	}
+	uid, errUID := strconv.Atoi(uidText)
+	gid, errGID := strconv.Atoi(gidText)
+	if errUID != nil || errGID != nil || uid < 0 || gid < 0 {
+		return 0, 0, fmt.Errorf("%q is not numeric uid:gid", value)
+	}
+	return uid, gid, nil
+}
+
+// onTmpfs reports whether dir is on a tmpfs mount, from /proc/self/mounts.
+// known is false where that cannot be read.
+func onTmpfs(dir string) (tmpfs, known bool) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return false, false
+	}
+	defer f.Close()
+
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	best := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		// Mount points escape spaces as \040
+		mountPoint := strings.ReplaceAll(fields[1], `\040`, " ")
+		if dir != mountPoint && !strings.HasPrefix(dir, strings.TrimSuffix(mountPoint, "/")+"/") {
+			continue
+		}
+		if len(mountPoint) >= len(best) {
+			best = mountPoint
+			tmpfs = fields[2] == "tmpfs"
+		}
+	}
+	return tmpfs, best != ""
+}
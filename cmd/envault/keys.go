@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// mergeDriverName is the git merge driver registered by `keys setup-merge`
+const mergeDriverName = "envault-keys"
+
+func handleKeys() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault keys fmt [--check] | merge <base> <ours> <theirs> | setup-merge | bundle export|import | revocation verify|import")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "fmt":
+		handleKeysFmt()
+	case "merge":
+		handleKeysMerge()
+	case "setup-merge":
+		handleKeysSetupMerge()
+	case "bundle":
+		handleKeysBundle()
+	case "revocation":
+		handleKeysRevocation()
+	default:
+		fatal("unknown keys command %q (expected fmt, merge, setup-merge, bundle or revocation)", os.Args[2])
+	}
+}
+
+func handleKeysFmt() {
+	fs := newFlagSet("keys fmt", "envault keys fmt [--check]")
+	check := fs.Bool("check", false, "exit non-zero if authorized_keys is not in canonical form")
+	parseFlags(fs, os.Args[3:])
+
+	keysPath, err := keys.AuthorizedKeysPath()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	data, err := os.ReadFile(keysPath)
+	if err != nil {
+		fatal("Failed to read authorized_keys: %v", err)
+	}
+
+	formatted, err := keys.Format(data)
+	if err != nil {
+		fatal("Failed to parse authorized_keys: %v", err)
+	}
+
+	if bytes.Equal(data, formatted) {
+		fmt.Printf("%s authorized_keys is already formatted\n", ui.OK())
+		return
+	}
+	if *check {
+		fmt.Printf("%s authorized_keys is not formatted - run: envault keys fmt\n", ui.Fail())
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(keysPath, formatted, 0644); err != nil {
+		fatal("Failed to write authorized_keys: %v", err)
+	}
+	fmt.Printf("%s Formatted authorized_keys\n", ui.OK())
+}
+
+// handleKeysMerge is invoked by git as the merge driver: it merges the
+// three versions and leaves the result in the "ours" file
+func handleKeysMerge() {
+	if len(os.Args) != 6 {
+		fmt.Println("Usage: envault keys merge <base> <ours> <theirs>")
+		os.Exit(1)
+	}
+	basePath, oursPath, theirsPath := os.Args[3], os.Args[4], os.Args[5]
+
+	var versions [3][]byte
+	for i, path := range []string{basePath, oursPath, theirsPath} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fatal("Failed to read %s: %v", path, err)
+		}
+		versions[i] = data
+	}
+
+	merged, err := keys.Merge(versions[0], versions[1], versions[2])
+	if err != nil {
+		// A non-zero exit makes git fall back to a conflict
+		fatal("Cannot merge authorized_keys: %v", err)
+	}
+
+	if err := os.WriteFile(oursPath, merged, 0644); err != nil {
+		fatal("Failed to write %s: %v", oursPath, err)
+	}
+}
+
+func handleKeysSetupMerge() {
+	settings := [][]string{
+		{"merge." + mergeDriverName + ".name", "envault authorized_keys union merge"},
+		{"merge." + mergeDriverName + ".driver", "envault keys merge %O %A %B"},
+	}
+	for _, kv := range settings {
+		if out, err := exec.Command("git", "config", kv[0], kv[1]).CombinedOutput(); err != nil {
+			fatal("Failed to configure git: %v\n%s", err, out)
+		}
+	}
+	fmt.Printf("%s Registered git merge driver %s\n", ui.OK(), mergeDriverName)
+
+	attr := ".envault/authorized_keys merge=" + mergeDriverName
+	data, err := os.ReadFile(".gitattributes")
+	if err != nil && !os.IsNotExist(err) {
+		fatal("Failed to read .gitattributes: %v", err)
+	}
+	if strings.Contains(string(data), attr) {
+		fmt.Printf("%s .gitattributes already uses the merge driver\n", ui.OK())
+		return
+	}
+
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	data = append(data, attr+"\n"...)
+	if err := os.WriteFile(".gitattributes", data, 0644); err != nil {
+		fatal("Failed to write .gitattributes: %v", err)
+	}
+	fmt.Printf("%s Added %q to .gitattributes\n", ui.OK(), attr)
+
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Commit: git add .gitattributes && git commit -m 'chore: merge driver for authorized_keys'")
+	fmt.Println("  - Each clone runs once: envault keys setup-merge")
+}
+
+// keyRecord is the machine-readable form of an authorized key
+type keyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Type        string `json:"type"`
+	Key         string `json:"key"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+// writeKeys renders keys for use by other tooling
+func writeKeys(w io.Writer, format string, authorized []keys.Key) error {
+	switch format {
+	case "authorized_keys":
+		for _, k := range authorized {
+			fmt.Fprintln(w, k.Line())
+		}
+	case "age-recipients":
+		// Accepted by age -R; comments are kept as # lines
+		for _, k := range authorized {
+			if k.Comment != "" {
+				fmt.Fprintf(w, "# %s\n", k.Comment)
+			}
+			fmt.Fprintln(w, k.Recipient())
+		}
+	case "json":
+		records := make([]keyRecord, 0, len(authorized))
+		for _, k := range authorized {
+			records = append(records, keyRecord{Fingerprint: k.Fingerprint, Type: k.Type, Key: k.Recipient(), Comment: k.Comment})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"fingerprint", "type", "comment", "key"})
+		for _, k := range authorized {
+			cw.Write([]string{k.Fingerprint, k.Type, k.Comment, k.Recipient()})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format %q (use table, authorized_keys, age-recipients, json, or csv)", format)
+	}
+	return nil
+}
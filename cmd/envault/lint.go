@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/orchard9/envault/internal/lint"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleConfig() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault config lint [--fix]")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "lint":
+		handleConfigLint()
+	default:
+		fatal("unknown config command %q (expected lint)", os.Args[2])
+	}
+}
+
+func handleConfigLint() {
+	fs := newFlagSet("config lint", "envault config lint [--fix]")
+	fix := fs.Bool("fix", false, "make absolute target paths relative and add unignored targets to .gitignore")
+	parseFlags(fs, os.Args[3:])
+
+	project, err := lint.Load()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	diags, err := project.Run()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if *fix {
+		fixed, err := project.Fix(diags)
+		for _, d := range fixed {
+			fmt.Printf("%s Fixed [%s] %s\n", ui.OK(), d.Code, d)
+		}
+		if err != nil {
+			fatal("Failed to apply fixes: %v", err)
+		}
+
+		// Lint again so only what is left is reported
+		if diags, err = project.Run(); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	if len(diags) == 0 {
+		fmt.Printf("%s config.yaml has no lint findings\n", ui.OK())
+		return
+	}
+
+	fixable := 0
+	for _, d := range diags {
+		suffix := ""
+		if d.Fixable {
+			suffix = " (fixable)"
+			fixable++
+		}
+		fmt.Printf("%s [%s] %s%s\n", ui.Warn(), d.Code, d, suffix)
+	}
+
+	if fixable > 0 && !*fix {
+		fmt.Printf("\nRun envault config lint --fix to fix %d finding(s)\n", fixable)
+	}
+	os.Exit(1)
+}
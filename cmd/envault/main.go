@@ -1,20 +1,43 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/orchard9/envault/internal/ci"
 	"github.com/orchard9/envault/internal/config"
 	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
 	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/guard"
 	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/manifest"
+	"github.com/orchard9/envault/internal/migrate"
+	"github.com/orchard9/envault/internal/notify"
+	"github.com/orchard9/envault/internal/profile"
+	"github.com/orchard9/envault/internal/remote"
+	"github.com/orchard9/envault/internal/resolve"
+	"github.com/orchard9/envault/internal/scaffold"
+	"github.com/orchard9/envault/internal/schema"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/ui"
+	"github.com/orchard9/envault/internal/vault"
 )
 
-const version = "0.1.0"
+// version is overridden at release time with -ldflags "-X main.version=..."
+var version = "0.1.0"
 
 func main() {
+	os.Args = stripGlobalFlags(os.Args)
+
 	if len(os.Args) < 2 {
 		printUsage()
 		os.Exit(1)
@@ -22,9 +45,12 @@ func main() {
 
 	command := os.Args[1]
 
-	// Check if age is installed for crypto operations
-	if needsAge(command) {
-		if err := crypto.CheckAge(); err != nil {
+	// Keep decrypted secrets out of core dumps
+	secmem.Protect()
+
+	// Check that the configured crypto backends can run
+	if needsCrypto(command) {
+		if err := crypto.CheckAvailable(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -35,6 +61,8 @@ func main() {
 		handleInit()
 	case "dev", "staging", "prod":
 		handleLoadEnv(command)
+	case "load":
+		handleLoad()
 	case "add-key":
 		handleAddKey()
 	case "remove-key":
@@ -47,10 +75,102 @@ func main() {
 		handleDecrypt()
 	case "reencrypt":
 		handleReencrypt()
+	case "doctor":
+		handleDoctor()
 	case "check":
 		handleCheck()
+	case "approve-change":
+		handleApproveChange()
+	case "verify":
+		handleVerify()
+	case "session":
+		handleSession()
+	case "ide-server":
+		handleIDEServer()
+	case "remote-decrypt":
+		handleRemoteDecrypt()
+	case "pipe":
+		handlePipe()
+	case "apply":
+		handleApply()
+	case "__session":
+		handleSessionAgent()
+	case "k8s-init":
+		handleK8sInit()
+	case "docker":
+		handleDocker()
+	case "scan":
+		handleScan()
+	case "notes":
+		handleNotes()
+	case "config":
+		handleConfig()
+	case "ci":
+		handleCI()
+	case "vault":
+		handleVault()
+	case "env":
+		handleEnv()
+	case "sync":
+		handleSync()
+	case "review-diff":
+		handleReviewDiff()
+	case "identity":
+		handleIdentity()
+	case "profile":
+		handleProfile()
+	case "tokens":
+		handleTokens()
+	case "bot":
+		handleBot()
+	case "verify-content":
+		handleVerifyContent()
+	case "schema":
+		handleSchema()
+	case "subvault":
+		handleSubvault()
+	case "grant":
+		handleGrant()
+	case "make", "task":
+		handleRunner(command)
+	case "keys":
+		handleKeys()
+	case "export":
+		handleExport()
+	case "exec":
+		handleExec()
+	case "test-env":
+		handleTestEnv()
+	case "shell-init":
+		handleShellInit()
+	case "completion":
+		handleCompletion()
+	case "__complete":
+		handleComplete()
+	case "embed":
+		handleEmbed()
+	case "devcontainer":
+		handleDevcontainer()
+	case "share":
+		handleShare()
+	case "receive":
+		handleReceive()
+	case "status":
+		handleStatus()
+	case "unload":
+		handleUnload()
+	case "clean":
+		handleClean()
+	case "migrate":
+		handleMigrate()
+	case "serve":
+		handleServe()
+	case "agent":
+		handleAgent()
 	case "version", "--version", "-v":
-		fmt.Printf("envault version %s\n", version)
+		handleVersion()
+	case "upgrade":
+		handleUpgrade()
 	case "help", "--help", "-h":
 		printUsage()
 	default:
@@ -61,6 +181,37 @@ func main() {
 }
 
 func handleInit() {
+	fs := newFlagSet("init", "envault init [--template <path|git-url[#subdir]>] [--from-dotenv [--yes]] [--layout flat|nested] [--private]")
+	templateSrc := fs.String("template", "", "bootstrap config.yaml, schema.yaml and placeholder environments from a template")
+	fromDotenv := fs.Bool("from-dotenv", false, "detect existing .env files, .env.example and docker compose env_file references and offer to import them")
+	yes := fs.Bool("yes", false, "with --from-dotenv, accept everything detected without asking")
+	layout := fs.String("layout", config.LayoutFlat, "where encrypted files live: flat (<env>.age) or nested (<env>/secrets.age)")
+	private := fs.Bool("private", false, "keep .envault from other local users: directories 0700, files 0600")
+	parseFlags(fs, os.Args[2:])
+
+	// Check the layout before anything is created
+	cfg, err := config.DefaultConfigWithLayout(*layout)
+	if err != nil {
+		fatal("%v", err)
+	}
+	cfg.Private = *private
+	if *templateSrc != "" && *layout != config.LayoutFlat {
+		fatal("--layout cannot be combined with --template (the template's config.yaml sets the layout)")
+	}
+	if *fromDotenv && *templateSrc != "" {
+		fatal("--from-dotenv cannot be combined with --template")
+	}
+
+	// Fetch the template first so a bad URL leaves no half-created .envault
+	templateDir, cleanupTemplate := "", func() {}
+	if *templateSrc != "" {
+		dir, cleanup, err := scaffold.Fetch(*templateSrc)
+		if err != nil {
+			fatal("Failed to fetch template: %v", err)
+		}
+		templateDir, cleanupTemplate = dir, cleanup
+	}
+
 	envaultDir, err := config.EnvaultDir()
 	if err != nil {
 		fatal("Failed to determine .envault directory: %v", err)
@@ -71,15 +222,46 @@ func handleInit() {
 		fatal(".envault directory already exists")
 	}
 
+	// Ask about existing dotenv files before anything is created
+	var imported *dotenvImport
+	if *fromDotenv {
+		imported = detectDotenv(cfg, *layout, *yes)
+	}
+
 	// Create .envault directory
-	if err := os.MkdirAll(envaultDir, 0755); err != nil {
+	if err := os.MkdirAll(envaultDir, cfg.DirMode()); err != nil {
 		fatal("Failed to create .envault directory: %v", err)
 	}
 
-	// Create default config.yaml
-	cfg := config.DefaultConfig()
-	if err := cfg.Save(); err != nil {
-		fatal("Failed to create config.yaml: %v", err)
+	// Create config.yaml from the template, or the default configuration
+	var result *scaffold.Result
+	if templateDir != "" {
+		result, err = scaffold.Apply(templateDir, envaultDir)
+		cleanupTemplate()
+		if err != nil {
+			os.RemoveAll(envaultDir)
+			fatal("Failed to apply template: %v", err)
+		}
+		if *private {
+			templateCfg, err := config.LoadDir(envaultDir)
+			if err != nil {
+				fatal("%v", err)
+			}
+			templateCfg.Private = true
+			if err := templateCfg.SaveDir(envaultDir); err != nil {
+				fatal("%v", err)
+			}
+		}
+	} else {
+		if err := cfg.Save(); err != nil {
+			fatal("Failed to create config.yaml: %v", err)
+		}
+		// Nested layouts keep each environment in its own directory
+		for _, env := range cfg.Environments {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(envaultDir, env.EncryptedFile)), cfg.DirMode()); err != nil {
+				fatal("Failed to create environment directory: %v", err)
+			}
+		}
 	}
 
 	// Create empty authorized_keys file
@@ -88,20 +270,56 @@ func handleInit() {
 		fatal("Failed to determine authorized_keys path: %v", err)
 	}
 
-	if err := os.WriteFile(keysPath, []byte(""), 0644); err != nil {
+	if err := os.WriteFile(keysPath, []byte(""), cfg.FileMode()); err != nil {
 		fatal("Failed to create authorized_keys: %v", err)
 	}
 
 	// Create .gitignore to ignore plaintext files
 	gitignorePath := filepath.Join(envaultDir, ".gitignore")
-	gitignoreContent := "*.plaintext\n*.plain\n*.decrypted\n"
-	if err := os.WriteFile(gitignorePath, []byte(gitignoreContent), 0644); err != nil {
+	gitignoreContent := "*.plaintext\n*.plain\n*.decrypted\nstate.json\n"
+	if err := os.WriteFile(gitignorePath, []byte(gitignoreContent), cfg.FileMode()); err != nil {
 		fatal("Failed to create .gitignore: %v", err)
 	}
 
-	fmt.Println("✓ Initialized .envault directory")
-	fmt.Println("✓ Created config.yaml with default configuration")
-	fmt.Println("✓ Created authorized_keys file")
+	// Templates bring their own files, written with the usual modes
+	if *private {
+		if err := restrictTree(envaultDir); err != nil {
+			fatal("Failed to restrict .envault: %v", err)
+		}
+		fmt.Printf("%s Initialized private .envault directory (0700, files 0600)\n", ui.OK())
+	} else {
+		fmt.Printf("%s Initialized .envault directory\n", ui.OK())
+	}
+	if result != nil {
+		fmt.Printf("%s Created config.yaml from template %s\n", ui.OK(), *templateSrc)
+		if result.Schema {
+			fmt.Printf("%s Created schema.yaml from template\n", ui.OK())
+		}
+	} else if imported != nil {
+		fmt.Printf("%s Created config.yaml from the detected files\n", ui.OK())
+	} else {
+		fmt.Printf("%s Created config.yaml with default configuration\n", ui.OK())
+	}
+	fmt.Printf("%s Created authorized_keys file\n", ui.OK())
+
+	if imported != nil {
+		finishDotenvImport(imported)
+		return
+	}
+
+	if result != nil && len(result.Placeholders) > 0 {
+		fmt.Println("\nPlaceholder environments (gitignored, fill in then encrypt):")
+		for _, name := range result.Placeholders {
+			fmt.Printf("  - .envault/%s\n", name)
+		}
+		fmt.Println("\nNext steps:")
+		fmt.Println("  1. Add SSH public keys: envault add-key <public-key>")
+		fmt.Println("  2. Fill in placeholder values")
+		fmt.Println("  3. Encrypt each: envault encrypt <env> .envault/<env>.plaintext")
+		fmt.Println("  4. Delete the plaintext files and commit: git add .envault && git commit -m 'chore: add envault'")
+		return
+	}
+
 	fmt.Println("\nNext steps:")
 	fmt.Println("  1. Add SSH public keys: envault add-key <public-key>")
 	fmt.Println("  2. Create plaintext secrets file")
@@ -110,7 +328,31 @@ func handleInit() {
 }
 
 func handleLoadEnv(envName string) {
-	if err := env.Load(envName); err != nil {
+	fs := newFlagSet(envName, "envault "+envName+" [--force] [--as-file KEY,...] [--dry-run]")
+	force := fs.Bool("force", false, "overwrite targets with local changes")
+	asFile := fs.String("as-file", "", "comma-separated variables to write to their own files, passing the path instead")
+	dryRun := dryRunFlag(fs)
+	parseFlags(fs, os.Args[2:])
+
+	warnDeprecated(envName)
+
+	opts := env.Options{Force: *force, Confirm: confirmOverwrite, AsFile: splitList(*asFile)}
+	if *dryRun {
+		cfg, err := config.Load()
+		if err != nil {
+			fatal("Failed to load config: %v", err)
+		}
+		ok := planLoad(cfg, envName, opts)
+		if ok {
+			planLine("record the targets in .envault/state.json")
+		}
+		endDryRun()
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	if err := env.LoadWith(envName, opts); err != nil {
 		fatal("Failed to load %s environment: %v", envName, err)
 	}
 
@@ -119,18 +361,193 @@ func handleLoadEnv(envName string) {
 		fatal("Failed to list targets: %v", err)
 	}
 
-	fmt.Printf("✓ Loaded %s secrets to:\n", envName)
+	fmt.Printf("%s Loaded %s secrets to:\n", ui.OK(), envName)
 	for _, target := range targets {
 		fmt.Printf("  - %s\n", target)
 	}
 }
 
+// handleLoad renders several environments at once. All of them are
+// decrypted up front in one crypto session, so identities are unlocked
+// once and age runs concurrently; targets are then written one
+// environment at a time.
+func handleLoad() {
+	fs := newFlagSet("load", "envault load <env...> | --all | --auto [--force] [--as-file KEY,...] [--dry-run]")
+	all := fs.Bool("all", false, "load every environment in config.yaml")
+	auto := fs.Bool("auto", false, "load the environment mapped to the current git branch (branches: in config.yaml)")
+	force := fs.Bool("force", false, "overwrite targets with local changes")
+	asFile := fs.String("as-file", "", "comma-separated variables to write to their own files, passing the path instead")
+	dryRun := dryRunFlag(fs)
+	envNames := parseFlags(fs, os.Args[2:])
+
+	if *auto {
+		if *all || len(envNames) > 0 {
+			fatal("--auto cannot be combined with --all or environment names")
+		}
+		envNames = []string{autoEnvironment()}
+	}
+
+	// Without arguments, the profile's default environments
+	p := profile.Current()
+	if !*all && len(envNames) == 0 && p != nil {
+		envNames = p.DefaultEnvironments
+	}
+	if *all == (len(envNames) > 0) {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	if *all {
+		for envName := range cfg.Environments {
+			envNames = append(envNames, envName)
+		}
+		sort.Strings(envNames)
+	}
+	for _, envName := range envNames {
+		if _, err := cfg.GetEnvironment(envName); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	opts := env.Options{Force: *force, Confirm: confirmOverwrite, AsFile: splitList(*asFile)}
+	if *dryRun {
+		failed := 0
+		for _, envName := range envNames {
+			warnDeprecated(envName)
+			if !planLoad(cfg, envName, opts) {
+				failed++
+			}
+		}
+		if failed < len(envNames) {
+			planLine("record the targets in .envault/state.json")
+		}
+		endDryRun()
+		if failed > 0 {
+			fatal("%d of %d environments would fail to load", failed, len(envNames))
+		}
+		return
+	}
+
+	// A profile with cache: false decrypts each environment as it loads
+	session := crypto.OpenSession()
+	defer session.Close()
+	decryptErrs := map[string]error{}
+	if p.Caches() {
+		decryptErrs = session.Prewarm(envNames)
+	}
+
+	failed := 0
+	for _, envName := range envNames {
+		if err := decryptErrs[envName]; err != nil {
+			fmt.Printf("%s %s: failed to decrypt: %v\n", ui.Fail(), envName, err)
+			failed++
+			continue
+		}
+		warnDeprecated(envName)
+		if err := env.LoadWith(envName, opts); err != nil {
+			fmt.Printf("%s %s: %v\n", ui.Fail(), envName, err)
+			failed++
+			continue
+		}
+
+		targets, err := env.ListTargets(envName)
+		if err != nil {
+			fmt.Printf("%s %s: failed to list targets: %v\n", ui.Fail(), envName, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s Loaded %s secrets to:\n", ui.OK(), envName)
+		for _, target := range targets {
+			fmt.Printf("  - %s\n", target)
+		}
+	}
+
+	if failed > 0 {
+		session.Close()
+		fatal("%d of %d environments failed to load", failed, len(envNames))
+	}
+}
+
+// confirmOverwrite asks on the terminal before clobbering a hand-edited target
+func confirmOverwrite(targetPath string) bool {
+	if !ui.Out.TTY || !isTerminal(os.Stdin) {
+		return false
+	}
+	fmt.Printf("%s %s has local changes. Overwrite? [y/N] ", ui.Warn(), targetPath)
+	return readYes()
+}
+
 func handleAddKey() {
-	if len(os.Args) < 3 {
-		fatal("Usage: envault add-key <public-key-or-file>")
+	fs := newFlagSet("add-key", "envault add-key <public-key-or-file> | --github <user> | --gitlab <user> | --gitea <user> [--host <host>] [--comment <text>] [--root name] [--dry-run]")
+	github := fs.String("github", "", "import the keys of a GitHub user")
+	gitlab := fs.String("gitlab", "", "import the keys of a GitLab user (token: ENVAULT_GITLAB_TOKEN)")
+	gitea := fs.String("gitea", "", "import the keys of a Gitea user (token: ENVAULT_GITEA_TOKEN)")
+	host := fs.String("host", "", "GitLab or Gitea host (default gitlab.com)")
+	comment := fs.String("comment", "", "replace the key comment, e.g. with the owner's email for key_comment_patterns")
+	root := fs.String("root", "", "add to the authorized_keys of a root in config.yaml instead of .envault's")
+	dryRun := dryRunFlag(fs)
+	args := parseFlags(fs, os.Args[2:])
+	keysPath := rootKeysPath(*root)
+
+	var imported []keys.Key
+	var err error
+	switch {
+	case *github != "":
+		imported, err = keys.FromGitHub(*github)
+	case *gitlab != "":
+		imported, err = keys.FromGitLab(*host, *gitlab)
+	case *gitea != "":
+		imported, err = keys.FromGitea(*host, *gitea)
+	}
+	if err != nil {
+		fatal("Failed to import keys: %v", err)
+	}
+
+	if imported != nil {
+		added := 0
+		for _, k := range imported {
+			if *comment != "" {
+				k.Comment = *comment
+			}
+			if *dryRun {
+				if _, err := keys.CheckAdd(keysPath, k.Line()); err != nil {
+					fmt.Printf("%s Would skip %s: %v\n", ui.Warn(), k.Fingerprint, err)
+					continue
+				}
+				planLine("add %s to %s", k.String(), displayPath(keysPath))
+				planNotify(notify.OpAddKey, k.Fingerprint)
+				added++
+				continue
+			}
+			if err := keys.AddTo(keysPath, k.Line()); err != nil {
+				fmt.Printf("%s Skipped %s: %v\n", ui.Warn(), k.Fingerprint, err)
+				continue
+			}
+			fmt.Printf("%s Added %s\n", ui.OK(), k.String())
+			sendNotification(notify.Event{Operation: notify.OpAddKey, Key: k.Fingerprint})
+			added++
+		}
+		if added == 0 {
+			fatal("no new keys were added")
+		}
+		if *dryRun {
+			endDryRun()
+			return
+		}
+		printAddKeyNextSteps()
+		return
+	}
+
+	if len(args) < 1 {
+		fs.Usage()
+		os.Exit(1)
 	}
 
-	keyArg := os.Args[2]
+	keyArg := args[0]
 
 	// Check if it's a file path
 	var keyString string
@@ -142,156 +559,1357 @@ func handleAddKey() {
 		keyString = strings.TrimSpace(string(data))
 	} else {
 		// Treat as raw key string (allow multi-word input)
-		keyString = strings.Join(os.Args[2:], " ")
+		keyString = strings.Join(args, " ")
+	}
+	if *comment != "" {
+		if k, err := keys.ParseKey(keyString); err == nil {
+			k.Comment = *comment
+			keyString = k.Line()
+		}
+	}
+
+	if *dryRun {
+		k, err := keys.CheckAdd(keysPath, keyString)
+		if err != nil {
+			fatal("Failed to add key: %v", err)
+		}
+		planLine("add %s to %s", k.String(), displayPath(keysPath))
+		planNotify(notify.OpAddKey, k.Fingerprint)
+		endDryRun()
+		return
 	}
 
-	if err := keys.Add(keyString); err != nil {
+	if err := keys.AddTo(keysPath, keyString); err != nil {
 		fatal("Failed to add key: %v", err)
 	}
 
-	fmt.Println("✓ Added SSH public key")
+	fmt.Printf("%s Added public key\n", ui.OK())
+	if k, err := keys.ParseKey(keyString); err == nil && k.Plugin() != "" {
+		if _, err := crypto.FindPlugin(k.Plugin()); err != nil {
+			fmt.Printf("%s %v; install it before encrypting\n", ui.Warn(), err)
+		}
+	}
+	if k, err := keys.ParseKey(keyString); err == nil {
+		sendNotification(notify.Event{Operation: notify.OpAddKey, Key: k.Fingerprint})
+	}
+	printAddKeyNextSteps()
+}
+
+// rootKeysPath returns the authorized_keys of a root given to --root, or
+// .envault's for ""
+func rootKeysPath(root string) string {
+	if root == "" {
+		keysPath, err := keys.AuthorizedKeysPath()
+		if err != nil {
+			fatal("%v", err)
+		}
+		return keysPath
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	keysPath, err := keys.RootKeysPath(cfg, root)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if info, err := os.Stat(filepath.Dir(keysPath)); err != nil || !info.IsDir() {
+		fatal("Root %s directory %s does not exist", root, filepath.Dir(keysPath))
+	}
+	return keysPath
+}
+
+func printAddKeyNextSteps() {
 	fmt.Println("\nNext steps:")
 	fmt.Println("  - Encrypt/re-encrypt environments: envault encrypt <env> <file>")
 	fmt.Println("  - Or re-encrypt existing: envault reencrypt <env>")
 }
 
 func handleRemoveKey() {
-	if len(os.Args) < 3 {
-		fatal("Usage: envault remove-key <fingerprint>")
+	fs := newFlagSet("remove-key", "envault remove-key <fingerprint> [--revoke] [--revoke-cert file [--reason text]] [--root name] [--dry-run]")
+	revoke := fs.Bool("revoke", false, "also add the key to revoked_keys so it can never be re-added")
+	revokeCert := fs.String("revoke-cert", "", "also write a revocation certificate signed with your SSH key (implies --revoke)")
+	reason := fs.String("reason", "", "reason recorded in the revocation certificate")
+	root := fs.String("root", "", "remove from the authorized_keys of a root in config.yaml instead of .envault's")
+	dryRun := dryRunFlag(fs)
+	args := parseFlags(fs, os.Args[2:])
+
+	if len(args) < 1 {
+		fatal("Usage: envault remove-key <fingerprint> [--revoke] [--revoke-cert file [--reason text]] [--root name] [--dry-run]")
+	}
+	if *reason != "" && *revokeCert == "" {
+		fatal("--reason is recorded in the certificate; use it with --revoke-cert")
+	}
+	if *revokeCert != "" {
+		*revoke = true
+	}
+
+	fingerprint := args[0]
+	keysPath := rootKeysPath(*root)
+
+	// Keys from key_sources are managed where they are listed
+	var shared []string
+	if *root == "" {
+		if k := sourcedKey(fingerprint); k != nil {
+			if k.Source != keys.LocalSource {
+				fatal("Failed to remove key: %s comes from key source %s; remove it there", fingerprint, k.Source)
+			}
+			shared = k.Also
+		}
+	}
+
+	if *dryRun {
+		planRemoveKey(keysPath, fingerprint, *revoke, *revokeCert, shared)
+		return
 	}
 
-	fingerprint := os.Args[2]
+	// Sign first, so a key is never removed without its certificate
+	if *revokeCert != "" {
+		existing, err := keys.LoadFile(keysPath)
+		if err != nil {
+			fatal("Failed to remove key: %v", err)
+		}
+		found := false
+		for _, k := range existing {
+			if k.Fingerprint == fingerprint {
+				writeRevocationCert(*revokeCert, k, *reason)
+				found = true
+				break
+			}
+		}
+		if !found {
+			fatal("Failed to remove key: key with fingerprint %s not found", fingerprint)
+		}
+	}
 
-	if err := keys.Remove(fingerprint); err != nil {
+	removed, err := keys.RemoveKeyFrom(keysPath, fingerprint)
+	if err != nil {
 		fatal("Failed to remove key: %v", err)
 	}
 
-	fmt.Println("✓ Removed SSH public key")
+	fmt.Printf("%s Removed SSH public key\n", ui.OK())
+	sendNotification(notify.Event{Operation: notify.OpRemoveKey, Key: removed.Fingerprint})
+
+	if *revoke {
+		if err := keys.Revoke(*removed); err != nil {
+			fatal("Failed to revoke key: %v", err)
+		}
+		fmt.Printf("%s Added %s to revoked_keys\n", ui.OK(), removed.Fingerprint)
+	}
+
+	// Usage history only matters for current recipients
+	if m, err := manifest.Load(); err == nil {
+		if _, ok := m.LastUsed(removed.Fingerprint); ok {
+			delete(m.Keys, removed.Fingerprint)
+			if err := m.Save(); err != nil {
+				fmt.Printf("%s Failed to update manifest.json: %v\n", ui.Warn(), err)
+			}
+		}
+	}
+
+	warnRemovedKey(removed.Fingerprint)
+	warnSharedKey(removed.Fingerprint, shared)
+
 	fmt.Println("\nIMPORTANT: Re-encrypt all environments to revoke access:")
 	fmt.Println("  envault reencrypt")
 }
 
-func handleListKeys() {
-	authorizedKeys, err := keys.Load()
+// planRemoveKey prints what remove-key would change
+func planRemoveKey(keysPath, fingerprint string, revoke bool, revokeCert string, shared []string) {
+	existing, err := keys.LoadFile(keysPath)
 	if err != nil {
-		fatal("Failed to load keys: %v", err)
+		fatal("Failed to remove key: %v", err)
 	}
-
-	if len(authorizedKeys) == 0 {
-		fmt.Println("No authorized keys found")
-		fmt.Println("\nAdd keys with: envault add-key <public-key>")
-		return
+	var removed *keys.Key
+	for i := range existing {
+		if existing[i].Fingerprint == fingerprint {
+			removed = &existing[i]
+		}
+	}
+	if removed == nil {
+		fatal("Failed to remove key: key with fingerprint %s not found", fingerprint)
 	}
 
-	fmt.Printf("Authorized keys (%d):\n", len(authorizedKeys))
-	for i, key := range authorizedKeys {
-		fmt.Printf("  %d. %s\n", i+1, key.String())
+	planLine("remove %s from %s", removed.String(), displayPath(keysPath))
+	if revoke {
+		planLine("add %s to .envault/revoked_keys", fingerprint)
+	}
+	if revokeCert != "" {
+		_, signer := localSigner()
+		planLine("write a revocation certificate to %s, signed by %s", revokeCert, signer.Fingerprint)
+	}
+	if m, err := manifest.Load(); err == nil {
+		if _, ok := m.LastUsed(fingerprint); ok {
+			planLine("drop its usage history from .envault/manifest.json")
+		}
 	}
+	planNotify(notify.OpRemoveKey, fingerprint)
+	warnRemovedKey(fingerprint)
+	warnSharedKey(fingerprint, shared)
+	endDryRun()
 }
 
-func handleEncrypt() {
-	if len(os.Args) < 4 {
-		fatal("Usage: envault encrypt <environment> <plaintext-file>")
+// sourcedKey finds a key among the default root's merged recipients, or
+// returns nil
+func sourcedKey(fingerprint string) *keys.Sourced {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil
 	}
-
-	envName := os.Args[2]
-	plaintextPath := os.Args[3]
-
-	if err := crypto.EncryptFile(envName, plaintextPath); err != nil {
-		fatal("Failed to encrypt: %v", err)
+	sourced, err := keys.LoadSourced(cfg)
+	if err != nil {
+		return nil
 	}
+	for i := range sourced {
+		if sourced[i].Fingerprint == fingerprint {
+			return &sourced[i]
+		}
+	}
+	return nil
+}
 
-	fmt.Printf("✓ Encrypted %s to .envault/%s\n", plaintextPath, envName)
-	fmt.Println("\nNext steps:")
-	fmt.Println("  - Test decryption: envault decrypt", envName)
-	fmt.Println("  - Commit: git add .envault && git commit -m 'chore: update secrets'")
+// warnSharedKey warns that a key removed from authorized_keys is still a
+// recipient through key_sources
+func warnSharedKey(fingerprint string, shared []string) {
+	if len(shared) == 0 {
+		return
+	}
+	fmt.Printf("%s %s is still listed in key source %s and stays a recipient until removed there\n", ui.Warn(), fingerprint, strings.Join(shared, ", "))
 }
 
-func handleDecrypt() {
-	if len(os.Args) < 3 {
-		fatal("Usage: envault decrypt <environment>")
+// warnRemovedKey warns when a removed key is one encryption requires
+func warnRemovedKey(fingerprint string) {
+	cfg, err := config.Load()
+	if err != nil {
+		return
+	}
+	if cfg.IsRecoveryKey(fingerprint) {
+		fmt.Printf("%s %s is a recovery key - environments with require_recovery_key will refuse to encrypt until another is added\n", ui.Warn(), fingerprint)
+	}
+	if pinned := cfg.PinnedBy(fingerprint); len(pinned) > 0 {
+		fmt.Printf("%s %s is pinned by %s - encrypt will fail there until it is removed from pinned_recipients\n", ui.Warn(), fingerprint, strings.Join(pinned, ", "))
 	}
+}
 
-	envName := os.Args[2]
+func handleListKeys() {
+	fs := newFlagSet("list-keys", "envault list-keys [--format table|authorized_keys|age-recipients|json|csv] [--root name] [--porcelain]")
+	format := fs.String("format", "table", "output format: table, authorized_keys, age-recipients, json, csv")
+	root := fs.String("root", "", "list the authorized_keys of a root in config.yaml instead of .envault's")
+	porcelain := porcelainFlag(fs)
+	parseFlags(fs, os.Args[2:])
+	if *porcelain && *format != "table" {
+		fatal("--porcelain cannot be combined with --format")
+	}
 
-	if err := crypto.DecryptToWriter(envName, os.Stdout); err != nil {
-		fatal("Failed to decrypt: %v", err)
+	sourced, layered := listedKeys(*root)
+	authorizedKeys := make([]keys.Key, 0, len(sourced))
+	for _, k := range sourced {
+		authorizedKeys = append(authorizedKeys, k.Key)
 	}
-}
 
-func handleReencrypt() {
-	// If no environment specified, re-encrypt all
-	if len(os.Args) < 3 {
-		envs, err := crypto.ReencryptAll()
-		if err != nil {
-			// Check if we partially succeeded
-			if len(envs) > 0 {
-				fmt.Printf("✓ Re-encrypted: %s\n", strings.Join(envs, ", "))
-			}
-			fatal("Failed to reencrypt all: %v", err)
+	if *porcelain {
+		startPorcelain()
+		for _, key := range sourced {
+			porcelainRecord("key", key.Fingerprint, key.Type, key.Comment, key.Source, key.Trust)
 		}
+		return
+	}
 
-		fmt.Printf("✓ Re-encrypted all environments with current authorized_keys:\n")
-		for _, env := range envs {
-			fmt.Printf("  - %s\n", env)
+	if *format != "table" {
+		if err := writeKeys(os.Stdout, *format, authorizedKeys); err != nil {
+			fatal("%v", err)
 		}
 		return
 	}
 
-	// Re-encrypt specific environment
-	envName := os.Args[2]
+	if len(authorizedKeys) == 0 {
+		fmt.Println("No authorized keys found")
+		fmt.Println("\nAdd keys with: envault add-key <public-key>")
+		return
+	}
 
-	if err := crypto.Reencrypt(envName); err != nil {
-		fatal("Failed to reencrypt: %v", err)
+	var rows [][]string
+	for i, key := range sourced {
+		row := []string{key.Fingerprint, key.Type, key.Comment}
+		if layered {
+			source := key.Source
+			if len(key.Also) > 0 {
+				source += " (also " + strings.Join(key.Also, ", ") + ")"
+			}
+			row = append(row, source, key.Trust)
+		}
+		if ui.Out.TTY {
+			row = append([]string{fmt.Sprintf("%d.", i+1)}, row...)
+		}
+		rows = append(rows, row)
 	}
 
-	fmt.Printf("✓ Re-encrypted %s with current authorized_keys\n", envName)
+	if ui.Out.TTY {
+		fmt.Printf("Authorized keys (%d):\n", len(authorizedKeys))
+	}
+	header := []string{"#", "FINGERPRINT", "TYPE", "COMMENT"}
+	if layered {
+		header = append(header, "SOURCE", "TRUST")
+	}
+	ui.Table(os.Stdout, ui.Out, header, rows)
 }
 
-func handleCheck() {
+// listedKeys loads the keys list-keys shows: a root's authorized_keys, or
+// for the default root the merged key_sources. layered reports whether
+// config.yaml has key_sources, so the table needs its source columns.
+func listedKeys(root string) (sourced []keys.Sourced, layered bool) {
+	if root != "" {
+		list, err := keys.LoadFile(rootKeysPath(root))
+		if err != nil {
+			fatal("Failed to load keys: %v", err)
+		}
+		for _, k := range list {
+			sourced = append(sourced, keys.Sourced{Key: k, Source: keys.LocalSource, Trust: config.LocalKeyTrust})
+		}
+		return sourced, false
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		fatal("Failed to load config: %v", err)
 	}
-
-	fmt.Println("Checking envault configuration...\n")
-
-	// Check authorized keys
-	authorizedKeys, err := keys.Load()
+	sourced, err = keys.LoadSourced(cfg)
 	if err != nil {
-		fmt.Printf("✗ Failed to load authorized_keys: %v\n", err)
-	} else {
-		fmt.Printf("✓ Authorized keys: %d\n", len(authorizedKeys))
+		fatal("Failed to load keys: %v", err)
 	}
+	return sourced, len(cfg.KeySources) > 0
+}
 
-	// Check each environment
-	for envName := range cfg.Environments {
-		fmt.Printf("\nEnvironment: %s\n", envName)
+func handleEncrypt() {
+	fs := newFlagSet("encrypt", "envault encrypt <environment> <plaintext-file|-> [--skip-validation] [--stream] [--force] [--dry-run]")
+	skipValidation := fs.Bool("skip-validation", false, "encrypt even if values violate schema.yaml or variable names conflict")
+	stream := fs.Bool("stream", false, "encrypt an opaque payload without loading it into memory (automatic for input over 8 MiB when the environment already holds one)")
+	force := fs.Bool("force", false, "encrypt input that looks like a private key, a credential file or an unexpected payload")
+	dryRun := dryRunFlag(fs)
+	args := parseFlags(fs, os.Args[2:])
 
-		// Check if encrypted file exists
-		envaultDir, _ := config.EnvaultDir()
-		env, _ := cfg.GetEnvironment(envName)
-		encryptedPath := filepath.Join(envaultDir, env.EncryptedFile)
+	if len(args) < 2 {
+		fatal("Usage: envault encrypt <environment> <plaintext-file|->")
+	}
 
-		if _, err := os.Stat(encryptedPath); os.IsNotExist(err) {
-			fmt.Printf("  ✗ Encrypted file missing: %s\n", env.EncryptedFile)
-			continue
-		}
-		fmt.Printf("  ✓ Encrypted file exists: %s\n", env.EncryptedFile)
+	envName := args[0]
+	plaintextPath := args[1]
 
-		// Check if we can decrypt
-		if err := crypto.CanDecrypt(envName); err != nil {
-			fmt.Printf("  ✗ Cannot decrypt: %v\n", err)
-		} else {
-			fmt.Println("  ✓ Can decrypt with your SSH key")
+	if plaintextPath != "-" {
+		if info, err := os.Stat(plaintextPath); err == nil && info.IsDir() {
+			fatal("%s is a directory; encrypt an archive instead: tar c %s | envault encrypt %s -", plaintextPath, plaintextPath, envName)
 		}
-
-		// List targets
-		fmt.Printf("  ✓ Targets: %d\n", len(env.Targets))
-		for _, target := range env.Targets {
-			fmt.Printf("    - %s\n", target.Path)
+		if !*force {
+			if err := guard.CheckPath(plaintextPath); err != nil {
+				fatal("%v (--force to encrypt it anyway)", err)
+			}
+		}
+	}
+
+	// A large input is only expected for an environment that already holds
+	// a large payload; anything else asks for --stream. Standard input is
+	// read up to the limit to tell; below it, it is an ordinary dotenv file.
+	var plaintext []byte
+	size := ""
+	if plaintextPath == "-" {
+		if !*stream {
+			head, err := io.ReadAll(io.LimitReader(os.Stdin, crypto.LargePayloadSize+1))
+			if err != nil {
+				fatal("Failed to read standard input: %v", err)
+			}
+			plaintext = head
+			if len(head) > crypto.LargePayloadSize {
+				size = fmt.Sprintf("standard input is over %d MiB", crypto.LargePayloadSize>>20)
+			}
+		}
+	} else if info, err := os.Stat(plaintextPath); err == nil && info.Size() > crypto.LargePayloadSize {
+		size = fmt.Sprintf("%s is %.1f MiB", plaintextPath, float64(info.Size())/(1<<20))
+	}
+	if size != "" {
+		if !*stream && !*force {
+			if large, _ := crypto.IsLarge(envName); !large {
+				fatal("%s, far larger than a dotenv file; use --stream to encrypt it as an opaque payload (--force to encrypt it anyway)", size)
+			}
+		}
+		*stream = true
+	}
+	if *stream {
+		encryptStream(envName, plaintextPath, plaintext, *force, *skipValidation, *dryRun)
+		return
+	}
+
+	if plaintextPath != "-" {
+		var err error
+		if plaintext, err = os.ReadFile(plaintextPath); err != nil {
+			fatal("Failed to read plaintext file: %v", err)
+		}
+	}
+	if !*force {
+		if err := guard.CheckContent(plaintext, true); err != nil {
+			fatal("%v (--force to encrypt it anyway)", err)
+		}
+	}
+
+	if !*skipValidation {
+		if violations := validateSchema(envName, plaintext); len(violations) > 0 {
+			for _, v := range violations {
+				fmt.Fprintf(os.Stderr, "%s %v\n", ui.Fail(), v)
+			}
+			fatal("%d value(s) violate schema.yaml (use --skip-validation to encrypt anyway)", len(violations))
+		}
+		if problems := checkNames(plaintext); len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "%s %v\n", ui.Fail(), p)
+			}
+			fatal("%d variable name(s) are not portable or conflict (use --skip-validation to encrypt anyway)", len(problems))
+		}
+	}
+
+	if *dryRun {
+		cfg, err := config.Load()
+		if err != nil {
+			fatal("Failed to load config: %v", err)
+		}
+		planEncrypt(cfg, envName, notify.OpEncrypt)
+		endDryRun()
+		return
+	}
+
+	changed, err := crypto.EncryptChanged(envName, plaintext, tracksRotation(envName))
+	if errors.Is(err, crypto.ErrRotationUntracked) {
+		fmt.Printf("%s %v\n", ui.Warn(), err)
+	} else if err != nil {
+		fatal("Failed to encrypt: %v", err)
+	}
+	if !changed {
+		fmt.Printf("%s %s is unchanged - ciphertext left as is (stable_ciphertext)\n", ui.OK(), envName)
+		return
+	}
+
+	fmt.Printf("%s Encrypted %s to .envault/%s\n", ui.OK(), plaintextPath, envName)
+	refreshSubvaults(envName, plaintext)
+	sendNotification(notify.Event{Operation: notify.OpEncrypt, Environment: envName})
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Test decryption: envault decrypt", envName)
+	if cfg, err := config.Load(); err == nil {
+		if r := cfg.RemoteFor(envName); r != nil {
+			path, _ := cfg.EncryptedPath(envName)
+			fmt.Printf("  - Publish: upload %s to %s\n", path, r.URL)
+			return
+		}
+	}
+	fmt.Println("  - Commit: git add .envault && git commit -m 'chore: update secrets'")
+}
+
+// encryptStream encrypts a large or non-dotenv payload without buffering
+// it, after head if standard input was partly read. Schema validation and
+// per-variable tracking do not apply, so environments that rely on them
+// are refused.
+func encryptStream(envName, plaintextPath string, head []byte, force, skipValidation, dryRun bool) {
+	if err := checkStreamable(envName, skipValidation); err != nil {
+		fatal("%v", err)
+	}
+
+	var in io.Reader = os.Stdin
+	if head != nil {
+		in = io.MultiReader(bytes.NewReader(head), os.Stdin)
+	}
+	if plaintextPath != "-" {
+		file, err := os.Open(plaintextPath)
+		if err != nil {
+			fatal("Failed to read plaintext file: %v", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	// Only the head is inspected, so the payload still streams
+	if !force {
+		buffered := bufio.NewReaderSize(in, guard.HeadSize)
+		head, _ := buffered.Peek(guard.HeadSize)
+		if err := guard.CheckContent(head, false); err != nil {
+			fatal("%v (--force to encrypt it anyway)", err)
+		}
+		in = buffered
+	}
+
+	if dryRun {
+		cfg, err := config.Load()
+		if err != nil {
+			fatal("Failed to load config: %v", err)
+		}
+		planEncrypt(cfg, envName, notify.OpEncrypt)
+		endDryRun()
+		return
+	}
+
+	if err := crypto.EncryptStream(envName, in); err != nil {
+		fatal("Failed to encrypt: %v", err)
+	}
+
+	fmt.Printf("%s Encrypted %s to .envault/%s (streamed; not validated or tracked per variable)\n", ui.OK(), plaintextPath, envName)
+	sendNotification(notify.Event{Operation: notify.OpEncrypt, Environment: envName})
+}
+
+// checkStreamable refuses to stream into an environment whose schema
+// rules, subvaults or stable_ciphertext need the plaintext as dotenv
+func checkStreamable(envName string, skipValidation bool) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		return err
+	}
+	if len(env.Subvaults) > 0 {
+		return fmt.Errorf("%s has subvaults, which cannot be cut from a streamed payload; remove them or encrypt a dotenv file without --stream", envName)
+	}
+	if cfg.StableCiphertext {
+		return fmt.Errorf("stable_ciphertext is set, and a streamed payload is always rewritten; encrypt a dotenv file without --stream, or turn stable_ciphertext off")
+	}
+	if !skipValidation {
+		sch, err := schema.Load()
+		if err != nil {
+			return err
+		}
+		if len(sch.ForEnvironment(envName)) > 0 {
+			return fmt.Errorf("schema.yaml has rules for %s, which a streamed payload cannot be checked against (use --skip-validation to encrypt anyway)", envName)
+		}
+	}
+	return nil
+}
+
+func handleDecrypt() {
+	if len(os.Args) < 3 {
+		fatal("Usage: envault decrypt <environment>")
+	}
+
+	envName := os.Args[2]
+
+	if err := crypto.DecryptToWriter(envName, os.Stdout); err != nil {
+		fatal("Failed to decrypt: %v", err)
+	}
+}
+
+func handleReencrypt() {
+	fs := newFlagSet("reencrypt", "envault reencrypt [env] [--dry-run]")
+	dryRun := dryRunFlag(fs)
+	args := parseFlags(fs, os.Args[2:])
+
+	if *dryRun {
+		cfg, err := config.Load()
+		if err != nil {
+			fatal("Failed to load config: %v", err)
+		}
+		envNames := args
+		if len(envNames) == 0 {
+			for envName := range cfg.Environments {
+				envNames = append(envNames, envName)
+			}
+			sort.Strings(envNames)
+		}
+		for _, envName := range envNames {
+			planEncrypt(cfg, envName, notify.OpReencrypt)
+		}
+		if len(args) == 0 {
+			planReencryptSchema()
+		}
+		endDryRun()
+		return
+	}
+
+	// If no environment specified, re-encrypt all
+	if len(args) == 0 {
+		envs, err := crypto.ReencryptAll()
+		if err != nil {
+			// Check if we partially succeeded
+			if len(envs) > 0 {
+				fmt.Printf("%s Re-encrypted: %s\n", ui.OK(), strings.Join(envs, ", "))
+				notifyReencrypted(envs)
+			}
+			fatal("Failed to reencrypt all: %v", err)
+		}
+
+		fmt.Printf("%s Re-encrypted all environments with current authorized_keys:\n", ui.OK())
+		for _, env := range envs {
+			fmt.Printf("  - %s\n", env)
+		}
+		notifyReencrypted(envs)
+		reencryptSchema()
+		for _, env := range envs {
+			refreshEnvSubvaults(env)
+		}
+		pruneGrants(envs)
+		return
+	}
+
+	// Re-encrypt specific environment
+	envName := args[0]
+
+	if err := crypto.Reencrypt(envName); err != nil {
+		fatal("Failed to reencrypt: %v", err)
+	}
+
+	fmt.Printf("%s Re-encrypted %s with current authorized_keys\n", ui.OK(), envName)
+	notifyReencrypted([]string{envName})
+	refreshEnvSubvaults(envName)
+	pruneGrants([]string{envName})
+}
+
+// reencryptSchema brings an encrypted schema to the current recipients,
+// which otherwise keep it readable by removed keys
+func reencryptSchema() {
+	encrypted, err := schema.Encrypted()
+	if err != nil || !encrypted {
+		return
+	}
+	sch, err := schema.Load()
+	if err == nil {
+		err = sch.Save()
+	}
+	if err != nil {
+		fatal("Failed to re-encrypt %s: %v", schema.EncryptedFileName, err)
+	}
+	fmt.Printf("%s Re-encrypted %s\n", ui.OK(), schema.EncryptedFileName)
+}
+
+func notifyReencrypted(envNames []string) {
+	for _, envName := range envNames {
+		sendNotification(notify.Event{Operation: notify.OpReencrypt, Environment: envName})
+	}
+}
+
+// sendNotification reports an event to the configured webhooks. Failures
+// are warnings: the change itself has already been made.
+func sendNotification(event notify.Event) {
+	cfg, err := config.Load()
+	if err != nil {
+		return
+	}
+	if err := notify.Send(cfg, event); err != nil {
+		fmt.Fprintf(os.Stderr, "%s Notification failed: %v\n", ui.Err.Warn(), err)
+	}
+}
+
+func handleCheck() {
+	fs := newFlagSet("check", "envault check [env...] [--skip-decrypt] [--porcelain]")
+	skipDecrypt := fs.Bool("skip-decrypt", false, "inspect recipients in the ciphertext header instead of decrypting")
+	porcelain := porcelainFlag(fs)
+	args := parseFlags(fs, os.Args[2:])
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+
+	for _, envName := range args {
+		if _, err := cfg.GetEnvironment(envName); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	if *porcelain {
+		startPorcelain()
+	}
+	fmt.Print("Checking envault configuration...\n\n")
+
+	if cfg.NeedsMigration() {
+		fmt.Printf("%s config.yaml is version %d (current: %d) - run: envault migrate\n", ui.Warn(), cfg.Version, config.CurrentVersion)
+	}
+
+	// Check authorized keys
+	authorizedKeys, err := keys.Load()
+	if err != nil {
+		fmt.Printf("%s Failed to load authorized_keys: %v\n", ui.Fail(), err)
+	} else {
+		fmt.Printf("%s Authorized keys: %d\n", ui.OK(), len(authorizedKeys))
+	}
+	checkKeySources(cfg)
+
+	revoked, err := keys.LoadRevoked()
+	if err != nil {
+		fmt.Printf("%s Failed to load revoked_keys: %v\n", ui.Fail(), err)
+	}
+	for _, k := range keys.FindRevoked(authorizedKeys, revoked) {
+		fmt.Printf("%s Revoked key %s is present in authorized_keys\n", ui.Fail(), k.Fingerprint)
+	}
+	for _, k := range authorizedKeys {
+		if err := cfg.CheckKeyComment(k.Comment); err != nil {
+			fmt.Printf("%s Key %s: %v\n", ui.Fail(), k.Fingerprint, err)
+		}
+	}
+	permissionsOK := checkPermissions(cfg)
+
+	// Schema and manifest are optional; report but don't abort
+	sch, err := schema.Load()
+	if err != nil {
+		fmt.Printf("%s Failed to load schema.yaml: %v\n", ui.Fail(), err)
+		sch = &schema.Schema{}
+	}
+	m, err := manifest.Load()
+	if err != nil {
+		fmt.Printf("%s Failed to load manifest.json: %v\n", ui.Fail(), err)
+		m = &manifest.Manifest{Environments: map[string]*manifest.Environment{}}
+	}
+
+	envNames := args
+	if len(envNames) == 0 {
+		for envName := range cfg.Environments {
+			envNames = append(envNames, envName)
+		}
+	}
+	sort.Strings(envNames)
+	recipientsOK := checkRecipientLines(cfg, envNames)
+
+	// Check each environment
+	var summary [][]string
+	var sunsetPassed, grantsEnded, unapproved []string
+	fingerprints := map[string]map[string]string{}
+	for _, envName := range envNames {
+		fmt.Printf("\nEnvironment: %s\n", envName)
+
+		// Check if encrypted file exists
+		env, _ := cfg.GetEnvironment(envName)
+		encryptedPath, _ := cfg.EncryptedPath(envName)
+
+		// An environment in another root is encrypted to that root's keys
+		envKeys := authorizedKeys
+		if env.Root != "" {
+			if envKeys, err = keys.LoadFor(cfg, envName); err != nil {
+				fmt.Printf("  %s Root %s: %v\n", ui.Fail(), env.Root, err)
+			} else {
+				fmt.Printf("  %s Root %s: %d authorized key(s)\n", ui.OK(), env.Root, len(envKeys))
+			}
+			for _, k := range keys.FindRevoked(envKeys, revoked) {
+				fmt.Printf("  %s Revoked key %s is present in root %s's authorized_keys\n", ui.Fail(), k.Fingerprint, env.Root)
+			}
+		}
+
+		// A deprecated environment is fine until its sunset is over
+		if d := env.Deprecated; d != nil {
+			if d.Expired(time.Now()) {
+				fmt.Printf("  %s Past its sunset (%s) - run: envault env remove %s --purge\n", ui.Fail(), d, envName)
+				sunsetPassed = append(sunsetPassed, envName)
+			} else {
+				fmt.Printf("  %s Environment is %s\n", ui.Warn(), d)
+			}
+		}
+
+		targets, targetsErr := cfg.ResolvedTargets(envName)
+		if targetsErr != nil {
+			targets = env.Targets
+		}
+
+		if cfg.RemoteFor(envName) != nil {
+			checkRemote(cfg, envName)
+		}
+
+		if _, err := os.Stat(encryptedPath); os.IsNotExist(err) {
+			if _, ok := vault.IndexBlob(encryptedPath); !ok {
+				fmt.Printf("  %s Encrypted file missing: %s\n", ui.Fail(), env.EncryptedFile)
+				summary = append(summary, []string{envName, "missing", "-", fmt.Sprint(len(targets))})
+				continue
+			}
+			fmt.Printf("  %s Encrypted file outside the sparse checkout, read from git: %s\n", ui.OK(), env.EncryptedFile)
+		} else {
+			fmt.Printf("  %s Encrypted file exists: %s\n", ui.OK(), env.EncryptedFile)
+		}
+
+		if chain, source := crypto.IdentityChain(cfg, envName); len(chain) > 0 {
+			fmt.Printf("  %s Identity chain (%s): %s\n", ui.OK(), source, strings.Join(chain, ", "))
+		}
+		delegated := env.DecryptVia != nil && os.Getenv(crypto.DecryptLocalEnv) == ""
+		if delegated {
+			fmt.Printf("  %s Decrypts on %s over ssh (%s)\n", ui.OK(), env.DecryptVia.SSH, env.DecryptVia.RemoteCommand())
+		}
+
+		// Check if we can decrypt
+		decryptStatus := "ok"
+		if *skipDecrypt {
+			decryptStatus = checkHeaderRecipients(envName, envKeys)
+		} else if plaintext, err := crypto.Decrypt(envName); err != nil {
+			fmt.Printf("  %s Cannot decrypt: %v\n", ui.Fail(), err)
+			decryptStatus = "failed"
+		} else {
+			if delegated {
+				fmt.Printf("  %s Can decrypt via ssh %s\n", ui.OK(), env.DecryptVia.SSH)
+			} else {
+				fmt.Printf("  %s Can decrypt with your SSH key\n", ui.OK())
+			}
+			if len(sch.Consistency) > 0 {
+				fingerprints[envName] = valueFingerprints(plaintext)
+			}
+			for _, v := range validateSchema(envName, plaintext) {
+				fmt.Printf("  %s %v\n", ui.Fail(), v)
+				porcelainViolation(envName, v)
+				decryptStatus = "invalid"
+			}
+			for _, p := range checkNames(plaintext) {
+				fmt.Printf("  %s %v\n", ui.Warn(), p)
+			}
+			if values, err := dotenv.ParseMap(plaintext); err == nil {
+				for _, err := range resolve.Check(values) {
+					fmt.Printf("  %s %v\n", ui.Warn(), err)
+				}
+			}
+			secmem.Wipe(plaintext)
+		}
+		summary = append(summary, []string{envName, "ok", decryptStatus, fmt.Sprint(len(targets))})
+
+		checkRevokedRecipients(envName, revoked)
+
+		// List targets
+		if targetsErr != nil {
+			fmt.Printf("  %s Targets: %v\n", ui.Fail(), targetsErr)
+		} else {
+			fmt.Printf("  %s Targets: %d\n", ui.OK(), len(targets))
+			for _, target := range targets {
+				fmt.Printf("    - %s\n", target)
+			}
+		}
+
+		if env.RequireApprovals > 0 {
+			// Unapproved ciphertext is not valid, however well it decrypts
+			if approved, err := checkApprovals(cfg, envName, "  "); err != nil || !approved {
+				unapproved = append(unapproved, envName)
+			}
+		}
+
+		if env.RequireRecoveryKey {
+			if err := crypto.CheckRecoveryRecipient(cfg, envName, envKeys); err != nil {
+				fmt.Printf("  %s %v\n", ui.Fail(), err)
+			} else {
+				fmt.Printf("  %s Recovery key is an authorized recipient\n", ui.OK())
+			}
+		}
+
+		if len(env.PinnedRecipients) > 0 {
+			if err := crypto.CheckPinnedRecipients(cfg, envName, envKeys); err != nil {
+				fmt.Printf("  %s %v\n", ui.Fail(), err)
+			} else {
+				fmt.Printf("  %s Pinned recipients: %d present\n", ui.OK(), len(env.PinnedRecipients))
+			}
+		}
+
+		if len(env.Subvaults) > 0 {
+			checkSubvaults(cfg, envName, env)
+		}
+
+		if !checkGrants(cfg, envName) {
+			grantsEnded = append(grantsEnded, envName)
+		}
+
+		checkRotation(envName, sch, m)
+	}
+
+	if len(sch.Consistency) > 0 {
+		fmt.Println("\nCross-environment rules:")
+		if *skipDecrypt {
+			fmt.Printf("  %s Not checked with --skip-decrypt\n", ui.Warn())
+		} else {
+			checkConsistency(cfg, sch, fingerprints)
+		}
+	}
+
+	checkRenderedTargets(envNames)
+	checkExposedTargets(envNames)
+	checkKeyUsage(cfg, authorizedKeys)
+	checkAgePlugins(cfg, envNames, authorizedKeys)
+
+	if len(summary) > 0 {
+		fmt.Println("\nSummary:")
+		ui.Table(os.Stdout, ui.Out, []string{"ENVIRONMENT", "CIPHERTEXT", "DECRYPT", "TARGETS"}, summary)
+	}
+	for _, row := range summary {
+		porcelainRecord("env", row...)
+	}
+	if len(sunsetPassed) > 0 {
+		porcelainRecord("fail", "sunset", strings.Join(sunsetPassed, ","))
+	}
+	if len(grantsEnded) > 0 {
+		porcelainRecord("fail", "grants", strings.Join(grantsEnded, ","))
+	}
+	if len(unapproved) > 0 {
+		porcelainRecord("fail", "approvals", strings.Join(unapproved, ","))
+	}
+	if !recipientsOK {
+		porcelainRecord("fail", "recipients", "")
+	}
+	if !permissionsOK {
+		porcelainRecord("fail", "permissions", "")
+	}
+
+	if len(sunsetPassed) > 0 {
+		fmt.Printf("\n%s Past their sunset: %s\n", ui.Fail(), strings.Join(sunsetPassed, ", "))
+		os.Exit(1)
+	}
+	if len(grantsEnded) > 0 {
+		fmt.Printf("\n%s Need re-encryption for ended grants: %s\n", ui.Fail(), strings.Join(grantsEnded, ", "))
+		os.Exit(1)
+	}
+	if len(unapproved) > 0 {
+		fmt.Printf("\n%s Ciphertext lacks the required approvals: %s\n", ui.Fail(), strings.Join(unapproved, ", "))
+		os.Exit(1)
+	}
+	if !recipientsOK {
+		fmt.Printf("\n%s authorized_keys has keys that cannot be encrypted to; fix or remove the lines reported above\n", ui.Fail())
+		os.Exit(1)
+	}
+	if !permissionsOK {
+		fmt.Printf("\n%s .envault is exposed to other local users; fix the permissions reported above\n", ui.Fail())
+		os.Exit(1)
+	}
+}
+
+// checkKeySources reports how many keys each key_sources file adds
+func checkKeySources(cfg *config.Config) {
+	if len(cfg.KeySources) == 0 {
+		return
+	}
+	sourced, err := keys.LoadSourced(cfg)
+	if err != nil {
+		// keys.Load already failed with the same error
+		return
+	}
+	counts := map[string]int{}
+	for _, k := range sourced {
+		counts[k.Source]++
+	}
+	for _, source := range cfg.KeySources {
+		fmt.Printf("  %s Key source %s (%s): %d key(s)\n", ui.OK(), source.Path, source.TrustLabel(), counts[source.Path])
+	}
+}
+
+// checkRecipientLines names the authorized_keys lines that the backends
+// of the checked environments cannot encrypt to, in each environment's root
+func checkRecipientLines(cfg *config.Config, envNames []string) bool {
+	checked := map[string]bool{}
+	ok := true
+	for _, envName := range envNames {
+		backend, err := crypto.BackendFor(cfg, envName)
+		if err != nil {
+			continue
+		}
+		env, _ := cfg.GetEnvironment(envName)
+		if checked[env.Root+" "+backend.Name()] {
+			continue
+		}
+		checked[env.Root+" "+backend.Name()] = true
+
+		problems, err := keys.RootProblems(cfg, env.Root, backend.ValidateRecipient)
+		if err != nil {
+			fmt.Printf("%s Failed to check authorized_keys: %v\n", ui.Fail(), err)
+			return false
+		}
+		for _, p := range problems {
+			if env.Root != "" {
+				fmt.Printf("%s Root %s: %s\n", ui.Fail(), env.Root, p)
+			} else {
+				fmt.Printf("%s %s\n", ui.Fail(), p)
+			}
+			ok = false
+		}
+	}
+	return ok
+}
+
+// checkExposedTargets reports rendered files, of any target type, that
+// are committed or readable by other users
+func checkExposedTargets(envNames []string) {
+	exposures, err := env.Exposures(envNames)
+	if err != nil || len(exposures) == 0 {
+		return
+	}
+	fmt.Println("\nExposed rendered files:")
+	reportExposures(exposures, "  ")
+}
+
+// checkRenderedTargets warns about rendered targets of the given
+// environments that no longer match their ciphertext or config
+func checkRenderedTargets(envNames []string) {
+	statuses, err := env.Status()
+	if err != nil {
+		fmt.Printf("\n%s Failed to read state.json: %v\n", ui.Warn(), err)
+		return
+	}
+
+	checked := map[string]bool{}
+	for _, envName := range envNames {
+		checked[envName] = true
+	}
+
+	header := false
+	for _, s := range statuses {
+		if s.Status == env.StatusCurrent || !checked[s.Env] {
+			continue
+		}
+		if !header {
+			fmt.Println("\nRendered targets:")
+			header = true
+		}
+		switch s.Status {
+		case env.StatusStale:
+			fmt.Printf("  %s %s is stale - run: envault %s\n", ui.Warn(), s.Path, s.Env)
+		case env.StatusOrphaned:
+			fmt.Printf("  %s %s is no longer a target of %s - run: envault clean\n", ui.Warn(), s.Path, s.Env)
+		default:
+			fmt.Printf("  %s %s is %s\n", ui.Warn(), s.Path, s.Status)
+		}
+	}
+}
+
+// validateSchema checks dotenv plaintext against the types and patterns in
+// schema.yaml. Unreadable schema or plaintext is reported as a violation.
+func validateSchema(envName string, plaintext []byte) []error {
+	sch, err := schema.Load()
+	if err != nil {
+		return []error{err}
+	}
+
+	values, err := dotenv.ParseMap(plaintext)
+	if err != nil {
+		return []error{fmt.Errorf("failed to parse %s: %w", envName, err)}
+	}
+
+	// References hold a pointer, not the value the schema describes, and
+	// are exempt from every rule
+	references := map[string]bool{}
+	for key, value := range values {
+		if resolve.IsReference(value) {
+			references[key] = true
+			delete(values, key)
+		}
+	}
+
+	var errs []error
+	for _, v := range sch.Validate(envName, values) {
+		if !references[v.Name] {
+			errs = append(errs, v)
+		}
+	}
+	return errs
+}
+
+// tracksRotation reports whether schema.yaml gives a variable of an
+// environment a rotate_every window, so each change must be recorded. A
+// schema that cannot be read counts as tracking.
+func tracksRotation(envName string) bool {
+	sch, err := schema.Load()
+	if err != nil {
+		return true
+	}
+	for _, v := range sch.ForEnvironment(envName) {
+		if v.RotateEvery != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRemote fetches an environment's ciphertext from its remote and
+// reports how current the cached copy is
+func checkRemote(cfg *config.Config, envName string) {
+	s, err := remote.Refresh(cfg, envName)
+	switch {
+	case err != nil:
+		fmt.Printf("  %s Remote: %v\n", ui.Fail(), err)
+	case s.Fetched:
+		fmt.Printf("  %s Remote: fetched %s\n", ui.OK(), s.URL)
+	case s.Modified:
+		fmt.Printf("  %s %s\n", ui.Warn(), s.Report(envName))
+	case s.Err != nil:
+		s.Report(envName)
+		fmt.Printf("  %s Remote: cached copy from %s ago in use; %s is unreachable: %v\n", ui.Warn(), remote.Age(time.Since(s.FetchedAt)), s.URL, s.Err)
+	default:
+		fmt.Printf("  %s Remote: cached copy is current (fetched %s ago from %s)\n", ui.OK(), remote.Age(time.Since(s.FetchedAt)), s.URL)
+	}
+}
+
+// checkNames reports variable names in dotenv plaintext that are not
+// portable or that conflict. Plaintext that is not dotenv has no names.
+func checkNames(plaintext []byte) []dotenv.NameProblem {
+	entries, err := dotenv.Parse(plaintext)
+	if err != nil {
+		return nil
+	}
+	return dotenv.CheckNames(entries)
+}
+
+// checkHeaderRecipients compares the ciphertext's recipients with
+// authorized_keys without decrypting, returning the summary status
+func checkHeaderRecipients(envName string, authorizedKeys []keys.Key) string {
+	stanzas, err := crypto.ReadHeader(envName)
+	if err != nil {
+		fmt.Printf("  %s Cannot read ciphertext header: %v\n", ui.Fail(), err)
+		return "failed"
+	}
+
+	found, err := crypto.KeysInHeader(envName, authorizedKeys)
+	if err != nil {
+		fmt.Printf("  %s Cannot read recipients: %v\n", ui.Fail(), err)
+		return "failed"
+	}
+	fmt.Printf("  %s Recipients: %d (%d matched in authorized_keys)\n", ui.OK(), len(stanzas), len(found))
+
+	status := "skipped"
+	recipients := map[string]bool{}
+	for _, k := range found {
+		recipients[k.Fingerprint] = true
+	}
+	for _, k := range authorizedKeys {
+		// Only SSH recipients are identifiable from the header
+		if k.SSHTag() != "" && !recipients[k.Fingerprint] {
+			fmt.Printf("  %s Key %s is not a recipient - run: envault reencrypt %s\n", ui.Warn(), k.Fingerprint, envName)
+			status = "stale"
 		}
 	}
+	return status
+}
+
+// checkRevokedRecipients alerts when a revoked key can still decrypt an
+// environment's ciphertext
+func checkRevokedRecipients(envName string, revoked []keys.Revoked) {
+	var revokedKeys []keys.Key
+	for _, r := range revoked {
+		if r.Key != nil {
+			revokedKeys = append(revokedKeys, *r.Key)
+		}
+	}
+	if len(revokedKeys) == 0 {
+		return
+	}
+
+	found, err := crypto.KeysInHeader(envName, revokedKeys)
+	if err != nil {
+		fmt.Printf("  %s Cannot read recipients: %v\n", ui.Warn(), err)
+		return
+	}
+	for _, k := range found {
+		fmt.Printf("  %s Revoked key %s can still decrypt - run: envault reencrypt %s\n", ui.Fail(), k.Fingerprint, envName)
+	}
+}
+
+// defaultStaleKeyAfter applies when config.yaml sets no stale_key_after
+const defaultStaleKeyAfter = 90 * 24 * time.Hour
+
+// checkKeyUsage warns about authorized keys with no recorded decrypt in
+// the stale_key_after window. Recovery keys and the CI identity are
+// skipped: the first are meant to sit unused, and CI never commits its
+// usage.
+func checkKeyUsage(cfg *config.Config, authorizedKeys []keys.Key) {
+	window := defaultStaleKeyAfter
+	if cfg.StaleKeyAfter != "" {
+		d, err := schema.ParseDuration(cfg.StaleKeyAfter)
+		if err != nil {
+			fmt.Printf("\n%s stale_key_after: %v\n", ui.Fail(), err)
+			return
+		}
+		window = d
+	}
+
+	// The manifest is reloaded: the decrypts above may have updated it
+	m, err := manifest.Load()
+	if err != nil || m.KeysSince == nil {
+		return
+	}
+
+	skip := map[string]bool{}
+	if envaultDir, err := config.EnvaultDir(); err == nil {
+		if data, err := os.ReadFile(ci.Path(envaultDir)); err == nil {
+			if public, err := ci.PublicKey(data); err == nil {
+				if k, err := keys.ParseKey(public); err == nil {
+					skip[k.Fingerprint] = true
+				}
+			}
+		}
+	}
+
+	now := time.Now()
+	var stale []string
+	for _, k := range authorizedKeys {
+		if skip[k.Fingerprint] || cfg.IsRecoveryKey(k.Fingerprint) {
+			continue
+		}
+		name := k.Fingerprint
+		if k.Comment != "" {
+			name += " (" + k.Comment + ")"
+		}
+
+		lastUsed, ok := m.LastUsed(k.Fingerprint)
+		switch {
+		case ok && now.Sub(lastUsed) > window:
+			stale = append(stale, fmt.Sprintf("%s last decrypted %s", name, lastUsed.Format("2006-01-02")))
+		case !ok && now.Sub(*m.KeysSince) > window:
+			stale = append(stale, fmt.Sprintf("%s has no recorded decrypt since %s", name, m.KeysSince.Format("2006-01-02")))
+		}
+	}
+
+	if len(stale) == 0 {
+		return
+	}
+	fmt.Printf("\n%s Possibly stale recipients (no decrypt in %d days):\n", ui.Warn(), int(window.Hours()/24))
+	for _, line := range stale {
+		fmt.Printf("  - %s\n", line)
+	}
+	fmt.Println("  Remove departed members with: envault remove-key <fingerprint>")
+}
+
+// checkAgePlugins reports the age plugins that recipients in
+// authorized_keys and local identity files need, and whether each is
+// installed. age runs a recipient's plugin to encrypt and an identity's
+// plugin to decrypt.
+func checkAgePlugins(cfg *config.Config, envNames []string, authorizedKeys []keys.Key) {
+	needed := crypto.KeyPlugins(authorizedKeys)
+
+	identities := map[string][]string{} // plugin -> identity files
+	seen := map[string]bool{}
+	for _, envName := range envNames {
+		backend, err := crypto.BackendFor(cfg, envName)
+		if err != nil {
+			continue
+		}
+		for _, path := range crypto.LocalIdentities(backend) {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			for _, name := range crypto.IdentityPlugins(path) {
+				identities[name] = append(identities[name], path)
+			}
+		}
+	}
+
+	installed := crypto.InstalledPlugins()
+	if len(needed) == 0 && len(identities) == 0 && len(installed) == 0 {
+		return
+	}
+
+	fmt.Println("\nAge plugins:")
+	if len(installed) > 0 {
+		fmt.Printf("  %s Installed: %s\n", ui.OK(), strings.Join(installed, ", "))
+	}
+
+	names := map[string]bool{}
+	for name := range needed {
+		names[name] = true
+	}
+	for name := range identities {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		path, err := crypto.FindPlugin(name)
+		if err == nil {
+			fmt.Printf("  %s %s: %s\n", ui.OK(), name, path)
+			continue
+		}
+		if list := needed[name]; len(list) > 0 {
+			fingerprints := make([]string, len(list))
+			for i, k := range list {
+				fingerprints[i] = k.Fingerprint
+			}
+			fmt.Printf("  %s %v; encrypt and reencrypt fail until it is (recipients: %s)\n", ui.Fail(), err, strings.Join(fingerprints, ", "))
+		}
+		for _, identity := range identities[name] {
+			fmt.Printf("  %s %v; identity %s cannot decrypt\n", ui.Warn(), err, identity)
+		}
+	}
+}
+
+// checkRotation warns about variables that have outlived their rotate_every window
+func checkRotation(envName string, sch *schema.Schema, m *manifest.Manifest) {
+	vars := sch.ForEnvironment(envName)
+	tracked := m.Environments[envName]
+	now := time.Now()
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		window, err := vars[name].RotationWindow()
+		if err != nil {
+			fmt.Printf("  %s %s: %v\n", ui.Fail(), name, err)
+			continue
+		}
+		if window == 0 || tracked == nil {
+			continue
+		}
+
+		meta, ok := tracked.Variables[name]
+		if !ok {
+			continue
+		}
+
+		if age := now.Sub(meta.ChangedAt); age > window {
+			fmt.Printf("  %s %s last rotated %d days ago (rotate every %s)\n", ui.Warn(),
+				name, int(age.Hours()/24), vars[name].RotateEvery)
+		}
+	}
+}
+
+func handleMigrate() {
+	fs := newFlagSet("migrate", "envault migrate [--layout flat|nested]")
+	layout := fs.String("layout", "", "move encrypted files to another layout instead of upgrading the version")
+	parseFlags(fs, os.Args[2:])
+
+	if *layout != "" {
+		handleMigrateLayout(*layout)
+		return
+	}
+
+	backupDir, applied, err := migrate.Run()
+	for _, step := range applied {
+		fmt.Printf("%s Migrated from version %d: %s\n", ui.OK(), step.From, step.Description)
+	}
+	if err != nil {
+		fatal("Failed to migrate: %v", err)
+	}
+
+	if len(applied) == 0 {
+		fmt.Printf("%s .envault is already at version %d\n", ui.OK(), config.CurrentVersion)
+		return
+	}
+
+	fmt.Printf("\nBackup of previous files: %s\n", backupDir)
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Verify: envault check")
+	fmt.Println("  - Commit: git add .envault && git commit -m 'chore: migrate envault layout'")
+}
+
+// handleMigrateLayout converts .envault between the flat and nested layouts
+func handleMigrateLayout(layout string) {
+	if _, err := config.LayoutFile(layout, "env"); err != nil {
+		fatal("%v", err)
+	}
+
+	backupDir, moves, err := migrate.ConvertLayout(layout)
+	if err != nil {
+		fatal("Failed to convert layout: %v", err)
+	}
+	if backupDir == "" {
+		fmt.Printf("%s .envault already uses the %s layout\n", ui.OK(), layout)
+		return
+	}
+
+	for _, m := range moves {
+		fmt.Printf("%s %s: moved %s to %s\n", ui.OK(), m.Env, m.From, m.To)
+	}
+	fmt.Printf("%s .envault uses the %s layout\n", ui.OK(), layout)
+
+	fmt.Printf("\nBackup of previous files: %s\n", backupDir)
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Verify: envault check")
+	fmt.Println("  - Commit: git add -A .envault && git commit -m 'chore: change envault layout'")
 }
 
 func printUsage() {
@@ -299,17 +1917,83 @@ func printUsage() {
 	fmt.Println("\nUsage:")
 	fmt.Println("  envault <command> [arguments]")
 	fmt.Println("\nCommands:")
-	fmt.Println("  init                          Initialize .envault directory")
+	fmt.Println("  init [--template <src>]       Initialize .envault directory (optionally from a template)")
+	fmt.Println("  init --from-dotenv [--yes]    Initialize from existing .env files and compose env_file references")
+	fmt.Println("  init --layout nested          Initialize with .envault/<env>/secrets.age per environment")
+	fmt.Println("  init --private                Initialize with 0700/0600 permissions for shared hosts")
 	fmt.Println("  dev|staging|prod              Load environment secrets")
-	fmt.Println("  add-key <public-key>          Add SSH public key")
-	fmt.Println("  remove-key <fingerprint>      Remove SSH public key")
-	fmt.Println("  list-keys                     List authorized keys")
+	fmt.Println("  load <env...> | --all         Load several environments, decrypting them together")
+	fmt.Println("  load --auto                   Load the environment mapped to the current git branch")
+	fmt.Println("  profile list|show             Show per-machine profiles from the user config (--profile <name>)")
+	fmt.Println("  identity import <key>         Store a private key under a passphrase (--protect, --keychain)")
+	fmt.Println("  identity unlock|lock|status   Unlock the protected identity for this session (--ttl 8h)")
+	fmt.Println("  identity remove               Delete the protected identity")
+	fmt.Println("  add-key <public-key>          Add SSH public key (--github, --gitlab, --gitea <user> to import)")
+	fmt.Println("  remove-key <fingerprint>      Remove SSH public key (--revoke to deny it permanently, --revoke-cert to sign it)")
+	fmt.Println("  grant <key> --env --until     Give a key access to environments until a date (list, remove)")
+	fmt.Println("  list-keys [--format <fmt>]    List authorized keys (authorized_keys, age-recipients, json, csv)")
+	fmt.Println("  keys fmt [--check]            Sort and normalize authorized_keys")
+	fmt.Println("  keys setup-merge              Install the git merge driver for authorized_keys")
+	fmt.Println("  keys bundle export|import     Publish or adopt a signed recipient set (bundle_signers)")
+	fmt.Println("  keys revocation verify|import Check or apply a revocation certificate from remove-key")
+	fmt.Println("  scan [env...] [--engine name]  Find decrypted values in tracked files and commit messages")
+	fmt.Println("  notes show|edit <env>         Read or edit an environment's encrypted notes")
+	fmt.Println("  schema add|rm [env] <VAR>     Edit schema.yaml rules (--type, --pattern, --required, --rotate-every, --same-in, --differ-in)")
+	fmt.Println("  schema check [env...]         Check decrypted environments against the schema (exits 1 on violations)")
+	fmt.Println("  schema encrypt|decrypt        Keep the schema encrypted in schema.yaml.age, or in plaintext")
+	fmt.Println("  subvault create <env> --only  Encrypt some variables for an outsider (--for <who>; refresh, list, rm)")
+	fmt.Println("  config lint [--fix]           Lint config.yaml (duplicate, unignored or absolute targets)")
+	fmt.Println("  env deprecate <env> --sunset  Mark an environment for retirement (YYYY-MM-DD, --reason)")
+	fmt.Println("  env remove <env> [--purge]    Remove an environment (--purge deletes ciphertext and targets)")
+	fmt.Println("  vault status|commit|push|pull Manage a vault in a submodule or shared repository")
+	fmt.Println("  sync status                   Compare the vault and remote ciphertext with their upstreams; flag conflicts")
+	fmt.Println("  sync push [--dry-run]         Upload remote ciphertext if unchanged upstream (If-Match), push the vault")
+	fmt.Println("  review-diff [env...] [--base] Summarize secret changes for a PR without values (--format json)")
+	fmt.Println("  ci init [--force]             Commit a passphrase-encrypted CI identity and add its key")
+	fmt.Println("  bot serve --repo <owner/name> Re-encrypt and push when merged changes touch authorized_keys")
+	fmt.Println("  vault link <path>             Point .envault at a vault in a shared repository")
 	fmt.Println("  encrypt <env> <file>          Encrypt plaintext file")
 	fmt.Println("  decrypt <env>                 Decrypt environment to stdout")
 	fmt.Println("  reencrypt [env]               Re-encrypt with updated keys (all envs if not specified)")
-	fmt.Println("  check                         Verify configuration")
-	fmt.Println("  version                       Show version")
+	fmt.Println("  apply -f <desired.yaml>       Bring environments, recipients and schema to a declared state (--prune)")
+	fmt.Println("  <command> --dry-run           Preview encrypt, reencrypt, load, add-key, remove-key, vault push, pipe --write, apply")
+	fmt.Println("  check [env] [--skip-decrypt]  Verify configuration (--skip-decrypt for a header-only check)")
+	fmt.Println("  doctor [env...]               Check this machine can encrypt and decrypt each environment")
+	fmt.Println("  approve-change <env>          Sign the current ciphertext (for require_approvals)")
+	fmt.Println("  verify [env...]               Check required approvals (exits 1 if unmet)")
+	fmt.Println("  verify-content <env> <file>   Check the ciphertext decrypts to approved content (--sha256)")
+	fmt.Println("  shell-init bash|zsh|fish      Print envault_use/envault_drop shell functions")
+	fmt.Println("  completion bash|zsh|fish      Print a tab-completion script (environments and variable names)")
+	fmt.Println("  exec <env> -- <cmd> [args]    Run a command with secrets in its environment")
+	fmt.Println("  pipe <env> '<filter>'         Pipe decrypted plaintext through a filter (--write re-encrypts its output)")
+	fmt.Println("  make|task <env> [args...]     Run make or go-task with secrets and $ENVAULT_ENV_FILE")
+	fmt.Println("  session start <env...>        Hold decrypted values for fast exec, make and task (--ttl 30m)")
+	fmt.Println("  session stop|status           End or describe the running session")
+	fmt.Println("  test-env <env> [K=V...]       Create a throwaway vault for tests (--ephemeral -- <cmd>)")
+	fmt.Println("  docker run <env> -- <image>   Run a container with secrets (-e from env, or --env-file on tmpfs)")
+	fmt.Println("  docker secrets <env>          Create Docker Swarm or Podman secrets from an environment")
+	fmt.Println("  k8s-init <env> --out <dir>    Decrypt into an emptyDir as a Kubernetes init container, then exit")
+	fmt.Println("  export <env> [--shell <sh>]   Print export statements (posix, fish, powershell, cmd)")
+	fmt.Println("  embed <env> [--package name]  Generate a Go file embedding the ciphertext (for go:generate)")
+	fmt.Println("  devcontainer <env>            Write secrets for devcontainers/Codespaces")
+	fmt.Println("  ide-server                    Serve names, diagnostics and hover docs to editors (JSON-RPC on stdio)")
+	fmt.Println("  share <env> <KEY> --to <who>  Hand off one secret as a single-use encrypted blob")
+	fmt.Println("  receive [file|-]              Decrypt a blob created by share")
+	fmt.Println("  remote-decrypt                Decrypt stdin with this host's key (run over ssh by decrypt_via)")
+	fmt.Println("  status                        Show rendered targets and whether they are stale")
+	fmt.Println("  unload <env>                  Delete an environment's rendered targets")
+	fmt.Println("  clean                         Delete all rendered targets")
+	fmt.Println("  migrate                       Upgrade .envault to the current layout version")
+	fmt.Println("  migrate --layout flat|nested  Move encrypted files to another directory layout")
+	fmt.Println("  serve [--addr] [env...]       Serve secrets over HTTP with /metrics and /healthz")
+	fmt.Println("  tokens add|list|remove        Manage scoped bearer tokens for serve (hashed at rest)")
+	fmt.Println("  agent [--socket] [env...]     Serve secrets on a local unix socket")
+	fmt.Println("  version [--check]             Show version (--check for newer releases)")
+	fmt.Println("  upgrade                       Download, verify and install the latest release")
 	fmt.Println("  help                          Show this help")
+	fmt.Println("\nGlobal flags:")
+	fmt.Println("  --no-color                    Disable colors (also honors NO_COLOR)")
+	fmt.Println("  --verbose                     Report which identity decrypted (also ENVAULT_VERBOSE=1)")
 	fmt.Println("\nExamples:")
 	fmt.Println("  envault init")
 	fmt.Println("  envault add-key ~/.ssh/id_rsa.pub")
@@ -318,8 +2002,8 @@ func printUsage() {
 	fmt.Println("\nDocumentation: https://github.com/orchard9/envault")
 }
 
-func needsAge(command string) bool {
-	cryptoCommands := []string{"encrypt", "decrypt", "reencrypt", "dev", "staging", "prod", "check"}
+func needsCrypto(command string) bool {
+	cryptoCommands := []string{"encrypt", "decrypt", "reencrypt", "dev", "staging", "prod", "check", "serve", "agent", "share", "receive", "remote-decrypt", "pipe", "apply", "devcontainer", "export", "exec", "notes", "scan", "docker", "k8s-init", "session", "ci", "test-env", "load", "review-diff", "verify-content", "schema", "subvault", "make", "task"}
 	for _, cmd := range cryptoCommands {
 		if command == cmd {
 			return true
@@ -329,6 +2013,43 @@ func needsAge(command string) bool {
 }
 
 func fatal(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	fmt.Fprintf(os.Stderr, ui.Err.Red("Error:")+" "+format+"\n", args...)
 	os.Exit(1)
 }
+
+// stripGlobalFlags removes flags accepted by every command (such as
+// --no-color and --profile) and applies them. Arguments after -- belong
+// to a child command and are left alone.
+func stripGlobalFlags(args []string) []string {
+	noColor := false
+	verbose := os.Getenv("ENVAULT_VERBOSE") != ""
+	profileName := ""
+	filtered := args[:1]
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			filtered = append(filtered, args[i:]...)
+			break
+		}
+		switch {
+		case arg == "--no-color":
+			noColor = true
+		case arg == "--verbose":
+			verbose = true
+		case arg == "--profile" && i+1 < len(args):
+			i++
+			profileName = args[i]
+		case strings.HasPrefix(arg, "--profile="):
+			profileName = strings.TrimPrefix(arg, "--profile=")
+		default:
+			filtered = append(filtered, arg)
+		}
+	}
+
+	ui.Init(noColor)
+	crypto.Verbose = verbose
+	if err := profile.Activate(profileName); err != nil {
+		fatal("%v", err)
+	}
+	return filtered
+}
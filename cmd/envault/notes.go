@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleNotes() {
+	if len(os.Args) < 4 {
+		fmt.Println("Usage: envault notes show <env> | edit <env>")
+		os.Exit(1)
+	}
+
+	envName := os.Args[3]
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("%v", err)
+	}
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	switch os.Args[2] {
+	case "show":
+		handleNotesShow(envName)
+	case "edit":
+		handleNotesEdit(envName, env.NotesFileName())
+	default:
+		fatal("unknown notes command %q (expected show or edit)", os.Args[2])
+	}
+}
+
+func handleNotesShow(envName string) {
+	notes, err := crypto.DecryptNotes(envName)
+	if err != nil {
+		fatal("Failed to decrypt notes: %v", err)
+	}
+	if notes == nil {
+		fmt.Fprintf(os.Stderr, "No notes for %s - add them with: envault notes edit %s\n", envName, envName)
+		return
+	}
+	os.Stdout.Write(notes)
+}
+
+// handleNotesEdit opens the decrypted notes in $VISUAL or $EDITOR from a
+// private temp directory that is removed afterwards
+func handleNotesEdit(envName, notesFile string) {
+	notes, err := crypto.DecryptNotes(envName)
+	if err != nil {
+		fatal("Failed to decrypt notes: %v", err)
+	}
+
+	dir, err := os.MkdirTemp("", "envault-notes-")
+	if err != nil {
+		fatal("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	notesPath := filepath.Join(dir, envName+".md")
+	if err := os.WriteFile(notesPath, notes, 0600); err != nil {
+		fatal("Failed to write temp file: %v", err)
+	}
+
+	editor := strings.Fields(editorCommand())
+	cmd := exec.Command(editor[0], append(editor[1:], notesPath)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.RemoveAll(dir)
+		fatal("Editor %s failed: %v", editor[0], err)
+	}
+
+	edited, err := os.ReadFile(notesPath)
+	if err != nil {
+		os.RemoveAll(dir)
+		fatal("Failed to read edited notes: %v", err)
+	}
+	if bytes.Equal(edited, notes) {
+		fmt.Printf("%s Notes for %s unchanged\n", ui.OK(), envName)
+		return
+	}
+
+	if err := crypto.EncryptNotes(envName, edited); err != nil {
+		os.RemoveAll(dir)
+		fatal("Failed to encrypt notes: %v", err)
+	}
+
+	fmt.Printf("%s Encrypted notes for %s to .envault/%s\n", ui.OK(), envName, notesFile)
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Commit: git add .envault && git commit -m 'docs: update secret notes'")
+}
+
+// editorCommand returns the user's editor, which may include arguments
+// such as "code --wait"
+func editorCommand() string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.TrimSpace(os.Getenv(name)); editor != "" {
+			return editor
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
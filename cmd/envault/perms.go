@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/orchard9/envault/internal/ci"
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/fsutil"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// trustFiles decide who may decrypt and how secrets are written, so no
+// other local user may be able to change them
+var trustFiles = []string{"config.yaml", "authorized_keys", "revoked_keys", "schema.yaml", ci.FileName}
+
+// restrictTree removes group and other access below dir
+func restrictTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return os.Chmod(path, info.Mode().Perm()&^0077)
+	})
+}
+
+// checkPermissions reports local users other than the owner who could
+// change .envault, and, for a private vault, read it. It returns false on
+// any finding check should fail for.
+func checkPermissions(cfg *config.Config) bool {
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return true
+	}
+	info, err := os.Stat(envaultDir)
+	if err != nil {
+		return true
+	}
+
+	var problems []string
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// Whoever can write the parent can swap .envault for their own
+	parent := filepath.Dir(envaultDir)
+	if p, err := os.Stat(parent); err == nil && p.Mode().Perm()&0022 != 0 && p.Mode()&os.ModeSticky == 0 {
+		problem("%s is writable by other users, who could replace .envault - run: chmod go-w %s", parent, parent)
+	}
+
+	switch {
+	case fsutil.OwnedByOther(info):
+		problem(".envault is owned by another user")
+	case cfg.Private && info.Mode().Perm()&0077 != 0:
+		problem(".envault is private but open to other users (%04o) - run: chmod -R go-rwx %s", info.Mode().Perm(), envaultDir)
+	case info.Mode().Perm()&0022 != 0:
+		problem(".envault is writable by other users (%04o) - run: chmod go-w %s", info.Mode().Perm(), envaultDir)
+	}
+
+	for _, name := range trustFiles {
+		f, err := os.Stat(filepath.Join(envaultDir, name))
+		if err != nil {
+			continue
+		}
+		switch {
+		case fsutil.OwnedByOther(f):
+			problem("%s is owned by another user", name)
+		case f.Mode().Perm()&0022 != 0:
+			problem("%s is writable by other users (%04o) - run: chmod go-w .envault/%s", name, f.Mode().Perm(), name)
+		}
+	}
+
+	if len(problems) == 0 {
+		if cfg.Private {
+			fmt.Printf("%s .envault is private (%04o)\n", ui.OK(), info.Mode().Perm())
+		}
+		return true
+	}
+	for _, p := range problems {
+		fmt.Printf("%s %s\n", ui.Fail(), p)
+	}
+	return false
+}
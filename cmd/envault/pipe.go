@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/guard"
+	"github.com/orchard9/envault/internal/notify"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// handlePipe decrypts an environment into a filter's stdin. The
+// filter's output goes to stdout, or with --write replaces the
+// environment. No plaintext touches the disk either way.
+func handlePipe() {
+	fs := newFlagSet("pipe", "envault pipe <env> [--write] [--skip-validation] [--force] [--dry-run] '<filter>' | -- <command> [args...]")
+	write := fs.Bool("write", false, "encrypt the filter's output back into the environment instead of printing it")
+	skipValidation := fs.Bool("skip-validation", false, "with --write, encrypt even if values violate schema.yaml or variable names conflict")
+	force := fs.Bool("force", false, "with --write, encrypt output that is empty or looks like a private key")
+	dryRun := dryRunFlag(fs)
+	args := parseFlags(fs, os.Args[2:])
+
+	if len(args) < 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if !*write && (*skipValidation || *force || *dryRun) {
+		fatal("--skip-validation, --force and --dry-run only apply with --write")
+	}
+	envName, command := args[0], args[1:]
+	warnDeprecated(envName)
+
+	// One argument is a shell command line; several are run as is
+	var cmd *exec.Cmd
+	var err error
+	if len(command) == 1 {
+		cmd, err = shellCommand(command[0], os.Environ())
+	} else {
+		cmd, err = commandFor(command, os.Environ())
+	}
+	if err != nil {
+		fatal("Failed to start %s: %v", command[0], err)
+	}
+
+	stdin, decrypted := io.Pipe()
+	cmd.Stdin = stdin
+	cmd.Stderr = os.Stderr
+	var output *secmem.Buffer
+	if *write {
+		output = secmem.NewBuffer(0)
+		cmd.Stdout = output
+	} else {
+		cmd.Stdout = os.Stdout
+	}
+
+	if err := cmd.Start(); err != nil {
+		fatal("Failed to start %s: %v", command[0], err)
+	}
+	decryptErr := make(chan error, 1)
+	go func() {
+		err := crypto.DecryptToWriter(envName, decrypted)
+		// Reported before the filter can see the end of its input
+		decryptErr <- err
+		decrypted.CloseWithError(err)
+	}()
+
+	stop := forwardSignals(cmd)
+	waitErr := cmd.Wait()
+	stop()
+	select {
+	case err := <-decryptErr:
+		if err != nil {
+			wipeOutput(output)
+			fatal("Failed to decrypt: %v", err)
+		}
+	default:
+		// The filter stopped reading early (head, grep -q), which is not an
+		// error; closing its end stops the decryption
+		stdin.Close()
+		<-decryptErr
+	}
+	code := exitCode(command[0], waitErr)
+	if code != 0 {
+		wipeOutput(output)
+		if *write {
+			fmt.Fprintf(os.Stderr, "%s %s exited with status %d; %s left as is\n", ui.Err.Fail(), command[0], code, envName)
+		}
+		os.Exit(code)
+	}
+	if !*write {
+		return
+	}
+
+	plaintext := output.Bytes()
+	defer secmem.Wipe(plaintext)
+	if !*force {
+		if len(plaintext) == 0 {
+			fatal("%s printed nothing; refusing to empty %s (--force to write it anyway)", command[0], envName)
+		}
+		if err := guard.CheckContent(plaintext, true); err != nil {
+			fatal("%v (--force to encrypt it anyway)", err)
+		}
+	}
+	if !*skipValidation {
+		if violations := validateSchema(envName, plaintext); len(violations) > 0 {
+			for _, v := range violations {
+				fmt.Fprintf(os.Stderr, "%s %v\n", ui.Err.Fail(), v)
+			}
+			fatal("%d value(s) in the output violate schema.yaml; %s left as is (use --skip-validation to encrypt anyway)", len(violations), envName)
+		}
+		if problems := checkNames(plaintext); len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "%s %v\n", ui.Err.Fail(), p)
+			}
+			fatal("%d variable name(s) in the output are not portable or conflict; %s left as is (use --skip-validation to encrypt anyway)", len(problems), envName)
+		}
+	}
+
+	if *dryRun {
+		cfg, err := config.Load()
+		if err != nil {
+			fatal("Failed to load config: %v", err)
+		}
+		planEncrypt(cfg, envName, notify.OpEncrypt)
+		endDryRun()
+		return
+	}
+
+	changed, err := crypto.EncryptChanged(envName, plaintext, tracksRotation(envName))
+	if errors.Is(err, crypto.ErrRotationUntracked) {
+		fmt.Printf("%s %v\n", ui.Warn(), err)
+	} else if err != nil {
+		fatal("Failed to encrypt: %v", err)
+	}
+	if !changed {
+		fmt.Printf("%s %s is unchanged - ciphertext left as is\n", ui.OK(), envName)
+		return
+	}
+	fmt.Printf("%s Encrypted the output of %s to .envault/%s\n", ui.OK(), command[0], envName)
+	refreshSubvaults(envName, plaintext)
+	sendNotification(notify.Event{Operation: notify.OpEncrypt, Environment: envName})
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Test decryption: envault decrypt", envName)
+	fmt.Println("  - Commit: git add .envault && git commit -m 'chore: update secrets'")
+}
+
+func wipeOutput(output *secmem.Buffer) {
+	if output != nil {
+		secmem.Wipe(output.Bytes())
+	}
+}
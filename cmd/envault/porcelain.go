@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/orchard9/envault/internal/schema"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// porcelainOut receives --porcelain records. It is nil unless porcelain
+// output was asked for.
+var porcelainOut *os.File
+
+// porcelainEscaper keeps every record on one line
+var porcelainEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// startPorcelain switches a command to --porcelain output: records go to
+// stdout and the human output, written by helpers shared with the normal
+// mode, is discarded. Errors still go to stderr.
+func startPorcelain() {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		fatal("%v", err)
+	}
+	porcelainOut = os.Stdout
+	os.Stdout = devNull
+	ui.Out = ui.Stream{}
+}
+
+// porcelainRecord writes one tab-separated record, the first field naming
+// its kind. Fields are escaped so tabs and newlines cannot split a record.
+func porcelainRecord(kind string, fields ...string) {
+	if porcelainOut == nil {
+		return
+	}
+	escaped := make([]string, 0, len(fields)+1)
+	escaped = append(escaped, kind)
+	for _, field := range fields {
+		escaped = append(escaped, porcelainEscaper.Replace(field))
+	}
+	fmt.Fprintln(porcelainOut, strings.Join(escaped, "\t"))
+}
+
+// porcelainFlag adds --porcelain to a command
+func porcelainFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("porcelain", false, "stable tab-separated records for scripts and editors (see Output in scripts in the README)")
+}
+
+// porcelainViolation records a schema violation, splitting out the
+// variable name where the error carries one
+func porcelainViolation(envName string, err error) {
+	if v, ok := err.(schema.Violation); ok {
+		porcelainRecord("violation", envName, v.Name, v.Err.Error())
+		return
+	}
+	porcelainRecord("violation", envName, "", err.Error())
+}
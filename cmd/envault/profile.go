@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/orchard9/envault/internal/profile"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleProfile() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault profile list | show")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "list":
+		handleProfileList()
+	case "show":
+		handleProfileShow()
+	default:
+		fatal("unknown profile command %q (expected list or show)", os.Args[2])
+	}
+}
+
+func handleProfileList() {
+	f, err := profile.Load()
+	if err != nil {
+		fatal("%v", err)
+	}
+	configPath, _ := profile.Path()
+	if len(f.Profiles) == 0 {
+		fmt.Printf("No profiles defined in %s\n", configPath)
+		return
+	}
+
+	current := profile.Current()
+	var rows [][]string
+	for _, p := range f.Profiles {
+		active := ""
+		if current != nil && current.Name == p.Name {
+			active = "*"
+		}
+		rows = append(rows, []string{active, p.Name, strings.Join(p.Hosts, ", "), p.Identity})
+	}
+	ui.Table(os.Stdout, ui.Out, []string{"", "PROFILE", "HOSTS", "IDENTITY"}, rows)
+}
+
+func handleProfileShow() {
+	p := profile.Current()
+	if p == nil {
+		hostname, _ := os.Hostname()
+		fmt.Printf("No profile is active (hostname %s matches none; select one with --profile or %s)\n", hostname, profile.EnvVar)
+		return
+	}
+
+	fmt.Printf("%s Profile %s (selected by %s)\n", ui.OK(), p.Name, profile.Source())
+	identity := "default search"
+	if p.Identity != "" {
+		identity = p.IdentityPath()
+		if _, err := os.Stat(identity); err != nil {
+			identity += " (missing)"
+		}
+	}
+	if os.Getenv("ENVAULT_IDENTITY") != "" {
+		identity += " (overridden by ENVAULT_IDENTITY)"
+	}
+	fmt.Printf("  Identity:     %s\n", identity)
+	if len(p.Identities) > 0 {
+		fmt.Printf("  Chain:        %s\n", strings.Join(p.Identities, ", "))
+	}
+	fmt.Printf("  Cache:        %t\n", p.Caches())
+	if len(p.DefaultEnvironments) > 0 {
+		fmt.Printf("  Default load: %s\n", strings.Join(p.DefaultEnvironments, ", "))
+	}
+	if bin := profile.AgeBinary(); bin != "" {
+		fmt.Printf("  Age binary:   %s\n", bin)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+
+	"github.com/orchard9/envault/internal/crypto"
+)
+
+// handleRemoteDecrypt is the far end of decrypt_via: it decrypts the
+// ciphertext on stdin with this host's identities and writes the plaintext
+// to stdout. No project is needed on the host.
+func handleRemoteDecrypt() {
+	fs := newFlagSet("remote-decrypt", "envault remote-decrypt [--backend age-ssh|age] < ciphertext")
+	backendName := fs.String("backend", crypto.DefaultBackend, "backend whose local identities decrypt")
+	parseFlags(fs, os.Args[2:])
+
+	if isTerminal(os.Stdin) {
+		fatal("remote-decrypt reads ciphertext on stdin; it is run over ssh by environments with decrypt_via")
+	}
+	backend, err := crypto.LookupBackend(*backendName)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if err := crypto.DecryptStreamWith(backend, os.Stdin, os.Stdout); err != nil {
+		fatal("Failed to decrypt: %v", err)
+	}
+}
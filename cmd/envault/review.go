@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/orchard9/envault/internal/review"
+	"github.com/orchard9/envault/internal/vault"
+)
+
+// handleReviewDiff prints a redacted summary of how the vault changed since
+// a base revision, for a bot to post on the pull request. Values are
+// decrypted in memory to compare them but are never printed.
+func handleReviewDiff() {
+	fs := newFlagSet("review-diff", "envault review-diff [env...] [--base origin/main] [--format markdown|json]")
+	base := fs.String("base", "origin/main", "revision of the vault repository to compare against")
+	format := fs.String("format", "markdown", "output format: markdown or json")
+	envNames := parseFlags(fs, os.Args[2:])
+
+	if *format != "markdown" && *format != "json" {
+		fatal("unknown format %q (use markdown or json)", *format)
+	}
+
+	info, err := vault.Locate()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	report, err := review.Compare(info, *base, envNames)
+	var missing *vault.HistoryError
+	if errors.As(err, &missing) {
+		// Bots read the commands that would fetch the base
+		if *format == "json" {
+			json.NewEncoder(os.Stdout).Encode(map[string]any{"error": err.Error(), "shallow": missing.Shallow, "fetch": missing.Advice()})
+		}
+		fatal("%v - fetch it with one of:\n  %s", err, strings.Join(missing.Advice(), "\n  "))
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fatal("%v", err)
+		}
+	} else {
+		fmt.Print(report.Markdown())
+	}
+
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/sshsig"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleKeysRevocation() {
+	if len(os.Args) < 4 {
+		fmt.Println("Usage: envault keys revocation verify|import <file|->")
+		os.Exit(1)
+	}
+
+	switch os.Args[3] {
+	case "verify":
+		handleRevocationVerify()
+	case "import":
+		handleRevocationImport()
+	default:
+		fatal("unknown keys revocation command %q (expected verify or import)", os.Args[3])
+	}
+}
+
+// handleRevocationVerify checks a revocation certificate against
+// bundle_signers without changing anything
+func handleRevocationVerify() {
+	fs := newFlagSet("keys revocation verify", "envault keys revocation verify <file|->")
+	args := parseFlags(fs, os.Args[4:])
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	revocation, key, signer := trustedRevocation(args[0])
+	fmt.Printf("%s %s revoked %s by %s\n", ui.OK(), key.String(), revocation.RevokedAt.Format("2006-01-02"), signer.Fingerprint)
+	if revocation.Reason != "" {
+		fmt.Printf("  Reason: %s\n", revocation.Reason)
+	}
+}
+
+// handleRevocationImport applies a revocation certificate from another
+// repository: the key leaves authorized_keys and joins revoked_keys
+func handleRevocationImport() {
+	fs := newFlagSet("keys revocation import", "envault keys revocation import <file|->")
+	args := parseFlags(fs, os.Args[4:])
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	revocation, key, signer := trustedRevocation(args[0])
+	removed, revoked, err := keys.ApplyRevocation(*key)
+	if err != nil {
+		fatal("Failed to apply revocation: %v", err)
+	}
+
+	fmt.Printf("%s Revocation of %s signed by %s (%s)\n", ui.OK(), key.Fingerprint, signer.Fingerprint, revocation.RevokedAt.Format("2006-01-02"))
+	if revoked {
+		fmt.Printf("%s Added %s to revoked_keys\n", ui.OK(), key.Fingerprint)
+	}
+	if !removed {
+		if !revoked {
+			fmt.Printf("%s %s is already revoked here\n", ui.OK(), key.Fingerprint)
+		}
+		return
+	}
+	fmt.Printf("%s Removed %s from authorized_keys\n", ui.OK(), key.String())
+	fmt.Println("\nNext steps:")
+	fmt.Println("  1. Re-encrypt to revoke access: envault reencrypt")
+	fmt.Println("  2. Commit: git add .envault/ && git commit -m 'chore: revoke key'")
+}
+
+// trustedRevocation reads a revocation certificate and checks that one of
+// bundle_signers signed it
+func trustedRevocation(path string) (*keys.Revocation, *keys.Key, *keys.Key) {
+	revocation, err := readRevocation(path)
+	if err != nil {
+		fatal("%v", err)
+	}
+	key, err := revocation.Revoked()
+	if err != nil {
+		fatal("%v", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	signer, err := trustedSigner(cfg, "revocation certificate", revocation.Signer, revocation.Message(), revocation.Signature)
+	if err != nil {
+		fatal("%v", err)
+	}
+	return revocation, key, signer
+}
+
+func readRevocation(path string) (*keys.Revocation, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocation certificate: %w", err)
+	}
+	return keys.ParseRevocation(data)
+}
+
+// writeRevocationCert signs a statement that key was revoked with the
+// local SSH key, for other repositories to verify
+func writeRevocationCert(path string, key keys.Key, reason string) {
+	revocation, err := keys.NewRevocation(key, reason)
+	if err != nil {
+		fatal("%v", err)
+	}
+	privateKey, signer := localSigner()
+	revocation.Signer = signer.Recipient()
+	if revocation.Signature, err = sshsig.Sign(privateKey, revocation.Message()); err != nil {
+		fatal("Failed to sign revocation certificate: %v", err)
+	}
+
+	data, err := json.MarshalIndent(revocation, "", "  ")
+	if err != nil {
+		fatal("Failed to encode revocation certificate: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		fatal("Failed to write %s: %v", path, err)
+	}
+	fmt.Printf("%s Wrote revocation certificate for %s to %s, signed by %s\n", ui.OK(), key.Fingerprint, path, signer.Fingerprint)
+}
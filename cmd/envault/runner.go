@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/shellenv"
+)
+
+// EnvFileVar names the private env file given to a task runner, for tools
+// that read variables from a file (Taskfile dotenv:, just's dotenv-path,
+// docker compose --env-file). It is removed when the runner exits.
+const EnvFileVar = "ENVAULT_ENV_FILE"
+
+// runners maps the shim commands to the task runner each one starts
+var runners = map[string]string{
+	"make": "make",
+	"task": "task",
+}
+
+// handleRunner runs make or task with an environment's secrets, e.g.
+// envault make dev build test. Everything after the environment is passed
+// to the runner unchanged, so its own flags need no -- separator.
+func handleRunner(command string) {
+	fs := newFlagSet(command, fmt.Sprintf("envault %s [--tag t] [--bin path] <env> [%s args...]", command, command))
+	tags := fs.String("tag", "", "comma-separated tags; only pass variables carrying one of them")
+	bin := fs.String("bin", runners[command], "runner to start")
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		os.Exit(2)
+	}
+	args := fs.Args()
+	if len(args) < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName, runArgs := args[0], args[1:]
+	if len(runArgs) > 0 && runArgs[0] == "--" {
+		runArgs = runArgs[1:]
+	}
+	runner := append([]string{*bin}, runArgs...)
+
+	// A recipe that calls envault again for the same environment already
+	// has its secrets and env file; run without decrypting twice
+	if os.Getenv(shellenv.TrackEnvVar) == envName && len(splitList(*tags)) == 0 {
+		if path := os.Getenv(EnvFileVar); path != "" && fileExists(path) {
+			os.Exit(runCommand(runner, os.Environ()))
+		}
+	}
+	warnDeprecated(envName)
+
+	// Fail before any plaintext touches disk
+	if _, err := exec.LookPath(*bin); err != nil {
+		fatal("%s not found: %v", *bin, err)
+	}
+
+	entries, err := entriesFor(envName, splitList(*tags))
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	dir, err := os.MkdirTemp(tmpfsDir(), "envault-"+command+"-")
+	if err != nil {
+		fatal("Failed to create temp directory: %v", err)
+	}
+	envFile := filepath.Join(dir, "env")
+
+	var b strings.Builder
+	secrets := map[string]string{}
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s=%s\n", e.Key, dotenv.Quote(e.Value))
+		secrets[e.Key] = e.Value
+	}
+	if err := os.WriteFile(envFile, []byte(b.String()), 0600); err != nil {
+		os.RemoveAll(dir)
+		fatal("Failed to write env file: %v", err)
+	}
+	secrets[EnvFileVar] = envFile
+	secrets[shellenv.TrackEnvVar] = envName
+
+	environ, err := env.Environ(os.Environ(), secrets, env.ExecOptions{})
+	if err != nil {
+		os.RemoveAll(dir)
+		fatal("%v", err)
+	}
+
+	code := runCommand(runner, environ)
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/scan"
+	"github.com/orchard9/envault/internal/ui"
+	"github.com/orchard9/envault/internal/vault"
+)
+
+func handleScan() {
+	fs := newFlagSet("scan", "envault scan [env...] [--min-length n] [--engine gitleaks|trufflehog]")
+	minLength := fs.Int("min-length", scan.DefaultMinLength, "ignore values shorter than this")
+	engine := fs.String("engine", "", "also run gitleaks or trufflehog over the repository")
+	envNames := parseFlags(fs, os.Args[2:])
+
+	if *engine != "" {
+		if err := scan.CheckEngine(*engine); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("%v", err)
+	}
+	if len(envNames) == 0 {
+		for name := range cfg.Environments {
+			envNames = append(envNames, name)
+		}
+		sort.Strings(envNames)
+	}
+
+	var secrets []scan.Secret
+	for _, envName := range envNames {
+		if _, err := cfg.GetEnvironment(envName); err != nil {
+			fatal("%v", err)
+		}
+		values, err := env.Values(envName)
+		if err != nil {
+			fmt.Printf("%s %s: skipped, cannot decrypt: %v\n", ui.Warn(), envName, err)
+			continue
+		}
+		for key, value := range values {
+			secrets = append(secrets, scan.Secret{Env: envName, Key: key, Value: value})
+		}
+	}
+
+	secrets, short := scan.Filter(secrets, *minLength)
+	if short > 0 {
+		fmt.Printf("%s Ignoring %d value(s) shorter than %d characters\n", ui.Warn(), short, *minLength)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		fatal("Failed to get current directory: %v", err)
+	}
+	vaultDir, err := config.EnvaultDir()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	files, err := scan.TrackedFiles(dir, vaultDir, secrets)
+	if err != nil {
+		fatal("%v", err)
+	}
+	commits, err := scan.CommitMessages(dir, secrets)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if vault.IsShallow(dir) {
+		fmt.Printf("%s Shallow clone: only fetched commits were searched; run git fetch --unshallow to search the whole history\n", ui.Warn())
+	}
+	findings := append(files, commits...)
+
+	for _, f := range findings {
+		label := map[string]string{scan.KindFile: "tracked file", scan.KindCI: "CI config", scan.KindCommit: "commit"}[f.Kind]
+		fmt.Printf("%s [%s] %s\n", ui.Fail(), label, f)
+	}
+
+	leaked := len(findings) > 0
+	if *engine != "" {
+		found, err := scan.Engine(*engine, dir)
+		if err != nil {
+			fatal("%v", err)
+		}
+		if found {
+			fmt.Printf("%s %s reported findings\n", ui.Fail(), *engine)
+			leaked = true
+		}
+	}
+
+	exposures, err := env.Exposures(envNames)
+	if err != nil {
+		fmt.Printf("%s Failed to check rendered files: %v\n", ui.Warn(), err)
+	}
+	committed := reportExposures(exposures, "")
+	if committed {
+		leaked = true
+	}
+
+	if !leaked {
+		fmt.Printf("%s No decrypted values found in tracked files or commit messages (%d value(s) checked)\n", ui.OK(), len(secrets))
+		return
+	}
+
+	if len(findings) > 0 || committed {
+		fmt.Println("\nNext steps:")
+		fmt.Println("  - Remove the values from those files and rotate each leaked secret")
+		if committed {
+			fmt.Println("  - Untrack rendered files with: git rm --cached <path>, and add them to .gitignore")
+		}
+		fmt.Println("  - Values in history stay readable until the secret is rotated")
+	}
+	os.Exit(1)
+}
+
+// reportExposures prints rendered files that are committed or
+// world-readable, and returns whether any is committed
+func reportExposures(exposures []env.Exposure, indent string) bool {
+	committed := false
+	for _, e := range exposures {
+		what := e.Path
+		if e.Type != "" {
+			what += " (" + e.Type + " target)"
+		}
+		switch e.Problem {
+		case env.ExposedCommitted:
+			fmt.Printf("%s%s [rendered file] %s holds %s secrets and is tracked by git\n", indent, ui.Fail(), what, e.Env)
+			committed = true
+		case env.ExposedWorldReadable:
+			fmt.Printf("%s%s [rendered file] %s holds %s secrets and is readable by every local user - run: chmod o-r %s\n", indent, ui.Warn(), what, e.Env, e.Path)
+		}
+	}
+	return committed
+}
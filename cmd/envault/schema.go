@@ -0,0 +1,393 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/schema"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleSchema() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault schema add|rm [env] <VAR> | check [env...] | encrypt | decrypt")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "add":
+		handleSchemaAdd()
+	case "rm":
+		handleSchemaRemove()
+	case "check":
+		handleSchemaCheck()
+	case "encrypt":
+		handleSchemaEncrypt(true)
+	case "decrypt":
+		handleSchemaEncrypt(false)
+	default:
+		fatal("unknown schema command %q (expected add, rm, check, encrypt or decrypt)", os.Args[2])
+	}
+}
+
+// handleSchemaAdd creates or updates a variable's rule. Only the flags
+// given change an existing rule.
+func handleSchemaAdd() {
+	fs := newFlagSet("schema add", "envault schema add [env] <VAR> [--type <type>] [--pattern <regexp>] [--required] [--rotate-every <duration>] [--same-in envs] [--differ-in envs]")
+	typ := fs.String("type", "", "value type: url, int, bool, email, uuid, pem or json")
+	pattern := fs.String("pattern", "", "regular expression the whole value must match")
+	required := fs.Bool("required", false, "the variable must be set")
+	rotateEvery := fs.String("rotate-every", "", "rotation window, e.g. 90d")
+	description := fs.String("description", "", "what the variable is for")
+	sameIn := fs.String("same-in", "", "comma-separated environments that must hold the same value")
+	differIn := fs.String("differ-in", "", "comma-separated environments that must each hold a different value")
+	args := parseFlags(fs, os.Args[3:])
+
+	envName, name := schemaTarget(fs, args)
+	sch := loadSchema()
+
+	if *sameIn != "" || *differIn != "" {
+		if envName != "" {
+			fatal("--same-in and --differ-in compare environments, so they take no environment argument")
+		}
+		addConsistency(sch, name, splitList(*sameIn), splitList(*differIn), *description)
+		// Only the cross-environment rule was asked for
+		if fs.NFlag() == countFlags(fs, "same-in", "differ-in", "description") {
+			return
+		}
+	}
+
+	rules := sch.Rules(envName)
+	rule, existed := rules[name]
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "type":
+			rule.Type = *typ
+		case "pattern":
+			rule.Pattern = *pattern
+		case "required":
+			rule.Required = *required
+		case "rotate-every":
+			rule.RotateEvery = *rotateEvery
+		case "description":
+			rule.Description = *description
+		}
+	})
+	if err := rule.Valid(); err != nil {
+		fatal("%s: %v", name, err)
+	}
+	rules[name] = rule
+
+	if err := sch.Save(); err != nil {
+		fatal("Failed to save schema: %v", err)
+	}
+	verb := "Added"
+	if existed {
+		verb = "Updated"
+	}
+	fmt.Printf("%s %s rule for %s (%s)\n", ui.OK(), verb, name, schemaScope(envName))
+
+	fmt.Println("\nNext steps:")
+	fmt.Println("  1. Check existing secrets: envault schema check")
+	fmt.Println("  2. Commit: git add .envault/ && git commit -m 'chore: update envault schema'")
+}
+
+func handleSchemaRemove() {
+	fs := newFlagSet("schema rm", "envault schema rm [env] <VAR>")
+	args := parseFlags(fs, os.Args[3:])
+
+	envName, name := schemaTarget(fs, args)
+	sch := loadSchema()
+	removed := sch.Remove(envName, name)
+	crossEnv := 0
+	if envName == "" {
+		crossEnv = sch.RemoveConsistency(name)
+	}
+	if !removed && crossEnv == 0 {
+		fatal("%s has no rule (%s)", name, schemaScope(envName))
+	}
+	if err := sch.Save(); err != nil {
+		fatal("Failed to save schema: %v", err)
+	}
+	if removed {
+		fmt.Printf("%s Removed rule for %s (%s)\n", ui.OK(), name, schemaScope(envName))
+	}
+	if crossEnv > 0 {
+		fmt.Printf("%s Removed %d cross-environment rule(s) for %s\n", ui.OK(), crossEnv, name)
+	}
+}
+
+// addConsistency saves a cross-environment rule for a variable
+func addConsistency(sch *schema.Schema, name string, sameIn, differIn []string, description string) {
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	for _, envName := range append(append([]string{}, sameIn...), differIn...) {
+		if _, err := cfg.GetEnvironment(envName); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	var rules []schema.Consistency
+	if len(sameIn) > 0 {
+		rules = append(rules, schema.Consistency{Variable: name, Same: sameIn, Description: description})
+	}
+	if len(differIn) > 0 {
+		rules = append(rules, schema.Consistency{Variable: name, Differ: differIn, Description: description})
+	}
+	for _, rule := range rules {
+		if err := rule.Valid(); err != nil {
+			fatal("%v", err)
+		}
+	}
+	for _, rule := range rules {
+		verb := "Added"
+		if sch.SetConsistency(rule) {
+			verb = "Updated"
+		}
+		if err := sch.Save(); err != nil {
+			fatal("Failed to save schema: %v", err)
+		}
+		fmt.Printf("%s %s cross-environment rule: %s\n", ui.OK(), verb, rule)
+	}
+}
+
+// countFlags counts how many of the named flags were set
+func countFlags(fs *flag.FlagSet, names ...string) int {
+	n := 0
+	fs.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				n++
+			}
+		}
+	})
+	return n
+}
+
+// handleSchemaCheck decrypts environments and checks them against the
+// schema, exiting 1 on any violation
+func handleSchemaCheck() {
+	fs := newFlagSet("schema check", "envault schema check [env...]")
+	envNames := parseFlags(fs, os.Args[3:])
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	for _, envName := range envNames {
+		if _, err := cfg.GetEnvironment(envName); err != nil {
+			fatal("%v", err)
+		}
+	}
+	if len(envNames) == 0 {
+		for envName := range cfg.Environments {
+			envNames = append(envNames, envName)
+		}
+		sort.Strings(envNames)
+	}
+
+	failed := false
+	sch := loadSchema()
+	for _, problem := range schemaProblems(sch) {
+		fmt.Printf("%s %s\n", ui.Fail(), problem)
+		failed = true
+	}
+
+	fingerprints := map[string]map[string]string{}
+	for _, envName := range envNames {
+		plaintext, err := crypto.Decrypt(envName)
+		if err != nil {
+			fmt.Printf("%s %s: cannot decrypt: %v\n", ui.Fail(), envName, err)
+			failed = true
+			continue
+		}
+		violations := validateSchema(envName, plaintext)
+		if len(sch.Consistency) > 0 {
+			fingerprints[envName] = valueFingerprints(plaintext)
+		}
+		secmem.Wipe(plaintext)
+
+		if len(violations) == 0 {
+			fmt.Printf("%s %s: %d rules satisfied\n", ui.OK(), envName, len(sch.ForEnvironment(envName)))
+			continue
+		}
+		failed = true
+		fmt.Printf("%s %s:\n", ui.Fail(), envName)
+		for _, v := range violations {
+			fmt.Printf("  - %v\n", v)
+		}
+	}
+
+	if len(sch.Consistency) > 0 {
+		fmt.Println("\nCross-environment rules:")
+		if !checkConsistency(cfg, sch, fingerprints) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// valueFingerprints hashes each value of dotenv plaintext, for comparing
+// environments after their plaintext is wiped
+func valueFingerprints(plaintext []byte) map[string]string {
+	values, err := dotenv.ParseMap(plaintext)
+	if err != nil {
+		return nil
+	}
+	fingerprints := make(map[string]string, len(values))
+	for key, value := range values {
+		fingerprints[key] = schema.Fingerprint(value)
+	}
+	return fingerprints
+}
+
+// checkConsistency applies the cross-environment rules to the
+// environments that were decrypted, printing one line per rule, and
+// reports whether none was broken. A rule naming an environment that was
+// not decrypted is skipped with a warning.
+func checkConsistency(cfg *config.Config, sch *schema.Schema, fingerprints map[string]map[string]string) bool {
+	ok := true
+	for _, rule := range sch.Consistency {
+		kind := "same"
+		if len(rule.Same) == 0 {
+			kind = "differ"
+		}
+		record := func(result, message string) {
+			porcelainRecord("consistency", rule.Variable, kind, strings.Join(rule.Environments(), ","), result, message)
+		}
+		if err := rule.Valid(); err != nil {
+			fmt.Printf("  %s %v\n", ui.Fail(), err)
+			record("invalid", err.Error())
+			ok = false
+			continue
+		}
+		var unknown, missing []string
+		for _, envName := range rule.Environments() {
+			if _, err := cfg.GetEnvironment(envName); err != nil {
+				unknown = append(unknown, envName)
+			} else if _, decrypted := fingerprints[envName]; !decrypted {
+				missing = append(missing, envName)
+			}
+		}
+		switch {
+		case len(unknown) > 0:
+			fmt.Printf("  %s %s: unknown environment %s\n", ui.Fail(), rule, strings.Join(unknown, ", "))
+			record("invalid", "unknown environment "+strings.Join(unknown, ", "))
+			ok = false
+		case len(missing) > 0:
+			fmt.Printf("  %s %s: not checked, %s not decrypted\n", ui.Warn(), rule, strings.Join(missing, ", "))
+			record("skipped", strings.Join(missing, ", ")+" not decrypted")
+		default:
+			if err := rule.Check(fingerprints); err != nil {
+				fmt.Printf("  %s %s: %v\n", ui.Fail(), rule.Variable, err)
+				record("fail", err.Error())
+				ok = false
+			} else {
+				fmt.Printf("  %s %s\n", ui.OK(), rule)
+				record("ok", "")
+			}
+		}
+	}
+	return ok
+}
+
+// handleSchemaEncrypt moves the schema into schema.yaml.age, encrypted to
+// every authorized key, or back to plaintext schema.yaml
+func handleSchemaEncrypt(encrypt bool) {
+	encrypted, err := schema.Encrypted()
+	if err != nil {
+		fatal("%v", err)
+	}
+	if encrypted == encrypt {
+		if encrypt {
+			fmt.Printf("%s The schema is already encrypted in %s\n", ui.OK(), schema.EncryptedFileName)
+		} else {
+			fmt.Printf("%s The schema is already plaintext schema.yaml\n", ui.OK())
+		}
+		return
+	}
+
+	sch := loadSchema()
+	if err := sch.SetEncrypted(encrypt); err != nil {
+		fatal("%v", err)
+	}
+	if encrypt {
+		fmt.Printf("%s Encrypted the schema to every authorized key in %s and removed schema.yaml\n", ui.OK(), schema.EncryptedFileName)
+	} else {
+		fmt.Printf("%s Decrypted the schema to schema.yaml and removed %s\n", ui.OK(), schema.EncryptedFileName)
+	}
+
+	fmt.Println("\nNext steps:")
+	fmt.Println("  1. Commit: git add -A .envault/ && git commit -m 'chore: move envault schema'")
+	if encrypt {
+		fmt.Println("  2. Earlier commits still hold the plaintext schema.yaml")
+	}
+}
+
+// schemaTarget reads [env] <VAR>; without env the rule is shared by every
+// environment
+func schemaTarget(fs *flag.FlagSet, args []string) (string, string) {
+	switch len(args) {
+	case 1:
+		return "", args[0]
+	case 2:
+		cfg, err := config.Load()
+		if err != nil {
+			fatal("Failed to load config: %v", err)
+		}
+		if _, err := cfg.GetEnvironment(args[0]); err != nil {
+			fatal("%v", err)
+		}
+		return args[0], args[1]
+	}
+	fs.Usage()
+	os.Exit(1)
+	return "", ""
+}
+
+func schemaScope(envName string) string {
+	if envName == "" {
+		return "all environments"
+	}
+	return "environment " + envName
+}
+
+func loadSchema() *schema.Schema {
+	sch, err := schema.Load()
+	if err != nil {
+		fatal("Failed to load schema: %v", err)
+	}
+	return sch
+}
+
+// schemaProblems lists rules that cannot be applied, such as an unknown
+// type or a pattern that does not compile
+func schemaProblems(sch *schema.Schema) []string {
+	var problems []string
+	scopes := map[string]map[string]schema.Variable{"": sch.Variables}
+	for envName, env := range sch.Environments {
+		scopes[envName] = env.Variables
+	}
+	for envName, rules := range scopes {
+		for name, rule := range rules {
+			if err := rule.Valid(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s (%s): %v", name, schemaScope(envName), err))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
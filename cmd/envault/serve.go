@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/profile"
+	"github.com/orchard9/envault/internal/server"
+	"github.com/orchard9/envault/internal/state"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleServe() {
+	fs := newFlagSet("serve", "envault serve [--addr host:port] [--tls-cert file --tls-key file [--client-ca file]] [--tokens file | --no-auth] [env...]")
+	addr := fs.String("addr", "127.0.0.1:7755", "address to listen on")
+	tlsCert := fs.String("tls-cert", "", "serve HTTPS with this certificate")
+	tlsKey := fs.String("tls-key", "", "private key for --tls-cert")
+	clientCA := fs.String("client-ca", "", "require client certificates signed by this CA (mTLS)")
+	tokensFile := fs.String("tokens", "", "require scoped bearer tokens from this file (default .envault/tokens.yaml when it exists)")
+	noAuth := fs.Bool("no-auth", false, "serve a loopback address without tokens or client certificates")
+	allowHosts := fs.String("allow-host", "", "comma-separated names clients may use in the Host header besides loopback ones")
+	broker := addBrokerFlags(fs)
+	envNames := parseFlags(fs, os.Args[2:])
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fatal("--tls-cert and --tls-key must be used together")
+	}
+	if *clientCA != "" && *tlsCert == "" {
+		fatal("--client-ca requires --tls-cert and --tls-key")
+	}
+
+	tokens, tokensPath, err := serveTokens(*tokensFile)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fatal("Failed to listen on %s: %v", *addr, err)
+	}
+	loopback := false
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok {
+		loopback = tcpAddr.IP.IsLoopback()
+	}
+	if tokens == nil && *clientCA == "" {
+		switch {
+		case !*noAuth:
+			fatal("serve needs bearer tokens or client certificates: create a token with envault tokens add <name>, or use --client-ca\n  --no-auth serves a loopback address without either")
+		case !loopback:
+			fatal("--no-auth only serves loopback addresses, not %s", listener.Addr())
+		}
+	}
+
+	scheme := "http"
+	if *tlsCert != "" {
+		tlsConfig, err := serverTLSConfig(*tlsCert, *tlsKey, *clientCA)
+		if err != nil {
+			fatal("%v", err)
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+		scheme = "https"
+	}
+
+	fmt.Printf("%s Serving secrets on %s://%s\n", ui.OK(), scheme, listener.Addr())
+	if *clientCA != "" {
+		fmt.Printf("%s Clients must present a certificate signed by %s\n", ui.OK(), *clientCA)
+	}
+	if tokens != nil {
+		fmt.Printf("%s Requests need a bearer token from %s (%d defined)\n", ui.OK(), tokensPath, len(tokens.Tokens))
+	} else if *clientCA == "" {
+		fmt.Printf("%s No authentication: any local process can read these secrets\n", ui.Warn())
+	}
+	srv := newServer(envNames, broker)
+	srv.Tokens = tokens
+	srv.Hosts = serveHosts(*addr, splitList(*allowHosts))
+	printServeEndpoints()
+
+	if err := srv.Serve(listener); err != nil {
+		fatal("Server stopped: %v", err)
+	}
+}
+
+// serveTokens loads the tokens file for serve. Without --tokens, the
+// default file is used only when it exists.
+func serveTokens(path string) (*server.Tokens, string, error) {
+	explicit := path != ""
+	if !explicit {
+		var err error
+		if path, err = server.TokensPath(); err != nil {
+			return nil, "", err
+		}
+	}
+	tokens, err := server.LoadTokens(path)
+	if !explicit && errors.Is(err, fs.ErrNotExist) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if len(tokens.Tokens) == 0 {
+		return nil, "", fmt.Errorf("%s defines no tokens; add one with envault tokens add", path)
+	}
+	return tokens, path, nil
+}
+
+// serveHosts returns the names TCP clients may use in the Host header:
+// those given with --allow-host and the host of --addr, unless it is a
+// wildcard address. Loopback names are always allowed.
+func serveHosts(addr string, allowed []string) []string {
+	hosts := allowed
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// serverTLSConfig loads the serving certificate and, for mTLS, the CA that
+// client certificates must chain to
+func serverTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"}, // gRPC needs HTTP/2
+	}
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
+
+func handleAgent() {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		fatal("Failed to determine .envault directory: %v", err)
+	}
+
+	fs := newFlagSet("agent", "envault agent [--socket path] [env...]")
+	socketPath := fs.String("socket", filepath.Join(envaultDir, "agent.sock"), "unix socket to listen on")
+	broker := addBrokerFlags(fs)
+	envNames := parseFlags(fs, os.Args[2:])
+
+	// Remove a stale socket left by a previous agent
+	os.Remove(*socketPath)
+
+	listener, err := net.Listen("unix", *socketPath)
+	if err != nil {
+		fatal("Failed to listen on %s: %v", *socketPath, err)
+	}
+	defer os.Remove(*socketPath)
+
+	// Other users can only reach the socket if some environment lets them
+	// in; every request is then checked against the environment's uids
+	mode := os.FileMode(0600)
+	if cfg, err := config.Load(); err == nil {
+		mode = server.SocketMode(cfg)
+		if server.SharedSocket(cfg) && !server.PeerCredentials {
+			fmt.Printf("%s access uids cannot be checked on %s; the socket stays private to you and environments with an access list are refused\n", ui.Warn(), runtime.GOOS)
+		}
+	}
+	if err := os.Chmod(*socketPath, mode); err != nil {
+		fatal("Failed to restrict socket permissions: %v", err)
+	}
+
+	// Close the listener on interrupt so the socket gets cleaned up
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		listener.Close()
+	}()
+
+	fmt.Printf("%s Agent listening on %s (mode %04o)\n", ui.OK(), *socketPath, mode)
+	srv := newServer(envNames, broker)
+	srv.SharedSocket = mode&0077 != 0
+	printServeEndpoints()
+
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+		fatal("Agent stopped: %v", err)
+	}
+}
+
+func printServeEndpoints() {
+	fmt.Println("\nEndpoints:")
+	fmt.Println("  GET /v1/environments/<env>         All variables as JSON")
+	fmt.Println("  GET /v1/environments/<env>/<key>   Single value")
+	fmt.Println("  GET /v1/keys/<env>                 Variable names as JSON")
+	fmt.Println("  GET /v1/watch/<env>                Server-sent events when variables change")
+	fmt.Println("  GET /metrics                       Prometheus metrics")
+	fmt.Println("  GET /healthz                       Health check")
+	fmt.Println("  gRPC envault.agent.v1.Agent        GetSecret, ListKeys, WatchEnvironment (pkg/agentpb)")
+}
+
+// auditFileName is the default audit log, under .envault
+const auditFileName = "audit.log"
+
+// brokerFlags hold the accountability and transit settings serve and
+// agent share
+type brokerFlags struct {
+	auditLog  *string
+	auditSize *int
+	auditKeep *int
+	rateLimit *int
+
+	requireTransit *bool
+}
+
+func addBrokerFlags(fs *flag.FlagSet) brokerFlags {
+	return brokerFlags{
+		auditLog:  fs.String("audit-log", "", "audit log of every request (default .envault/audit.log)"),
+		auditSize: fs.Int("audit-max-size", 10, "rotate the audit log at this size, in MiB"),
+		auditKeep: fs.Int("audit-keep", 5, "rotated audit logs to keep"),
+		rateLimit: fs.Int("rate-limit", 120, "requests per minute allowed to each client (0 for no limit)"),
+
+		requireTransit: fs.Bool("require-transit", false, "only send values encrypted to the age recipient in the client's "+server.RecipientHeader+" header"),
+	}
+}
+
+// newServer applies the profile's cache setting and opens the audit log,
+// which is not optional: a broker must account for what it hands out
+func newServer(envNames []string, broker brokerFlags) *server.Server {
+	srv := server.New(envNames)
+	srv.NoCache = !profile.Current().Caches()
+
+	if *broker.auditSize < 1 || *broker.auditKeep < 0 || *broker.rateLimit < 0 {
+		fatal("--audit-max-size must be at least 1, --audit-keep and --rate-limit at least 0")
+	}
+
+	auditPath := *broker.auditLog
+	if auditPath == "" {
+		envaultDir, err := config.EnvaultDir()
+		if err != nil {
+			fatal("%v", err)
+		}
+		auditPath = filepath.Join(envaultDir, auditFileName)
+		if err := state.EnsureIgnored(envaultDir, auditFileName+"*"); err != nil {
+			fatal("Failed to update .envault/.gitignore: %v", err)
+		}
+	}
+	audit, err := server.OpenAudit(auditPath, int64(*broker.auditSize)<<20, *broker.auditKeep)
+	if err != nil {
+		fatal("%v", err)
+	}
+	srv.Audit = audit
+	fmt.Printf("%s Auditing requests to %s (rotated at %d MiB, %d kept)\n", ui.OK(), auditPath, *broker.auditSize, *broker.auditKeep)
+
+	if *broker.rateLimit > 0 {
+		srv.Limiter = server.NewRateLimiter(*broker.rateLimit)
+		fmt.Printf("%s Each client may make %d requests a minute\n", ui.OK(), *broker.rateLimit)
+	}
+
+	srv.RequireTransit = *broker.requireTransit
+	if srv.RequireTransit {
+		fmt.Printf("%s Values are only sent encrypted to a recipient in the %s header\n", ui.OK(), server.RecipientHeader)
+	}
+	return srv
+}
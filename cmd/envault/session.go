@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/profile"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/session"
+	"github.com/orchard9/envault/internal/state"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleSession() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault session start <env...> [--ttl 30m] | stop | status")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "start":
+		handleSessionStart()
+	case "stop":
+		handleSessionStop()
+	case "status":
+		handleSessionStatus()
+	default:
+		fatal("unknown session command %q (expected start, stop or status)", os.Args[2])
+	}
+}
+
+// handleSessionStart decrypts environments once and leaves them with a
+// background process until the TTL passes, replacing any running session
+func handleSessionStart() {
+	fs := newFlagSet("session start", "envault session start <env...> [--ttl 30m]")
+	ttl := fs.String("ttl", "30m", "how long the session holds the decrypted values")
+	envNames := parseFlags(fs, os.Args[3:])
+	if len(envNames) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	duration := parseDurationFlag("ttl", *ttl)
+	if duration <= 0 {
+		fatal("--ttl must be positive")
+	}
+	if p := profile.Current(); !p.Caches() {
+		fatal("Profile %s sets cache: false, and a session keeps decrypted values in memory", p.Name)
+	}
+
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		fatal("Failed to determine .envault directory: %v", err)
+	}
+	socketPath := session.SocketPath(envaultDir)
+	if err := state.EnsureIgnored(envaultDir, session.SocketName); err != nil {
+		fatal("Failed to update .envault/.gitignore: %v", err)
+	}
+
+	payload := session.Payload{
+		Expires:      time.Now().Add(duration).Truncate(time.Second),
+		Environments: map[string]*session.Environment{},
+	}
+	s := crypto.OpenSession()
+	for envName, err := range s.Prewarm(envNames) {
+		s.Close()
+		fatal("Failed to decrypt %s: %v", envName, err)
+	}
+	for _, envName := range envNames {
+		warnDeprecated(envName)
+		hash, err := crypto.CiphertextHash(envName)
+		if err != nil {
+			s.Close()
+			fatal("%v", err)
+		}
+		entries, err := env.Entries(envName, nil)
+		if err != nil {
+			s.Close()
+			fatal("%v", err)
+		}
+		payload.Environments[envName] = &session.Environment{CiphertextHash: hash, Entries: entries}
+	}
+	s.Close()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		fatal("Failed to encode session: %v", err)
+	}
+	defer secmem.Wipe(data)
+
+	if err := session.Stop(socketPath); err == nil {
+		fmt.Printf("%s Stopped the previous session\n", ui.OK())
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("Failed to find the envault binary: %v", err)
+	}
+	cmd := exec.Command(exe, "__session", socketPath)
+	detach(cmd)
+	cmd.Stdin = bytes.NewReader(data)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		fatal("%v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		fatal("Failed to start session: %v", err)
+	}
+	line, _ := bufio.NewReader(stdout).ReadString('\n')
+	if line = strings.TrimSpace(line); line != "ready" {
+		cmd.Wait()
+		if line == "" {
+			line = "it exited"
+		}
+		fatal("Failed to start session: %s", line)
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+
+	fmt.Printf("%s Session holds %s until %s (pid %d)\n", ui.OK(), strings.Join(envNames, ", "), payload.Expires.Format("15:04"), pid)
+	fmt.Println("\nNext steps:")
+	fmt.Printf("  1. Run: envault exec %s -- <command>   (no decryption while the session lasts)\n", envNames[0])
+	fmt.Println("  2. End it early: envault session stop")
+}
+
+// handleSessionAgent is the background half of session start. It reports
+// "ready" or its error on stdout, which session start reads.
+func handleSessionAgent() {
+	if len(os.Args) != 3 {
+		os.Exit(2)
+	}
+	err := session.Serve(os.Args[2], os.Stdin, func() {
+		fmt.Println("ready")
+		os.Stdout.Close()
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func handleSessionStop() {
+	if err := session.Stop(sessionSocket()); err != nil {
+		fatal("%v", err)
+	}
+	fmt.Printf("%s Session stopped\n", ui.OK())
+}
+
+func handleSessionStatus() {
+	info, err := session.Status(sessionSocket())
+	if err == session.ErrNotRunning {
+		fmt.Println("No session is running")
+		return
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+	fmt.Printf("%s Session holds %s until %s (pid %d)\n", ui.OK(), strings.Join(info.Environments, ", "), info.Expires.Format("15:04"), info.PID)
+}
+
+func sessionSocket() string {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		fatal("Failed to determine .envault directory: %v", err)
+	}
+	return session.SocketPath(envaultDir)
+}
+
+// entriesFor returns an environment's entries from the running session if
+// it holds them and the ciphertext is unchanged since it started, so exec
+// and the task runner shims skip decryption; otherwise it decrypts
+func entriesFor(envName string, tags []string) ([]dotenv.Entry, error) {
+	if envaultDir, err := config.EnvaultDir(); err == nil {
+		held, err := session.Get(session.SocketPath(envaultDir), envName)
+		if err == nil && held != nil {
+			if hash, err := crypto.CiphertextHash(envName); err == nil && hash == held.CiphertextHash {
+				return dotenv.FilterTags(held.Entries, tags), nil
+			}
+			fmt.Fprintf(os.Stderr, "%s %s changed since the session started; decrypting it again\n", ui.Warn(), envName)
+		}
+	}
+	return env.Entries(envName, tags)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/share"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleShare() {
+	fs := newFlagSet("share", "envault share <env> <KEY> --to <ssh-pubkey|key-file|github-user> [--expires 24h] [--out file]")
+	to := fs.String("to", "", "recipient: public key, key file, or GitHub username")
+	expires := fs.String("expires", "24h", "how long the share stays valid (0 for no expiry)")
+	out := fs.String("out", "", "write the share blob to a file instead of stdout")
+	args := parseFlags(fs, os.Args[2:])
+
+	if len(args) != 2 || *to == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName, key := args[0], args[1]
+
+	ttl := parseDurationFlag("expires", *expires)
+
+	// age accepts both SSH and X25519 recipients
+	backend, err := crypto.LookupBackend("age")
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	recipients, err := resolveRecipients(*to, backend)
+	if err != nil {
+		fatal("Failed to resolve recipient: %v", err)
+	}
+
+	values, err := env.Values(envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+	value, ok := values[key]
+	if !ok {
+		fatal("%s not found in %s", key, envName)
+	}
+
+	blob, err := share.Create(backend, envName, key, value, recipients, ttl)
+	if err != nil {
+		fatal("Failed to create share: %v", err)
+	}
+
+	if *out != "" {
+		if err := os.WriteFile(*out, []byte(blob+"\n"), 0600); err != nil {
+			fatal("Failed to write %s: %v", *out, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s Wrote share for %s to %s\n", ui.Err.OK(), key, *out)
+	} else {
+		fmt.Println(blob)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s Shared %s from %s with %d key(s)", ui.Err.OK(), key, envName, len(recipients))
+	if ttl > 0 {
+		fmt.Fprintf(os.Stderr, ", valid for %s", *expires)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "\nRecipient runs: envault receive <file>  (or pipe the blob to: envault receive -)")
+}
+
+func handleReceive() {
+	fs := newFlagSet("receive", "envault receive [file|-] [--backend age-ssh|age]")
+	backendName := fs.String("backend", crypto.DefaultBackend, "backend whose local identity decrypts the share")
+	args := parseFlags(fs, os.Args[2:])
+
+	var data []byte
+	var err error
+	if len(args) == 0 || args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		fatal("Failed to read share: %v", err)
+	}
+
+	backend, err := crypto.LookupBackend(*backendName)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	payload, err := share.Open(backend, string(data))
+	if err != nil {
+		fatal("Failed to receive share: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s Received %s from %s (shared %s)\n", ui.Err.OK(), payload.Key, payload.Env, payload.CreatedAt.Format("2006-01-02 15:04 MST"))
+	fmt.Printf("%s=%s\n", payload.Key, payload.Value)
+}
+
+// resolveRecipients turns a --to argument into usable recipient keys.
+// Accepts a raw public key, a file of keys, or a GitHub username.
+func resolveRecipients(to string, backend crypto.Backend) ([]keys.Key, error) {
+	var candidates []keys.Key
+
+	keyType, _, _ := strings.Cut(to, " ")
+	switch {
+	case strings.HasPrefix(to, "ssh-") || strings.HasPrefix(to, "age1") || keys.UnsupportedSSHType(keyType) != "":
+		key, err := keys.ParseKey(to)
+		if err != nil {
+			return nil, err
+		}
+		candidates = []keys.Key{*key}
+	case fileExists(to):
+		found, err := keys.ReadKeyFile(to)
+		if err != nil {
+			return nil, err
+		}
+		candidates = found
+	default:
+		found, err := keys.FromGitHub(to)
+		if err != nil {
+			return nil, err
+		}
+		candidates = found
+	}
+
+	var usable []keys.Key
+	for _, k := range candidates {
+		if err := backend.ValidateRecipient(k); err != nil {
+			fmt.Fprintf(os.Stderr, "%s Skipping %s: %v\n", ui.Err.Warn(), k.Fingerprint, err)
+			continue
+		}
+		usable = append(usable, k)
+	}
+	if len(usable) == 0 {
+		return nil, fmt.Errorf("no usable public keys for %s", to)
+	}
+	return usable, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/orchard9/envault/internal/env"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleStatus() {
+	fs := newFlagSet("status", "envault status [--porcelain]")
+	porcelain := porcelainFlag(fs)
+	parseFlags(fs, os.Args[2:])
+
+	statuses, err := env.Status()
+	if err != nil {
+		fatal("Failed to read state: %v", err)
+	}
+
+	if *porcelain {
+		startPorcelain()
+		for _, s := range statuses {
+			porcelainRecord("target", s.Path, s.Env, s.Status, s.RenderedAt.UTC().Format(time.RFC3339))
+		}
+		return
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("No rendered targets")
+		fmt.Println("\nLoad an environment with: envault dev")
+		return
+	}
+
+	var rows [][]string
+	for _, s := range statuses {
+		rows = append(rows, []string{s.Path, s.Env, s.Status, s.RenderedAt.Local().Format("2006-01-02 15:04")})
+	}
+	ui.Table(os.Stdout, ui.Out, []string{"TARGET", "ENV", "STATUS", "RENDERED"}, rows)
+}
+
+func handleUnload() {
+	fs := newFlagSet("unload", "envault unload <env> [--force]")
+	force := fs.Bool("force", false, "also delete targets with local changes")
+	args := parseFlags(fs, os.Args[2:])
+
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	removeRendered(args[0], *force)
+}
+
+func handleClean() {
+	fs := newFlagSet("clean", "envault clean [--force]")
+	force := fs.Bool("force", false, "also delete targets with local changes")
+	parseFlags(fs, os.Args[2:])
+
+	removeRendered("", *force)
+}
+
+// removeRendered deletes rendered targets for one environment or all and
+// returns the modified targets it kept
+func removeRendered(envName string, force bool) []string {
+	removed, skipped, err := env.Unload(envName, force)
+	for _, path := range removed {
+		fmt.Printf("%s Removed %s\n", ui.OK(), path)
+	}
+	for _, path := range skipped {
+		fmt.Printf("%s Kept %s (local changes - use --force to delete)\n", ui.Warn(), path)
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+	if len(removed) == 0 && len(skipped) == 0 {
+		fmt.Println("No rendered targets to remove")
+	}
+	return skipped
+}
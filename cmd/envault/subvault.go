@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/subvault"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleSubvault() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault subvault create <env> --only <KEY,...> --for <who> [--name n] | refresh [env...] | list | rm <env> <name>")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "create":
+		handleSubvaultCreate()
+	case "refresh":
+		handleSubvaultRefresh()
+	case "list":
+		handleSubvaultList()
+	case "rm":
+		handleSubvaultRemove()
+	default:
+		fatal("unknown subvault command %q (expected create, refresh, list or rm)", os.Args[2])
+	}
+}
+
+// handleSubvaultCreate writes a copy of some of an environment's variables
+// encrypted only to an outside collaborator, and records it in config.yaml
+// so later encrypts keep it current
+func handleSubvaultCreate() {
+	fs := newFlagSet("subvault create", "envault subvault create <env> --only <KEY,...> --for <ssh-pubkey|key-file|github-user> [--name n] [--file f]")
+	only := fs.String("only", "", "comma-separated variables to include")
+	to := fs.String("for", "", "recipient: public key, key file, or GitHub username")
+	name := fs.String("name", "", "subvault name (default: the GitHub username given to --for)")
+	file := fs.String("file", "", "file to write, relative to .envault (default <env>.<name>.age next to the ciphertext)")
+	args := parseFlags(fs, os.Args[3:])
+
+	keyNames := splitList(*only)
+	if len(args) != 1 || len(keyNames) == 0 || *to == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName := args[0]
+
+	if *name == "" && !strings.HasPrefix(*to, "ssh-") && !strings.HasPrefix(*to, "age1") && !fileExists(*to) {
+		*name = *to
+	}
+	if *name == "" {
+		fatal("--name is required when --for is a key or key file")
+	}
+	if !config.ValidSubvaultName(*name) {
+		fatal("invalid subvault name %q: use letters, digits, - and _", *name)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+	backend, err := crypto.BackendFor(cfg, envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+	recipients, err := resolveRecipients(*to, backend)
+	if err != nil {
+		fatal("Failed to resolve recipient: %v", err)
+	}
+
+	sv := &config.Subvault{File: *file, Keys: keyNames}
+	if sv.File == "" {
+		sv.File = env.SubvaultFileName(*name)
+	}
+	for _, k := range recipients {
+		sv.Recipients = append(sv.Recipients, k.Line())
+	}
+
+	plaintext, err := crypto.Decrypt(envName)
+	if err != nil {
+		fatal("Failed to decrypt %s: %v", envName, err)
+	}
+	defer secmem.Wipe(plaintext)
+
+	selected, missing, err := dotenv.SelectKeys(plaintext, keyNames)
+	if err != nil {
+		fatal("Failed to parse %s: %v", envName, err)
+	}
+	secmem.Wipe(selected)
+	if len(missing) > 0 {
+		fatal("%s has no %s", envName, strings.Join(missing, ", "))
+	}
+
+	if env.Subvaults == nil {
+		env.Subvaults = map[string]*config.Subvault{}
+	}
+	_, existed := env.Subvaults[*name]
+	env.Subvaults[*name] = sv
+	cfg.Environments[envName] = *env
+	if err := cfg.Validate(); err != nil {
+		fatal("%v", err)
+	}
+	if _, err := writeSubvault(cfg, envName, *name, sv, plaintext); err != nil {
+		fatal("%v", err)
+	}
+	if err := cfg.Save(); err != nil {
+		fatal("Failed to save config: %v", err)
+	}
+
+	verb := "Created"
+	if existed {
+		verb = "Replaced"
+	}
+	fmt.Printf("%s %s subvault %s of %s with %d variable(s) in .envault/%s\n", ui.OK(), verb, *name, envName, len(keyNames), sv.File)
+	for _, k := range recipients {
+		fmt.Printf("  - %s\n", k.String())
+	}
+
+	fmt.Println("\nNext steps:")
+	fmt.Printf("  1. Send them .envault/%s; they decrypt it with: age -d -i <their private key> %s\n", sv.File, filepath.Base(sv.File))
+	fmt.Println("  2. Commit: git add .envault/ && git commit -m 'chore: add envault subvault'")
+	fmt.Printf("  3. It is rewritten whenever %s is encrypted; retire it with: envault subvault rm %s %s\n", envName, envName, *name)
+}
+
+// handleSubvaultRefresh rewrites subvaults from the current plaintext
+func handleSubvaultRefresh() {
+	fs := newFlagSet("subvault refresh", "envault subvault refresh [env...]")
+	envNames := parseFlags(fs, os.Args[3:])
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	for _, envName := range envNames {
+		env, err := cfg.GetEnvironment(envName)
+		if err != nil {
+			fatal("%v", err)
+		}
+		if len(env.Subvaults) == 0 {
+			fatal("%s has no subvaults", envName)
+		}
+	}
+	if len(envNames) == 0 {
+		envNames = subvaultEnvironments(cfg)
+	}
+	if len(envNames) == 0 {
+		fmt.Printf("%s No subvaults configured\n", ui.OK())
+		return
+	}
+
+	for _, envName := range envNames {
+		refreshEnvSubvaults(envName)
+	}
+}
+
+func handleSubvaultList() {
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+
+	var rows [][]string
+	for _, envName := range subvaultEnvironments(cfg) {
+		env := cfg.Environments[envName]
+		source, _ := crypto.CiphertextHash(envName)
+		for _, name := range sortedSubvaults(&env) {
+			sv := env.Subvaults[name]
+			var fingerprints []string
+			for _, line := range sv.Recipients {
+				if k, err := keys.ParseKey(line); err == nil {
+					fingerprints = append(fingerprints, k.Fingerprint)
+				}
+			}
+			rows = append(rows, []string{envName, name, strings.Join(sv.Keys, ","), strings.Join(fingerprints, ","), subvaultStatus(cfg, envName, sv, source)})
+		}
+	}
+	if len(rows) == 0 {
+		fmt.Println("No subvaults configured")
+		return
+	}
+	ui.Table(os.Stdout, ui.Out, []string{"ENVIRONMENT", "NAME", "KEYS", "RECIPIENTS", "STATUS"}, rows)
+}
+
+func handleSubvaultRemove() {
+	fs := newFlagSet("subvault rm", "envault subvault rm <env> <name>")
+	args := parseFlags(fs, os.Args[3:])
+	if len(args) != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName, name := args[0], args[1]
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		fatal("%v", err)
+	}
+	sv, ok := env.Subvaults[name]
+	if !ok {
+		fatal("%s has no subvault %s", envName, name)
+	}
+
+	delete(env.Subvaults, name)
+	cfg.Environments[envName] = *env
+	if err := cfg.Save(); err != nil {
+		fatal("Failed to save config: %v", err)
+	}
+	path, err := cfg.SubvaultPath(envName, sv)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fatal("Failed to delete %s: %v", sv.File, err)
+	}
+	fmt.Printf("%s Removed subvault %s of %s and deleted .envault/%s\n", ui.OK(), name, envName, sv.File)
+
+	fmt.Println("\nNext steps:")
+	fmt.Println("  1. Rotate the values it held if they should no longer work for its recipients")
+	fmt.Println("  2. Commit: git add -A .envault/ && git commit -m 'chore: remove envault subvault'")
+}
+
+// refreshSubvaults rewrites an environment's subvaults from plaintext that
+// was just encrypted, so collaborators keep getting current values
+func refreshSubvaults(envName string, plaintext []byte) {
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil || len(env.Subvaults) == 0 {
+		return
+	}
+
+	for _, name := range sortedSubvaults(env) {
+		sv := env.Subvaults[name]
+		missing, err := writeSubvault(cfg, envName, name, sv, plaintext)
+		if err != nil {
+			fatal("%v", err)
+		}
+		fmt.Printf("%s Rewrote subvault %s (.envault/%s)\n", ui.OK(), name, sv.File)
+		if len(missing) > 0 {
+			fmt.Printf("%s Subvault %s: %s no longer has %s\n", ui.Warn(), name, envName, strings.Join(missing, ", "))
+		}
+	}
+	if err := cfg.Save(); err != nil {
+		fatal("Failed to save config: %v", err)
+	}
+}
+
+// refreshEnvSubvaults decrypts an environment and rewrites its subvaults,
+// e.g. after re-encryption changed the ciphertext they were made from
+func refreshEnvSubvaults(envName string) {
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config: %v", err)
+	}
+	if env, err := cfg.GetEnvironment(envName); err != nil || len(env.Subvaults) == 0 {
+		return
+	}
+
+	plaintext, err := crypto.Decrypt(envName)
+	if err != nil {
+		fatal("Failed to decrypt %s: %v", envName, err)
+	}
+	defer secmem.Wipe(plaintext)
+	refreshSubvaults(envName, plaintext)
+}
+
+// writeSubvault writes a subvault and records the ciphertext it was cut
+// from; the caller saves the config
+func writeSubvault(cfg *config.Config, envName, name string, sv *config.Subvault, plaintext []byte) ([]string, error) {
+	missing, err := subvault.Write(cfg, envName, sv, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("subvault %s: %w", name, err)
+	}
+	if sv.SourceSHA256, err = crypto.CiphertextHash(envName); err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// checkSubvaults reports subvaults that are missing or were made from
+// older ciphertext
+func checkSubvaults(cfg *config.Config, envName string, env *config.Environment) {
+	source, _ := crypto.CiphertextHash(envName)
+	for _, name := range sortedSubvaults(env) {
+		status := subvaultStatus(cfg, envName, env.Subvaults[name], source)
+		switch status {
+		case "current":
+			fmt.Printf("  %s Subvault %s is current\n", ui.OK(), name)
+		default:
+			fmt.Printf("  %s Subvault %s is %s - run: envault subvault refresh %s\n", ui.Warn(), name, status, envName)
+		}
+	}
+}
+
+func subvaultStatus(cfg *config.Config, envName string, sv *config.Subvault, source string) string {
+	path, err := cfg.SubvaultPath(envName, sv)
+	if err != nil {
+		return "unknown"
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "missing"
+	}
+	if source == "" || sv.SourceSHA256 != source {
+		return "stale"
+	}
+	return "current"
+}
+
+// subvaultEnvironments lists the environments that have subvaults
+func subvaultEnvironments(cfg *config.Config) []string {
+	var envNames []string
+	for envName, env := range cfg.Environments {
+		if len(env.Subvaults) > 0 {
+			envNames = append(envNames, envName)
+		}
+	}
+	sort.Strings(envNames)
+	return envNames
+}
+
+func sortedSubvaults(env *config.Environment) []string {
+	var names []string
+	for name := range env.Subvaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
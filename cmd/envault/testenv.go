@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/testvault"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// handleTestEnv creates a throwaway vault with a generated identity for
+// integration tests. With --ephemeral it runs a command against the vault
+// and removes it afterwards.
+func handleTestEnv() {
+	// Everything after -- is the command, which may contain KEY=VALUE words
+	args, command := os.Args[2:], []string(nil)
+	for i, arg := range args {
+		if arg == "--" {
+			args, command = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	fs := newFlagSet("test-env", "envault test-env <env> [KEY=VALUE...] [--from file] [--ephemeral] [-- command...]")
+	from := fs.String("from", "", "read values from a dotenv file")
+	ephemeral := fs.Bool("ephemeral", false, "run the command against the vault, then delete it")
+	positional := parseFlags(fs, args)
+
+	if len(positional) < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName := positional[0]
+	if *ephemeral && len(command) == 0 {
+		fatal("--ephemeral needs a command to run: envault test-env %s --ephemeral -- <command>", envName)
+	}
+
+	values := map[string]string{}
+	if *from != "" {
+		data, err := os.ReadFile(*from)
+		if err != nil {
+			fatal("Failed to read %s: %v", *from, err)
+		}
+		if values, err = dotenv.ParseMap(data); err != nil {
+			fatal("Failed to parse %s: %v", *from, err)
+		}
+	}
+	for _, pair := range positional[1:] {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			fatal("expected KEY=VALUE, got %q", pair)
+		}
+		values[key] = value
+	}
+
+	root, err := os.MkdirTemp("", "envault-test-")
+	if err != nil {
+		fatal("Failed to create temp directory: %v", err)
+	}
+	projectDir := filepath.Join(root, "project")
+	vault, err := testvault.Create(projectDir, filepath.Join(root, "identity", "id_ed25519"))
+	if err == nil {
+		err = vault.SetEnvironment(envName, values)
+	}
+	if err != nil {
+		os.RemoveAll(root)
+		fatal("Failed to create test vault: %v", err)
+	}
+
+	environ := append(os.Environ(), "ENVAULT_IDENTITY="+vault.Identity, "ENVAULT_TEST_VAULT="+vault.Dir)
+	if *ephemeral {
+		code := runCommand(command, environ)
+		os.RemoveAll(root)
+		os.Exit(code)
+	}
+
+	fmt.Printf("%s Created test vault %s with %s (%d variables)\n", ui.OK(), vault.Dir, envName, len(values))
+	fmt.Printf("%s Generated identity %s\n", ui.OK(), vault.Identity)
+	if len(command) > 0 {
+		code := runCommand(command, environ)
+		fmt.Printf("\nThe vault is kept; remove it with: rm -rf %s\n", root)
+		os.Exit(code)
+	}
+
+	fmt.Println("\nNext steps:")
+	fmt.Printf("  export ENVAULT_IDENTITY=%s ENVAULT_TEST_VAULT=%s\n", vault.Identity, vault.Dir)
+	fmt.Printf("  cd %s && envault %s\n", vault.Dir, envName)
+	fmt.Printf("  Remove it when done: rm -rf %s\n", root)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/orchard9/envault/internal/server"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+func handleTokens() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault tokens add <name> --env <envs> --scope <scopes> | list | remove <name>")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "add":
+		handleTokensAdd()
+	case "list":
+		handleTokensList()
+	case "remove":
+		handleTokensRemove()
+	default:
+		fatal("unknown tokens command %q (expected add, list or remove)", os.Args[2])
+	}
+}
+
+func handleTokensAdd() {
+	flags := newFlagSet("tokens add", "envault tokens add <name> --env <env,...|*> --scope <read,read-key,list> [--file path]")
+	envs := flags.String("env", "", "comma-separated environments the token may read, or * for all served")
+	scopes := flags.String("scope", "", "comma-separated scopes: "+strings.Join(server.Scopes, ", "))
+	file := flags.String("file", "", "tokens file (default .envault/tokens.yaml)")
+	args := parseFlags(flags, os.Args[3:])
+
+	if len(args) != 1 || *envs == "" || *scopes == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+	name := args[0]
+
+	path, tokens := loadTokensFile(*file, true)
+	if tokens.Find(name) != nil {
+		fatal("token %s already exists (remove it first to issue a new one)", name)
+	}
+
+	secret, hash, err := server.NewToken()
+	if err != nil {
+		fatal("%v", err)
+	}
+	tokens.Tokens = append(tokens.Tokens, server.Token{
+		Name:         name,
+		Hash:         hash,
+		Environments: splitList(*envs),
+		Scopes:       splitList(*scopes),
+	})
+	if err := tokens.Save(path); err != nil {
+		fatal("%v", err)
+	}
+
+	fmt.Printf("%s Added token %s to %s\n", ui.OK(), name, path)
+	fmt.Println("\nToken (shown once; only its hash is stored):")
+	fmt.Printf("  %s\n", secret)
+	fmt.Println("\nNext steps:")
+	fmt.Println("  Give the token to the consumer, which sends it as: Authorization: Bearer <token>")
+	fmt.Println("  Restart envault serve to pick up the change")
+}
+
+func handleTokensList() {
+	flags := newFlagSet("tokens list", "envault tokens list [--file path]")
+	file := flags.String("file", "", "tokens file (default .envault/tokens.yaml)")
+	parseFlags(flags, os.Args[3:])
+
+	path, tokens := loadTokensFile(*file, true)
+	if len(tokens.Tokens) == 0 {
+		fmt.Printf("No tokens defined in %s\n", path)
+		return
+	}
+
+	var rows [][]string
+	for _, t := range tokens.Tokens {
+		rows = append(rows, []string{t.Name, strings.Join(t.Environments, ", "), strings.Join(t.Scopes, ", ")})
+	}
+	ui.Table(os.Stdout, ui.Out, []string{"TOKEN", "ENVIRONMENTS", "SCOPES"}, rows)
+}
+
+func handleTokensRemove() {
+	flags := newFlagSet("tokens remove", "envault tokens remove <name> [--file path]")
+	file := flags.String("file", "", "tokens file (default .envault/tokens.yaml)")
+	args := parseFlags(flags, os.Args[3:])
+
+	if len(args) != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	name := args[0]
+
+	path, tokens := loadTokensFile(*file, false)
+	kept := tokens.Tokens[:0]
+	for _, t := range tokens.Tokens {
+		if t.Name != name {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == len(tokens.Tokens) {
+		fatal("token %s not found in %s", name, path)
+	}
+	tokens.Tokens = kept
+	if err := tokens.Save(path); err != nil {
+		fatal("%v", err)
+	}
+
+	fmt.Printf("%s Removed token %s\n", ui.OK(), name)
+	if len(kept) == 0 {
+		fmt.Printf("%s %s has no tokens left; envault serve refuses to start with it until one is added\n", ui.Warn(), path)
+	}
+}
+
+// loadTokensFile reads the tokens file at path, or the default one. A
+// missing file is empty when missingOK is set.
+func loadTokensFile(path string, missingOK bool) (string, *server.Tokens) {
+	if path == "" {
+		var err error
+		if path, err = server.TokensPath(); err != nil {
+			fatal("%v", err)
+		}
+	}
+	tokens, err := server.LoadTokens(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if !missingOK {
+			fatal("no tokens file at %s (create one with envault tokens add)", path)
+		}
+		return path, &server.Tokens{}
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+	return path, tokens
+}
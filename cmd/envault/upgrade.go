@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/orchard9/envault/internal/semver"
+	"github.com/orchard9/envault/internal/ui"
+	"github.com/orchard9/envault/internal/upgrade"
+)
+
+func handleVersion() {
+	fs := newFlagSet("version", "envault version [--check]")
+	check := fs.Bool("check", false, "check GitHub for a newer release")
+	parseFlags(fs, os.Args[2:])
+
+	fmt.Printf("envault version %s\n", version)
+	if !*check {
+		return
+	}
+
+	release, err := upgrade.Latest()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if semver.Newer(release.Version(), version) {
+		fmt.Printf("%s envault %s is available - run: envault upgrade\n", ui.Warn(), release.Version())
+		os.Exit(1)
+	}
+	fmt.Printf("%s Up to date\n", ui.OK())
+}
+
+func handleUpgrade() {
+	fs := newFlagSet("upgrade", "envault upgrade [--force]")
+	force := fs.Bool("force", false, "reinstall even if already on the latest version")
+	parseFlags(fs, os.Args[2:])
+
+	release, err := upgrade.Latest()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if !*force && !semver.Newer(release.Version(), version) {
+		fmt.Printf("%s envault %s is the latest version\n", ui.OK(), version)
+		return
+	}
+
+	fmt.Printf("Downloading envault %s...\n", release.Version())
+	path, err := upgrade.Install(release)
+	if err != nil {
+		fatal("Failed to upgrade: %v", err)
+	}
+
+	fmt.Printf("%s Verified signature and checksum and installed envault %s to %s\n", ui.OK(), release.Version(), path)
+}
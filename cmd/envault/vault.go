@@ -0,0 +1,407 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/remote"
+	"github.com/orchard9/envault/internal/ui"
+	"github.com/orchard9/envault/internal/vault"
+)
+
+func handleVault() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: envault vault status | link <path> | commit [-m <message>] | push [--dry-run] | pull [--rebase]")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "status":
+		handleVaultStatus()
+	case "link":
+		handleVaultLink()
+	case "commit":
+		handleVaultCommit()
+	case "push":
+		handleVaultPush()
+	case "pull":
+		handleVaultPull()
+	default:
+		fatal("unknown vault command %q (expected status, link, commit, push or pull)", os.Args[2])
+	}
+}
+
+func handleVaultStatus() {
+	info, err := vault.Locate()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	fmt.Printf("Vault:      %s\n", info.Dir)
+	fmt.Printf("Mode:       %s\n", info.Mode)
+	if info.VaultRepo != "" {
+		fmt.Printf("Repository: %s\n", info.VaultRepo)
+	}
+	fmt.Printf("Targets:    relative to %s\n", info.ProjectDir)
+
+	if info.Mode == vault.ModeUntracked {
+		return
+	}
+
+	changes, err := info.Status()
+	if err != nil {
+		fatal("%v", err)
+	}
+	if changes == "" {
+		fmt.Printf("\n%s No uncommitted vault changes\n", ui.OK())
+		return
+	}
+	fmt.Printf("\n%s Uncommitted vault changes:\n%s\n", ui.Warn(), changes)
+	fmt.Println("\nCommit them with: envault vault commit")
+}
+
+// handleVaultLink points this project at a vault directory elsewhere,
+// typically inside a shared secrets repository
+func handleVaultLink() {
+	if len(os.Args) != 4 {
+		fatal("Usage: envault vault link <path>")
+	}
+	target := os.Args[3]
+
+	if _, err := os.Stat(filepath.Join(target, "config.yaml")); err != nil {
+		fatal("%s does not contain a config.yaml - run envault init there first", target)
+	}
+
+	if _, err := os.Lstat(".envault"); err == nil {
+		fatal(".envault already exists (remove it to link elsewhere)")
+	}
+
+	pointer := fmt.Sprintf("%s %s\n", config.PointerPrefix, filepath.ToSlash(target))
+	if err := os.WriteFile(".envault", []byte(pointer), 0644); err != nil {
+		fatal("Failed to write .envault: %v", err)
+	}
+
+	fmt.Printf("%s Linked .envault to %s\n", ui.OK(), target)
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Verify: envault vault status")
+	fmt.Println("  - Commit the pointer: git add .envault && git commit -m 'chore: link shared vault'")
+}
+
+func handleVaultCommit() {
+	fs := newFlagSet("vault commit", "envault vault commit [-m <message>]")
+	message := fs.String("m", "chore: update secrets", "commit message")
+	parseFlags(fs, os.Args[3:])
+
+	info, err := vault.Locate()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	committed, err := info.Commit(*message)
+	if err != nil {
+		fatal("Failed to commit: %v", err)
+	}
+	if !committed {
+		fmt.Printf("%s Nothing to commit\n", ui.OK())
+		return
+	}
+
+	fmt.Printf("%s Committed vault changes in %s\n", ui.OK(), info.VaultRepo)
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Push: envault vault push")
+	if info.Mode == vault.ModeSubmodule {
+		fmt.Println("  - The new submodule revision is staged; commit it in the project: git commit -m 'chore: bump vault'")
+	}
+}
+
+// handleVaultPush pushes the vault's repository
+func handleVaultPush() {
+	fs := newFlagSet("vault push", "envault vault push [--dry-run]")
+	dryRun := dryRunFlag(fs)
+	parseFlags(fs, os.Args[3:])
+
+	info, err := vault.Locate()
+	if err != nil {
+		fatal("%v", err)
+	}
+	vaultPush(info, *dryRun)
+	if *dryRun {
+		endDryRun()
+	}
+}
+
+// vaultPush pushes the vault's repository, or with dryRun prints what
+// would be pushed
+func vaultPush(info *vault.Info, dryRun bool) {
+	if dryRun {
+		d, err := info.PushPlan()
+		if err != nil {
+			fatal("Failed to push: %v", err)
+		}
+		if d.Ahead == 0 {
+			fmt.Printf("%s Nothing to push; %s is up to date\n", ui.OK(), d.Upstream)
+		} else {
+			planLine("push %d commit(s) from %s to %s, changing:", d.Ahead, info.VaultRepo, d.Upstream)
+			for _, path := range d.Local {
+				fmt.Printf("  - %s\n", path)
+			}
+		}
+		return
+	}
+	if err := info.Push(); err != nil {
+		fatal("Failed to push: %v", err)
+	}
+	fmt.Printf("%s Pushed %s\n", ui.OK(), info.VaultRepo)
+}
+
+func handleVaultPull() {
+	fs := newFlagSet("vault pull", "envault vault pull [--rebase]")
+	rebase := fs.Bool("rebase", false, "replay local commits on top of the upstream when they touch different files")
+	parseFlags(fs, os.Args[3:])
+
+	info, err := vault.Locate()
+	if err != nil {
+		fatal("%v", err)
+	}
+	if err := info.Pull(*rebase); err != nil {
+		fatal("Failed to pull: %v", err)
+	}
+	fmt.Printf("%s Updated %s\n", ui.OK(), info.VaultRepo)
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Re-render targets: envault status")
+}
+
+func handleSync() {
+	if len(os.Args) < 3 {
+		fatal("Usage: envault sync status [--offline] | push [--dry-run] [--force]")
+	}
+	switch os.Args[2] {
+	case "status":
+		handleSyncStatus()
+	case "push":
+		handleSyncPush()
+	default:
+		fatal("unknown sync command %q (expected status or push)", os.Args[2])
+	}
+}
+
+// syncTargets splits environments into those whose ciphertext lives on a
+// remote and those kept in git, and reports whether the vault's
+// repository is part of the sync: always when nothing uses a remote, so
+// an untracked vault still says why it cannot sync
+func syncTargets() (*config.Config, *vault.Info, []string, bool) {
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("%v", err)
+	}
+	info, err := vault.Locate()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	var remotes []string
+	inGit := false
+	for envName := range cfg.Environments {
+		if cfg.RemoteFor(envName) != nil {
+			remotes = append(remotes, envName)
+		} else {
+			inGit = true
+		}
+	}
+	sort.Strings(remotes)
+	useGit := len(remotes) == 0 || (inGit && info.Mode != vault.ModeUntracked)
+	return cfg, info, remotes, useGit
+}
+
+func handleSyncStatus() {
+	fs := newFlagSet("sync status", "envault sync status [--offline]")
+	offline := fs.Bool("offline", false, "compare with the upstream as last fetched, and with the remote ciphertext as last fetched or pushed")
+	parseFlags(fs, os.Args[3:])
+
+	cfg, info, remotes, useGit := syncTargets()
+	conflict := false
+	if len(remotes) > 0 {
+		conflict = remoteSyncStatus(cfg, remotes, *offline)
+	}
+	if useGit {
+		if len(remotes) > 0 {
+			fmt.Println()
+		}
+		if vaultSyncStatus(info, *offline) {
+			conflict = true
+		}
+	}
+	if conflict {
+		os.Exit(1)
+	}
+}
+
+// remoteSyncStatus compares each environment's encrypted_file and remote
+// with the copy last fetched or pushed, and reports whether any changed
+// on both sides
+func remoteSyncStatus(cfg *config.Config, envNames []string, offline bool) bool {
+	fmt.Println("Remote ciphertext:")
+	var conflicts []string
+	pending := false
+	for _, envName := range envNames {
+		d, err := remote.Compare(cfg, envName, offline)
+		if err != nil {
+			fmt.Printf("  %s %s: %v (--offline compares with the copy last fetched)\n", ui.Warn(), envName, err)
+			continue
+		}
+		since := "the copy here was never fetched"
+		if !d.FetchedAt.IsZero() {
+			since = "fetched or pushed " + remote.Age(time.Since(d.FetchedAt)) + " ago"
+		}
+
+		switch {
+		case d.Conflict() && d.FetchedAt.IsZero():
+			fmt.Printf("  %s %s: %s has a different copy, and the one here was not fetched from it\n", ui.Fail(), envName, d.URL)
+			conflicts = append(conflicts, envName)
+		case d.Conflict():
+			fmt.Printf("  %s %s: changed here and at %s since it was %s\n", ui.Fail(), envName, d.URL, since)
+			conflicts = append(conflicts, envName)
+		case d.NoLocal && d.RemoteMissing:
+			fmt.Printf("  %s %s: no ciphertext here or at %s\n", ui.Warn(), envName, d.URL)
+		case d.NoLocal:
+			fmt.Printf("  %s %s: not fetched yet; the next command that reads it fetches it\n", ui.OK(), envName)
+		case d.LocalChanged && !d.Checked:
+			fmt.Printf("  %s %s: local change not pushed to %s (remote not checked)\n", ui.OK(), envName, d.URL)
+			pending = true
+		case d.LocalChanged:
+			fmt.Printf("  %s %s: local change not pushed to %s\n", ui.OK(), envName, d.URL)
+			pending = true
+		case d.RemoteMissing:
+			fmt.Printf("  %s %s: %s no longer has a copy\n", ui.Warn(), envName, d.URL)
+		case d.RemoteChanged:
+			fmt.Printf("  %s %s: %s has a newer copy; the next command that reads it fetches it\n", ui.Warn(), envName, d.URL)
+		case !d.Checked:
+			fmt.Printf("  %s %s: unchanged here; %s (remote not checked)\n", ui.OK(), envName, since)
+		default:
+			fmt.Printf("  %s %s: in sync with %s\n", ui.OK(), envName, d.URL)
+		}
+	}
+
+	switch {
+	case len(conflicts) > 0:
+		fmt.Printf("\n%s Changed on both sides: %s\n", ui.Fail(), strings.Join(conflicts, ", "))
+		fmt.Println("\nNext steps:")
+		fmt.Println("  - Keep the remote copy: delete the local encrypted_file; the next command fetches it")
+		fmt.Println("  - Re-apply your change: envault encrypt <env> <file>, then envault sync push")
+		fmt.Println("  - Or replace the remote copy with yours: envault sync push --force")
+	case pending:
+		fmt.Println("\nNext steps:")
+		fmt.Println("  - Push: envault sync push")
+	}
+	return len(conflicts) > 0
+}
+
+// vaultSyncStatus compares the vault's repository with its upstream and
+// reports whether a file changed on both sides
+func vaultSyncStatus(info *vault.Info, offline bool) bool {
+	if !offline {
+		if err := info.Fetch(); err != nil {
+			fatal("Failed to fetch: %v (--offline to use the last fetch)", err)
+		}
+	}
+
+	d, err := info.Divergence()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	fmt.Printf("Repository: %s\n", info.VaultRepo)
+	fmt.Printf("Upstream:   %s\n", d.Upstream)
+	fmt.Printf("Ahead:      %d\n", d.Ahead)
+	fmt.Printf("Behind:     %d\n", d.Behind)
+	if len(d.Local) > 0 {
+		fmt.Printf("Local:      %s\n", strings.Join(d.Local, ", "))
+	}
+	if len(d.Remote) > 0 {
+		fmt.Printf("Remote:     %s\n", strings.Join(d.Remote, ", "))
+	}
+
+	switch {
+	case len(d.Both) > 0:
+		fmt.Printf("\n%s Changed on both sides: %s\n", ui.Fail(), strings.Join(d.Both, ", "))
+		fmt.Println("\nNext steps:")
+		fmt.Printf("  - Keep the upstream version: git -C %s reset --hard %s\n", info.VaultRepo, d.Upstream)
+		fmt.Println("  - Re-apply your change: envault encrypt <env> <file>, then envault vault commit")
+		return true
+	case d.Diverged():
+		fmt.Printf("\n%s Diverged, but no file changed on both sides\n", ui.Warn())
+		fmt.Println("\nNext steps:")
+		fmt.Println("  - Replay your commits: envault vault pull --rebase")
+		fmt.Println("  - Then push: envault vault push")
+	case d.Behind > 0:
+		fmt.Printf("\n%s Behind %s\n", ui.Warn(), d.Upstream)
+		fmt.Println("\nNext steps:")
+		fmt.Println("  - Update: envault vault pull")
+	case d.Ahead > 0:
+		fmt.Printf("\n%s Ahead of %s\n", ui.OK(), d.Upstream)
+		fmt.Println("\nNext steps:")
+		fmt.Println("  - Push: envault vault push")
+	default:
+		fmt.Printf("\n%s In sync with %s\n", ui.OK(), d.Upstream)
+	}
+	return false
+}
+
+// handleSyncPush uploads remote ciphertext changed here, each only if the
+// remote still holds the copy it was made from, then pushes the vault's
+// repository when environments are kept in git
+func handleSyncPush() {
+	fs := newFlagSet("sync push", "envault sync push [--dry-run] [--force]")
+	dryRun := dryRunFlag(fs)
+	force := fs.Bool("force", false, "upload remote ciphertext without checking that the remote still holds the copy it was made from")
+	parseFlags(fs, os.Args[3:])
+
+	cfg, info, remotes, useGit := syncTargets()
+	failed, conflict := false, false
+	for _, envName := range remotes {
+		u, err := remote.Pending(cfg, envName, *force)
+		if err != nil {
+			fmt.Printf("%s %s: %v\n", ui.Fail(), envName, err)
+			failed = true
+			continue
+		}
+		if u == nil {
+			fmt.Printf("%s %s: nothing to push\n", ui.OK(), envName)
+			continue
+		}
+		if *dryRun {
+			planLine("upload %s to %s %s", u.Path, u.URL, u.Describe())
+			continue
+		}
+		if err := u.Send(); err != nil {
+			fmt.Printf("%s %s: %v\n", ui.Fail(), envName, err)
+			conflict = conflict || errors.Is(err, remote.ErrConflict)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s %s: pushed to %s\n", ui.OK(), envName, u.URL)
+	}
+
+	if conflict {
+		fmt.Println("\nNext steps:")
+		fmt.Println("  - See what changed: envault sync status")
+		fmt.Println("  - Keep the remote copy: delete the local encrypted_file, then re-apply your change with envault encrypt")
+		fmt.Println("  - Or replace the remote copy with yours: envault sync push --force")
+	}
+
+	if useGit {
+		vaultPush(info, *dryRun)
+	}
+	if *dryRun {
+		endDryRun()
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
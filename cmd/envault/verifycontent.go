@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// handleVerifyContent checks that an environment's committed ciphertext
+// decrypts to approved content, given as a plaintext file or its SHA-256.
+// Only digests are compared, so large payloads are streamed, never held.
+func handleVerifyContent() {
+	fs := newFlagSet("verify-content", "envault verify-content <env> <plaintext-file|-> | --sha256 <hex>")
+	sum := fs.String("sha256", "", "compare against this SHA-256 of the approved plaintext instead of a file")
+	args := parseFlags(fs, os.Args[2:])
+
+	withFile := len(args) == 2 && *sum == ""
+	withSum := len(args) == 1 && *sum != ""
+	if !withFile && !withSum {
+		fs.Usage()
+		os.Exit(1)
+	}
+	envName := args[0]
+
+	var expected []byte
+	source := "--sha256"
+	if withSum {
+		var err error
+		expected, err = hex.DecodeString(strings.TrimPrefix(strings.ToLower(*sum), "sha256:"))
+		if err != nil || len(expected) != sha256.Size {
+			fatal("--sha256 must be 64 hex characters")
+		}
+	} else {
+		source = args[1]
+		var err error
+		if expected, err = hashInput(source); err != nil {
+			fatal("Failed to read %s: %v", source, err)
+		}
+	}
+
+	h := sha256.New()
+	if err := crypto.DecryptToWriter(envName, h); err != nil {
+		fatal("Failed to decrypt %s: %v", envName, err)
+	}
+
+	if subtle.ConstantTimeCompare(h.Sum(nil), expected) != 1 {
+		fmt.Printf("%s %s: ciphertext does not decrypt to the content of %s\n", ui.Fail(), envName, source)
+		os.Exit(1)
+	}
+	fmt.Printf("%s %s: ciphertext decrypts to the approved content (sha256 %s)\n", ui.OK(), envName, hex.EncodeToString(expected))
+}
+
+// hashInput returns the SHA-256 of a file, or of stdin for "-"
+func hashInput(path string) ([]byte, error) {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -2,4 +2,16 @@ module github.com/orchard9/envault
 
 go 1.22
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	golang.org/x/crypto v0.24.0
+	golang.org/x/net v0.23.0
+	golang.org/x/sys v0.21.0
+	google.golang.org/grpc v1.63.2
+	google.golang.org/protobuf v1.34.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240227224415-6ceb2ff114de // indirect
+)
@@ -35,14 +35,18 @@ detect_platform() {
     case "$(uname -s)" in
         Linux*)     os="Linux" ;;
         Darwin*)    os="Darwin" ;;
+        FreeBSD*)   os="Freebsd" ;;
+        OpenBSD*)   os="Openbsd" ;;
+        NetBSD*)    os="Netbsd" ;;
+        SunOS*)     os="Illumos" ;;
         CYGWIN*|MINGW*|MSYS*) os="Windows" ;;
         *)          error "Unsupported operating system: $(uname -s)" ;;
     esac
 
     # Detect architecture
     case "$(uname -m)" in
-        x86_64|amd64)   arch="x86_64" ;;
-        arm64|aarch64)  arch="arm64" ;;
+        x86_64|amd64|i86pc) arch="x86_64" ;;
+        arm64|aarch64)      arch="arm64" ;;
         *)              error "Unsupported architecture: $(uname -m)" ;;
     esac
 
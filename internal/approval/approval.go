@@ -0,0 +1,154 @@
+package approval
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/manifest"
+	"github.com/orchard9/envault/internal/sshsig"
+)
+
+// Message returns the data signed to approve a ciphertext
+func Message(envName, ciphertextHash string) []byte {
+	return []byte(fmt.Sprintf("envault-approval:v1\nenv=%s\nsha256=%s\n", envName, ciphertextHash))
+}
+
+// Approve signs the environment's current ciphertext with the local SSH key
+// and records the approval in the manifest
+func Approve(envName string) (*manifest.Approval, error) {
+	hash, err := crypto.CiphertextHash(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := crypto.FindSSHPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := authorizedKeyFor(envName, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := sshsig.Sign(privateKey, Message(envName, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	a := manifest.Approval{
+		Fingerprint:    signer.Fingerprint,
+		CiphertextHash: hash,
+		Signature:      signature,
+		SignedAt:       time.Now().UTC(),
+	}
+
+	m, err := manifest.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep only approvals for the current ciphertext, one per key
+	env := m.Environment(envName)
+	var kept []manifest.Approval
+	for _, existing := range env.Approvals {
+		if existing.CiphertextHash == hash && existing.Fingerprint != a.Fingerprint {
+			kept = append(kept, existing)
+		}
+	}
+	env.Approvals = append(kept, a)
+
+	if err := m.Save(); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// Verify returns the fingerprints of distinct authorized keys holding a
+// valid signature over the environment's current ciphertext
+func Verify(envName string) ([]string, error) {
+	hash, err := crypto.CiphertextHash(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := manifest.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	env, ok := m.Environments[envName]
+	if !ok {
+		return nil, nil
+	}
+
+	authorizedKeys, err := approvers(envName)
+	if err != nil {
+		return nil, err
+	}
+	byFingerprint := map[string]keys.Key{}
+	for _, k := range authorizedKeys {
+		byFingerprint[k.Fingerprint] = k
+	}
+
+	seen := map[string]bool{}
+	var valid []string
+	for _, a := range env.Approvals {
+		if a.CiphertextHash != hash || seen[a.Fingerprint] {
+			continue
+		}
+		key, ok := byFingerprint[a.Fingerprint]
+		if !ok {
+			continue
+		}
+		if err := sshsig.Verify(key.Recipient(), Message(envName, hash), a.Signature); err != nil {
+			continue
+		}
+		seen[a.Fingerprint] = true
+		valid = append(valid, a.Fingerprint)
+	}
+
+	return valid, nil
+}
+
+// approvers returns the keys that may approve an environment: those it is
+// encrypted to, from its root's authorized_keys
+func approvers(envName string) ([]keys.Key, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	return keys.LoadFor(cfg, envName)
+}
+
+// authorizedKeyFor returns the approver matching a private key's .pub
+func authorizedKeyFor(envName, privateKey string) (*keys.Key, error) {
+	data, err := os.ReadFile(privateKey + ".pub")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key for %s: %w", privateKey, err)
+	}
+
+	pub, err := keys.ParseKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s.pub: %w", privateKey, err)
+	}
+
+	authorizedKeys, err := approvers(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range authorizedKeys {
+		if k.Data == pub.Data {
+			return &k, nil
+		}
+	}
+
+	return nil, fmt.Errorf("your key %s is not in authorized_keys", pub.Fingerprint)
+}
@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Files whose changes require the environments to be re-encrypted
+var recipientFiles = []string{"authorized_keys", "revoked_keys"}
+
+// Job is a re-encryption requested by a webhook
+type Job struct {
+	Repo     string // owner/name
+	CloneURL string
+	Branch   string
+	Reason   string // e.g. "push abc1234" or "pull request #12"
+
+	// MergeCommit is set for a merged pull request, whose changed files
+	// are not in the payload and are read from git instead
+	MergeCommit string
+}
+
+// VerifySignature checks GitHub's X-Hub-Signature-256 header, an HMAC
+// of the body with the webhook secret
+func VerifySignature(secret, body []byte, header string) bool {
+	digest, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(digest)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// ParseEvent returns the job a webhook calls for, or nil when the event
+// is irrelevant: pushes to the default branch touching authorized_keys
+// or revoked_keys, and merged pull requests into it
+func ParseEvent(eventType string, body []byte) (*Job, error) {
+	switch eventType {
+	case "push":
+		var e struct {
+			Ref     string `json:"ref"`
+			After   string `json:"after"`
+			Commits []struct {
+				Added    []string `json:"added"`
+				Modified []string `json:"modified"`
+				Removed  []string `json:"removed"`
+			} `json:"commits"`
+			Repository repository `json:"repository"`
+		}
+		if err := json.Unmarshal(body, &e); err != nil {
+			return nil, fmt.Errorf("invalid push payload: %w", err)
+		}
+		if e.Ref != "refs/heads/"+e.Repository.DefaultBranch {
+			return nil, nil
+		}
+		touched := false
+		for _, c := range e.Commits {
+			for _, list := range [][]string{c.Added, c.Modified, c.Removed} {
+				touched = touched || TouchesRecipients(list)
+			}
+		}
+		if !touched {
+			return nil, nil
+		}
+		return e.Repository.job("push " + short(e.After)), nil
+
+	case "pull_request":
+		var e struct {
+			Action      string `json:"action"`
+			Number      int    `json:"number"`
+			PullRequest struct {
+				Merged         bool   `json:"merged"`
+				MergeCommitSHA string `json:"merge_commit_sha"`
+				Base           struct {
+					Ref string `json:"ref"`
+				} `json:"base"`
+			} `json:"pull_request"`
+			Repository repository `json:"repository"`
+		}
+		if err := json.Unmarshal(body, &e); err != nil {
+			return nil, fmt.Errorf("invalid pull_request payload: %w", err)
+		}
+		pr := e.PullRequest
+		if e.Action != "closed" || !pr.Merged || pr.Base.Ref != e.Repository.DefaultBranch {
+			return nil, nil
+		}
+		job := e.Repository.job(fmt.Sprintf("pull request #%d", e.Number))
+		job.MergeCommit = pr.MergeCommitSHA
+		return job, nil
+	}
+	return nil, nil
+}
+
+// TouchesRecipients reports whether any path is a vault's authorized_keys
+// or revoked_keys
+func TouchesRecipients(paths []string) bool {
+	for _, p := range paths {
+		for _, name := range recipientFiles {
+			if path.Base(p) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type repository struct {
+	FullName      string `json:"full_name"`
+	CloneURL      string `json:"clone_url"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+func (r repository) job(reason string) *Job {
+	return &Job{Repo: r.FullName, CloneURL: r.CloneURL, Branch: r.DefaultBranch, Reason: reason}
+}
+
+func short(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
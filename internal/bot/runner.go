@@ -0,0 +1,169 @@
+package bot
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Runner re-encrypts a repository's vault in a clone under WorkDir, with
+// the bot's own identity, and pushes the result
+type Runner struct {
+	WorkDir    string
+	Executable string // the envault binary, run for reencrypt and vault commit/push
+	Identity   string // bot identity file, passed as ENVAULT_IDENTITY
+	Token      string // HTTPS token for clone and push, e.g. a GitHub App installation token
+	Subdir     string // directory holding .envault, relative to the repository root
+
+	AuthorName  string
+	AuthorEmail string
+
+	// done is the last commit per repository whose ciphertext the runner
+	// brought up to date, so duplicate events (a merge sends both a push
+	// and a pull_request) re-encrypt once. Jobs run one at a time.
+	done map[string]string
+}
+
+// Run carries out a job. It reports false when there was nothing to do:
+// the merge did not touch the recipients, or the ciphertext was current.
+func (r *Runner) Run(job *Job) (bool, error) {
+	clone := filepath.Join(r.WorkDir, strings.ReplaceAll(job.Repo, "/", "__"))
+	if err := r.checkout(clone, job); err != nil {
+		return false, err
+	}
+
+	if job.MergeCommit != "" {
+		changed, err := r.git(clone, "diff", "--name-only", job.MergeCommit+"^1", job.MergeCommit)
+		if err != nil {
+			return false, err
+		}
+		if !TouchesRecipients(strings.Fields(changed)) {
+			return false, nil
+		}
+	}
+
+	if r.upToDate(clone, job.Repo) {
+		return false, nil
+	}
+
+	before, err := r.git(clone, "rev-parse", "HEAD")
+	if err != nil {
+		return false, err
+	}
+
+	project := filepath.Join(clone, r.Subdir)
+	message := fmt.Sprintf("chore: re-encrypt secrets after key change (%s)", job.Reason)
+	for _, args := range [][]string{{"reencrypt"}, {"vault", "commit", "-m", message}} {
+		if err := r.envault(project, args...); err != nil {
+			return false, err
+		}
+	}
+
+	after, err := r.git(clone, "rev-parse", "HEAD")
+	if err != nil {
+		return false, err
+	}
+	if after == before {
+		r.markDone(job.Repo, after)
+		return false, nil
+	}
+	if err := r.envault(project, "vault", "push"); err != nil {
+		return false, err
+	}
+	r.markDone(job.Repo, after)
+	return true, nil
+}
+
+// upToDate reports whether the latest change to the recipient files is
+// already covered by a commit the runner brought up to date
+func (r *Runner) upToDate(clone, repo string) bool {
+	done := r.done[repo]
+	if done == "" {
+		return false
+	}
+	change, err := r.git(clone, "log", "-1", "--format=%H", "--", ":(glob)**/authorized_keys", ":(glob)**/revoked_keys")
+	if err != nil || change == "" {
+		return false
+	}
+	_, err = r.git(clone, "merge-base", "--is-ancestor", change, done)
+	return err == nil
+}
+
+func (r *Runner) markDone(repo, commit string) {
+	if r.done == nil {
+		r.done = map[string]string{}
+	}
+	r.done[repo] = commit
+}
+
+// checkout clones the repository, or brings an existing clone to the tip
+// of the job's branch, discarding anything left by an earlier run
+func (r *Runner) checkout(clone string, job *Job) error {
+	if _, err := os.Stat(filepath.Join(clone, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(r.WorkDir, 0700); err != nil {
+			return fmt.Errorf("failed to create work directory: %w", err)
+		}
+		_, err := r.git(r.WorkDir, "clone", "--quiet", "--branch", job.Branch, job.CloneURL, clone)
+		return err
+	}
+
+	if _, err := r.git(clone, "fetch", "--quiet", "origin", job.Branch); err != nil {
+		return err
+	}
+	if _, err := r.git(clone, "checkout", "--quiet", "--force", "-B", job.Branch, "FETCH_HEAD"); err != nil {
+		return err
+	}
+	_, err := r.git(clone, "clean", "--quiet", "-fdx")
+	return err
+}
+
+// environ is the environment for git and envault: the bot's identity,
+// commit author and credentials, and no interactive prompts
+func (r *Runner) environ() []string {
+	env := append(os.Environ(),
+		"ENVAULT_IDENTITY="+r.Identity,
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_AUTHOR_NAME="+r.AuthorName,
+		"GIT_AUTHOR_EMAIL="+r.AuthorEmail,
+		"GIT_COMMITTER_NAME="+r.AuthorName,
+		"GIT_COMMITTER_EMAIL="+r.AuthorEmail,
+	)
+	if r.Token != "" {
+		// Sent as a header so the token never lands in .git/config
+		basic := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + r.Token))
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+basic,
+		)
+	}
+	return env
+}
+
+func (r *Runner) git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = r.environ()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (r *Runner) envault(dir string, args ...string) error {
+	cmd := exec.Command(r.Executable, append([]string{"--no-color"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = r.environ()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		name := args[0]
+		if name == "vault" {
+			name += " " + args[1]
+		}
+		return fmt.Errorf("envault %s: %s", name, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// maxPayload bounds webhook bodies; GitHub caps them at 25 MB
+const maxPayload = 25 << 20
+
+// Server receives webhooks and runs their jobs one at a time. A repository
+// is queued at most once: the job re-reads the branch when it runs, so
+// repeated events while it waits add nothing.
+type Server struct {
+	Secret []byte          // webhook secret shared with GitHub
+	Repos  map[string]bool // owner/name accepted; empty accepts none
+	Runner *Runner
+	Log    *log.Logger
+
+	mu      sync.Mutex
+	pending map[string]bool
+	jobs    chan *Job
+}
+
+// Start launches the worker and returns the webhook handler
+func (s *Server) Start() http.Handler {
+	s.pending = map[string]bool{}
+	s.jobs = make(chan *Job, 64)
+	go s.work()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook", s.handleWebhook)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPayload))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if !VerifySignature(s.Secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	job, err := ParseEvent(event, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if job == nil {
+		fmt.Fprintf(w, "ignored %s event\n", event)
+		return
+	}
+	if !s.Repos[job.Repo] {
+		http.Error(w, fmt.Sprintf("repository %s is not served by this bot", job.Repo), http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	queued := s.pending[job.Repo]
+	if !queued {
+		select {
+		case s.jobs <- job:
+			s.pending[job.Repo] = true
+		default:
+			s.mu.Unlock()
+			http.Error(w, "queue is full", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	s.mu.Unlock()
+
+	if queued {
+		s.Log.Printf("%s: %s (already queued)", job.Repo, job.Reason)
+	} else {
+		s.Log.Printf("%s: %s, queued re-encryption", job.Repo, job.Reason)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "queued re-encryption of %s\n", job.Repo)
+}
+
+func (s *Server) work() {
+	for job := range s.jobs {
+		s.mu.Lock()
+		delete(s.pending, job.Repo)
+		s.mu.Unlock()
+
+		pushed, err := s.Runner.Run(job)
+		switch {
+		case err != nil:
+			s.Log.Printf("%s: re-encryption failed: %v", job.Repo, err)
+		case pushed:
+			s.Log.Printf("%s: re-encrypted and pushed to %s", job.Repo, job.Branch)
+		default:
+			s.Log.Printf("%s: nothing to re-encrypt", job.Repo)
+		}
+	}
+}
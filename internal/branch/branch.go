@@ -0,0 +1,57 @@
+package branch
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// OverrideEnv names the branch explicitly, e.g. in a CI system envault
+// does not know
+const OverrideEnv = "ENVAULT_BRANCH"
+
+// ciVariables hold the branch being built, in order of preference. CI
+// checkouts are usually a detached HEAD, so these come before git. For
+// pull requests the source branch is preferred, so a feature branch gets
+// its own environment rather than its target's.
+var ciVariables = []string{
+	"GITHUB_HEAD_REF",                     // GitHub Actions pull requests
+	"CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", // GitLab merge requests
+	"CI_COMMIT_BRANCH",                    // GitLab
+	"CIRCLE_BRANCH",                       // CircleCI
+	"BUILDKITE_BRANCH",                    // Buildkite
+	"BITBUCKET_BRANCH",                    // Bitbucket Pipelines
+	"TRAVIS_PULL_REQUEST_BRANCH",          // Travis CI pull requests
+	"TRAVIS_BRANCH",                       // Travis CI
+	"DRONE_SOURCE_BRANCH",                 // Drone
+	"BRANCH_NAME",                         // Jenkins multibranch
+	"BUILD_SOURCEBRANCHNAME",              // Azure Pipelines
+}
+
+// Current returns the branch being worked on and where it was read from:
+// ENVAULT_BRANCH, a CI system's variable, or git
+func Current() (string, string, error) {
+	if name := os.Getenv(OverrideEnv); name != "" {
+		return name, OverrideEnv, nil
+	}
+	if os.Getenv("GITHUB_REF_TYPE") == "branch" && os.Getenv("GITHUB_HEAD_REF") == "" {
+		if name := os.Getenv("GITHUB_REF_NAME"); name != "" {
+			return name, "GITHUB_REF_NAME", nil
+		}
+	}
+	for _, variable := range ciVariables {
+		if name := os.Getenv(variable); name != "" {
+			return strings.TrimPrefix(name, "refs/heads/"), variable, nil
+		}
+	}
+
+	out, err := exec.Command("git", "symbolic-ref", "--short", "-q", "HEAD").Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return "", "", fmt.Errorf("HEAD is detached, so there is no current branch (set %s)", OverrideEnv)
+		}
+		return "", "", fmt.Errorf("failed to read the current branch: %w", err)
+	}
+	return strings.TrimSpace(string(out)), "git", nil
+}
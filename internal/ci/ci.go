@@ -0,0 +1,98 @@
+package ci
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/orchard9/envault/internal/identity"
+)
+
+// PassphraseEnv holds the passphrase that unlocks the CI identity. It is
+// the only secret CI needs.
+const PassphraseEnv = "ENVAULT_CI_PASSPHRASE"
+
+// FileName is the sealed identity inside .envault. It is committed.
+const FileName = "ci_identity.json"
+
+// Path returns the sealed identity path in a vault directory
+func Path(envaultDir string) string {
+	return filepath.Join(envaultDir, FileName)
+}
+
+// Generate creates an unencrypted ed25519 SSH key pair with ssh-keygen,
+// returning the private key and the public authorized_keys line
+func Generate(comment string) ([]byte, string, error) {
+	dir, err := os.MkdirTemp("", "envault-ci-")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "id_ed25519")
+	cmd := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", comment, "-f", keyPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, "", fmt.Errorf("ssh-keygen failed: %w\nStderr: %s", err, stderr.String())
+	}
+
+	private, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read generated key: %w", err)
+	}
+	public, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read generated key: %w", err)
+	}
+	return private, string(bytes.TrimSpace(public)), nil
+}
+
+// NewPassphrase returns a random passphrase suitable for a CI secret
+func NewPassphrase() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate passphrase: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Seal encrypts a private key with a passphrase in the sealed identity
+// format of the identity package, returning the file's contents
+func Seal(private []byte, publicKey, passphrase string) ([]byte, error) {
+	sealed, err := identity.Seal(private, publicKey, "", passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return sealed.Marshal()
+}
+
+// Open decrypts a sealed identity, returning the private key
+func Open(data []byte, passphrase string) ([]byte, error) {
+	sealed, err := identity.Parse(data, FileName)
+	if err != nil {
+		return nil, err
+	}
+	private, err := sealed.Open(passphrase)
+	if errors.Is(err, identity.ErrWrongPassphrase) {
+		return nil, fmt.Errorf("wrong %s or corrupt %s", PassphraseEnv, FileName)
+	}
+	return private, err
+}
+
+// PublicKey returns the public key recorded in a sealed identity
+func PublicKey(data []byte) (string, error) {
+	sealed, err := identity.Parse(data, FileName)
+	if err != nil {
+		return "", err
+	}
+	if sealed.PublicKey == "" {
+		return "", fmt.Errorf("%s has no public_key", FileName)
+	}
+	return sealed.PublicKey, nil
+}
@@ -2,26 +2,533 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// CurrentVersion is the config.yaml layout version written by this build.
+// Older layouts are upgraded with `envault migrate`.
+const CurrentVersion = 1
+
 // Config represents the .envault/config.yaml structure
 type Config struct {
+	Version      int                    `yaml:"version"`
+	Backend      string                 `yaml:"backend,omitempty"`    // crypto backend, defaults to age-ssh
+	AgeBinary    string                 `yaml:"age_binary,omitempty"` // ignored: set in the per-user config or ENVAULT_AGE_BIN, see config lint
+	Layout       string                 `yaml:"layout,omitempty"`     // flat (default) or nested, see LayoutFile
 	Environments map[string]Environment `yaml:"environments"`
+
+	// RecoveryKeys are fingerprints of offline break-glass recipients
+	// (e.g. a security-team age key kept in a safe)
+	RecoveryKeys []string `yaml:"recovery_keys,omitempty"`
+
+	// StableCiphertext skips rewriting ciphertext when neither the
+	// plaintext nor the recipients changed, keeping git diffs quiet
+	StableCiphertext bool `yaml:"stable_ciphertext,omitempty"`
+
+	// StaleKeyAfter is how long an authorized key may go without a recorded
+	// decrypt before check flags it as possibly stale (e.g. "90d")
+	StaleKeyAfter string `yaml:"stale_key_after,omitempty"`
+
+	// KeyCommentPatterns are globs (e.g. "*@company.com") one of which every
+	// authorized key's comment must match, so each recipient is attributable
+	KeyCommentPatterns []string `yaml:"key_comment_patterns,omitempty"`
+
+	Notifications Notifications `yaml:"notifications,omitempty"`
+
+	// BundleSigners are the public keys (OpenSSH lines) of maintainers
+	// trusted to sign recipient bundles for envault keys bundle import
+	BundleSigners []string `yaml:"bundle_signers,omitempty"`
+
+	// KeySources are recipient files layered under .envault/authorized_keys
+	// for the default root, e.g. a team-shared list or an org registry
+	// cache; see KeySource for precedence
+	KeySources []KeySource `yaml:"key_sources,omitempty"`
+
+	// Identities is the default decryption chain for every environment:
+	// the identity sources to try, in order (see ValidateIdentities)
+	Identities []string `yaml:"identities,omitempty"`
+
+	// Branches pick the environment for --auto from the current git
+	// branch; the first matching rule wins
+	Branches []BranchRule `yaml:"branches,omitempty"`
+
+	// Roots are vault directories besides .envault, each with its own
+	// ciphertext and authorized_keys, so environments can be kept apart
+	// for data residency (e.g. EU secrets in a repository only EU staff
+	// can read). Environments pick one with root:.
+	Roots map[string]Root `yaml:"roots,omitempty"`
+
+	// Grants give keys outside authorized_keys access to some environments
+	// until a date (envault grant); expired grants are dropped on reencrypt
+	Grants []Grant `yaml:"grants,omitempty"`
+
+	// Private keeps .envault from other local users on shared hosts: set
+	// by init --private, it makes envault create directories 0700 and
+	// files 0600, and check fail while .envault is open to others
+	Private bool `yaml:"private,omitempty"`
+}
+
+// FileMode is the mode for files envault creates in .envault
+func (c *Config) FileMode() os.FileMode {
+	if c.Private {
+		return 0600
+	}
+	return 0644
+}
+
+// DirMode is the mode for directories envault creates in .envault
+func (c *Config) DirMode() os.FileMode {
+	if c.Private {
+		return 0700
+	}
+	return 0755
+}
+
+// Layouts name where each environment's files live inside .envault
+const (
+	LayoutFlat   = "flat"   // .envault/<env>.age
+	LayoutNested = "nested" // .envault/<env>/secrets.age, a directory per environment
+)
+
+// LayoutFile returns the encrypted_file an environment uses in a layout
+func LayoutFile(layout, envName string) (string, error) {
+	switch layout {
+	case "", LayoutFlat:
+		return envName + ".age", nil
+	case LayoutNested:
+		return envName + "/secrets.age", nil
+	default:
+		return "", fmt.Errorf("unknown layout %q (use flat or nested)", layout)
+	}
+}
+
+// Notifications configures where lifecycle events are reported
+type Notifications struct {
+	Webhooks []Webhook `yaml:"webhooks,omitempty"`
+}
+
+// Webhook receives a JSON POST for key and secret changes
+type Webhook struct {
+	URL    string   `yaml:"url,omitempty"`     // endpoint, committed in config.yaml
+	URLEnv string   `yaml:"url_env,omitempty"` // or the variable holding it, for secret URLs
+	Format string   `yaml:"format,omitempty"`  // generic (default), slack, or teams
+	Events []string `yaml:"events,omitempty"`  // operations to send, all if empty
+}
+
+// BranchRule maps branches matching a pattern, such as feature/*, to an
+// environment. Patterns use path.Match syntax, so * does not cross a /.
+type BranchRule struct {
+	Branch string `yaml:"branch"`
+	Env    string `yaml:"env"`
+}
+
+// EnvironmentForBranch returns the environment of the first rule matching
+// a branch
+func (c *Config) EnvironmentForBranch(branch string) (string, bool) {
+	for _, rule := range c.Branches {
+		if ok, _ := path.Match(rule.Branch, branch); ok {
+			return rule.Env, true
+		}
+	}
+	return "", false
+}
+
+// Root is a vault directory holding some environments' encrypted files and
+// the authorized_keys they are encrypted to
+type Root struct {
+	Path string `yaml:"path"` // relative to .envault, or absolute
+
+	// Remote is a base URL its environments fetch ciphertext from, as
+	// <url>/<encrypted_file>, unless they set their own remote
+	Remote *Remote `yaml:"remote,omitempty"`
+}
+
+// KeySource is an authorized_keys file read alongside .envault's own.
+// Keys are merged by key data: .envault/authorized_keys comes first, then
+// the sources in the order listed, and the first file to list a key gives
+// its comment and trust. revoked_keys applies to every source.
+type KeySource struct {
+	Path     string `yaml:"path"`               // relative to .envault, or absolute
+	Trust    string `yaml:"trust,omitempty"`    // label shown by list-keys, defaults to DefaultKeySourceTrust
+	Optional bool   `yaml:"optional,omitempty"` // a missing file is skipped instead of failing
+}
+
+// DefaultKeySourceTrust labels keys from a source without trust:, as
+// LocalKeyTrust labels those in .envault/authorized_keys
+const (
+	DefaultKeySourceTrust = "shared"
+	LocalKeyTrust         = "local"
+)
+
+// TrustLabel returns the source's trust, or the default
+func (s KeySource) TrustLabel() string {
+	if s.Trust == "" {
+		return DefaultKeySourceTrust
+	}
+	return s.Trust
+}
+
+// Grant is time-boxed access for one key, e.g. an incident responder
+type Grant struct {
+	Key          string   `yaml:"key"`          // public key line
+	Environments []string `yaml:"environments"` // environments it may decrypt
+	Until        string   `yaml:"until"`        // last day of access, YYYY-MM-DD
+	Reason       string   `yaml:"reason,omitempty"`
+}
+
+// UntilTime returns the start of the day after Until, in local time
+func (g *Grant) UntilTime() (time.Time, error) {
+	day, err := time.ParseInLocation(SunsetLayout, g.Until, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid until %q (use YYYY-MM-DD)", g.Until)
+	}
+	return day.AddDate(0, 0, 1), nil
+}
+
+// Expired reports whether the grant's last day is over at now. A grant
+// with an unreadable date counts as expired.
+func (g *Grant) Expired(now time.Time) bool {
+	end, err := g.UntilTime()
+	return err != nil || !now.Before(end)
+}
+
+// Covers reports whether the grant includes an environment
+func (g *Grant) Covers(envName string) bool {
+	for _, name := range g.Environments {
+		if name == envName {
+			return true
+		}
+	}
+	return false
+}
+
+// Remote is where an environment's ciphertext is published, such as an
+// artifact store or a bucket behind HTTPS
+type Remote struct {
+	URL     string `yaml:"url"`
+	AuthEnv string `yaml:"auth_env,omitempty"` // variable holding a bearer token for the request
+}
+
+// Delegate decrypts on another host over SSH, such as a bastion holding a
+// production key that never leaves it
+type Delegate struct {
+	SSH     string   `yaml:"ssh"`                   // destination: [user@]host or an ssh_config alias
+	Command string   `yaml:"command,omitempty"`     // run remotely; defaults to envault remote-decrypt
+	Options []string `yaml:"ssh_options,omitempty"` // extra ssh arguments, e.g. [-p, "2222"]
+}
+
+// DefaultDelegateCommand decrypts stdin to stdout with the remote host's
+// own identities
+const DefaultDelegateCommand = "envault remote-decrypt"
+
+// RemoteCommand is the command run on the delegate host
+func (d *Delegate) RemoteCommand() string {
+	if d.Command == "" {
+		return DefaultDelegateCommand
+	}
+	return d.Command
+}
+
+// Validate requires a destination that ssh cannot mistake for an option
+func (d *Delegate) Validate() error {
+	if d.SSH == "" {
+		return fmt.Errorf("ssh is required")
+	}
+	if strings.HasPrefix(d.SSH, "-") || strings.ContainsAny(d.SSH, " \t\n") {
+		return fmt.Errorf("invalid ssh destination %q", d.SSH)
+	}
+	return validateSSHOptions(d.Options)
+}
+
+// sshFlags are the ssh flags ssh_options may use, and whether each takes a
+// value. ssh_options come from the committed config.yaml and run on every
+// decrypt, so anything that could start a local command, such as
+// -o ProxyCommand or -o LocalCommand, must stay out.
+var sshFlags = map[string]bool{
+	"-p": true, "-i": true, "-J": true, "-l": true,
+	"-4": false, "-6": false, "-C": false, "-q": false, "-v": false,
+}
+
+// sshConfigOptions are the -o options ssh_options may set
+var sshConfigOptions = []string{
+	"Port", "User", "IdentityFile", "IdentitiesOnly", "ProxyJump",
+	"ConnectTimeout", "ServerAliveInterval", "ServerAliveCountMax",
+}
+
+// validateSSHOptions accepts flags from sshFlags, with their values
+// separate ("-p", "2222") or attached ("-p2222"), and -o with an option
+// from sshConfigOptions
+func validateSSHOptions(options []string) error {
+	for i := 0; i < len(options); i++ {
+		arg := options[i]
+		if len(arg) < 2 || arg[0] != '-' {
+			return fmt.Errorf("ssh_options: unexpected argument %q", arg)
+		}
+		flag, value := arg[:2], arg[2:]
+		takesValue, ok := sshFlags[flag]
+		if flag == "-o" {
+			ok, takesValue = true, true
+		}
+		if !ok {
+			return fmt.Errorf("ssh_options: %s is not allowed (use -p, -i, -J, -l, -4, -6, -C, -q, -v or -o with %s)", flag, strings.Join(sshConfigOptions, ", "))
+		}
+		if !takesValue {
+			if value != "" {
+				return fmt.Errorf("ssh_options: give %s on its own, not combined as %q", flag, arg)
+			}
+			continue
+		}
+		if value == "" {
+			if i+1 == len(options) {
+				return fmt.Errorf("ssh_options: %s needs a value", flag)
+			}
+			i++
+			value = options[i]
+		}
+		if value == "" || strings.HasPrefix(value, "-") || strings.ContainsAny(value, "\n\r") {
+			return fmt.Errorf("ssh_options: invalid value %q for %s", value, flag)
+		}
+		if flag != "-o" {
+			continue
+		}
+		name, _, found := strings.Cut(value, "=")
+		if !found {
+			name, _, _ = strings.Cut(value, " ")
+		}
+		allowed := false
+		for _, option := range sshConfigOptions {
+			if strings.EqualFold(strings.TrimSpace(name), option) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("ssh_options: -o %s is not allowed (allowed: %s)", strings.TrimSpace(name), strings.Join(sshConfigOptions, ", "))
+		}
+	}
+	return nil
 }
 
 // Environment defines an environment's configuration
 type Environment struct {
 	EncryptedFile string   `yaml:"encrypted_file"`
+	Backend       string   `yaml:"backend,omitempty"` // overrides the top-level backend
 	Targets       []Target `yaml:"targets"`
+
+	// RequireApprovals is the number of distinct authorized keys that must
+	// sign new ciphertext (envault approve-change) before it is valid
+	RequireApprovals int `yaml:"require_approvals,omitempty"`
+
+	// RequireRecoveryKey refuses to encrypt unless at least one of the
+	// top-level recovery_keys is among the recipients
+	RequireRecoveryKey bool `yaml:"require_recovery_key,omitempty"`
+
+	// PinnedRecipients are fingerprints that must be in authorized_keys for
+	// encryption to proceed, so an edit cannot silently drop them
+	PinnedRecipients []string `yaml:"pinned_recipients,omitempty"`
+
+	// NotesFile holds encrypted free-text notes (envault notes), by default
+	// next to the ciphertext as <name>.notes.age
+	NotesFile string `yaml:"notes_file,omitempty"`
+
+	// Access limits which local peers the agent and serve hand this
+	// environment to. Without it, unix socket peers must be the agent's own
+	// user and TCP clients are not checked.
+	Access *Access `yaml:"access,omitempty"`
+
+	// Deprecated marks the environment for retirement (envault env
+	// deprecate). Loading it warns; check fails once the sunset has passed.
+	Deprecated *Deprecation `yaml:"deprecated,omitempty"`
+
+	// Remote fetches the ciphertext over HTTP instead of reading it from
+	// git; encrypted_file then holds the cached copy
+	Remote *Remote `yaml:"remote,omitempty"`
+
+	// Root names the entry of roots holding this environment's files and
+	// recipients; empty means .envault itself
+	Root string `yaml:"root,omitempty"`
+
+	// Identities replaces the decryption chain for this environment, e.g.
+	// [plugin:yubikey] so only a hardware key is tried for prod
+	Identities []string `yaml:"identities,omitempty"`
+
+	// DecryptVia sends the ciphertext to another host over SSH and reads
+	// the plaintext back, so the identity only exists on that host.
+	// Encryption stays local; it only needs public keys.
+	DecryptVia *Delegate `yaml:"decrypt_via,omitempty"`
+
+	// Subvaults are copies of some of the environment's variables for
+	// outside collaborators, keyed by name (envault subvault)
+	Subvaults map[string]*Subvault `yaml:"subvaults,omitempty"`
+}
+
+// Subvault is a filtered copy of an environment, encrypted only to
+// recipients outside authorized_keys. It is rewritten whenever the
+// environment is encrypted or re-encrypted.
+type Subvault struct {
+	File       string   `yaml:"file"`       // relative to the environment's root
+	Keys       []string `yaml:"keys"`       // the variables it holds
+	Recipients []string `yaml:"recipients"` // public key lines
+
+	// SourceSHA256 is the hash of the environment ciphertext the copy was
+	// made from; a different hash means the copy may be out of date
+	SourceSHA256 string `yaml:"source_sha256,omitempty"`
+}
+
+// SubvaultFileName returns the default file for a subvault, next to the
+// ciphertext as <name>.<subvault>.age
+func (e Environment) SubvaultFileName(name string) string {
+	ext := filepath.Ext(e.EncryptedFile)
+	return strings.TrimSuffix(e.EncryptedFile, ext) + "." + name + ext
+}
+
+// ValidSubvaultName reports whether name can be a subvault name, which
+// goes into file names: letters, digits, - and _
+func ValidSubvaultName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// SunsetLayout is the date format of Deprecation.Sunset
+const SunsetLayout = "2006-01-02"
+
+// Deprecation announces that an environment is going away
+type Deprecation struct {
+	Sunset string `yaml:"sunset"`           // last day the environment may be used, YYYY-MM-DD
+	Reason string `yaml:"reason,omitempty"` // e.g. "use staging-eu instead"
+}
+
+// SunsetTime returns the start of the day after Sunset, in local time
+func (d *Deprecation) SunsetTime() (time.Time, error) {
+	day, err := time.ParseInLocation(SunsetLayout, d.Sunset, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid sunset %q (use YYYY-MM-DD)", d.Sunset)
+	}
+	return day.AddDate(0, 0, 1), nil
+}
+
+// Expired reports whether the sunset date is over at now
+func (d *Deprecation) Expired(now time.Time) bool {
+	end, err := d.SunsetTime()
+	return err == nil && !now.Before(end)
+}
+
+// String describes the deprecation for warnings
+func (d *Deprecation) String() string {
+	s := "deprecated, sunset " + d.Sunset
+	if d.Reason != "" {
+		s += " (" + d.Reason + ")"
+	}
+	return s
+}
+
+// Access is a per-environment ACL for envault agent and envault serve
+type Access struct {
+	UIDs    []int    `yaml:"uids,omitempty"`    // unix socket peers, besides the agent's own user
+	Clients []string `yaml:"clients,omitempty"` // TLS client certificate common names (serve --client-ca)
+}
+
+// NotesFileName returns the notes file, relative to .envault
+func (e Environment) NotesFileName() string {
+	if e.NotesFile != "" {
+		return e.NotesFile
+	}
+	ext := filepath.Ext(e.EncryptedFile)
+	return strings.TrimSuffix(e.EncryptedFile, ext) + ".notes" + ext
 }
 
+// Overwrite policies for targets with local modifications
+const (
+	OverwritePrompt = "prompt" // ask on a terminal, refuse otherwise (default)
+	OverwriteAlways = "always" // clobber local changes
+	OverwriteNever  = "never"  // refuse to overwrite local changes
+)
+
+// Write strategies for file targets
+const (
+	StrategyReplace = "replace" // write the whole file (default)
+	StrategyMerge   = "merge"   // update secret keys in place, keep every other line
+	StrategyAppend  = "append"  // own only a section between envault markers
+)
+
+// Symlink policies for targets whose path is a symbolic link
+const (
+	SymlinkFollow  = "follow"  // write to the file the link points to (default)
+	SymlinkReplace = "replace" // replace the link with a regular file
+	SymlinkRefuse  = "refuse"  // fail rather than write through a link
+)
+
 // Target defines where decrypted secrets should be written
 type Target struct {
-	Path string `yaml:"path"`
+	Type      string            `yaml:"type,omitempty"`      // writer type, defaults to "file"
+	Path      string            `yaml:"path,omitempty"`      // output path, relative to the repo root
+	Overwrite string            `yaml:"overwrite,omitempty"` // prompt, always, or never
+	Strategy  string            `yaml:"strategy,omitempty"`  // replace, merge, or append (file targets)
+	Symlink   string            `yaml:"symlink,omitempty"`   // follow, replace, or refuse
+	Options   map[string]string `yaml:"options,omitempty"`   // writer-specific settings
+	Tags      []string          `yaml:"tags,omitempty"`      // only write variables with these tags
+	Services  []string          `yaml:"services,omitempty"`  // render once per service, as {{ .Service }}
+	AsFile    []string          `yaml:"as_file,omitempty"`   // variables written to their own file; the variable holds its path
+
+	// Overrides is a developer-owned dotenv file, such as .env.local,
+	// layered over the secrets. envault reads it but never writes it.
+	Overrides string `yaml:"overrides,omitempty"`
+}
+
+// PathData is the data available to target path templates
+type PathData struct {
+	Env     string
+	Service string
+}
+
+// OverwritePolicy returns the target's overwrite policy, defaulting to prompt
+func (t Target) OverwritePolicy() string {
+	if t.Overwrite == "" {
+		return OverwritePrompt
+	}
+	return t.Overwrite
+}
+
+// WriteStrategy returns the target's write strategy, defaulting to replace
+func (t Target) WriteStrategy() string {
+	if t.Strategy == "" {
+		return StrategyReplace
+	}
+	return t.Strategy
+}
+
+// SymlinkPolicy returns the target's symlink policy, defaulting to follow
+func (t Target) SymlinkPolicy() string {
+	if t.Symlink == "" {
+		return SymlinkFollow
+	}
+	return t.Symlink
+}
+
+// String returns a human-readable description of the target
+func (t Target) String() string {
+	if t.Type == "" || t.Type == "file" {
+		return t.Path
+	}
+	if t.Path == "" {
+		return t.Type
+	}
+	return fmt.Sprintf("%s (%s)", t.Path, t.Type)
 }
 
 // EnvaultDir returns the path to .envault directory
@@ -30,7 +537,44 @@ func EnvaultDir() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to get current directory: %w", err)
 	}
-	return filepath.Join(cwd, ".envault"), nil
+	return ResolveDir(cwd)
+}
+
+// PointerPrefix starts the single line of a .envault pointer file
+const PointerPrefix = "vault:"
+
+// ResolveDir returns the vault directory for a project. .envault is
+// normally a directory (possibly a git submodule or a symlink), but may
+// also be a file containing "vault: <path>" pointing into a shared secrets
+// repository; relative paths are resolved from the project directory.
+func ResolveDir(projectDir string) (string, error) {
+	envaultPath := filepath.Join(projectDir, ".envault")
+
+	info, err := os.Stat(envaultPath)
+	if err != nil || info.IsDir() {
+		return envaultPath, nil
+	}
+
+	data, err := os.ReadFile(envaultPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read .envault: %w", err)
+	}
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), PointerPrefix)
+	target = strings.TrimSpace(target)
+	if !ok || target == "" || strings.Contains(target, "\n") {
+		return "", fmt.Errorf(".envault is a file but not a pointer (expected a single %q line)", PointerPrefix+" <path>")
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(projectDir, target)
+	}
+	return filepath.Clean(target), nil
+}
+
+// IsVault reports whether dir contains a .envault directory or pointer
+func IsVault(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".envault"))
+	return err == nil
 }
 
 // Load reads and parses the config.yaml file
@@ -39,44 +583,293 @@ func Load() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	return LoadDir(envaultDir)
+}
 
+// LoadDir reads config.yaml from a specific .envault directory
+func LoadDir(envaultDir string) (*Config, error) {
 	configPath := filepath.Join(envaultDir, "config.yaml")
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config.yaml: %w", err)
 	}
+	return Parse(data)
+}
 
+// Parse reads config.yaml content, e.g. from an older revision of the vault
+func Parse(data []byte) (*Config, error) {
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config.yaml: %w", err)
 	}
 
+	if cfg.Version > CurrentVersion {
+		return nil, fmt.Errorf("config.yaml version %d is newer than this envault supports (%d) - upgrade envault", cfg.Version, CurrentVersion)
+	}
+
+	// Environments without an encrypted_file follow the layout
+	for name, env := range cfg.Environments {
+		if env.EncryptedFile == "" {
+			if file, err := LayoutFile(cfg.Layout, name); err == nil {
+				env.EncryptedFile = file
+				cfg.Environments[name] = env
+			}
+		}
+	}
+
 	return &cfg, nil
 }
 
+// NeedsMigration reports whether config.yaml predates the current layout
+func (c *Config) NeedsMigration() bool {
+	return c.Version < CurrentVersion
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	if len(c.Environments) == 0 {
 		return fmt.Errorf("no environments defined in config.yaml")
 	}
+	if _, err := LayoutFile(c.Layout, "env"); err != nil {
+		return err
+	}
+	for _, pattern := range c.KeyCommentPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("key_comment_patterns: invalid pattern %q", pattern)
+		}
+	}
+	if err := ValidateIdentities(c.Identities); err != nil {
+		return fmt.Errorf("identities: %w", err)
+	}
+	for i, rule := range c.Branches {
+		if _, err := path.Match(rule.Branch, ""); err != nil || rule.Branch == "" {
+			return fmt.Errorf("branches: rule %d has invalid branch pattern %q", i, rule.Branch)
+		}
+		if _, ok := c.Environments[rule.Env]; !ok {
+			return fmt.Errorf("branches: rule %d (%s): environment %q not found", i, rule.Branch, rule.Env)
+		}
+	}
+	for name, root := range c.Roots {
+		if root.Path == "" {
+			return fmt.Errorf("roots: %s has no path", name)
+		}
+		if root.Remote != nil {
+			if err := root.Remote.Validate(); err != nil {
+				return fmt.Errorf("roots: %s: remote: %w", name, err)
+			}
+		}
+	}
+	seenSources := map[string]bool{}
+	for i, source := range c.KeySources {
+		if source.Path == "" {
+			return fmt.Errorf("key_sources: source %d has no path", i)
+		}
+		if seenSources[filepath.Clean(source.Path)] {
+			return fmt.Errorf("key_sources: %s is listed twice", source.Path)
+		}
+		seenSources[filepath.Clean(source.Path)] = true
+		if strings.ContainsAny(source.Trust, " \t\n") {
+			return fmt.Errorf("key_sources: %s: trust must be a single word", source.Path)
+		}
+	}
+	for i, g := range c.Grants {
+		if strings.TrimSpace(g.Key) == "" {
+			return fmt.Errorf("grants: grant %d has no key", i)
+		}
+		if _, err := g.UntilTime(); err != nil {
+			return fmt.Errorf("grants: grant %d: %w", i, err)
+		}
+		if len(g.Environments) == 0 {
+			return fmt.Errorf("grants: grant %d names no environments", i)
+		}
+		for _, envName := range g.Environments {
+			if _, ok := c.Environments[envName]; !ok {
+				return fmt.Errorf("grants: grant %d: environment %s not found", i, envName)
+			}
+		}
+	}
 
 	for name, env := range c.Environments {
+		if env.RequireRecoveryKey && len(c.RecoveryKeys) == 0 {
+			return fmt.Errorf("environment %s: require_recovery_key is set but no recovery_keys are configured", name)
+		}
 		if env.EncryptedFile == "" {
 			return fmt.Errorf("environment %s: encrypted_file is required", name)
 		}
 		if len(env.Targets) == 0 {
 			return fmt.Errorf("environment %s: at least one target is required", name)
 		}
+		if err := ValidateIdentities(env.Identities); err != nil {
+			return fmt.Errorf("environment %s: identities: %w", name, err)
+		}
+		if env.Remote != nil {
+			if err := env.Remote.Validate(); err != nil {
+				return fmt.Errorf("environment %s: remote: %w", name, err)
+			}
+		}
+		if env.DecryptVia != nil {
+			if err := env.DecryptVia.Validate(); err != nil {
+				return fmt.Errorf("environment %s: decrypt_via: %w", name, err)
+			}
+		}
+		if _, ok := c.Roots[env.Root]; env.Root != "" && !ok {
+			return fmt.Errorf("environment %s: root %q not found in roots", name, env.Root)
+		}
+		if _, err := c.ResolvedTargets(name); err != nil {
+			return fmt.Errorf("environment %s: %w", name, err)
+		}
+		for subName, sv := range env.Subvaults {
+			switch {
+			case !ValidSubvaultName(subName):
+				return fmt.Errorf("environment %s: subvault %q: names may use letters, digits, - and _", name, subName)
+			case sv == nil || sv.File == "" || len(sv.Keys) == 0 || len(sv.Recipients) == 0:
+				return fmt.Errorf("environment %s: subvault %s needs a file, keys and recipients", name, subName)
+			case filepath.Clean(sv.File) == filepath.Clean(env.EncryptedFile) || filepath.Clean(sv.File) == filepath.Clean(env.NotesFileName()):
+				return fmt.Errorf("environment %s: subvault %s: file cannot be the environment's own ciphertext or notes", name, subName)
+			}
+		}
+		if env.Access != nil {
+			for _, uid := range env.Access.UIDs {
+				if uid < 0 {
+					return fmt.Errorf("environment %s: access has invalid uid %d", name, uid)
+				}
+			}
+			for _, client := range env.Access.Clients {
+				if strings.TrimSpace(client) == "" {
+					return fmt.Errorf("environment %s: access has an empty client name", name)
+				}
+			}
+		}
+		if env.Deprecated != nil {
+			if _, err := env.Deprecated.SunsetTime(); err != nil {
+				return fmt.Errorf("environment %s: deprecated: %w", name, err)
+			}
+		}
 		for i, target := range env.Targets {
-			if target.Path == "" {
+			if target.Path == "" && (target.Type == "" || target.Type == "file") {
 				return fmt.Errorf("environment %s: target %d has empty path", name, i)
 			}
+			if target.Type == "template" && target.Options["template"] == "" {
+				return fmt.Errorf("environment %s: template target %s needs options.template", name, target.Path)
+			}
+			if target.Overrides != "" && target.Overrides == target.Path {
+				return fmt.Errorf("environment %s: target %d: overrides cannot be the target's own path", name, i)
+			}
+			switch target.OverwritePolicy() {
+			case OverwritePrompt, OverwriteAlways, OverwriteNever:
+			default:
+				return fmt.Errorf("environment %s: target %d has invalid overwrite %q (use prompt, always, or never)", name, i, target.Overwrite)
+			}
+			switch target.SymlinkPolicy() {
+			case SymlinkFollow, SymlinkReplace, SymlinkRefuse:
+			default:
+				return fmt.Errorf("environment %s: target %d has invalid symlink %q (use follow, replace, or refuse)", name, i, target.Symlink)
+			}
+			switch target.WriteStrategy() {
+			case StrategyReplace:
+			case StrategyMerge, StrategyAppend:
+				if target.Type != "" && target.Type != "file" {
+					return fmt.Errorf("environment %s: target %d: strategy %s only applies to file targets", name, i, target.Strategy)
+				}
+			default:
+				return fmt.Errorf("environment %s: target %d has invalid strategy %q (use replace, merge, or append)", name, i, target.Strategy)
+			}
 		}
 	}
 
 	return nil
 }
 
+// PinnedBy returns the environments that pin a fingerprint, sorted
+func (c *Config) PinnedBy(fingerprint string) []string {
+	var envNames []string
+	for name, env := range c.Environments {
+		for _, fp := range env.PinnedRecipients {
+			if fp == fingerprint {
+				envNames = append(envNames, name)
+				break
+			}
+		}
+	}
+	sort.Strings(envNames)
+	return envNames
+}
+
+// IsRecoveryKey reports whether a fingerprint is a designated recovery key
+func (c *Config) IsRecoveryKey(fingerprint string) bool {
+	for _, fp := range c.RecoveryKeys {
+		if fp == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckKeyComment enforces key_comment_patterns on a key comment. Matching
+// ignores case, since the comment is usually an email address.
+func (c *Config) CheckKeyComment(comment string) error {
+	if len(c.KeyCommentPatterns) == 0 {
+		return nil
+	}
+	for _, pattern := range c.KeyCommentPatterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(comment)); ok {
+			return nil
+		}
+	}
+	if comment == "" {
+		return fmt.Errorf("key has no comment; key_comment_patterns requires one matching %s", strings.Join(c.KeyCommentPatterns, ", "))
+	}
+	return fmt.Errorf("key comment %q does not match key_comment_patterns (%s)", comment, strings.Join(c.KeyCommentPatterns, ", "))
+}
+
+// ResolvedTargets returns an environment's targets with path templates
+// such as "services/{{ .Service }}/.env.{{ .Env }}" expanded, one target
+// per entry in a target's services list
+func (c *Config) ResolvedTargets(envName string) ([]Target, error) {
+	env, err := c.GetEnvironment(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved []Target
+	for _, target := range env.Targets {
+		services := target.Services
+		if len(services) == 0 {
+			if strings.Contains(target.Path, ".Service") {
+				return nil, fmt.Errorf("target %s uses {{ .Service }} but lists no services", target.Path)
+			}
+			services = []string{""}
+		}
+
+		tmpl, err := template.New("path").Option("missingkey=error").Parse(target.Path)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: invalid path template: %w", target.Path, err)
+		}
+		overrides, err := template.New("overrides").Option("missingkey=error").Parse(target.Overrides)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: invalid overrides template: %w", target.Path, err)
+		}
+
+		for _, service := range services {
+			data := PathData{Env: envName, Service: service}
+			var path, overridesPath strings.Builder
+			if err := tmpl.Execute(&path, data); err != nil {
+				return nil, fmt.Errorf("target %s: %w", target.Path, err)
+			}
+			if err := overrides.Execute(&overridesPath, data); err != nil {
+				return nil, fmt.Errorf("target %s: overrides: %w", target.Path, err)
+			}
+
+			t := target
+			t.Path = path.String()
+			t.Overrides = overridesPath.String()
+			t.Services = nil
+			resolved = append(resolved, t)
+		}
+	}
+	return resolved, nil
+}
+
 // GetEnvironment returns the configuration for a specific environment
 func (c *Config) GetEnvironment(name string) (*Environment, error) {
 	env, ok := c.Environments[name]
@@ -86,18 +879,197 @@ func (c *Config) GetEnvironment(name string) (*Environment, error) {
 	return &env, nil
 }
 
+// EncryptedPath returns the absolute path to an environment's encrypted file
+func (c *Config) EncryptedPath(envName string) (string, error) {
+	env, err := c.GetEnvironment(envName)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := c.RootDir(env.Root)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, env.EncryptedFile), nil
+}
+
+// NotesPath returns the full path to an environment's encrypted notes
+func (c *Config) NotesPath(envName string) (string, error) {
+	env, err := c.GetEnvironment(envName)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := c.RootDir(env.Root)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, env.NotesFileName()), nil
+}
+
+// SubvaultPath returns the full path to one of an environment's subvaults,
+// which live in the environment's root
+func (c *Config) SubvaultPath(envName string, sv *Subvault) (string, error) {
+	env, err := c.GetEnvironment(envName)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := c.RootDir(env.Root)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, sv.File), nil
+}
+
+// RootDir returns the directory of a root, or .envault for ""
+func (c *Config) RootDir(name string) (string, error) {
+	envaultDir, err := EnvaultDir()
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return envaultDir, nil
+	}
+
+	root, ok := c.Roots[name]
+	if !ok {
+		return "", fmt.Errorf("root %s not found in config.yaml", name)
+	}
+	if filepath.IsAbs(root.Path) {
+		return filepath.Clean(root.Path), nil
+	}
+	return filepath.Join(envaultDir, root.Path), nil
+}
+
+// RemoteFor returns where an environment's ciphertext is fetched from:
+// its own remote, else its root's with encrypted_file appended, else nil
+func (c *Config) RemoteFor(envName string) *Remote {
+	env, ok := c.Environments[envName]
+	if !ok {
+		return nil
+	}
+	if env.Remote != nil {
+		return env.Remote
+	}
+	root, ok := c.Roots[env.Root]
+	if !ok || root.Remote == nil {
+		return nil
+	}
+	return &Remote{
+		URL:     strings.TrimSuffix(root.Remote.URL, "/") + "/" + filepath.ToSlash(env.EncryptedFile),
+		AuthEnv: root.Remote.AuthEnv,
+	}
+}
+
+// BackendName returns the crypto backend name for an environment.
+// An empty result means the default backend.
+func (c *Config) BackendName(envName string) (string, error) {
+	env, err := c.GetEnvironment(envName)
+	if err != nil {
+		return "", err
+	}
+	if env.Backend != "" {
+		return env.Backend, nil
+	}
+	return c.Backend, nil
+}
+
+// Validate requires an https URL; plain http is only accepted for the
+// local machine
+func (r *Remote) Validate() error {
+	u, err := url.Parse(r.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid url %q", r.URL)
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if host := u.Hostname(); host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			return fmt.Errorf("url %s must use https", r.URL)
+		}
+	default:
+		return fmt.Errorf("url %s must use https", r.URL)
+	}
+	return nil
+}
+
+// Sources in a decryption chain (identities in config.yaml or a profile).
+// Keys held by ssh-agent or a KMS are reached through the age plugin that
+// fronts them, since age only decrypts with identity files.
+const (
+	IdentityEnv        = "env"       // ENVAULT_IDENTITY_KEY, then the CI identity
+	IdentityConfigured = "identity"  // ENVAULT_IDENTITY or the profile's identity
+	IdentitySSH        = "ssh"       // default SSH keys in ~/.ssh
+	IdentityAge        = "age"       // identity.txt in the user config dir
+	IdentityProtected  = "protected" // the key stored by envault identity import --protect
+	IdentityPlugin     = "plugin:"   // plugin:<name>, identity files needing age-plugin-<name>
+	IdentityFile       = "file:"     // file:<path>, a specific identity file
+)
+
+// ValidateIdentities checks a decryption chain
+func ValidateIdentities(chain []string) error {
+	seen := map[string]bool{}
+	for _, source := range chain {
+		if seen[source] {
+			return fmt.Errorf("%s is listed twice", source)
+		}
+		seen[source] = true
+
+		switch source {
+		case IdentityEnv, IdentityConfigured, IdentitySSH, IdentityAge, IdentityProtected:
+			continue
+		case "agent", "ssh-agent", "kms":
+			return fmt.Errorf("%s: age cannot decrypt with it directly; use the age plugin that fronts it (plugin:<name>)", source)
+		}
+		if name, ok := strings.CutPrefix(source, IdentityPlugin); ok {
+			if name == "" || strings.ContainsAny(name, "/ ") {
+				return fmt.Errorf("invalid plugin name in %q", source)
+			}
+			continue
+		}
+		if file, ok := strings.CutPrefix(source, IdentityFile); ok {
+			if file == "" {
+				return fmt.Errorf("%q has no path", source)
+			}
+			continue
+		}
+		return fmt.Errorf("unknown identity source %q (use env, identity, ssh, age, protected, plugin:<name> or file:<path>)", source)
+	}
+	return nil
+}
+
 // DefaultConfig returns a default configuration for initialization
 func DefaultConfig() *Config {
-	return &Config{
+	cfg, _ := DefaultConfigWithLayout(LayoutFlat)
+	return cfg
+}
+
+// DefaultConfigWithLayout returns the default configuration using a layout
+func DefaultConfigWithLayout(layout string) (*Config, error) {
+	encryptedFile, err := LayoutFile(layout, "dev")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Version: CurrentVersion,
 		Environments: map[string]Environment{
 			"dev": {
-				EncryptedFile: "dev.age",
+				EncryptedFile: encryptedFile,
 				Targets: []Target{
 					{Path: ".env"},
 				},
 			},
 		},
 	}
+	if layout != LayoutFlat {
+		cfg.Layout = layout
+	}
+	return cfg, nil
 }
 
 // Save writes the configuration to config.yaml
@@ -106,14 +1078,18 @@ func (c *Config) Save() error {
 	if err != nil {
 		return err
 	}
+	return c.SaveDir(envaultDir)
+}
 
+// SaveDir writes the configuration to config.yaml in a vault directory
+func (c *Config) SaveDir(envaultDir string) error {
 	configPath := filepath.Join(envaultDir, "config.yaml")
 	data, err := yaml.Marshal(c)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	if err := os.WriteFile(configPath, data, c.FileMode()); err != nil {
 		return fmt.Errorf("failed to write config.yaml: %w", err)
 	}
 
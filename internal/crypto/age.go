@@ -0,0 +1,322 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/orchard9/envault/internal/ci"
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/profile"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/semver"
+	"github.com/orchard9/envault/internal/wsl"
+)
+
+// MinAgeVersion is the oldest age release with -R files and SSH recipients
+const MinAgeVersion = "1.0.0"
+
+// sshKeyNames are the private keys looked for in ~/.ssh, in order of
+// preference
+var sshKeyNames = []string{"id_ed25519", "id_rsa", "id_ecdsa", "id_dsa"}
+
+// sshRecipientTypes are the SSH key types age accepts as recipients
+var sshRecipientTypes = []string{"ssh-ed25519", "ssh-rsa"}
+
+// ageBackend encrypts by shelling out to the age binary
+type ageBackend struct {
+	name           string
+	identity       func() (string, error)
+	recipientTypes []string
+
+	// chain lists the identity sources to try, in order (see
+	// IdentityChain); empty tries every source in the default order
+	chain []string
+}
+
+func (b *ageBackend) Name() string {
+	return b.name
+}
+
+func (b *ageBackend) Available() error {
+	return CheckAge()
+}
+
+// ValidateRecipient accepts the backend's key types and any age plugin
+// recipient, provided the plugin is installed: age runs it to encrypt
+func (b *ageBackend) ValidateRecipient(key keys.Key) error {
+	if name := key.Plugin(); name != "" {
+		_, err := FindPlugin(name)
+		return err
+	}
+	for _, t := range b.recipientTypes {
+		if key.Type == t {
+			if t == "age" {
+				return nil
+			}
+			_, err := key.ParseSSH()
+			return err
+		}
+	}
+	if reason := keys.UnsupportedSSHType(key.Type); reason != "" {
+		return fmt.Errorf("key type %s is not supported: %s - %s", key.Type, reason, keys.Advice(key.Type))
+	}
+	return fmt.Errorf("key type %s is not supported (supported: %s, age plugin recipients)", key.Type, strings.Join(b.recipientTypes, ", "))
+}
+
+func (b *ageBackend) Encrypt(plaintext []byte, recipients []keys.Key, w io.Writer) error {
+	return b.EncryptStream(bytes.NewReader(plaintext), recipients, w)
+}
+
+// EncryptStream pipes plaintext through age, which encrypts in 64 KiB
+// chunks, so memory use does not grow with the payload
+func (b *ageBackend) EncryptStream(plaintext io.Reader, recipients []keys.Key, w io.Writer) error {
+	// Check each key here, so a bad one is named rather than failing age
+	// with an error about the whole recipient list
+	if err := ValidateRecipients(b, recipients); err != nil {
+		return err
+	}
+	args := []string{"-e"}
+	for _, k := range recipients {
+		args = append(args, "-r", k.Recipient())
+	}
+
+	cmd := exec.Command(AgeBinary(), args...)
+	cmd.Stdin = plaintext
+	cmd.Stdout = w
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("age encryption failed: %w\nStderr: %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+func (b *ageBackend) Decrypt(r io.Reader) ([]byte, error) {
+	plaintext := secmem.NewBuffer(0)
+	if err := b.DecryptStream(r, plaintext); err != nil {
+		secmem.Wipe(plaintext.Bytes())
+		return nil, err
+	}
+	return plaintext.Bytes(), nil
+}
+
+// DecryptStream tries every available identity in priority order; see
+// candidates
+func (b *ageBackend) DecryptStream(r io.Reader, w io.Writer) error {
+	return b.decryptAny(r, w)
+}
+
+// DecryptWithIdentity decrypts age ciphertext with a specific identity file
+func DecryptWithIdentity(r io.Reader, identityPath string) ([]byte, error) {
+	plaintext := secmem.NewBuffer(0)
+	if err := DecryptStreamWithIdentity(r, identityPath, plaintext); err != nil {
+		secmem.Wipe(plaintext.Bytes())
+		return nil, err
+	}
+	return plaintext.Bytes(), nil
+}
+
+// DecryptStreamWithIdentity decrypts age ciphertext from r to w. age
+// authenticates each chunk before writing it, and fails on truncated
+// ciphertext, but w may already hold the chunks before the failure.
+func DecryptStreamWithIdentity(r io.Reader, identityPath string, w io.Writer) error {
+	if stderr, err := runAgeDecrypt(r, identityPath, w); err != nil {
+		return fmt.Errorf("age decryption failed: %w\nStderr: %s", err, stderr)
+	}
+	return nil
+}
+
+// runAgeDecrypt runs age -d with one identity, returning its stderr
+func runAgeDecrypt(r io.Reader, identityPath string, w io.Writer) (string, error) {
+	cmd := exec.Command(AgeBinary(), "-d", "-i", identityPath)
+	cmd.Stdin = r
+	cmd.Stdout = w
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	return stderr.String(), err
+}
+
+// identityFromEnv writes private key material from ENVAULT_IDENTITY_KEY
+// (e.g. a Codespaces or CI secret), or the CI identity unlocked by
+// ENVAULT_CI_PASSPHRASE, to a private temp file. Returns an empty path
+// when neither variable is set.
+func identityFromEnv() (string, func(), error) {
+	material := os.Getenv("ENVAULT_IDENTITY_KEY")
+	if material == "" {
+		if passphrase := os.Getenv(ci.PassphraseEnv); passphrase != "" {
+			return ciIdentity(passphrase)
+		}
+		return "", func() {}, nil
+	}
+	return keyMaterial(material)
+}
+
+// keyMaterial writes private key material from an environment variable
+func keyMaterial(material string) (string, func(), error) {
+	// Secrets UIs often strip the trailing newline that ssh keys require
+	if !strings.HasSuffix(material, "\n") {
+		material += "\n"
+	}
+	return writeIdentity([]byte(material))
+}
+
+// ciIdentity unlocks the committed CI identity created by envault ci init
+func ciIdentity(passphrase string) (string, func(), error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return "", func() {}, err
+	}
+	data, err := os.ReadFile(ci.Path(envaultDir))
+	if err != nil {
+		return "", func() {}, fmt.Errorf("%s is set but the CI identity cannot be read (run: envault ci init): %w", ci.PassphraseEnv, err)
+	}
+	private, err := ci.Open(data, passphrase)
+	if err != nil {
+		return "", func() {}, err
+	}
+	return writeIdentity(private)
+}
+
+// writeIdentity stores identity material in a private temp file
+func writeIdentity(material []byte) (string, func(), error) {
+	file, err := os.CreateTemp("", "envault-identity-")
+	if err != nil {
+		return "", func() {}, fmt.Errorf("failed to create identity file: %w", err)
+	}
+	cleanup := func() { os.Remove(file.Name()) }
+
+	if _, err := file.Write(material); err != nil {
+		file.Close()
+		cleanup()
+		return "", func() {}, fmt.Errorf("failed to write identity file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		cleanup()
+		return "", func() {}, fmt.Errorf("failed to write identity file: %w", err)
+	}
+
+	return file.Name(), cleanup, nil
+}
+
+// DefaultIdentity resolves the identity used when none is configured:
+// ENVAULT_IDENTITY_KEY material or the CI identity, then ENVAULT_IDENTITY,
+// the protected identity or ~/.ssh. The returned cleanup removes any temp
+// file and must always be called.
+func DefaultIdentity() (string, func(), error) {
+	identityPath, cleanup, err := identityFromEnv()
+	if err != nil || identityPath != "" {
+		return identityPath, cleanup, err
+	}
+
+	if path, _ := configuredIdentity(); path == "" {
+		if c, remove, ok := protectedCandidate(); ok {
+			if c.err != nil {
+				remove()
+				return "", func() {}, fmt.Errorf("%s: %w", c, c.err)
+			}
+			return c.path, remove, nil
+		}
+	}
+
+	identityPath, err = FindSSHPrivateKey()
+	if err != nil {
+		return "", func() {}, err
+	}
+	return identityPath, func() {}, nil
+}
+
+// FindSSHPrivateKey finds the user's SSH private key (ENVAULT_IDENTITY,
+// then the profile's identity, wins)
+func FindSSHPrivateKey() (string, error) {
+	if path, _ := configuredIdentity(); path != "" {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	if files := sshKeyFiles(); len(files) > 0 {
+		return files[0], nil
+	}
+
+	dirs := []string{filepath.Join(homeDir, ".ssh")}
+	if wsl.Detected() {
+		if winHome, err := wsl.WindowsHome(); err == nil {
+			dirs = append(dirs, filepath.Join(winHome, ".ssh"))
+		}
+	}
+	return "", fmt.Errorf("no SSH private key found in %s (tried: %s)", strings.Join(dirs, " or "), strings.Join(sshKeyNames, ", "))
+}
+
+// findAgeIdentity finds the user's age X25519 identity file
+func findAgeIdentity() (string, error) {
+	if path, _ := configuredIdentity(); path != "" {
+		return path, nil
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config directory: %w", err)
+	}
+
+	identityPath := filepath.Join(configDir, "envault", "identity.txt")
+	if _, err := os.Stat(identityPath); err != nil {
+		return "", fmt.Errorf("no age identity found at %s (create one with: age-keygen -o %s, or set ENVAULT_IDENTITY)", identityPath, identityPath)
+	}
+	return identityPath, nil
+}
+
+// AgeBinary returns the age executable to run: ENVAULT_AGE_BIN, then
+// age_binary in the per-user config, then "age" from PATH. It is never
+// read from .envault/config.yaml, which anyone who can merge to the
+// repository controls.
+func AgeBinary() string {
+	if bin := os.Getenv("ENVAULT_AGE_BIN"); bin != "" {
+		return bin
+	}
+	if bin := profile.AgeBinary(); bin != "" {
+		return bin
+	}
+	return "age"
+}
+
+// AgeVersion returns the version reported by the configured age binary
+func AgeVersion() (string, error) {
+	bin := AgeBinary()
+	out, err := exec.Command(bin, "--version").Output()
+	if err != nil {
+		if bin == "age" {
+			return "", fmt.Errorf("age is not installed - install with: brew install age")
+		}
+		return "", fmt.Errorf("age binary %s is not runnable: %w", bin, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// CheckAge verifies that age is installed and new enough
+func CheckAge() error {
+	v, err := AgeVersion()
+	if err != nil {
+		return err
+	}
+
+	// Development builds report "(devel)"; trust them
+	if semver.Valid(v) && !semver.AtLeast(v, MinAgeVersion) {
+		return fmt.Errorf("age %s is too old (need %s or newer for SSH recipients) - upgrade with: brew upgrade age", v, MinAgeVersion)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package crypto
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/keys"
+)
+
+// DefaultBackend is used when config.yaml does not name a backend
+const DefaultBackend = "age-ssh"
+
+// Backend performs encryption and decryption for an environment. Backends
+// are selected with `backend:` in config.yaml (globally or per environment).
+type Backend interface {
+	// Name identifies the backend in config.yaml
+	Name() string
+
+	// Available reports whether the backend can run on this machine
+	Available() error
+
+	// ValidateRecipient checks that an authorized key is usable as a recipient
+	ValidateRecipient(key keys.Key) error
+
+	// Encrypt encrypts plaintext for all recipients and writes the ciphertext to w
+	Encrypt(plaintext []byte, recipients []keys.Key, w io.Writer) error
+
+	// Decrypt decrypts ciphertext from r with the local identity
+	Decrypt(r io.Reader) ([]byte, error)
+}
+
+// StreamBackend is implemented by backends that can encrypt and decrypt
+// without holding the whole payload in memory. Large payloads use it when
+// available.
+type StreamBackend interface {
+	Backend
+
+	// EncryptStream encrypts everything read from plaintext to w
+	EncryptStream(plaintext io.Reader, recipients []keys.Key, w io.Writer) error
+
+	// DecryptStream decrypts ciphertext from r to w with the local identity
+	DecryptStream(r io.Reader, w io.Writer) error
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Backend{}
+)
+
+func init() {
+	RegisterBackend(&ageBackend{name: "age-ssh", identity: FindSSHPrivateKey, recipientTypes: sshRecipientTypes})
+	RegisterBackend(&ageBackend{name: "age", identity: findAgeIdentity, recipientTypes: append([]string{"age"}, sshRecipientTypes...)})
+}
+
+// RegisterBackend makes a backend available under its name
+func RegisterBackend(b Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[b.Name()] = b
+}
+
+// LookupBackend returns a registered backend by name ("" means the default)
+func LookupBackend(name string) (Backend, error) {
+	if name == "" {
+		name = DefaultBackend
+	}
+
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	b, ok := backends[name]
+	if !ok {
+		names := make([]string, 0, len(backends))
+		for n := range backends {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown backend %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return b, nil
+}
+
+// BackendFor returns the backend configured for an environment, limited
+// to the environment's identity chain, or decrypting on its decrypt_via
+// host
+func BackendFor(cfg *config.Config, envName string) (Backend, error) {
+	name, err := cfg.BackendName(envName)
+	if err != nil {
+		return nil, err
+	}
+	b, err := LookupBackend(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err = withIdentityChain(b, cfg, envName)
+	if err != nil {
+		return nil, err
+	}
+	return withDelegate(b, cfg, envName)
+}
+
+// withIdentityChain limits an age backend to the identities in the chain
+// for envName; an empty envName takes the profile's or top-level chain
+func withIdentityChain(b Backend, cfg *config.Config, envName string) (Backend, error) {
+	ab, ok := b.(*ageBackend)
+	if !ok {
+		return b, nil
+	}
+	chain, source := IdentityChain(cfg, envName)
+	if len(chain) == 0 {
+		return b, nil
+	}
+	if err := config.ValidateIdentities(chain); err != nil {
+		return nil, fmt.Errorf("identities of %s: %w", source, err)
+	}
+	return ab.withChain(chain), nil
+}
+
+// ValidateRecipients checks every key against the backend
+func ValidateRecipients(b Backend, recipients []keys.Key) error {
+	var problems []string
+	for _, k := range recipients {
+		if err := b.ValidateRecipient(k); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", k.Fingerprint, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("unusable recipients for backend %s:\n  - %s", b.Name(), strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
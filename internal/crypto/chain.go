@@ -0,0 +1,151 @@
+package crypto
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/orchard9/envault/internal/ci"
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/identity"
+	"github.com/orchard9/envault/internal/profile"
+)
+
+// IdentityChain returns the decryption chain for an environment and where
+// it is set: the environment's identities in config.yaml, then the active
+// profile's, then the top-level identities. An empty chain tries every
+// source in the default order.
+func IdentityChain(cfg *config.Config, envName string) ([]string, string) {
+	if env, err := cfg.GetEnvironment(envName); err == nil && len(env.Identities) > 0 {
+		return env.Identities, "environment " + envName + " in config.yaml"
+	}
+	if p := profile.Current(); p != nil && len(p.Identities) > 0 {
+		return p.Identities, "profile " + p.Name
+	}
+	if len(cfg.Identities) > 0 {
+		return cfg.Identities, "config.yaml"
+	}
+	return nil, ""
+}
+
+// withChain returns a copy of the backend that tries only the chain's
+// identities
+func (b *ageBackend) withChain(chain []string) *ageBackend {
+	chained := *b
+	chained.chain = chain
+	return &chained
+}
+
+// sessionKey distinguishes a backend's candidate lists by chain
+func (b *ageBackend) sessionKey() string {
+	return b.name + "\x00" + strings.Join(b.chain, "\x00")
+}
+
+// chainCandidates lists the identities of each source in the chain, in
+// chain order. A source with no identity is listed as a failure so the
+// error says what was expected. withEnv false leaves out the env source,
+// whose identities are temp files prepared on demand.
+func (b *ageBackend) chainCandidates(withEnv bool) ([]candidate, func()) {
+	var list []candidate
+	cleanup := func() {}
+	seen := map[string]bool{}
+	add := func(c candidate) {
+		if c.path != "" && seen[c.path] {
+			return
+		}
+		seen[c.path] = true
+		list = append(list, c)
+	}
+	missing := func(source string, err error) {
+		list = append(list, candidate{label: source, err: err})
+	}
+
+	for _, source := range b.chain {
+		switch {
+		case source == config.IdentityEnv:
+			if !withEnv {
+				continue
+			}
+			envList, envCleanup := envCandidates()
+			cleanup = joinCleanups(cleanup, envCleanup)
+			if len(envList) == 0 {
+				missing(source, fmt.Errorf("neither ENVAULT_IDENTITY_KEY nor %s is set", ci.PassphraseEnv))
+			}
+			for _, c := range envList {
+				add(c)
+			}
+
+		case source == config.IdentityConfigured:
+			c, ok := configuredCandidate()
+			if !ok {
+				missing(source, fmt.Errorf("neither ENVAULT_IDENTITY nor a profile identity is set"))
+				continue
+			}
+			add(c)
+
+		case source == config.IdentitySSH:
+			files := sshKeyFiles()
+			if len(files) == 0 {
+				missing(source, fmt.Errorf("no SSH private key found (tried: %s)", strings.Join(sshKeyNames, ", ")))
+			}
+			for _, path := range files {
+				add(fileCandidate(shortPath(path), path))
+			}
+
+		case source == config.IdentityAge:
+			path := ageIdentityFile()
+			if path == "" {
+				missing(source, fmt.Errorf("no identity.txt in the envault user config directory"))
+				continue
+			}
+			add(fileCandidate(shortPath(path), path))
+
+		case source == config.IdentityProtected:
+			c, remove, ok := protectedCandidate()
+			if !ok {
+				missing(source, identity.ErrNotStored)
+				continue
+			}
+			cleanup = joinCleanups(cleanup, remove)
+			add(c)
+
+		case strings.HasPrefix(source, config.IdentityPlugin):
+			name := strings.TrimPrefix(source, config.IdentityPlugin)
+			found := false
+			for _, path := range b.chainFiles() {
+				for _, plugin := range IdentityPlugins(path) {
+					if plugin == name {
+						add(fileCandidate(source+" ("+shortPath(path)+")", path))
+						found = true
+						break
+					}
+				}
+			}
+			if !found {
+				missing(source, fmt.Errorf("no identity file needs age-plugin-%s", name))
+			}
+
+		case strings.HasPrefix(source, config.IdentityFile):
+			path := profile.ExpandHome(strings.TrimPrefix(source, config.IdentityFile))
+			add(fileCandidate(shortPath(path), path))
+		}
+	}
+	return list, cleanup
+}
+
+// chainFiles are the identity files a plugin source may pick from: the
+// configured identity, identity.txt, and the chain's file sources
+func (b *ageBackend) chainFiles() []string {
+	var files []string
+	if path, _ := configuredIdentity(); path != "" {
+		files = append(files, path)
+	}
+	if path := ageIdentityFile(); path != "" {
+		files = append(files, path)
+	}
+	for _, source := range b.chain {
+		if path, ok := strings.CutPrefix(source, config.IdentityFile); ok {
+			files = append(files, profile.ExpandHome(path))
+		}
+	}
+	return files
+}
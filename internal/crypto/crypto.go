@@ -2,73 +2,271 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/dotenv"
 	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/manifest"
 )
 
-// Encrypt encrypts plaintext data for all authorized SSH keys
+// ErrRotationUntracked is returned, wrapped, after the ciphertext was
+// written but the previous plaintext could not be decrypted, so variables
+// whose values changed kept their old changed_at
+var ErrRotationUntracked = errors.New("rotation tracking skipped")
+
+// Encrypt encrypts plaintext data for all authorized keys
 func Encrypt(envName string, plaintext []byte) error {
-	envaultDir, err := config.EnvaultDir()
-	if err != nil {
-		return err
-	}
+	_, err := EncryptChanged(envName, plaintext, false)
+	return err
+}
 
+// EncryptChanged is Encrypt, reporting whether the ciphertext was written.
+// With stable_ciphertext, unchanged plaintext and recipients are skipped.
+// trackRotation decrypts the previous ciphertext first to stamp the
+// variables whose values changed (schema rotate_every); decrypting may
+// prompt for a passphrase or reach a decrypt_via host, so without it only
+// new variables are stamped.
+func EncryptChanged(envName string, plaintext []byte, trackRotation bool) (bool, error) {
 	// Load config to get encrypted file path
 	cfg, err := config.Load()
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	encryptedPath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		return false, err
+	}
+
+	backend, err := BackendFor(cfg, envName)
+	if err != nil {
+		return false, err
+	}
+
+	authorizedKeys, err := recipientsFor(cfg, envName, backend)
+	if err != nil {
+		return false, err
+	}
+
+	_, statErr := os.Stat(encryptedPath)
+	exists := statErr == nil
+
+	// Keep the previous plaintext to see which variables changed
+	var previous []byte
+	var untracked error
+	if trackRotation && exists {
+		if previous, err = Decrypt(envName); err != nil {
+			untracked = fmt.Errorf("%w: cannot decrypt the previous ciphertext of %s to see which variables changed, so they keep their last changed_at: %v", ErrRotationUntracked, envName, err)
+		}
 	}
 
+	recipients := recipientsHash(backend.Name(), authorizedKeys)
+	if cfg.StableCiphertext && exists {
+		m, err := manifest.Load()
+		if err != nil {
+			return false, err
+		}
+		if env, ok := m.Environments[envName]; ok && env.RecipientsHash == recipients && env.ContentMatches(plaintext) {
+			return false, nil
+		}
+	}
+
+	// Encrypt into a temp file and rename, so a failure mid-write never
+	// truncates the previous good ciphertext
+	err = writeCiphertext(cfg, encryptedPath, func(w io.Writer) error {
+		return backend.Encrypt(plaintext, authorizedKeys, w)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if err := recordChanges(cfg, envName, previous, plaintext, recipients); err != nil {
+		return true, fmt.Errorf("encrypted, but failed to update manifest: %w", err)
+	}
+
+	return true, untracked
+}
+
+// Recipients returns the keys an environment would be encrypted to now,
+// enforcing the same rules as encryption, e.g. for --dry-run
+func Recipients(cfg *config.Config, envName string) ([]keys.Key, error) {
+	backend, err := BackendFor(cfg, envName)
+	if err != nil {
+		return nil, err
+	}
+	return recipientsFor(cfg, envName, backend)
+}
+
+// recipientsFor loads the authorized_keys of an environment's root and
+// enforces every rule on who it may be encrypted to
+func recipientsFor(cfg *config.Config, envName string, backend Backend) ([]keys.Key, error) {
 	env, err := cfg.GetEnvironment(envName)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	authorizedKeys, err := authorizedRecipients(cfg, env.Root, backend)
+	if err != nil {
+		if env.Root != "" {
+			return nil, fmt.Errorf("root %s: %w", env.Root, err)
+		}
+		return nil, err
 	}
 
-	encryptedPath := filepath.Join(envaultDir, env.EncryptedFile)
+	if err := CheckRecoveryRecipient(cfg, envName, authorizedKeys); err != nil {
+		return nil, err
+	}
+	if err := CheckPinnedRecipients(cfg, envName, authorizedKeys); err != nil {
+		return nil, err
+	}
 
-	// Get authorized_keys path
-	authorizedKeysPath, err := keys.AuthorizedKeysPath()
+	granted, err := GrantRecipients(cfg, envName, backend, time.Now())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	present := map[string]bool{}
+	for _, k := range authorizedKeys {
+		present[k.Fingerprint] = true
+	}
+	for _, k := range granted {
+		if !present[k.Fingerprint] {
+			present[k.Fingerprint] = true
+			authorizedKeys = append(authorizedKeys, k)
+		}
+	}
+
+	return authorizedKeys, nil
+}
+
+// GrantRecipients returns the keys of grants covering an environment that
+// have not expired at now. Revoked keys are refused like in authorized_keys.
+func GrantRecipients(cfg *config.Config, envName string, backend Backend, now time.Time) ([]keys.Key, error) {
+	var granted []keys.Key
+	for _, g := range cfg.Grants {
+		if !g.Covers(envName) || g.Expired(now) {
+			continue
+		}
+		k, err := keys.ParseKey(g.Key)
+		if err != nil {
+			return nil, fmt.Errorf("grant: %w", err)
+		}
+		granted = append(granted, *k)
+	}
+	if len(granted) == 0 {
+		return nil, nil
 	}
 
-	// Verify authorized_keys has at least one key
-	authorizedKeys, err := keys.Load()
+	if err := ValidateRecipients(backend, granted); err != nil {
+		return nil, fmt.Errorf("grant: %w", err)
+	}
+	revoked, err := keys.LoadRevoked()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if found := keys.FindRevoked(granted, revoked); len(found) > 0 {
+		return nil, fmt.Errorf("grant for revoked key %s - run: envault grant remove %s", found[0].Fingerprint, found[0].Fingerprint)
+	}
+	return granted, nil
+}
+
+// authorizedRecipients loads the keys of a root, refusing keys the
+// backend cannot use and revoked keys
+func authorizedRecipients(cfg *config.Config, root string, backend Backend) ([]keys.Key, error) {
+	authorizedKeys, err := keys.LoadRoot(cfg, root)
+	if err != nil {
+		return nil, err
 	}
 
 	if len(authorizedKeys) == 0 {
-		return fmt.Errorf("no authorized keys found - run 'envault add-key' first")
+		return nil, fmt.Errorf("no authorized keys found - run 'envault add-key' first")
 	}
 
-	// Run age encryption with authorized_keys file as recipient
-	// age can read SSH public keys from a file with -R flag
-	cmd := exec.Command("age", "-e", "-o", encryptedPath, "-R", authorizedKeysPath)
-	cmd.Stdin = bytes.NewReader(plaintext)
+	if err := ValidateRecipients(backend, authorizedKeys); err != nil {
+		problems, lerr := keys.RootProblems(cfg, root, backend.ValidateRecipient)
+		if lerr != nil || len(problems) == 0 {
+			return nil, err
+		}
+		var lines []string
+		for _, p := range problems {
+			lines = append(lines, p.String())
+		}
+		return nil, fmt.Errorf("unusable recipients for backend %s - fix or remove these lines:\n  - %s", backend.Name(), strings.Join(lines, "\n  - "))
+	}
 
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	// Refuse revoked keys even if they reappear in authorized_keys
+	revoked, err := keys.LoadRevoked()
+	if err != nil {
+		return nil, err
+	}
+	if found := keys.FindRevoked(authorizedKeys, revoked); len(found) > 0 {
+		var fps []string
+		for _, k := range found {
+			fps = append(fps, k.Fingerprint)
+		}
+		return nil, fmt.Errorf("authorized keys include revoked keys: %s - remove them before encrypting", strings.Join(fps, ", "))
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("age encryption failed: %w\nStderr: %s", err, stderr.String())
+	return authorizedKeys, nil
+}
+
+// CheckRecoveryRecipient enforces require_recovery_key: the recipients
+// must include at least one of the configured recovery keys
+func CheckRecoveryRecipient(cfg *config.Config, envName string, recipients []keys.Key) error {
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		return err
+	}
+	if !env.RequireRecoveryKey {
+		return nil
+	}
+
+	for _, k := range recipients {
+		if cfg.IsRecoveryKey(k.Fingerprint) {
+			return nil
+		}
+	}
+	return fmt.Errorf("environment %s requires a recovery key, but none of recovery_keys (%s) is in authorized_keys", envName, strings.Join(cfg.RecoveryKeys, ", "))
+}
+
+// CheckPinnedRecipients enforces pinned_recipients: every pinned
+// fingerprint must be among the recipients
+func CheckPinnedRecipients(cfg *config.Config, envName string, recipients []keys.Key) error {
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]bool, len(recipients))
+	for _, k := range recipients {
+		present[k.Fingerprint] = true
 	}
 
+	var missing []string
+	for _, fp := range env.PinnedRecipients {
+		if !present[fp] {
+			missing = append(missing, fp)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("environment %s pins recipients missing from authorized_keys: %s - restore them or update pinned_recipients", envName, strings.Join(missing, ", "))
+	}
 	return nil
 }
 
-// Decrypt decrypts an encrypted file using the user's SSH key
+// Decrypt decrypts an environment's encrypted file with the local identity
 func Decrypt(envName string) ([]byte, error) {
-	envaultDir, err := config.EnvaultDir()
-	if err != nil {
-		return nil, err
+	if s := activeSession(); s != nil {
+		if plaintext, ok := s.cached(envName); ok {
+			return plaintext, nil
+		}
 	}
 
 	// Load config to get encrypted file path
@@ -77,36 +275,67 @@ func Decrypt(envName string) ([]byte, error) {
 		return nil, err
 	}
 
-	env, err := cfg.GetEnvironment(envName)
+	backend, err := BackendFor(cfg, envName)
 	if err != nil {
 		return nil, err
 	}
 
-	encryptedPath := filepath.Join(envaultDir, env.EncryptedFile)
-
-	// Check if encrypted file exists
-	if _, err := os.Stat(encryptedPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("encrypted file %s does not exist", env.EncryptedFile)
+	file, err := openCiphertext(cfg, envName)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
+
+	return backend.Decrypt(file)
+}
 
-	// Find user's SSH private key
-	sshKeyPath, err := findSSHPrivateKey()
+// DecryptData decrypts ciphertext that is not the file on disk, such as an
+// older revision, with the backend cfg names for the environment
+func DecryptData(cfg *config.Config, envName string, ciphertext []byte) ([]byte, error) {
+	backend, err := BackendFor(cfg, envName)
 	if err != nil {
 		return nil, err
 	}
+	return backend.Decrypt(bytes.NewReader(ciphertext))
+}
 
-	// Run age decryption
-	cmd := exec.Command("age", "-d", "-i", sshKeyPath, encryptedPath)
+// recordChanges updates per-variable change timestamps in the manifest,
+// and the stable content hashes when enabled. Variables in plaintext that
+// is not in dotenv format are not tracked.
+func recordChanges(cfg *config.Config, envName string, previous, current []byte, recipients string) error {
+	m, err := manifest.Load()
+	if err != nil {
+		return err
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	if currentValues, err := dotenv.ParseMap(current); err == nil {
+		var previousValues map[string]string
+		if previous != nil {
+			previousValues, _ = dotenv.ParseMap(previous)
+		}
+		m.RecordChanges(envName, previousValues, currentValues, time.Now().UTC())
+	}
 
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("age decryption failed: %w\nStderr: %s", err, stderr.String())
+	if cfg.StableCiphertext {
+		if err := m.RecordContent(envName, current, recipients); err != nil {
+			return err
+		}
 	}
 
-	return stdout.Bytes(), nil
+	return m.Save()
+}
+
+// recipientsHash returns a hex SHA256 over the backend and the sorted
+// recipient list, which together determine who can decrypt
+func recipientsHash(backendName string, recipients []keys.Key) string {
+	lines := make([]string, 0, len(recipients))
+	for _, k := range recipients {
+		lines = append(lines, k.Recipient())
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(backendName + "\n" + strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
 }
 
 // EncryptFile encrypts a plaintext file
@@ -119,69 +348,97 @@ func EncryptFile(envName string, plaintextPath string) error {
 	return Encrypt(envName, data)
 }
 
-// DecryptToWriter decrypts and writes to an io.Writer
+// DecryptToWriter decrypts and writes to an io.Writer, streaming when the
+// backend supports it
 func DecryptToWriter(envName string, w io.Writer) error {
-	plaintext, err := Decrypt(envName)
+	return DecryptStream(envName, w)
+}
+
+// Reencrypt re-encrypts an environment with updated authorized_keys
+func Reencrypt(envName string) error {
+	large, err := IsLarge(envName)
 	if err != nil {
 		return err
 	}
 
-	if _, err := w.Write(plaintext); err != nil {
-		return fmt.Errorf("failed to write decrypted data: %w", err)
-	}
+	if large {
+		if err := reencryptStream(envName); err != nil {
+			return fmt.Errorf("failed to re-encrypt: %w", err)
+		}
+	} else {
+		// Decrypt with current key
+		plaintext, err := Decrypt(envName)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt: %w", err)
+		}
 
-	return nil
-}
+		// Re-encrypt with all authorized keys
+		if err := Encrypt(envName, plaintext); err != nil {
+			return fmt.Errorf("failed to re-encrypt: %w", err)
+		}
+	}
 
-// Reencrypt re-encrypts an environment with updated authorized_keys
-func Reencrypt(envName string) error {
-	// Decrypt with current key
-	plaintext, err := Decrypt(envName)
+	// Notes must follow the same recipients, or removed keys keep access
+	notes, err := DecryptNotes(envName)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt: %w", err)
+		return fmt.Errorf("failed to decrypt notes: %w", err)
 	}
-
-	// Re-encrypt with all authorized keys
-	if err := Encrypt(envName, plaintext); err != nil {
-		return fmt.Errorf("failed to re-encrypt: %w", err)
+	if notes != nil {
+		if err := EncryptNotes(envName, notes); err != nil {
+			return fmt.Errorf("failed to re-encrypt notes: %w", err)
+		}
 	}
 
 	return nil
 }
 
-// findSSHPrivateKey finds the user's SSH private key
-func findSSHPrivateKey() (string, error) {
-	homeDir, err := os.UserHomeDir()
+// IsLarge reports whether an environment's ciphertext exceeds
+// LargePayloadSize
+func IsLarge(envName string) (bool, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return false, err
+	}
+	encryptedPath, err := cfg.EncryptedPath(envName)
 	if err != nil {
-		return "", fmt.Errorf("failed to get home directory: %w", err)
+		return false, err
 	}
+	info, err := os.Stat(encryptedPath)
+	if err != nil {
+		return false, nil // reported by the decrypt that follows
+	}
+	return info.Size() > LargePayloadSize, nil
+}
 
-	sshDir := filepath.Join(homeDir, ".ssh")
+// CheckAvailable verifies that the backends used by the configured
+// environments can run on this machine. Without a config.yaml, the
+// default backend is checked.
+func CheckAvailable() error {
+	names := map[string]bool{}
 
-	// Try common key names in order of preference
-	keyNames := []string{
-		"id_ed25519",
-		"id_rsa",
-		"id_ecdsa",
-		"id_dsa",
+	cfg, err := config.Load()
+	if err != nil {
+		names[DefaultBackend] = true
+	} else {
+		for envName := range cfg.Environments {
+			name, err := cfg.BackendName(envName)
+			if err != nil {
+				return err
+			}
+			names[name] = true
+		}
 	}
 
-	for _, keyName := range keyNames {
-		keyPath := filepath.Join(sshDir, keyName)
-		if _, err := os.Stat(keyPath); err == nil {
-			return keyPath, nil
+	for name := range names {
+		backend, err := LookupBackend(name)
+		if err != nil {
+			return err
+		}
+		if err := backend.Available(); err != nil {
+			return err
 		}
 	}
 
-	return "", fmt.Errorf("no SSH private key found in %s (tried: %s)", sshDir, strings.Join(keyNames, ", "))
-}
-
-// CheckAge verifies that the age tool is installed
-func CheckAge() error {
-	cmd := exec.Command("age", "--version")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("age is not installed - install with: brew install age")
-	}
 	return nil
 }
 
@@ -215,3 +472,31 @@ func ReencryptAll() ([]string, error) {
 
 	return reencrypted, nil
 }
+
+// CiphertextHash returns the hex SHA256 of an environment's encrypted file
+func CiphertextHash(envName string) (string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return "", err
+	}
+	if err := refreshRemote(cfg, envName); err != nil {
+		return "", err
+	}
+
+	encryptedPath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := openEncrypted(encryptedPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read encrypted file for %s: %w", envName, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read encrypted file for %s: %w", envName, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/secmem"
+)
+
+// DecryptLocalEnv ignores decrypt_via, for running envault on the
+// delegate host itself
+const DecryptLocalEnv = "ENVAULT_DECRYPT_LOCAL"
+
+// delegateBackend decrypts by piping the ciphertext through ssh to a host
+// that holds the identity. Everything else, encryption included, is left
+// to the configured backend.
+type delegateBackend struct {
+	Backend
+	via *config.Delegate
+}
+
+// withDelegate routes an environment's decryption through its
+// decrypt_via host, unless ENVAULT_DECRYPT_LOCAL is set
+func withDelegate(b Backend, cfg *config.Config, envName string) (Backend, error) {
+	env, ok := cfg.Environments[envName]
+	if !ok || env.DecryptVia == nil || os.Getenv(DecryptLocalEnv) != "" {
+		return b, nil
+	}
+	if err := env.DecryptVia.Validate(); err != nil {
+		return nil, fmt.Errorf("decrypt_via of %s: %w", envName, err)
+	}
+	return &delegateBackend{Backend: b, via: env.DecryptVia}, nil
+}
+
+func (b *delegateBackend) Available() error {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return fmt.Errorf("ssh not found in PATH (needed to decrypt via %s)", b.via.SSH)
+	}
+	return nil
+}
+
+func (b *delegateBackend) EncryptStream(plaintext io.Reader, recipients []keys.Key, w io.Writer) error {
+	return encryptStream(b.Backend, plaintext, recipients, w)
+}
+
+func (b *delegateBackend) Decrypt(r io.Reader) ([]byte, error) {
+	plaintext := secmem.NewBuffer(0)
+	if err := b.DecryptStream(r, plaintext); err != nil {
+		secmem.Wipe(plaintext.Bytes())
+		return nil, err
+	}
+	return plaintext.Bytes(), nil
+}
+
+// DecryptStream streams ciphertext to the remote command's stdin and its
+// stdout to w. Agent and X11 forwarding are turned off: the remote host
+// has no business using the caller's keys.
+func (b *delegateBackend) DecryptStream(r io.Reader, w io.Writer) error {
+	args := []string{"-T", "-a", "-x"}
+	args = append(args, b.via.Options...)
+	args = append(args, "--", b.via.SSH, b.via.RemoteCommand())
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = r
+	cmd.Stdout = w
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("decryption via ssh %s failed: %w\nStderr: %s", b.via.SSH, err, strings.TrimSpace(stderr.String()))
+	}
+	if Verbose {
+		fmt.Fprintf(os.Stderr, "Decrypted via ssh %s\n", b.via.SSH)
+	}
+	return nil
+}
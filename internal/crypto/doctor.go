@@ -0,0 +1,332 @@
+package crypto
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/orchard9/envault/internal/ci"
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/identity"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/profile"
+)
+
+// Probe is one thing an environment needs from this machine and what was
+// found for it
+type Probe struct {
+	Name   string // e.g. "age", "age-plugin-yubikey", "ssh"
+	Detail string // what was found: a path, a version, a count
+	Err    error  // why the need is not met
+}
+
+// IdentityProbe is an identity envault would try to decrypt with
+type IdentityProbe struct {
+	Label       string // where it comes from, e.g. ~/.ssh/id_ed25519
+	Fingerprint string // of its public key, "" when it cannot be derived
+	Recipient   bool   // the key is one of the environment's recipients
+	Err         error  // the identity cannot be used at all
+}
+
+// Usable reports whether the identity may decrypt: it can be used and is
+// a recipient, or its key is unknown
+func (p IdentityProbe) Usable() bool {
+	return p.Err == nil && (p.Recipient || p.Fingerprint == "")
+}
+
+// Diagnosis is what an environment needs from this machine to be
+// encrypted and decrypted, and whether the machine has it. Nothing is
+// decrypted to find out.
+type Diagnosis struct {
+	Env           string
+	Backend       string
+	BackendSource string           // where the backend is chosen
+	DecryptVia    *config.Delegate // set when another host decrypts
+	ChainSource   string           // where the identity chain is set, "" for the default order
+	Encrypt       []Probe          // every one must pass to encrypt
+	Decrypt       []Probe          // every one must pass to decrypt, besides an identity
+	AgeIdentities bool             // the backend decrypts here with age identity files
+	Identities    []IdentityProbe  // with AgeIdentities, decrypting needs one usable identity
+}
+
+// CanEncrypt reports whether every encryption need is met
+func (d *Diagnosis) CanEncrypt() bool {
+	return allPass(d.Encrypt)
+}
+
+// CanDecrypt reports whether every decryption need is met and, for age
+// decrypting here, some identity may decrypt
+func (d *Diagnosis) CanDecrypt() bool {
+	if !allPass(d.Decrypt) {
+		return false
+	}
+	if !d.AgeIdentities {
+		return true
+	}
+	for _, p := range d.Identities {
+		if p.Usable() {
+			return true
+		}
+	}
+	return false
+}
+
+func allPass(probes []Probe) bool {
+	for _, p := range probes {
+		if p.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Diagnose probes what an environment's backend needs on this machine:
+// the backend itself (for age, the binary and its version), the plugins
+// its recipients and identities need, the identities it would try and
+// whether they are recipients, and ssh for decrypt_via
+func Diagnose(cfg *config.Config, envName string) (*Diagnosis, error) {
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		return nil, err
+	}
+	d := &Diagnosis{Env: envName, Backend: DefaultBackend, BackendSource: "default"}
+	switch {
+	case env.Backend != "":
+		d.Backend, d.BackendSource = env.Backend, "environment "+envName
+	case cfg.Backend != "":
+		d.Backend, d.BackendSource = cfg.Backend, "config.yaml"
+	}
+
+	b, err := BackendFor(cfg, envName)
+	if err != nil {
+		failed := Probe{Name: "backend " + d.Backend, Err: err}
+		d.Encrypt = append(d.Encrypt, failed)
+		d.Decrypt = append(d.Decrypt, failed)
+		return d, nil
+	}
+	inner := b
+	if db, ok := b.(*delegateBackend); ok {
+		inner = db.Backend
+		d.DecryptVia = db.via
+		ssh := Probe{Name: "ssh", Err: db.Available()}
+		if path, err := exec.LookPath("ssh"); err == nil {
+			ssh.Detail = fmt.Sprintf("%s, decrypting on %s with %s", path, db.via.SSH, db.via.RemoteCommand())
+		}
+		d.Decrypt = append(d.Decrypt, ssh)
+	}
+
+	backend := backendProbe(inner)
+	d.Encrypt = append(d.Encrypt, backend)
+	if d.DecryptVia == nil {
+		d.Decrypt = append(d.Decrypt, backend)
+	}
+
+	// Everything recipientsFor enforces, so this fails when encrypt would
+	recipients, err := recipientsFor(cfg, envName, inner)
+	if err != nil {
+		d.Encrypt = append(d.Encrypt, Probe{Name: "recipients", Err: err})
+		recipients, _ = keys.LoadFor(cfg, envName)
+	} else {
+		d.Encrypt = append(d.Encrypt, Probe{Name: "recipients", Detail: fmt.Sprintf("%d key(s)", len(recipients))})
+	}
+	plugins := KeyPlugins(recipients)
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path, err := FindPlugin(name)
+		d.Encrypt = append(d.Encrypt, Probe{Name: PluginPrefix + name, Detail: path, Err: err})
+	}
+
+	ab, ok := inner.(*ageBackend)
+	if !ok || d.DecryptVia != nil {
+		return d, nil
+	}
+	d.AgeIdentities = true
+	_, d.ChainSource = IdentityChain(cfg, envName)
+	present := map[string]bool{}
+	for _, k := range recipients {
+		present[k.Fingerprint] = true
+	}
+	for _, p := range ab.identityProbes() {
+		p.Recipient = p.Fingerprint != "" && present[p.Fingerprint]
+		d.Identities = append(d.Identities, p)
+	}
+	return d, nil
+}
+
+// backendProbe checks that a backend can run; for age it also names the
+// binary and its version
+func backendProbe(b Backend) Probe {
+	if _, ok := b.(*ageBackend); !ok {
+		return Probe{Name: "backend " + b.Name(), Err: b.Available()}
+	}
+	p := Probe{Name: "age", Err: CheckAge()}
+	if path, err := exec.LookPath(AgeBinary()); err == nil {
+		p.Detail = path
+		if v, err := AgeVersion(); err == nil {
+			p.Detail += " " + v
+		}
+	}
+	return p
+}
+
+// identityProbes lists the identities the backend would try, in order,
+// like candidates but without preparing or unsealing any key: identities
+// passed in the environment are only reported as set, and the protected
+// identity by its recorded public key
+func (b *ageBackend) identityProbes() []IdentityProbe {
+	chain := b.chain
+	explicit := len(chain) > 0
+	if !explicit {
+		chain = []string{config.IdentityEnv, config.IdentityConfigured, config.IdentityProtected, ""}
+	}
+
+	var list []IdentityProbe
+	missing := func(source string, err error) {
+		if explicit {
+			list = append(list, IdentityProbe{Label: source, Err: err})
+		}
+	}
+	for _, source := range chain {
+		switch {
+		case source == "":
+			// The backend's default files
+			for _, path := range b.identityFiles() {
+				list = append(list, fileProbe(shortPath(path), path))
+			}
+
+		case source == config.IdentityEnv:
+			found := false
+			if os.Getenv("ENVAULT_IDENTITY_KEY") != "" {
+				list = append(list, IdentityProbe{Label: "ENVAULT_IDENTITY_KEY"})
+				found = true
+			}
+			if os.Getenv(ci.PassphraseEnv) != "" {
+				list = append(list, ciProbe())
+				found = true
+			}
+			if !found {
+				missing(source, fmt.Errorf("neither ENVAULT_IDENTITY_KEY nor %s is set", ci.PassphraseEnv))
+			}
+
+		case source == config.IdentityConfigured:
+			path, from := configuredIdentity()
+			if path == "" {
+				missing(source, fmt.Errorf("neither ENVAULT_IDENTITY nor a profile identity is set"))
+				continue
+			}
+			list = append(list, fileProbe(from+" ("+shortPath(path)+")", path))
+
+		case source == config.IdentitySSH:
+			files := sshKeyFiles()
+			if len(files) == 0 {
+				missing(source, fmt.Errorf("no SSH private key found (tried: %s)", strings.Join(sshKeyNames, ", ")))
+			}
+			for _, path := range files {
+				list = append(list, fileProbe(shortPath(path), path))
+			}
+
+		case source == config.IdentityAge:
+			path := ageIdentityFile()
+			if path == "" {
+				missing(source, fmt.Errorf("no identity.txt in the envault user config directory"))
+				continue
+			}
+			list = append(list, fileProbe(shortPath(path), path))
+
+		case source == config.IdentityProtected:
+			p, ok := protectedProbe()
+			if !ok {
+				missing(source, identity.ErrNotStored)
+				continue
+			}
+			list = append(list, p)
+
+		case strings.HasPrefix(source, config.IdentityPlugin):
+			name := strings.TrimPrefix(source, config.IdentityPlugin)
+			found := false
+			for _, path := range b.chainFiles() {
+				for _, plugin := range IdentityPlugins(path) {
+					if plugin == name && !found {
+						list = append(list, fileProbe(source+" ("+shortPath(path)+")", path))
+						found = true
+					}
+				}
+			}
+			if !found {
+				missing(source, fmt.Errorf("no identity file needs age-plugin-%s", name))
+			}
+
+		case strings.HasPrefix(source, config.IdentityFile):
+			path := profile.ExpandHome(strings.TrimPrefix(source, config.IdentityFile))
+			list = append(list, fileProbe(shortPath(path), path))
+		}
+	}
+	return list
+}
+
+// fileProbe checks an identity file and derives its public key
+func fileProbe(label, path string) IdentityProbe {
+	c := fileCandidate(label, path)
+	p := IdentityProbe{Label: label, Err: c.err}
+	if c.err == nil {
+		p.Fingerprint = identityFingerprint(path)
+	}
+	return p
+}
+
+// ciProbe reports the CI identity that ENVAULT_CI_PASSPHRASE opens, by the
+// public key stored beside it
+func ciProbe() IdentityProbe {
+	p := IdentityProbe{Label: "CI identity (.envault/" + ci.FileName + ")"}
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	data, err := os.ReadFile(ci.Path(envaultDir))
+	if err != nil {
+		p.Err = fmt.Errorf("%s is set but the CI identity cannot be read (run: envault ci init): %w", ci.PassphraseEnv, err)
+		return p
+	}
+	if public, err := ci.PublicKey(data); err == nil {
+		if key, err := keys.ParseKey(public); err == nil {
+			p.Fingerprint = key.Fingerprint
+		}
+	}
+	return p
+}
+
+// protectedProbe reports the identity stored by envault identity import
+// --protect without unsealing it. ok is false when none is stored.
+func protectedProbe() (p IdentityProbe, ok bool) {
+	p.Label = "protected identity"
+	sealed, err := identity.Load()
+	if err == identity.ErrNotStored {
+		return p, false
+	}
+	if err != nil {
+		p.Err = err
+		return p, true
+	}
+	if key, err := keys.ParseKey(sealed.PublicKey); err == nil {
+		p.Fingerprint = key.Fingerprint
+	}
+	_, _, unlocked := identity.Unlocked(sealed.PublicKey)
+	switch {
+	case unlocked:
+		p.Label += " (unlocked)"
+	case os.Getenv(identity.PassphraseEnv) != "":
+		p.Label += " (" + identity.PassphraseEnv + ")"
+	case sealed.Keychain:
+		p.Label += " (keychain)"
+	default:
+		p.Err = fmt.Errorf("locked (run: envault identity unlock)")
+	}
+	return p, true
+}
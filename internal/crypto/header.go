@@ -0,0 +1,106 @@
+package crypto
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/keys"
+)
+
+// Stanza is a recipient entry from an age file header
+type Stanza struct {
+	Type string   // e.g. "ssh-ed25519", "ssh-rsa", "X25519"
+	Args []string // for SSH stanzas, Args[0] is the recipient tag
+}
+
+// Tag returns the SSH recipient tag, or "" for anonymous stanza types
+func (s Stanza) Tag() string {
+	if (s.Type == "ssh-ed25519" || s.Type == "ssh-rsa") && len(s.Args) > 0 {
+		return s.Args[0]
+	}
+	return ""
+}
+
+// ReadHeader returns the recipient stanzas of an environment's ciphertext
+// without decrypting it
+func ReadHeader(envName string) ([]Stanza, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := refreshRemote(cfg, envName); err != nil {
+		return nil, err
+	}
+	encryptedPath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := openEncrypted(encryptedPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encrypted file for %s: %w", envName, err)
+	}
+	defer file.Close()
+
+	return ParseHeader(file, encryptedPath)
+}
+
+// ParseHeader reads the recipient stanzas at the start of age ciphertext;
+// name identifies it in errors
+func ParseHeader(r io.Reader, name string) ([]Stanza, error) {
+	reader := bufio.NewReader(r)
+	first, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(first) != "age-encryption.org/v1" {
+		return nil, fmt.Errorf("%s is not a binary age file", name)
+	}
+
+	var stanzas []Stanza
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("truncated age header in %s", name)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case strings.HasPrefix(line, "---"):
+			return stanzas, nil
+		case strings.HasPrefix(line, "-> "):
+			fields := strings.Fields(strings.TrimPrefix(line, "-> "))
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("malformed stanza in %s", name)
+			}
+			stanzas = append(stanzas, Stanza{Type: fields[0], Args: fields[1:]})
+		}
+		// Other lines are stanza bodies
+	}
+}
+
+// KeysInHeader returns which of the given keys are recipients of an
+// environment's ciphertext. Only SSH keys can be identified; X25519
+// stanzas are anonymous.
+func KeysInHeader(envName string, candidates []keys.Key) ([]keys.Key, error) {
+	stanzas, err := ReadHeader(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]bool{}
+	for _, s := range stanzas {
+		if tag := s.Tag(); tag != "" {
+			tags[s.Type+" "+tag] = true
+		}
+	}
+
+	var found []keys.Key
+	for _, k := range candidates {
+		if tag := k.SSHTag(); tag != "" && tags[k.Type+" "+tag] {
+			found = append(found, k)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,287 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/orchard9/envault/internal/ci"
+	"github.com/orchard9/envault/internal/profile"
+	"github.com/orchard9/envault/internal/wsl"
+)
+
+// Verbose reports on stderr which identity decrypted each environment
+var Verbose bool
+
+// candidate is an identity to try, labelled by where it came from
+type candidate struct {
+	label string
+	path  string
+	err   error // the identity could not be prepared
+}
+
+func (c candidate) String() string {
+	return c.label
+}
+
+// candidates lists the identities to try, in priority order:
+// ENVAULT_IDENTITY_KEY, the CI identity, ENVAULT_IDENTITY or the profile's
+// identity, the protected identity, then the backend's default files. A configured chain replaces
+// this order (see chainCandidates). The cleanup removes temp files and
+// must always be called.
+func (b *ageBackend) candidates() ([]candidate, func()) {
+	if len(b.chain) > 0 {
+		return b.chainCandidates(true)
+	}
+
+	list, cleanup := envCandidates()
+	if c, ok := configuredCandidate(); ok {
+		list = append(list, c)
+	}
+	if c, remove, ok := protectedCandidate(); ok {
+		list = append(list, c)
+		cleanup = joinCleanups(cleanup, remove)
+	}
+	for _, path := range b.identityFiles() {
+		list = append(list, candidate{label: shortPath(path), path: path, err: missingPlugin(path)})
+	}
+	return list, cleanup
+}
+
+// envCandidates prepares the identities passed in the environment:
+// ENVAULT_IDENTITY_KEY material and the CI identity
+func envCandidates() ([]candidate, func()) {
+	var list []candidate
+	var cleanups []func()
+	cleanup := func() {
+		for _, c := range cleanups {
+			c()
+		}
+	}
+
+	if material := os.Getenv("ENVAULT_IDENTITY_KEY"); material != "" {
+		path, remove, err := keyMaterial(material)
+		cleanups = append(cleanups, remove)
+		list = append(list, candidate{label: "ENVAULT_IDENTITY_KEY", path: path, err: err})
+	}
+	if passphrase := os.Getenv(ci.PassphraseEnv); passphrase != "" {
+		path, remove, err := ciIdentity(passphrase)
+		cleanups = append(cleanups, remove)
+		list = append(list, candidate{label: "CI identity (.envault/" + ci.FileName + ")", path: path, err: err})
+	}
+	return list, cleanup
+}
+
+// configuredCandidate is the identity named by ENVAULT_IDENTITY or the
+// profile, if either names one
+func configuredCandidate() (candidate, bool) {
+	path, source := configuredIdentity()
+	if path == "" {
+		return candidate{}, false
+	}
+	return fileCandidate(source+" ("+shortPath(path)+")", path), true
+}
+
+// fileCandidate is an identity file, with the reason it cannot be used
+func fileCandidate(label, path string) candidate {
+	c := candidate{label: label, path: path}
+	if _, err := os.Stat(path); err != nil {
+		c.err = fmt.Errorf("cannot read identity file: %w", err)
+	} else {
+		c.err = missingPlugin(path)
+	}
+	return c
+}
+
+// configuredIdentity returns the identity file set by ENVAULT_IDENTITY or,
+// failing that, by the active profile, and which of the two named it
+func configuredIdentity() (string, string) {
+	if path := os.Getenv("ENVAULT_IDENTITY"); path != "" {
+		return path, "ENVAULT_IDENTITY"
+	}
+	if p := profile.Current(); p != nil && p.Identity != "" {
+		return p.IdentityPath(), "profile " + p.Name
+	}
+	return "", ""
+}
+
+// decryptAny tries each identity until one decrypts r to w. Only an
+// attempt that wrote nothing moves on to the next identity, so a failure
+// partway through a stream is returned as is.
+func (b *ageBackend) decryptAny(r io.Reader, w io.Writer) error {
+	s := activeSession()
+	var candidates []candidate
+	if s != nil {
+		candidates = s.candidatesFor(b)
+	} else {
+		var cleanup func()
+		candidates, cleanup = b.candidates()
+		defer cleanup()
+	}
+	if len(candidates) == 0 {
+		_, err := b.identity()
+		return err
+	}
+
+	rewind, err := rewinder(r)
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, c := range candidates {
+		if c.err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", c, c.err))
+			continue
+		}
+
+		in, err := rewind()
+		if err != nil {
+			return err
+		}
+		out := &countingWriter{w: w}
+		stderr, err := runAgeDecrypt(in, c.path, out)
+		if err == nil {
+			if Verbose {
+				fmt.Fprintf(os.Stderr, "Decrypted with %s\n", c)
+			}
+			if s == nil || s.decrypted(c.path) {
+				recordKeyUse(c.path)
+			}
+			return nil
+		}
+		if out.n > 0 {
+			return fmt.Errorf("age decryption with %s failed: %w\nStderr: %s", c, err, stderr)
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", c, ageReason(stderr, err)))
+	}
+
+	return fmt.Errorf("no identity could decrypt; tried in order:\n  - %s", strings.Join(failures, "\n  - "))
+}
+
+// identityFiles lists the backend's default identity files that exist.
+// The age backend tries identity.txt first; age-ssh only tries it after
+// the SSH keys, and only for plugin identities (a hardware key may ask for
+// a touch or PIN, so it goes last).
+func (b *ageBackend) identityFiles() []string {
+	identity := ageIdentityFile()
+
+	if b.name == "age" {
+		if identity == "" {
+			return sshKeyFiles()
+		}
+		return append([]string{identity}, sshKeyFiles()...)
+	}
+	files := sshKeyFiles()
+	if identity != "" && len(IdentityPlugins(identity)) > 0 {
+		files = append(files, identity)
+	}
+	return files
+}
+
+// ageIdentityFile returns identity.txt in the user config dir, or "" when
+// there is none
+func ageIdentityFile() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(configDir, "envault", "identity.txt")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// sshKeyFiles lists existing SSH private keys in ~/.ssh, then under WSL
+// on the Windows host, in order of preference
+func sshKeyFiles() []string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	dirs := []string{filepath.Join(homeDir, ".ssh")}
+	if wsl.Detected() {
+		if winHome, err := wsl.WindowsHome(); err == nil {
+			dirs = append(dirs, filepath.Join(winHome, ".ssh"))
+		}
+	}
+
+	var files []string
+	for _, dir := range dirs {
+		for _, keyName := range sshKeyNames {
+			keyPath := filepath.Join(dir, keyName)
+			if _, err := os.Stat(keyPath); err == nil {
+				files = append(files, keyPath)
+			}
+		}
+	}
+	return files
+}
+
+// rewinder returns a function that yields r from its current position on
+// every call. Seekable readers are rewound; others are read into memory.
+func rewinder(r io.Reader) (func() (io.Reader, error), error) {
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			return func() (io.Reader, error) {
+				if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+					return nil, fmt.Errorf("failed to rewind ciphertext: %w", err)
+				}
+				return seeker, nil
+			}, nil
+		}
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ciphertext: %w", err)
+	}
+	return func() (io.Reader, error) {
+		return bytes.NewReader(data), nil
+	}, nil
+}
+
+// ageReason is the last line age printed, without its "age: error:" prefix
+func ageReason(stderr string, err error) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "age: report unexpected") || strings.HasPrefix(line, "age: [ See") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "age: ")
+		return strings.TrimPrefix(line, "error: ")
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return fmt.Sprintf("age exited with status %d", exitErr.ExitCode())
+	}
+	return err.Error()
+}
+
+// shortPath abbreviates the home directory to ~
+func shortPath(path string) string {
+	if home, err := os.UserHomeDir(); err == nil {
+		if rel, err := filepath.Rel(home, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join("~", rel)
+		}
+	}
+	return path
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
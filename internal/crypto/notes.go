@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// EncryptNotes encrypts an environment's free-text notes to the same
+// recipients as its secrets
+func EncryptNotes(envName string, notes []byte) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	notesPath, err := cfg.NotesPath(envName)
+	if err != nil {
+		return err
+	}
+
+	backend, err := BackendFor(cfg, envName)
+	if err != nil {
+		return err
+	}
+
+	recipients, err := recipientsFor(cfg, envName, backend)
+	if err != nil {
+		return err
+	}
+
+	return writeCiphertext(cfg, notesPath, func(w io.Writer) error {
+		return backend.Encrypt(notes, recipients, w)
+	})
+}
+
+// DecryptNotes returns an environment's notes, or nil if it has none
+func DecryptNotes(envName string) ([]byte, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	notesPath, err := cfg.NotesPath(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := BackendFor(cfg, envName)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(notesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open notes: %w", err)
+	}
+	defer file.Close()
+
+	return backend.Decrypt(file)
+}
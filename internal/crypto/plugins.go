@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/secmem"
+)
+
+// PluginPrefix names age plugin executables: age-plugin-<name>
+const PluginPrefix = "age-plugin-"
+
+// FindPlugin locates an age plugin on PATH, where age looks for it
+func FindPlugin(name string) (string, error) {
+	path, err := exec.LookPath(PluginPrefix + name)
+	if err != nil {
+		return "", fmt.Errorf("age plugin %s is not installed (%s%s not found on PATH)", name, PluginPrefix, name)
+	}
+	return path, nil
+}
+
+// InstalledPlugins lists the age plugins on PATH by name
+func InstalledPlugins() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		matches, _ := filepath.Glob(filepath.Join(dir, PluginPrefix+"*"))
+		for _, match := range matches {
+			name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), PluginPrefix), ".exe")
+			if name == "" || seen[name] {
+				continue
+			}
+			if _, err := exec.LookPath(match); err != nil {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IdentityPlugins returns the plugins an identity file needs to decrypt:
+// one per AGE-PLUGIN-<NAME>-1... line. Other keys need none.
+func IdentityPlugins(identityPath string) []string {
+	data, err := os.ReadFile(identityPath)
+	if err != nil {
+		return nil
+	}
+	defer secmem.Wipe(data)
+
+	seen := map[string]bool{}
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		rest, ok := bytes.CutPrefix(bytes.TrimSpace(scanner.Bytes()), []byte("AGE-PLUGIN-"))
+		if !ok {
+			continue
+		}
+		// The bech32 separator is the last 1, after the plugin name
+		sep := bytes.LastIndexByte(rest, '1')
+		if sep < 1 || rest[sep-1] != '-' {
+			continue
+		}
+		name := strings.ToLower(string(rest[:sep-1]))
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// missingPlugin reports the first plugin an identity needs that is not
+// installed, since age would fail on it only after trying other stanzas
+func missingPlugin(identityPath string) error {
+	for _, name := range IdentityPlugins(identityPath) {
+		if _, err := FindPlugin(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyPlugins maps the plugins that recipients in authorized_keys need to
+// the keys that need them
+func KeyPlugins(authorized []keys.Key) map[string][]keys.Key {
+	plugins := map[string][]keys.Key{}
+	for _, k := range authorized {
+		if name := k.Plugin(); name != "" {
+			plugins[name] = append(plugins[name], k)
+		}
+	}
+	return plugins
+}
+
+// LocalIdentities lists the identity files a backend would try, without
+// the temp files made for ENVAULT_IDENTITY_KEY or the CI identity
+func LocalIdentities(b Backend) []string {
+	ab, ok := b.(*ageBackend)
+	if !ok {
+		return nil
+	}
+	var files []string
+	if len(ab.chain) > 0 {
+		list, cleanup := ab.chainCandidates(false)
+		cleanup()
+		for _, c := range list {
+			if c.path != "" {
+				files = append(files, c.path)
+			}
+		}
+		return files
+	}
+	if path, _ := configuredIdentity(); path != "" {
+		files = append(files, path)
+	}
+	return append(files, ab.identityFiles()...)
+}
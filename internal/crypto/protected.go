@@ -0,0 +1,77 @@
+package crypto
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/orchard9/envault/internal/identity"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/secmem"
+)
+
+// protectedCandidate is the identity stored by envault identity import
+// --protect: the key unlocked for this session, else the key opened with
+// ENVAULT_IDENTITY_PASSPHRASE or the keychain's passphrase into a temp
+// file. ok is false when no identity is stored. The cleanup must always be
+// called.
+func protectedCandidate() (c candidate, cleanup func(), ok bool) {
+	c.label = "protected identity"
+	sealed, err := identity.Load()
+	if err == identity.ErrNotStored {
+		return candidate{}, func() {}, false
+	}
+	if err != nil {
+		c.err = err
+		return c, func() {}, true
+	}
+	if path, _, unlocked := identity.Unlocked(sealed.PublicKey); unlocked {
+		c.label += " (unlocked)"
+		c.path = path
+		return c, func() {}, true
+	}
+
+	passphrase, source := os.Getenv(identity.PassphraseEnv), identity.PassphraseEnv
+	if passphrase == "" && sealed.Keychain {
+		source = "keychain"
+		if passphrase, err = identity.KeychainLookup(); err != nil {
+			c.label += " (keychain)"
+			c.err = err
+			return c, func() {}, true
+		}
+	}
+	if passphrase == "" {
+		c.err = fmt.Errorf("locked (run: envault identity unlock)")
+		return c, func() {}, true
+	}
+
+	c.label += " (" + source + ")"
+	private, err := sealed.Open(passphrase)
+	if err != nil {
+		c.err = err
+		return c, func() {}, true
+	}
+	c.path, cleanup, c.err = writeIdentity(private)
+	secmem.Wipe(private)
+	return c, cleanup, true
+}
+
+// IdentityKey returns the public key of an identity file, refusing one that
+// is already passphrase-protected or whose public key cannot be found
+func IdentityKey(identityPath string) (*keys.Key, error) {
+	if passphraseProtected(identityPath) {
+		return nil, fmt.Errorf("%s is already passphrase-protected", shortPath(identityPath))
+	}
+	key := identityPublicKey(identityPath)
+	if key == nil {
+		return nil, fmt.Errorf("cannot determine the public key of %s (add a .pub file next to it)", shortPath(identityPath))
+	}
+	return key, nil
+}
+
+// joinCleanups returns a cleanup running both
+func joinCleanups(first, second func()) func() {
+	return func() {
+		first()
+		second()
+	}
+}
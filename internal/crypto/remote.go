@@ -0,0 +1,23 @@
+package crypto
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/remote"
+	"github.com/orchard9/envault/internal/ui"
+)
+
+// refreshRemote fetches an environment's ciphertext when it has a remote,
+// warning on stderr when a cached or locally changed copy is used
+func refreshRemote(cfg *config.Config, envName string) error {
+	s, err := remote.Refresh(cfg, envName)
+	if err != nil || s == nil {
+		return err
+	}
+	if warning := s.Report(envName); warning != "" {
+		fmt.Fprintf(os.Stderr, "%s %s\n", ui.Err.Warn(), warning)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// EncryptShared encrypts a vault file that belongs to no one environment,
+// such as schema.yaml.age, to every authorized key with the top-level
+// backend
+func EncryptShared(path string, plaintext []byte) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	backend, err := sharedBackend(cfg)
+	if err != nil {
+		return err
+	}
+	recipients, err := authorizedRecipients(cfg, "", backend)
+	if err != nil {
+		return err
+	}
+	return writeCiphertext(cfg, path, func(w io.Writer) error {
+		return backend.Encrypt(plaintext, recipients, w)
+	})
+}
+
+// DecryptShared decrypts a file written by EncryptShared
+func DecryptShared(path string) ([]byte, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	backend, err := sharedBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+
+	return backend.Decrypt(file)
+}
+
+// DecryptSharedData decrypts ciphertext written like EncryptShared's, such
+// as a desired-state file for envault apply
+func DecryptSharedData(ciphertext []byte) ([]byte, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	backend, err := sharedBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Decrypt(bytes.NewReader(ciphertext))
+}
+
+// sharedBackend is the top-level backend, limited to the identity chain
+// of the profile or config.yaml
+func sharedBackend(cfg *config.Config) (Backend, error) {
+	b, err := LookupBackend(cfg.Backend)
+	if err != nil {
+		return nil, err
+	}
+	return withIdentityChain(b, cfg, "")
+}
@@ -0,0 +1,211 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/fsutil"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/vault"
+)
+
+// LargePayloadSize is the ciphertext size above which reencrypt streams
+// instead of decrypting into memory. Streamed payloads are treated as
+// opaque: no per-variable tracking or stable_ciphertext comparison.
+const LargePayloadSize = 8 << 20
+
+// EncryptStream encrypts everything read from r for an environment without
+// buffering it. Use it for large or non-dotenv payloads such as keystores.
+func EncryptStream(envName string, r io.Reader) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	encryptedPath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		return err
+	}
+
+	backend, err := BackendFor(cfg, envName)
+	if err != nil {
+		return err
+	}
+
+	recipients, err := recipientsFor(cfg, envName, backend)
+	if err != nil {
+		return err
+	}
+
+	return writeCiphertext(cfg, encryptedPath, func(w io.Writer) error {
+		return encryptStream(backend, r, recipients, w)
+	})
+}
+
+// DecryptStream decrypts an environment to w without buffering it
+func DecryptStream(envName string, w io.Writer) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	backend, err := BackendFor(cfg, envName)
+	if err != nil {
+		return err
+	}
+
+	file, err := openCiphertext(cfg, envName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return decryptStream(backend, file, w)
+}
+
+// DecryptStreamWith decrypts ciphertext from r to w with a backend's local
+// identities, outside any environment (envault remote-decrypt)
+func DecryptStreamWith(b Backend, r io.Reader, w io.Writer) error {
+	return decryptStream(b, r, w)
+}
+
+// reencryptStream pipes decryption straight into encryption, so a large
+// payload never sits in memory. The old ciphertext is only replaced once
+// both sides succeed.
+func reencryptStream(envName string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	backend, err := BackendFor(cfg, envName)
+	if err != nil {
+		return err
+	}
+
+	recipients, err := recipientsFor(cfg, envName, backend)
+	if err != nil {
+		return err
+	}
+
+	encryptedPath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		return err
+	}
+
+	file, err := openCiphertext(cfg, envName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pr, pw := io.Pipe()
+	decrypted := make(chan error, 1)
+	go func() {
+		err := decryptStream(backend, file, pw)
+		pw.CloseWithError(err)
+		decrypted <- err
+	}()
+
+	err = writeCiphertext(cfg, encryptedPath, func(w io.Writer) error {
+		if err := encryptStream(backend, pr, recipients, w); err != nil {
+			return err
+		}
+		// Encryption may finish cleanly on a truncated stream
+		return <-decrypted
+	})
+	pr.Close()
+	return err
+}
+
+// writeCiphertext atomically writes an encrypted file, creating its
+// directory for layouts that keep one per environment
+func writeCiphertext(cfg *config.Config, path string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), cfg.DirMode()); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	return fsutil.WriteAtomic(path, cfg.FileMode(), write)
+}
+
+// openCiphertext opens an environment's encrypted file, fetching it
+// first when the environment has a remote
+func openCiphertext(cfg *config.Config, envName string) (io.ReadSeekCloser, error) {
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		return nil, err
+	}
+	if err := refreshRemote(cfg, envName); err != nil {
+		return nil, err
+	}
+
+	encryptedPath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := openEncrypted(encryptedPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("encrypted file %s does not exist", env.EncryptedFile)
+		}
+		return nil, fmt.Errorf("failed to open %s: %w", env.EncryptedFile, err)
+	}
+	return file, nil
+}
+
+// indexFile is ciphertext read from the git index; like a file it can be
+// rewound to try another identity
+type indexFile struct {
+	*bytes.Reader
+}
+
+func (indexFile) Close() error { return nil }
+
+// openEncrypted opens an encrypted file, or its blob in the git index when
+// a sparse checkout leaves it out of the working tree
+func openEncrypted(path string) (io.ReadSeekCloser, error) {
+	file, err := os.Open(path)
+	if err == nil {
+		return file, nil
+	}
+	if os.IsNotExist(err) {
+		if data, ok := vault.IndexBlob(path); ok {
+			return indexFile{bytes.NewReader(data)}, nil
+		}
+	}
+	return nil, err
+}
+
+// encryptStream uses the backend's streaming path, or buffers for
+// backends that only work on whole payloads
+func encryptStream(b Backend, plaintext io.Reader, recipients []keys.Key, w io.Writer) error {
+	if sb, ok := b.(StreamBackend); ok {
+		return sb.EncryptStream(plaintext, recipients, w)
+	}
+
+	data, err := io.ReadAll(plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to read plaintext: %w", err)
+	}
+	return b.Encrypt(data, recipients, w)
+}
+
+// decryptStream is the decrypting counterpart of encryptStream
+func decryptStream(b Backend, r io.Reader, w io.Writer) error {
+	if sb, ok := b.(StreamBackend); ok {
+		return sb.DecryptStream(r, w)
+	}
+
+	plaintext, err := b.Decrypt(r)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, bytes.NewReader(plaintext)); err != nil {
+		return fmt.Errorf("failed to write decrypted data: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/manifest"
+)
+
+// recordKeyUse notes in the manifest that the key behind an identity file
+// just decrypted successfully. It is best effort: a read-only checkout or
+// an unrecognized identity must never fail a decrypt.
+func recordKeyUse(identityPath string) {
+	fingerprint := identityFingerprint(identityPath)
+	if fingerprint == "" {
+		return
+	}
+
+	authorizedKeys, err := keys.Load()
+	if err != nil || !hasFingerprint(authorizedKeys, fingerprint) {
+		return
+	}
+
+	m, err := manifest.Load()
+	if err != nil {
+		return
+	}
+	if m.RecordKeyUse(fingerprint, time.Now()) {
+		m.Save()
+	}
+}
+
+// identityFingerprint returns the fingerprint of an identity's public key,
+// or "" if it cannot be found (see identityPublicKey)
+func identityFingerprint(identityPath string) string {
+	if key := identityPublicKey(identityPath); key != nil {
+		return key.Fingerprint
+	}
+	return ""
+}
+
+// identityPublicKey returns an identity's public key, from a .pub file
+// next to it, an age-keygen "# public key:" or plugin "# Recipient:"
+// comment, or ssh-keygen for an unencrypted SSH key. Nil if none works.
+func identityPublicKey(identityPath string) *keys.Key {
+	if data, err := os.ReadFile(identityPath + ".pub"); err == nil {
+		if key, err := keys.ParseKey(strings.TrimSpace(string(data))); err == nil {
+			return key
+		}
+	}
+
+	data, err := os.ReadFile(identityPath)
+	if err != nil {
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// age-plugin-* tools write "# Recipient: age1<plugin>1..."
+		comment, ok := strings.CutPrefix(scanner.Text(), "#")
+		if !ok {
+			continue
+		}
+		comment = strings.TrimSpace(comment)
+		recipient, ok := strings.CutPrefix(comment, "public key: ")
+		if !ok {
+			recipient, ok = strings.CutPrefix(comment, "Recipient: ")
+		}
+		if ok {
+			if key, err := keys.ParseKey(recipient); err == nil {
+				return key
+			}
+		}
+	}
+
+	// An empty passphrase makes ssh-keygen fail instead of prompting
+	out, err := exec.Command("ssh-keygen", "-y", "-P", "", "-f", identityPath).Output()
+	if err != nil {
+		return nil
+	}
+	key, err := keys.ParseKey(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+func hasFingerprint(list []keys.Key, fingerprint string) bool {
+	for _, k := range list {
+		if k.Fingerprint == fingerprint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+// Package desired describes the state a vault should be in (envault apply):
+// its environments, its recipients and its schema. Plan compares that with
+// the vault as it is and lists the changes that get it there.
+package desired
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/schema"
+)
+
+// State is a desired-state file. A section that is left out is not
+// managed: apply leaves that part of the vault alone.
+type State struct {
+	Environments map[string]config.Environment `yaml:"environments,omitempty"` // as in config.yaml
+	Recipients   []string                      `yaml:"recipients,omitempty"`   // the complete authorized_keys, one key line each
+	Schema       *schema.Schema                `yaml:"schema,omitempty"`       // replaces schema.yaml
+}
+
+// Parse reads a desired-state file. Unknown fields are errors, so a typo
+// cannot silently leave something unmanaged.
+func Parse(data []byte) (*State, error) {
+	var s State
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to parse desired state: %w", err)
+	}
+	if s.Environments == nil && s.Recipients == nil && s.Schema == nil {
+		return nil, fmt.Errorf("desired state sets none of environments, recipients or schema")
+	}
+	if s.Schema != nil {
+		if err := validSchema(s.Schema); err != nil {
+			return nil, fmt.Errorf("schema: %w", err)
+		}
+	}
+	return &s, nil
+}
+
+// Change is one difference between the vault and the desired state
+type Change struct {
+	Op     string // "+" add, "~" update or "-" remove
+	Kind   string // environment, key or schema
+	Name   string
+	Detail string
+}
+
+func (c Change) String() string {
+	parts := []string{c.Op, c.Kind}
+	if c.Name != "" {
+		parts = append(parts, c.Name)
+	}
+	if c.Detail != "" {
+		parts = append(parts, "("+c.Detail+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Plan is what applying a State changes
+type Plan struct {
+	Changes []Change
+
+	// Config is config.yaml with the desired environments merged in
+	Config *config.Config
+
+	// Keys is the desired authorized_keys in canonical form, and
+	// Recipients its keys; both are empty when recipients are not managed
+	// or already match
+	Keys       string
+	Recipients []keys.Key
+
+	// Schema is the desired schema; nil when it is not managed or already
+	// matches
+	Schema *schema.Schema
+
+	// Unmanaged are environments in config.yaml that the state does not
+	// list and that were kept
+	Unmanaged []string
+
+	// Added are the environments the plan creates
+	Added []string
+}
+
+// Plan compares the state with the vault: cfg, the current authorized
+// keys and schema. Environments the state does not list are removed from
+// config.yaml with prune and otherwise kept.
+func (s *State) Plan(cfg *config.Config, current []keys.Key, currentSchema *schema.Schema, prune bool) (*Plan, error) {
+	merged := *cfg
+	merged.Environments = make(map[string]config.Environment, len(cfg.Environments))
+	for name, env := range cfg.Environments {
+		merged.Environments[name] = env
+	}
+	p := &Plan{Config: &merged}
+
+	if s.Environments != nil {
+		for _, name := range sortedNames(s.Environments) {
+			want := s.Environments[name]
+			have, exists := cfg.Environments[name]
+			if want.EncryptedFile == "" {
+				if exists {
+					want.EncryptedFile = have.EncryptedFile
+				} else {
+					file, err := config.LayoutFile(cfg.Layout, name)
+					if err != nil {
+						return nil, err
+					}
+					want.EncryptedFile = file
+				}
+			}
+			// Subvaults are written by envault subvault, not declared
+			if want.Subvaults == nil {
+				want.Subvaults = have.Subvaults
+			}
+			merged.Environments[name] = want
+
+			if !exists {
+				p.Changes = append(p.Changes, Change{Op: "+", Kind: "environment", Name: name})
+				p.Added = append(p.Added, name)
+				continue
+			}
+			fields, err := changedFields(have, want)
+			if err != nil {
+				return nil, err
+			}
+			if len(fields) > 0 {
+				p.Changes = append(p.Changes, Change{Op: "~", Kind: "environment", Name: name, Detail: strings.Join(fields, ", ")})
+			}
+		}
+		for _, name := range sortedNames(cfg.Environments) {
+			if _, ok := s.Environments[name]; ok {
+				continue
+			}
+			if !prune {
+				p.Unmanaged = append(p.Unmanaged, name)
+				continue
+			}
+			delete(merged.Environments, name)
+			p.Changes = append(p.Changes, Change{Op: "-", Kind: "environment", Name: name, Detail: "ciphertext kept"})
+		}
+		if err := merged.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Recipients != nil {
+		if len(s.Recipients) == 0 {
+			return nil, fmt.Errorf("recipients lists no keys; leave the section out to keep authorized_keys as it is")
+		}
+		text, recipients, changes, err := s.recipientChanges(current)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 {
+			p.Keys, p.Recipients = text, recipients
+			p.Changes = append(p.Changes, changes...)
+		}
+	}
+
+	if s.Schema != nil {
+		same, err := sameYAML(currentSchema, s.Schema)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			p.Schema = s.Schema
+			p.Changes = append(p.Changes, Change{Op: "~", Kind: "schema", Detail: schemaSummary(s.Schema)})
+		}
+	}
+	return p, nil
+}
+
+// recipientChanges returns the desired authorized_keys, its keys and the
+// keys it adds and removes. Keys are matched on their key data, not
+// comments.
+func (s *State) recipientChanges(current []keys.Key) (string, []keys.Key, []Change, error) {
+	var lines []string
+	var recipients []keys.Key
+	wanted := map[string]bool{}
+	var changes []Change
+	have := map[string]bool{}
+	for _, k := range current {
+		have[k.Data] = true
+	}
+	for i, line := range s.Recipients {
+		k, err := keys.ParseKey(strings.TrimSpace(line))
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("recipients entry %d: %w", i+1, err)
+		}
+		if wanted[k.Data] {
+			return "", nil, nil, fmt.Errorf("recipients lists %s twice", k.Fingerprint)
+		}
+		wanted[k.Data] = true
+		recipients = append(recipients, *k)
+		lines = append(lines, k.Line())
+		if !have[k.Data] {
+			changes = append(changes, Change{Op: "+", Kind: "key", Name: k.String()})
+		}
+	}
+	for _, k := range current {
+		if !wanted[k.Data] {
+			changes = append(changes, Change{Op: "-", Kind: "key", Name: k.String()})
+		}
+	}
+	formatted, err := keys.Format([]byte(strings.Join(lines, "\n") + "\n"))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("recipients: %w", err)
+	}
+	return string(formatted), recipients, changes, nil
+}
+
+// changedFields names the config.yaml fields that differ between two
+// environments
+func changedFields(have, want config.Environment) ([]string, error) {
+	var a, b map[string]any
+	for _, pair := range []struct {
+		env config.Environment
+		out *map[string]any
+	}{{have, &a}, {want, &b}} {
+		data, err := yaml.Marshal(pair.env)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, pair.out); err != nil {
+			return nil, err
+		}
+	}
+
+	names := map[string]bool{}
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+	var fields []string
+	for name := range names {
+		x, _ := yaml.Marshal(a[name])
+		y, _ := yaml.Marshal(b[name])
+		if !bytes.Equal(x, y) {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+func sameYAML(a, b *schema.Schema) (bool, error) {
+	if a == nil {
+		a = &schema.Schema{}
+	}
+	x, err := yaml.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	y, err := yaml.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(x, y), nil
+}
+
+func schemaSummary(s *schema.Schema) string {
+	rules := len(s.Variables)
+	for _, env := range s.Environments {
+		rules += len(env.Variables)
+	}
+	return fmt.Sprintf("%d rule(s), %d consistency rule(s)", rules, len(s.Consistency))
+}
+
+func validSchema(s *schema.Schema) error {
+	for name, v := range s.Variables {
+		if err := v.Valid(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for envName, env := range s.Environments {
+		for name, v := range env.Variables {
+			if err := v.Valid(); err != nil {
+				return fmt.Errorf("%s in %s: %w", name, envName, err)
+			}
+		}
+	}
+	for _, c := range s.Consistency {
+		if err := c.Valid(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedNames(m map[string]config.Environment) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,259 @@
+package dockersecret
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Labels envault puts on the secrets it manages
+const (
+	LabelEnvironment = "envault.environment"
+	LabelCiphertext  = "envault.ciphertext_sha256" // ciphertext the value came from
+)
+
+// Engines that speak the Docker secrets API
+const (
+	EngineDocker = "docker"
+	EnginePodman = "podman" // through its Docker-compatible API
+)
+
+// Client talks to the Docker Engine API, or to Podman's compatible API
+type Client struct {
+	http *http.Client
+	base string
+}
+
+// Secret is an engine secret as listed by the API; the data is never
+// returned
+type Secret struct {
+	ID   string
+	Name string
+	// Labels as set at creation; secrets cannot be updated in place
+	Labels map[string]string
+}
+
+// Connect resolves the engine's API socket: DOCKER_HOST (or CONTAINER_HOST
+// for Podman), then the usual socket paths. Only unix sockets and plain
+// tcp:// hosts are supported.
+func Connect(engine string) (*Client, error) {
+	host, err := engineHost(engine)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid engine host %q: %w", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &Client{http: &http.Client{Transport: transport, Timeout: 30 * time.Second}, base: "http://engine"}, nil
+	case "tcp", "http":
+		return &Client{http: &http.Client{Timeout: 30 * time.Second}, base: "http://" + u.Host}, nil
+	}
+	return nil, fmt.Errorf("unsupported engine host %q (use unix:// or tcp://)", host)
+}
+
+func engineHost(engine string) (string, error) {
+	switch engine {
+	case EngineDocker:
+		if host := os.Getenv("DOCKER_HOST"); host != "" {
+			return host, nil
+		}
+		return "unix:///var/run/docker.sock", nil
+	case EnginePodman:
+		if host := os.Getenv("CONTAINER_HOST"); host != "" {
+			return host, nil
+		}
+		var sockets []string
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			sockets = append(sockets, filepath.Join(dir, "podman", "podman.sock"))
+		}
+		sockets = append(sockets, "/run/podman/podman.sock")
+		for _, socket := range sockets {
+			if _, err := os.Stat(socket); err == nil {
+				return "unix://" + socket, nil
+			}
+		}
+		return "", fmt.Errorf("no Podman API socket found (start it with: systemctl --user start podman.socket)")
+	}
+	return "", fmt.Errorf("unknown engine %q (use docker or podman)", engine)
+}
+
+// List returns the secrets envault manages for an environment
+func (c *Client) List(envName string) ([]Secret, error) {
+	var listed []struct {
+		ID   string
+		Spec struct {
+			Name   string
+			Labels map[string]string
+		}
+	}
+	if err := c.do(http.MethodGet, "/secrets", nil, &listed); err != nil {
+		return nil, err
+	}
+
+	// Filtered here: not every engine supports label filters on secrets
+	var secrets []Secret
+	for _, s := range listed {
+		if s.Spec.Labels[LabelEnvironment] != envName {
+			continue
+		}
+		secrets = append(secrets, Secret{ID: s.ID, Name: s.Spec.Name, Labels: s.Spec.Labels})
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Name < secrets[j].Name })
+	return secrets, nil
+}
+
+// Create adds a secret
+func (c *Client) Create(name string, data []byte, labels map[string]string) error {
+	spec := map[string]any{
+		"Name":   name,
+		"Data":   base64.StdEncoding.EncodeToString(data),
+		"Labels": labels,
+	}
+	return c.do(http.MethodPost, "/secrets/create", spec, nil)
+}
+
+// Remove deletes a secret. The engine refuses while a service uses it.
+func (c *Client) Remove(id string) error {
+	return c.do(http.MethodDelete, "/secrets/"+url.PathEscape(id), nil, nil)
+}
+
+// do sends a request with an optional JSON body and decodes a JSON reply
+func (c *Client) do(method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("container engine unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Message == "" {
+			apiErr.Message = resp.Status
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable && strings.Contains(apiErr.Message, "swarm") {
+			return fmt.Errorf("%s (Docker secrets need swarm mode: docker swarm init)", apiErr.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, apiErr.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid engine response: %w", err)
+	}
+	return nil
+}
+
+// Result lists what Sync did, by secret name
+type Result struct {
+	Created   []string
+	Replaced  []string
+	Unchanged []string
+	Removed   []string
+}
+
+// SyncOptions controls Sync
+type SyncOptions struct {
+	Prune bool // remove managed secrets that are no longer wanted
+	Force bool // replace secrets even when the ciphertext is unchanged
+}
+
+// Sync makes the engine's secrets for an environment match want, which
+// maps secret names to their data. Secrets made from the same ciphertext
+// are left alone; others are removed and created again, since secrets are
+// immutable.
+func Sync(c *Client, envName, ciphertextHash string, want map[string][]byte, opts SyncOptions) (*Result, error) {
+	existing, err := c.List(envName)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]Secret{}
+	for _, s := range existing {
+		byName[s.Name] = s
+	}
+
+	names := make([]string, 0, len(want))
+	for name := range want {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	labels := map[string]string{LabelEnvironment: envName, LabelCiphertext: ciphertextHash}
+	res := &Result{}
+	for _, name := range names {
+		old, exists := byName[name]
+		if exists && !opts.Force && old.Labels[LabelCiphertext] == ciphertextHash {
+			res.Unchanged = append(res.Unchanged, name)
+			continue
+		}
+		if exists {
+			if err := c.Remove(old.ID); err != nil {
+				return res, fmt.Errorf("cannot replace %s: %w (detach it from its services first)", name, err)
+			}
+		}
+		if err := c.Create(name, want[name], labels); err != nil {
+			return res, fmt.Errorf("cannot create %s: %w", name, err)
+		}
+		if exists {
+			res.Replaced = append(res.Replaced, name)
+		} else {
+			res.Created = append(res.Created, name)
+		}
+	}
+
+	if opts.Prune {
+		for _, s := range existing {
+			if _, ok := want[s.Name]; ok {
+				continue
+			}
+			if err := c.Remove(s.ID); err != nil {
+				return res, fmt.Errorf("cannot remove %s: %w", s.Name, err)
+			}
+			res.Removed = append(res.Removed, s.Name)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,325 @@
+package dotenv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/orchard9/envault/internal/secmem"
+)
+
+// Entry is a single KEY=VALUE assignment from a dotenv file
+type Entry struct {
+	Key     string
+	Value   string
+	Line    int      // 1-based line number where the assignment starts
+	EndLine int      // 1-based line number where the assignment ends
+	Tags    []string // from "# tag: a, b" comments above the assignment
+}
+
+// Parse parses dotenv formatted data into an ordered list of entries.
+// Supports comments, blank lines, an optional "export " prefix, and
+// single-quoted (literal), double-quoted (with escapes) or bare values.
+// Quoted values may span lines, so a PEM block can be pasted as is.
+// A "# tag: a, b" comment tags every assignment below it up to the next
+// blank line.
+func Parse(data []byte) ([]Entry, error) {
+	var entries []Entry
+	var tags []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Blank lines end a tagged block; other comments are skipped
+		if line == "" {
+			tags = nil
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			tags = append(tags, parseTagComment(line)...)
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		eq := strings.Index(line, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNum)
+		}
+
+		key := strings.TrimSpace(line[:eq])
+		raw := strings.TrimSpace(line[eq+1:])
+		start := lineNum
+
+		// Quoted values may span multiple lines
+		if strings.HasPrefix(raw, `"`) || strings.HasPrefix(raw, "'") {
+			quote := raw[0]
+			for !closed(raw[1:], quote) {
+				if !scanner.Scan() {
+					return nil, fmt.Errorf("line %d: unterminated quoted value for %s", start, key)
+				}
+				lineNum++
+				raw += "\n" + strings.TrimSuffix(scanner.Text(), "\r")
+			}
+		}
+
+		value, err := parseValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", start, key, err)
+		}
+
+		entries = append(entries, Entry{Key: key, Value: value, Line: start, EndLine: lineNum, Tags: tags})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dotenv data: %w", err)
+	}
+
+	return entries, nil
+}
+
+// ParseMap parses dotenv data into a map. Later assignments win.
+func ParseMap(data []byte) (map[string]string, error) {
+	entries, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(entries))
+	for _, e := range entries {
+		values[e.Key] = e.Value
+	}
+	return values, nil
+}
+
+// parseTagComment returns the tags in a "# tag: a, b" comment, or nil
+func parseTagComment(line string) []string {
+	body := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+	list, ok := strings.CutPrefix(body, "tag:")
+	if !ok {
+		list, ok = strings.CutPrefix(body, "tags:")
+	}
+	if !ok {
+		return nil
+	}
+
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// HasTag reports whether the entry carries any of the given tags
+func (e Entry) HasTag(tags []string) bool {
+	for _, want := range tags {
+		for _, tag := range e.Tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FilterTags returns the entries carrying any of the given tags. No tags
+// means no filtering.
+func FilterTags(entries []Entry, tags []string) []Entry {
+	if len(tags) == 0 {
+		return entries
+	}
+
+	var filtered []Entry
+	for _, e := range entries {
+		if e.HasTag(tags) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// SelectTags returns dotenv data containing only the assignments carrying
+// any of the given tags, copied verbatim from the original lines
+func SelectTags(data []byte, tags []string) ([]byte, error) {
+	if len(tags) == 0 {
+		return data, nil
+	}
+
+	entries, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return selectEntries(data, FilterTags(entries, tags)), nil
+}
+
+// SelectKeys returns dotenv data containing only the assignments to the
+// given keys, copied verbatim, and the keys that have no assignment
+func SelectKeys(data []byte, keys []string) ([]byte, []string, error) {
+	entries, err := Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wanted := map[string]bool{}
+	for _, k := range keys {
+		wanted[k] = true
+	}
+	found := map[string]bool{}
+	var selected []Entry
+	for _, e := range entries {
+		if wanted[e.Key] {
+			selected = append(selected, e)
+			found[e.Key] = true
+		}
+	}
+
+	var missing []string
+	for _, k := range keys {
+		if !found[k] {
+			missing = append(missing, k)
+		}
+	}
+	return selectEntries(data, selected), missing, nil
+}
+
+// selectEntries copies the lines of the given entries out of data
+func selectEntries(data []byte, entries []Entry) []byte {
+	// Work on the bytes directly: a string copy of plaintext cannot be wiped
+	lines := bytes.Split(data, []byte("\n"))
+	out := secmem.NewBuffer(len(data) + 1)
+	for _, e := range entries {
+		for _, line := range lines[e.Line-1 : e.EndLine] {
+			out.Write(bytes.TrimSuffix(line, []byte("\r")))
+			out.Write([]byte("\n"))
+		}
+	}
+	return out.Bytes()
+}
+
+// parseValue unquotes a raw value and strips trailing inline comments
+func parseValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		end := closingQuote(raw[1:], '"')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated double quote")
+		}
+		return unescape(raw[1 : end+1]), nil
+	case strings.HasPrefix(raw, "'"):
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quote")
+		}
+		return raw[1 : end+1], nil
+	default:
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw = raw[:i]
+		}
+		return strings.TrimSpace(raw), nil
+	}
+}
+
+// closed reports whether s contains the closing quote. Single quotes
+// have no escapes.
+func closed(s string, quote byte) bool {
+	if quote == '\'' {
+		return strings.IndexByte(s, quote) >= 0
+	}
+	return closingQuote(s, quote) >= 0
+}
+
+// closingQuote returns the index of the first unescaped quote in s, or -1
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// unescape expands the escape sequences allowed in double-quoted values
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// bareValue matches values that need no quoting
+var bareValue = regexp.MustCompile(`^[A-Za-z0-9_./:@+,=%-]*$`)
+
+// Quote renders a value so Parse reads it back unchanged: bare when safe,
+// otherwise double-quoted with newlines, tabs, quotes and backslashes
+// escaped, so the assignment stays on one line
+func Quote(value string) string {
+	if bareValue.MatchString(value) {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// ReplaceValues rewrites the assignments of the given keys as single-line
+// KEY=value entries, keeping every other line as is
+func ReplaceValues(data []byte, values map[string]string) ([]byte, error) {
+	if len(values) == 0 {
+		return data, nil
+	}
+
+	entries, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	starts := map[int]Entry{}
+	for _, e := range entries {
+		if _, ok := values[e.Key]; ok {
+			starts[e.Line] = e
+		}
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	out := secmem.NewBuffer(len(data))
+	for i := 0; i < len(lines); i++ {
+		e, ok := starts[i+1]
+		if !ok {
+			out.Write(lines[i])
+			out.Write([]byte("\n"))
+			continue
+		}
+		fmt.Fprintf(out, "%s=%s\n", e.Key, Quote(values[e.Key]))
+		i = e.EndLine - 1
+	}
+	return out.Bytes(), nil
+}
+
+// IsMultiline reports whether a value spans lines
+func IsMultiline(value string) bool {
+	return strings.ContainsAny(value, "\r\n")
+}
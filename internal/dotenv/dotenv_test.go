@@ -0,0 +1,157 @@
+package dotenv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Entry
+	}{
+		{"bare", "KEY=value\n", []Entry{{Key: "KEY", Value: "value", Line: 1, EndLine: 1}}},
+		{"export prefix", "export KEY=value", []Entry{{Key: "KEY", Value: "value", Line: 1, EndLine: 1}}},
+		{"spaces around =", "KEY = value \n", []Entry{{Key: "KEY", Value: "value", Line: 1, EndLine: 1}}},
+		{"empty value", "KEY=\n", []Entry{{Key: "KEY", Value: "", Line: 1, EndLine: 1}}},
+		{"inline comment", "KEY=value # note\n", []Entry{{Key: "KEY", Value: "value", Line: 1, EndLine: 1}}},
+		{"hash without space", "URL=http://x/#frag\n", []Entry{{Key: "URL", Value: "http://x/#frag", Line: 1, EndLine: 1}}},
+		{"= in value", "DSN=user=a password=b\n", []Entry{{Key: "DSN", Value: "user=a password=b", Line: 1, EndLine: 1}}},
+		{"double quoted", `KEY="a # b"`, []Entry{{Key: "KEY", Value: "a # b", Line: 1, EndLine: 1}}},
+		{"double quoted escapes", `KEY="line\nnext\t\"q\" \\"`, []Entry{{Key: "KEY", Value: "line\nnext\t\"q\" \\", Line: 1, EndLine: 1}}},
+		{"comment after quotes", `KEY="abc" # note`, []Entry{{Key: "KEY", Value: "abc", Line: 1, EndLine: 1}}},
+		{"single quoted is literal", `KEY='a\nb "c"'`, []Entry{{Key: "KEY", Value: `a\nb "c"`, Line: 1, EndLine: 1}}},
+		{"comments and blank lines", "# header\n\nA=1\n\n# more\nB=2\n", []Entry{{Key: "A", Value: "1", Line: 3, EndLine: 3}, {Key: "B", Value: "2", Line: 6, EndLine: 6}}},
+		{"CRLF line endings", "A=1\r\nB=\"2\"\r\n", []Entry{{Key: "A", Value: "1", Line: 1, EndLine: 1}, {Key: "B", Value: "2", Line: 2, EndLine: 2}}},
+		{
+			"multi-line double quoted",
+			"KEY=\"-----BEGIN KEY-----\nMIIE\n-----END KEY-----\"\nNEXT=1\n",
+			[]Entry{{Key: "KEY", Value: "-----BEGIN KEY-----\nMIIE\n-----END KEY-----", Line: 1, EndLine: 3}, {Key: "NEXT", Value: "1", Line: 4, EndLine: 4}},
+		},
+		{"multi-line single quoted with CRLF", "KEY='a\r\nb'\r\n", []Entry{{Key: "KEY", Value: "a\nb", Line: 1, EndLine: 2}}},
+		{"escaped quote does not close", "KEY=\"a\\\"\nb\"\n", []Entry{{Key: "KEY", Value: "a\"\nb", Line: 1, EndLine: 2}}},
+		{
+			"tags apply up to a blank line",
+			"# tag: web, worker\nA=1\n# tags: db\nB=2\n\nC=3\n",
+			[]Entry{{Key: "A", Value: "1", Line: 2, EndLine: 2, Tags: []string{"web", "worker"}}, {Key: "B", Value: "2", Line: 4, EndLine: 4, Tags: []string{"web", "worker", "db"}}, {Key: "C", Value: "3", Line: 6, EndLine: 6}},
+		},
+		{"empty file", "", nil},
+	}
+	for _, tt := range tests {
+		got, err := Parse([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: Parse failed: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Parse(%q) =\n%+v\nwant\n%+v", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"A=1\nnot an assignment\n", "line 2: expected KEY=VALUE"},
+		{"=value\n", "line 1: expected KEY=VALUE"},
+		{"A=1\nKEY=\"never closed\nmore\n", "line 2: unterminated quoted value for KEY"},
+		{"KEY='never closed\n", "line 1: unterminated quoted value for KEY"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.in))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	values := []string{
+		"",
+		"plain",
+		"postgres://user@host:5432/db?sslmode=require",
+		"has space",
+		"a # b",
+		`back\slash`,
+		`"quoted"`,
+		"it's",
+		"tab\there",
+		"-----BEGIN KEY-----\nMIIE\n-----END KEY-----\n",
+		"crlf\r\nline",
+		"$HOME and `cmd`",
+	}
+	for _, v := range values {
+		quoted := Quote(v)
+		if strings.ContainsAny(quoted, "\r\n") {
+			t.Errorf("Quote(%q) = %q spans lines", v, quoted)
+		}
+		got, err := ParseMap([]byte("KEY=" + quoted + "\n"))
+		if err != nil {
+			t.Errorf("Quote(%q) = %q does not parse: %v", v, quoted, err)
+			continue
+		}
+		if got["KEY"] != v {
+			t.Errorf("Quote(%q) = %q parses back as %q", v, quoted, got["KEY"])
+		}
+	}
+	if got := Quote("plain-value_1"); got != "plain-value_1" {
+		t.Errorf("Quote left a safe value quoted: %q", got)
+	}
+}
+
+func TestParseMapLaterWins(t *testing.T) {
+	got, err := ParseMap([]byte("A=1\nB=2\nA=3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"A": "3", "B": "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMap = %v, want %v", got, want)
+	}
+}
+
+func TestReplaceValues(t *testing.T) {
+	in := "# db\nDB_URL=old\nKEY=\"-----BEGIN-----\nold\n-----END-----\"\nPORT=3000\n"
+	got, err := ReplaceValues([]byte(in), map[string]string{"DB_URL": "new url", "KEY": "a\nb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# db\nDB_URL=\"new url\"\nKEY=\"a\\nb\"\nPORT=3000\n"
+	if string(got) != want {
+		t.Errorf("ReplaceValues =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	in := "# tag: web\nA=1\nB=\"two\nlines\"\n\n# tag: db\nC=3\r\n"
+
+	got, missing, err := SelectKeys([]byte(in), []string{"C", "B", "NOPE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "B=\"two\nlines\"\nC=3\n"; string(got) != want {
+		t.Errorf("SelectKeys = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(missing, []string{"NOPE"}) {
+		t.Errorf("SelectKeys missing = %v, want [NOPE]", missing)
+	}
+
+	got, err = SelectTags([]byte(in), []string{"web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "A=1\nB=\"two\nlines\"\n"; string(got) != want {
+		t.Errorf("SelectTags(web) = %q, want %q", got, want)
+	}
+
+	got, err = SelectTags([]byte(in), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != in {
+		t.Errorf("SelectTags with no tags changed the data: %q", got)
+	}
+}
@@ -0,0 +1,78 @@
+package dotenv
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NameProblem is a variable name that behaves differently between shells,
+// Docker and language runtimes
+type NameProblem struct {
+	Key  string
+	Line int
+	Err  error
+}
+
+func (p NameProblem) Error() string {
+	return fmt.Sprintf("line %d: %s: %v", p.Line, p.Key, p.Err)
+}
+
+// ValidName reports whether a name can be exported by every shell:
+// letters, digits and underscores, not starting with a digit
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// NormalizeName is the form names converge on once tools coerce them:
+// upper case (Windows environments ignore case) with every character
+// other than a letter, digit or underscore replaced by an underscore, as
+// config loaders do when they flatten keys like db.host or db-host to
+// DB_HOST
+func NormalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		if r == '_' || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// CheckNames reports names that are not valid shell identifiers, keys
+// assigned more than once (the later assignment silently wins) and keys
+// that are distinct here but collide once normalized, such as api_key and
+// API_KEY or db.host and DB_HOST
+func CheckNames(entries []Entry) []NameProblem {
+	var problems []NameProblem
+	first := map[string]Entry{}      // by exact key
+	normalized := map[string]Entry{} // by NormalizeName
+
+	for _, e := range entries {
+		if !ValidName(e.Key) {
+			problems = append(problems, NameProblem{Key: e.Key, Line: e.Line, Err: fmt.Errorf("not a valid shell variable name (use letters, digits and underscores, not starting with a digit)")})
+		}
+
+		if prev, ok := first[e.Key]; ok {
+			problems = append(problems, NameProblem{Key: e.Key, Line: e.Line, Err: fmt.Errorf("already assigned on line %d; only the last assignment is used", prev.Line)})
+			continue
+		}
+		first[e.Key] = e
+
+		norm := NormalizeName(e.Key)
+		if prev, ok := normalized[norm]; ok {
+			how := "differs from " + prev.Key + " only by case"
+			if !strings.EqualFold(prev.Key, e.Key) {
+				how = "collides with " + prev.Key + " as " + norm
+			}
+			problems = append(problems, NameProblem{Key: e.Key, Line: e.Line, Err: fmt.Errorf("%s (line %d)", how, prev.Line)})
+			continue
+		}
+		normalized[norm] = e
+	}
+	return problems
+}
@@ -0,0 +1,146 @@
+package env
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/fsutil"
+	"github.com/orchard9/envault/internal/state"
+)
+
+// filesDirName holds values rendered with as_file, under .envault
+const filesDirName = "files"
+
+// FilesDir returns the gitignored directory for an environment's as_file
+// values, .envault/files/<env>
+func FilesDir(envName string) (string, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return "", err
+	}
+	if err := state.EnsureIgnored(envaultDir, filesDirName+"/"); err != nil {
+		return "", fmt.Errorf("failed to update .envault/.gitignore: %w", err)
+	}
+	return filepath.Join(envaultDir, filesDirName, envName), nil
+}
+
+// WriteValueFiles writes each named variable to dir/<KEY> with mode 0600
+// and returns the paths written by key. Every name must be set. Multi-line
+// values such as PEM blocks get the trailing newline their tools expect.
+func WriteValueFiles(dir string, values map[string]string, names []string) (map[string]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	paths := map[string]string{}
+	for _, name := range names {
+		value, ok := values[name]
+		if !ok {
+			return nil, fmt.Errorf("as_file: %s is not set", name)
+		}
+		if !validFileName(name) {
+			return nil, fmt.Errorf("as_file: %q cannot be used as a file name", name)
+		}
+		if dotenv.IsMultiline(value) && !strings.HasSuffix(value, "\n") {
+			value += "\n"
+		}
+		path := filepath.Join(dir, name)
+		if err := fsutil.WriteFileAtomic(path, []byte(value), 0600); err != nil {
+			return nil, fmt.Errorf("as_file: failed to write %s: %w", name, err)
+		}
+		paths[name] = path
+	}
+	return paths, nil
+}
+
+// renderAsFile writes a target's as_file variables to their own files and
+// substitutes the paths into its plaintext. Returns the files written.
+func renderAsFile(envName string, names []string, plaintext []byte) ([]byte, []string, error) {
+	if len(names) == 0 {
+		return plaintext, nil, nil
+	}
+
+	values, err := dotenv.ParseMap(plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, err := FilesDir(envName)
+	if err != nil {
+		return nil, nil, err
+	}
+	paths, err := WriteValueFiles(dir, values, names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rendered, err := dotenv.ReplaceValues(plaintext, paths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var written []string
+	for _, path := range paths {
+		written = append(written, path)
+	}
+	sort.Strings(written)
+	return rendered, written, nil
+}
+
+// recordValueFiles records as_file files in the state, relative to the
+// current directory like targets, so status and clean cover them
+func recordValueFiles(st *state.State, target config.Target, envName, ciphertextHash string, paths []string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+	for _, absPath := range paths {
+		rel, err := filepath.Rel(cwd, absPath)
+		if err != nil {
+			rel = absPath
+		}
+		if err := st.Record(rel, absPath, envName, ciphertextHash); err != nil {
+			return err
+		}
+		st.Targets[rel].For = target.Path
+	}
+	return nil
+}
+
+// escapeMultiline rewrites multi-line values as single-line escaped
+// double-quoted values, for dotenv loaders that cannot read quoted
+// newlines (target option multiline: escape)
+func escapeMultiline(plaintext []byte) ([]byte, error) {
+	entries, err := dotenv.Parse(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, e := range entries {
+		if dotenv.IsMultiline(e.Value) {
+			values[e.Key] = e.Value
+		}
+	}
+	return dotenv.ReplaceValues(plaintext, values)
+}
+
+// asFileNames merges a target's as_file list with extra names, keeping
+// order and dropping duplicates
+func asFileNames(target config.Target, extra []string) []string {
+	var names []string
+	for _, name := range append(append([]string{}, target.AsFile...), extra...) {
+		names = appendUnique(names, name)
+	}
+	return names
+}
+
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name
+}
@@ -7,17 +7,39 @@ import (
 
 	"github.com/orchard9/envault/internal/config"
 	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/resolve"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/state"
 )
 
+// Options controls how Load treats existing target files
+type Options struct {
+	// Force overwrites targets with local modifications regardless of policy
+	Force bool
+
+	// Confirm asks whether to overwrite a locally modified target under the
+	// prompt policy. A nil Confirm refuses.
+	Confirm func(targetPath string) bool
+
+	// AsFile adds variables to every target's as_file list
+	AsFile []string
+}
+
 // Load decrypts and writes environment secrets to configured target files
 func Load(envName string) error {
+	return LoadWith(envName, Options{})
+}
+
+// LoadWith is Load with explicit options
+func LoadWith(envName string, opts Options) error {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		return err
 	}
 
-	environment, err := cfg.GetEnvironment(envName)
+	targets, err := cfg.ResolvedTargets(envName)
 	if err != nil {
 		return err
 	}
@@ -27,62 +49,227 @@ func Load(envName string) error {
 	if err != nil {
 		return fmt.Errorf("failed to decrypt %s: %w", envName, err)
 	}
+	defer secmem.Wipe(plaintext)
+
+	// Fetch values delegated to other secret managers
+	decrypted := plaintext
+	if plaintext, err = resolve.Plaintext(decrypted); err != nil {
+		return err
+	}
+	defer secmem.Wipe(plaintext)
 
-	// Write to each target
-	cwd, err := os.Getwd()
+	// Resolve every writer up front so an unknown type writes nothing
+	writers := make([]Writer, len(targets))
+	for i, target := range targets {
+		w, err := LookupWriter(target.Type)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", target, err)
+		}
+		writers[i] = w
+	}
+
+	ciphertextHash, err := crypto.CiphertextHash(envName)
 	if err != nil {
-		return fmt.Errorf("failed to get current directory: %w", err)
+		return err
 	}
 
-	for _, target := range environment.Targets {
-		targetPath := filepath.Join(cwd, target.Path)
+	st, err := state.Load()
+	if err != nil {
+		return err
+	}
 
-		// Create parent directory if it doesn't exist
-		dir := filepath.Dir(targetPath)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	// Check every target before writing any, so a refusal writes nothing
+	for _, target := range targets {
+		if err := checkOverwrite(st, target, opts); err != nil {
+			return err
 		}
+	}
 
-		// Write file atomically (write to temp file, then rename)
-		tempPath := targetPath + ".tmp"
-		if err := os.WriteFile(tempPath, plaintext, 0600); err != nil {
-			return fmt.Errorf("failed to write %s: %w", targetPath, err)
+	// Each target sees only the variables matching its tags, with as_file
+	// variables replaced by the path of their own file
+	plaintexts := make([][]byte, len(targets))
+	valueFiles := make([][]string, len(targets))
+	overrideHashes := make([]string, len(targets))
+	var scratch [][]byte // every plaintext copy, wiped once written
+	defer func() {
+		for _, p := range scratch {
+			secmem.Wipe(p)
+		}
+	}()
+	for i, target := range targets {
+		selected, err := dotenv.SelectTags(plaintext, target.Tags)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", target, err)
+		}
+		scratch = append(scratch, selected)
+		// Overrides are applied whole, before as_file, so they can replace
+		// any value the target receives
+		if selected, overrideHashes[i], err = applyOverrides(target, selected); err != nil {
+			return fmt.Errorf("target %s: %w", target, err)
 		}
+		scratch = append(scratch, selected)
+		plaintexts[i], valueFiles[i], err = renderAsFile(envName, asFileNames(target, opts.AsFile), selected)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", target, err)
+		}
+		scratch = append(scratch, plaintexts[i])
+	}
+
+	// Write every target, rolling all of them back if any fails
+	if err := renderAll(targets, writers, plaintexts); err != nil {
+		return err
+	}
 
-		if err := os.Rename(tempPath, targetPath); err != nil {
-			os.Remove(tempPath) // Clean up temp file on error
-			return fmt.Errorf("failed to rename %s: %w", targetPath, err)
+	for i, target := range targets {
+		if target.Path != "" {
+			absPath, err := resolvePath(target.Path)
+			if err != nil {
+				return err
+			}
+			if err := st.Record(target.Path, absPath, envName, ciphertextHash); err != nil {
+				return fmt.Errorf("target %s: failed to record state: %w", target, err)
+			}
+			if err := recordStrategy(st, target, plaintexts[i]); err != nil {
+				return fmt.Errorf("target %s: failed to record state: %w", target, err)
+			}
+			st.Targets[target.Path].OverridesHash = overrideHashes[i]
+			if target.Type != "file" {
+				st.Targets[target.Path].Type = target.Type
+			}
+		}
+		if err := recordValueFiles(st, target, envName, ciphertextHash, valueFiles[i]); err != nil {
+			return fmt.Errorf("target %s: failed to record state: %w", target, err)
 		}
 	}
 
-	return nil
+	return st.Save()
 }
 
-// Validate checks if all target paths are valid
-func Validate(envName string) error {
-	cfg, err := config.Load()
+// checkOverwrite enforces a target's overwrite policy when the file on
+// disk differs from what envault last rendered. Merge and append targets
+// keep local edits, so they are never refused.
+func checkOverwrite(st *state.State, target config.Target, opts Options) error {
+	if target.Path == "" || opts.Force || target.WriteStrategy() != config.StrategyReplace {
+		return nil
+	}
+
+	absPath, err := resolvePath(target.Path)
 	if err != nil {
 		return err
 	}
 
-	environment, err := cfg.GetEnvironment(envName)
+	modified, err := st.Modified(target.Path, absPath)
+	if err != nil {
+		return fmt.Errorf("target %s: %w", target, err)
+	}
+	if !modified {
+		return nil
+	}
+
+	switch target.OverwritePolicy() {
+	case config.OverwriteAlways:
+		return nil
+	case config.OverwritePrompt:
+		if opts.Confirm != nil && opts.Confirm(target.Path) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("target %s differs from what envault last wrote - refusing to overwrite (use --force, or set overwrite: always)", target.Path)
+}
+
+// WriteTarget decrypts an environment and renders it to a single target
+// that is not necessarily listed in config.yaml
+func WriteTarget(envName string, target config.Target) error {
+	w, err := LookupWriter(target.Type)
 	if err != nil {
 		return err
 	}
 
-	cwd, err := os.Getwd()
+	decrypted, err := crypto.Decrypt(envName)
 	if err != nil {
-		return fmt.Errorf("failed to get current directory: %w", err)
+		return fmt.Errorf("failed to decrypt %s: %w", envName, err)
+	}
+	defer secmem.Wipe(decrypted)
+
+	resolved, err := resolve.Plaintext(decrypted)
+	if err != nil {
+		return err
 	}
+	defer secmem.Wipe(resolved)
 
-	for _, target := range environment.Targets {
-		targetPath := filepath.Join(cwd, target.Path)
+	plaintext, err := dotenv.SelectTags(resolved, target.Tags)
+	if err != nil {
+		return err
+	}
+	defer secmem.Wipe(plaintext)
+	plaintext, valueFiles, err := renderAsFile(envName, target.AsFile, plaintext)
+	if err != nil {
+		return err
+	}
+	defer secmem.Wipe(plaintext)
+
+	if err := w.Write(target, plaintext); err != nil {
+		return err
+	}
+
+	// Record the render so status and clean know about it
+	ciphertextHash, err := crypto.CiphertextHash(envName)
+	if err != nil {
+		return err
+	}
+	st, err := state.Load()
+	if err != nil {
+		return err
+	}
+	if err := recordValueFiles(st, target, envName, ciphertextHash, valueFiles); err != nil {
+		return err
+	}
+	if target.Path != "" {
+		absPath, err := resolvePath(target.Path)
+		if err != nil {
+			return err
+		}
+		if err := st.Record(target.Path, absPath, envName, ciphertextHash); err != nil {
+			return err
+		}
+		if err := recordStrategy(st, target, plaintext); err != nil {
+			return err
+		}
+	}
+	return st.Save()
+}
+
+// Validate checks if all target paths are valid
+func Validate(envName string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	targets, err := cfg.ResolvedTargets(envName)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if _, err := LookupWriter(target.Type); err != nil {
+			return fmt.Errorf("target %s: %w", target, err)
+		}
+		if target.Path == "" {
+			continue
+		}
 
 		// Check if path is absolute (should be relative)
 		if filepath.IsAbs(target.Path) {
 			return fmt.Errorf("target path %s should be relative, not absolute", target.Path)
 		}
 
+		targetPath, err := resolvePath(target.Path)
+		if err != nil {
+			return err
+		}
+
 		// Check if parent directory exists or can be created
 		dir := filepath.Dir(targetPath)
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -100,15 +287,54 @@ func ListTargets(envName string) ([]string, error) {
 		return nil, err
 	}
 
-	environment, err := cfg.GetEnvironment(envName)
+	resolved, err := cfg.ResolvedTargets(envName)
 	if err != nil {
 		return nil, err
 	}
 
 	var targets []string
-	for _, target := range environment.Targets {
-		targets = append(targets, target.Path)
+	for _, target := range resolved {
+		targets = append(targets, target.String())
 	}
 
 	return targets, nil
 }
+
+// Values decrypts an environment and returns its variables
+func Values(envName string) (map[string]string, error) {
+	return TaggedValues(envName, nil)
+}
+
+// TaggedValues is Values limited to variables carrying any of the tags
+func TaggedValues(envName string, tags []string) (map[string]string, error) {
+	entries, err := Entries(envName, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(entries))
+	for _, e := range entries {
+		values[e.Key] = e.Value
+	}
+	return values, nil
+}
+
+// Entries decrypts an environment and returns its assignments in file
+// order, limited to variables carrying any of the tags (all if none)
+func Entries(envName string, tags []string) ([]dotenv.Entry, error) {
+	plaintext, err := crypto.Decrypt(envName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", envName, err)
+	}
+
+	entries, err := dotenv.Parse(plaintext)
+	secmem.Wipe(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", envName, err)
+	}
+	entries = dotenv.FilterTags(entries, tags)
+	if err := resolve.Entries(entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
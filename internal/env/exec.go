@@ -0,0 +1,104 @@
+package env
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Collision policies for secrets that shadow an existing variable
+const (
+	CollisionOverride = "override" // the secret wins (default)
+	CollisionSkip     = "skip"     // the existing variable wins
+	CollisionError    = "error"    // refuse to run
+)
+
+// ExecOptions controls the environment a command is started with
+type ExecOptions struct {
+	CleanEnv    bool     // start from an empty environment
+	Inherit     []string // variables kept from the parent with CleanEnv
+	OnCollision string   // CollisionOverride, CollisionSkip or CollisionError
+}
+
+// Environ merges secrets into the parent environment (KEY=value entries,
+// as from os.Environ) according to opts
+func Environ(parent []string, secrets map[string]string, opts ExecOptions) ([]string, error) {
+	policy := opts.OnCollision
+	if policy == "" {
+		policy = CollisionOverride
+	}
+	switch policy {
+	case CollisionOverride, CollisionSkip, CollisionError:
+	default:
+		return nil, fmt.Errorf("invalid collision policy %q (use override, skip, or error)", policy)
+	}
+	if len(opts.Inherit) > 0 && !opts.CleanEnv {
+		return nil, fmt.Errorf("--inherit only applies with --clean-env")
+	}
+
+	inherit := map[string]bool{}
+	for _, name := range opts.Inherit {
+		inherit[envKey(name)] = true
+	}
+
+	// Keyed by envKey; spelling keeps the name as first seen
+	base := map[string]string{}
+	spelling := map[string]string{}
+	var order []string
+	for _, kv := range parent {
+		name, value, ok := strings.Cut(kv, "=")
+		key := envKey(name)
+		if !ok || (opts.CleanEnv && !inherit[key]) {
+			continue
+		}
+		if _, seen := base[key]; !seen {
+			order = append(order, key)
+			spelling[key] = name
+		}
+		base[key] = value
+	}
+
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var collisions []string
+	for _, name := range names {
+		key := envKey(name)
+		if _, exists := base[key]; exists {
+			switch policy {
+			case CollisionSkip:
+				continue
+			case CollisionError:
+				collisions = append(collisions, name)
+				continue
+			}
+		} else {
+			order = append(order, key)
+			spelling[key] = name
+		}
+		base[key] = secrets[name]
+	}
+
+	if len(collisions) > 0 {
+		return nil, fmt.Errorf("secrets would shadow existing variables: %s (use --on-collision override or skip)", strings.Join(collisions, ", "))
+	}
+
+	environ := make([]string, 0, len(order))
+	for _, key := range order {
+		environ = append(environ, spelling[key]+"="+base[key])
+	}
+	return environ, nil
+}
+
+// envKey normalizes a variable name for comparison. Windows treats Path
+// and PATH as the same variable.
+func envKey(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(name)
+	}
+	return name
+}
@@ -0,0 +1,81 @@
+package env
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/orchard9/envault/internal/state"
+)
+
+// Ways a rendered target can be exposed
+const (
+	ExposedCommitted     = "committed"      // tracked by git
+	ExposedWorldReadable = "world-readable" // readable by every local user
+)
+
+// Exposure is a rendered target, of any type, that holds secrets where
+// others can read them
+type Exposure struct {
+	Path    string
+	Env     string
+	Type    string // writer type, empty for file targets
+	Problem string
+}
+
+// Exposures checks every target recorded in state.json for the given
+// environments (all when none are given) and reports those tracked by git
+// or readable by other users. These are the rendered files envault knows
+// about, such as a templated nginx.conf, not only .env files.
+func Exposures(envNames []string) ([]Exposure, error) {
+	st, err := state.Load()
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, envName := range envNames {
+		wanted[envName] = true
+	}
+
+	var exposures []Exposure
+	for path, record := range st.Targets {
+		if len(wanted) > 0 && !wanted[record.Env] {
+			continue
+		}
+		absPath, err := resolvePath(path)
+		if err != nil {
+			continue
+		}
+		info, err := os.Lstat(absPath)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		e := Exposure{Path: path, Env: record.Env, Type: record.Type}
+		if Tracked(absPath) {
+			e.Problem = ExposedCommitted
+			exposures = append(exposures, e)
+		}
+		// Windows reports no useful permission bits
+		if runtime.GOOS != "windows" && info.Mode().Perm()&0o004 != 0 {
+			e.Problem = ExposedWorldReadable
+			exposures = append(exposures, e)
+		}
+	}
+
+	sort.Slice(exposures, func(i, j int) bool {
+		if exposures[i].Path != exposures[j].Path {
+			return exposures[i].Path < exposures[j].Path
+		}
+		return exposures[i].Problem < exposures[j].Problem
+	})
+	return exposures, nil
+}
+
+// Tracked reports whether git tracks a file; outside a repository it is not
+func Tracked(absPath string) bool {
+	cmd := exec.Command("git", "-C", filepath.Dir(absPath), "ls-files", "--error-unmatch", "--", filepath.Base(absPath))
+	return cmd.Run() == nil
+}
@@ -0,0 +1,92 @@
+package env
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/state"
+)
+
+// readOverrides returns the content of a target's overrides file. A target
+// without one, or whose file does not exist yet, has no overrides.
+func readOverrides(target config.Target) ([]byte, error) {
+	if target.Overrides == "" {
+		return nil, nil
+	}
+	absPath, err := resolvePath(target.Overrides)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(absPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides %s: %w", target.Overrides, err)
+	}
+	return data, nil
+}
+
+// applyOverrides layers a target's overrides file over its plaintext: keys
+// it sets replace the secret's assignment in place, new keys are appended
+func applyOverrides(target config.Target, plaintext []byte) ([]byte, string, error) {
+	overrides, err := readOverrides(target)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(overrides) == 0 {
+		return plaintext, "", nil
+	}
+	defer secmem.Wipe(overrides)
+
+	merged, err := mergeDotenv(plaintext, overrides)
+	if err != nil {
+		return nil, "", fmt.Errorf("overrides %s: %w", target.Overrides, err)
+	}
+	return merged, state.Hash(overrides), nil
+}
+
+// overridesHash returns the hash of a target's overrides file as it is
+// now, empty when there is none
+func overridesHash(target config.Target) (string, error) {
+	overrides, err := readOverrides(target)
+	if err != nil || len(overrides) == 0 {
+		return "", err
+	}
+	defer secmem.Wipe(overrides)
+	return state.Hash(overrides), nil
+}
+
+// Overrides returns the values from the overrides files of an
+// environment's targets, for exec to layer over the secrets. When several
+// targets set a key, the last target wins, as it would on load.
+func Overrides(envName string) (map[string]string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	targets, err := cfg.ResolvedTargets(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for _, target := range targets {
+		data, err := readOverrides(target)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := dotenv.Parse(data)
+		secmem.Wipe(data)
+		if err != nil {
+			return nil, fmt.Errorf("overrides %s: %w", target.Overrides, err)
+		}
+		for _, e := range entries {
+			values[e.Key] = e.Value
+		}
+	}
+	return values, nil
+}
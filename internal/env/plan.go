@@ -0,0 +1,61 @@
+package env
+
+import (
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/state"
+)
+
+// StatusNew marks a target envault has not written before
+const StatusNew = "new"
+
+// PlannedTarget is a target LoadWith would write
+type PlannedTarget struct {
+	Target config.Target
+	Path   string   // where it would be written; empty without a path
+	Status string   // as reported by Status, or StatusNew
+	AsFile []string // variables that would get their own files
+	Err    error    // why the load would refuse or fail at this target
+}
+
+// Plan reports what LoadWith would write, for load --dry-run, without
+// decrypting or touching any file. Locally modified targets under the
+// prompt policy count as refused, since nothing is asked.
+func Plan(envName string, opts Options) ([]PlannedTarget, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	targets, err := cfg.ResolvedTargets(envName)
+	if err != nil {
+		return nil, err
+	}
+	st, err := state.Load()
+	if err != nil {
+		return nil, err
+	}
+	hash, _ := crypto.CiphertextHash(envName)
+
+	opts.Confirm = nil
+	var planned []PlannedTarget
+	for _, target := range targets {
+		p := PlannedTarget{Target: target, Status: StatusNew, AsFile: asFileNames(target, opts.AsFile)}
+		if _, err := LookupWriter(target.Type); err != nil {
+			p.Err = err
+		} else if err := checkOverwrite(st, target, opts); err != nil {
+			p.Err = err
+		}
+		if target.Path != "" {
+			if p.Path, err = outputPath(target); err != nil && p.Err == nil {
+				p.Err = err
+			}
+			if record := st.Targets[target.Path]; record != nil {
+				if p.Status, err = targetStatus(cfg, st, target.Path, record, hash); err != nil {
+					return nil, err
+				}
+			}
+		}
+		planned = append(planned, p)
+	}
+	return planned, nil
+}
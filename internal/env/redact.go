@@ -0,0 +1,131 @@
+package env
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+// RedactMinLength skips values too short to redact without mangling
+// ordinary output, such as "true" or "3000"
+const RedactMinLength = 8
+
+// Redactor replaces secret values in a command's output with
+// [REDACTED:KEY]. Multi-line values are also matched line by line, since
+// programs often print one line of a PEM block or a config file.
+type Redactor struct {
+	patterns map[byte][]redaction // by first byte, longest first
+	longest  int
+}
+
+type redaction struct {
+	value       []byte
+	replacement []byte
+}
+
+// NewRedactor builds a Redactor for the values in secrets, ignoring those
+// shorter than minLength. When several keys share a value, the first name
+// in sorted order labels it.
+func NewRedactor(secrets map[string]string, minLength int) *Redactor {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r := &Redactor{patterns: map[byte][]redaction{}}
+	seen := map[string]bool{}
+	add := func(name, value string) {
+		if len(strings.TrimSpace(value)) < minLength || seen[value] {
+			return
+		}
+		seen[value] = true
+		p := redaction{value: []byte(value), replacement: []byte("[REDACTED:" + name + "]")}
+		r.patterns[value[0]] = append(r.patterns[value[0]], p)
+		if len(value) > r.longest {
+			r.longest = len(value)
+		}
+	}
+	for _, name := range names {
+		add(name, secrets[name])
+	}
+	for _, name := range names {
+		if strings.Contains(secrets[name], "\n") {
+			for _, line := range strings.Split(secrets[name], "\n") {
+				add(name, strings.TrimRight(line, "\r"))
+			}
+		}
+	}
+	// The longest value wins where one contains another
+	for _, list := range r.patterns {
+		sort.SliceStable(list, func(i, j int) bool { return len(list[i].value) > len(list[j].value) })
+	}
+	return r
+}
+
+// Empty reports whether no value is long enough to redact
+func (r *Redactor) Empty() bool {
+	return r.longest == 0
+}
+
+// Writer returns a writer that redacts what is written before passing it
+// to w. A value split across writes is still caught: output that could be
+// the start of a value is held back until it is decided, and Close writes
+// whatever is still held.
+func (r *Redactor) Writer(w io.Writer) io.WriteCloser {
+	return &redactWriter{r: r, w: w}
+}
+
+type redactWriter struct {
+	r       *Redactor
+	w       io.Writer
+	pending []byte
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	rw.pending = append(rw.pending, p...)
+	out, rest := rw.r.redact(rw.pending, false)
+	rw.pending = append(rw.pending[:0], rest...)
+	if len(out) > 0 {
+		if _, err := rw.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (rw *redactWriter) Close() error {
+	out, _ := rw.r.redact(rw.pending, true)
+	rw.pending = nil
+	if len(out) == 0 {
+		return nil
+	}
+	_, err := rw.w.Write(out)
+	return err
+}
+
+// redact returns data with values replaced, up to where it can be decided.
+// Unless final, a tail that is the start of some value is returned as rest
+// for the next call.
+func (r *Redactor) redact(data []byte, final bool) (out, rest []byte) {
+	var buf bytes.Buffer
+	i := 0
+scan:
+	for i < len(data) {
+		tail := data[i:]
+		for _, p := range r.patterns[data[i]] {
+			if bytes.HasPrefix(tail, p.value) {
+				buf.Write(p.replacement)
+				i += len(p.value)
+				continue scan
+			}
+			if !final && len(tail) < len(p.value) && bytes.HasPrefix(p.value, tail) {
+				return buf.Bytes(), tail
+			}
+		}
+		buf.WriteByte(data[i])
+		i++
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,80 @@
+package env
+
+import (
+	"bytes"
+	"testing"
+)
+
+// redactChunks writes each chunk separately, as a child process's output
+// arrives, and returns what reached the underlying writer
+func redactChunks(t *testing.T, r *Redactor, chunks ...string) string {
+	t.Helper()
+	var out bytes.Buffer
+	w := r.Writer(&out)
+	for _, c := range chunks {
+		n, err := w.Write([]byte(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(c) {
+			t.Fatalf("Write(%q) = %d, want %d", c, n, len(c))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestRedactor(t *testing.T) {
+	secrets := map[string]string{
+		"API_TOKEN":   "tok_0123456789",
+		"API_TOKEN_2": "tok_0123456789abcdef",
+		"ALIAS":       "tok_0123456789",
+		"PORT":        "3000",
+		"DEBUG":       "true",
+		"PADDED":      "   x    ",
+		"TLS_KEY":     "-----BEGIN KEY-----\r\nMIIEvQIBADANBgkqhkiG9w0B\r\n-----END KEY-----",
+	}
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"no secret", []string{"listening on :3000\n"}, "listening on :3000\n"},
+		{"whole value", []string{"token=tok_0123456789\n"}, "token=[REDACTED:ALIAS]\n"},
+		{"split across writes", []string{"token=tok_01", "23456789\n"}, "token=[REDACTED:ALIAS]\n"},
+		{"split one byte at a time", []string{"t", "o", "k", "_", "0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "!"}, "[REDACTED:ALIAS]!"},
+		{"longest value wins", []string{"tok_0123456789abcdef"}, "[REDACTED:API_TOKEN_2]"},
+		{"longer value split after the shorter", []string{"tok_0123456789", "abcdef\n"}, "[REDACTED:API_TOKEN_2]\n"},
+		{"shorter value once the longer fails", []string{"tok_0123456789", "abc!"}, "[REDACTED:ALIAS]abc!"},
+		{"held prefix that is not a value", []string{"tok_01", "x"}, "tok_01x"},
+		{"held prefix flushed on Close", []string{"end: tok_0123"}, "end: tok_0123"},
+		{"value at the end flushed on Close", []string{"tok_0123456789"}, "[REDACTED:ALIAS]"},
+		{"short values left alone", []string{"DEBUG=true PORT=3000"}, "DEBUG=true PORT=3000"},
+		{"padding does not count", []string{"   x    "}, "   x    "},
+		{"whole multi-line value", []string{"-----BEGIN KEY-----\r\nMIIEvQIBADANBgkqhkiG9w0B\r\n-----END KEY-----"}, "[REDACTED:TLS_KEY]"},
+		{"one line of a multi-line value", []string{"key line: MIIEvQIBADANBgkqhkiG9w0B\n"}, "key line: [REDACTED:TLS_KEY]\n"},
+		{"multi-line value split across writes", []string{"-----BEGIN KEY-----\r\nMIIEvQ", "IBADANBgkqhkiG9w0B\r\n-----END KEY-----\n"}, "[REDACTED:TLS_KEY]\n"},
+		{"adjacent values", []string{"tok_0123456789tok_0123456789"}, "[REDACTED:ALIAS][REDACTED:ALIAS]"},
+	}
+	r := NewRedactor(secrets, RedactMinLength)
+	for _, tt := range tests {
+		if got := redactChunks(t, r, tt.chunks...); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRedactorEmpty(t *testing.T) {
+	r := NewRedactor(map[string]string{"PORT": "3000", "DEBUG": "true"}, RedactMinLength)
+	if !r.Empty() {
+		t.Error("Empty() = false for values all shorter than the minimum")
+	}
+	if got := redactChunks(t, r, "PORT=3000"); got != "PORT=3000" {
+		t.Errorf("got %q, want output unchanged", got)
+	}
+	if NewRedactor(map[string]string{"API_TOKEN": "tok_0123456789"}, RedactMinLength).Empty() {
+		t.Error("Empty() = true with a value to redact")
+	}
+}
@@ -0,0 +1,115 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/fsutil"
+)
+
+// maxParallelWrites bounds how many targets are written at once
+const maxParallelWrites = 8
+
+// snapshot is a target file's content before a render, used to roll back
+type snapshot struct {
+	path    string
+	existed bool
+	data    []byte
+	mode    os.FileMode
+}
+
+// takeSnapshot records the current content of a target file
+func takeSnapshot(path string) (*snapshot, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return &snapshot{path: path}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{path: path, existed: true, data: data, mode: info.Mode().Perm()}, nil
+}
+
+// restore puts the file back as it was, removing it if it did not exist
+func (s *snapshot) restore() error {
+	if !s.existed {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return fsutil.WriteFileAtomic(s.path, s.data, s.mode)
+}
+
+// renderAll writes every target concurrently as a single transaction: if
+// any write fails, targets already written are restored to their previous
+// content. Writers without a path cannot be rolled back.
+func renderAll(targets []config.Target, writers []Writer, plaintexts [][]byte) error {
+	snapshots := make([]*snapshot, len(targets))
+	seen := map[string]string{}
+	for i, target := range targets {
+		if target.Path == "" {
+			continue
+		}
+		absPath, err := outputPath(target)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", target, err)
+		}
+		if other, dup := seen[absPath]; dup {
+			return fmt.Errorf("targets %s and %s write the same file", other, target)
+		}
+		seen[absPath] = target.String()
+
+		snap, err := takeSnapshot(absPath)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", target, err)
+		}
+		snapshots[i] = snap
+	}
+
+	errs := make([]error, len(targets))
+	sem := make(chan struct{}, maxParallelWrites)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target config.Target) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := writers[i].Write(target, plaintexts[i]); err != nil {
+				errs[i] = fmt.Errorf("target %s: %w", target, err)
+			}
+		}(i, target)
+	}
+	wg.Wait()
+
+	failed := errors.Join(errs...)
+	if failed == nil {
+		return nil
+	}
+
+	// Roll back every target, including the failed ones, which may have
+	// been left half-written by a writer that does not write atomically
+	var rollback []error
+	for i, snap := range snapshots {
+		if snap == nil {
+			continue
+		}
+		if err := snap.restore(); err != nil {
+			rollback = append(rollback, fmt.Errorf("failed to restore %s: %w", targets[i].Path, err))
+		}
+	}
+	if len(rollback) > 0 {
+		return errors.Join(append([]error{failed}, rollback...)...)
+	}
+	return fmt.Errorf("%w (all targets restored to their previous content)", failed)
+}
@@ -0,0 +1,212 @@
+package env
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/fsutil"
+	"github.com/orchard9/envault/internal/state"
+)
+
+// Target states reported by Status
+const (
+	StatusCurrent  = "current"  // matches the current ciphertext
+	StatusStale    = "stale"    // ciphertext changed since it was rendered
+	StatusModified = "modified" // edited since envault wrote it
+	StatusMissing  = "missing"  // deleted since envault wrote it
+	StatusOrphaned = "orphaned" // no longer a target in config.yaml
+)
+
+// TargetStatus describes one rendered target recorded in state.json
+type TargetStatus struct {
+	Path       string
+	Env        string
+	Status     string
+	RenderedAt time.Time
+}
+
+// Status reports the state of every rendered target, sorted by path
+func Status() ([]TargetStatus, error) {
+	st, err := state.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[string]string{}
+	var statuses []TargetStatus
+	for path, record := range st.Targets {
+		ts := TargetStatus{Path: path, Env: record.Env, RenderedAt: record.RenderedAt}
+
+		hash, ok := hashes[record.Env]
+		if !ok {
+			hash, _ = crypto.CiphertextHash(record.Env)
+			hashes[record.Env] = hash
+		}
+
+		ts.Status, err = targetStatus(cfg, st, path, record, hash)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, ts)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Path < statuses[j].Path })
+	return statuses, nil
+}
+
+func targetStatus(cfg *config.Config, st *state.State, path string, record *state.Target, currentHash string) (string, error) {
+	absPath, err := resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(absPath); os.IsNotExist(err) {
+		return StatusMissing, nil
+	}
+
+	// Local edits are expected around a merge or append render
+	if record.Strategy == "" {
+		modified, err := st.Modified(path, absPath)
+		if err != nil {
+			return "", err
+		}
+		if modified {
+			return StatusModified, nil
+		}
+	}
+
+	configured := path
+	if record.For != "" {
+		configured = record.For
+	}
+	target, ok := configuredTarget(cfg, record.Env, configured)
+	if !ok {
+		return StatusOrphaned, nil
+	}
+
+	if currentHash == "" || currentHash != record.CiphertextHash {
+		return StatusStale, nil
+	}
+
+	// An edited overrides file also needs a fresh load
+	if record.For == "" {
+		overrides, err := overridesHash(target)
+		if err != nil {
+			return "", err
+		}
+		if overrides != record.OverridesHash {
+			return StatusStale, nil
+		}
+	}
+	return StatusCurrent, nil
+}
+
+// configuredTarget returns the environment's resolved target at path
+func configuredTarget(cfg *config.Config, envName, path string) (config.Target, bool) {
+	targets, err := cfg.ResolvedTargets(envName)
+	if err != nil {
+		return config.Target{}, false
+	}
+	for _, t := range targets {
+		if t.Path == path {
+			return t, true
+		}
+	}
+	return config.Target{}, false
+}
+
+// Unload deletes the rendered targets recorded for an environment ("" for
+// every environment). Modified files are kept unless force is set.
+// Returns the removed paths and the paths skipped because of local edits.
+func Unload(envName string, force bool) (removed, skipped []string, err error) {
+	st, err := state.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths := make([]string, 0, len(st.Targets))
+	for path := range st.Targets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		record := st.Targets[path]
+		if envName != "" && record.Env != envName {
+			continue
+		}
+
+		absPath, err := resolvePath(path)
+		if err != nil {
+			return removed, skipped, err
+		}
+
+		// A target written through a symlink loses the file; the link stays
+		// so the next render lands in the same place
+		if absPath, err = fsutil.FollowSymlinks(absPath); err != nil {
+			return removed, skipped, err
+		}
+
+		// Merge and append targets lose only what envault wrote
+		if record.Strategy != "" {
+			if err := unrender(record, absPath); err != nil {
+				return removed, skipped, fmt.Errorf("failed to unload %s: %w", path, err)
+			}
+			st.Forget(path)
+			removed = append(removed, path)
+			continue
+		}
+
+		modified, err := st.Modified(path, absPath)
+		if err != nil {
+			return removed, skipped, err
+		}
+		if modified && !force {
+			skipped = append(skipped, path)
+			continue
+		}
+
+		if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
+			return removed, skipped, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		st.Forget(path)
+		removed = append(removed, path)
+	}
+
+	return removed, skipped, st.Save()
+}
+
+// unrender strips envault's content from a merge or append target,
+// deleting the file when nothing local remains
+func unrender(record *state.Target, absPath string) error {
+	info, err := os.Stat(absPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+
+	rest, err := stripRendered(record, data)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(rest)) == "" {
+		return os.Remove(absPath)
+	}
+	return fsutil.WriteFileAtomic(absPath, rest, info.Mode().Perm())
+}
@@ -0,0 +1,234 @@
+package env
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/state"
+)
+
+// Markers around the section an append target owns
+const (
+	sectionBegin = "# >>> envault >>>"
+	sectionEnd   = "# <<< envault <<<"
+)
+
+// applyStrategy combines plaintext with the current content of a file
+// target according to its write strategy
+func applyStrategy(strategy, targetPath string, plaintext []byte) ([]byte, error) {
+	if strategy == config.StrategyReplace {
+		return plaintext, nil
+	}
+
+	existing, err := os.ReadFile(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	switch strategy {
+	case config.StrategyMerge:
+		return mergeDotenv(existing, plaintext)
+	case config.StrategyAppend:
+		return appendSection(existing, plaintext), nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", strategy)
+	}
+}
+
+// mergeDotenv replaces the assignments in existing whose keys appear in
+// secrets, keeping every other line, and appends secrets that are new
+func mergeDotenv(existing, secrets []byte) ([]byte, error) {
+	existingEntries, err := dotenv.Parse(existing)
+	if err != nil {
+		return nil, fmt.Errorf("cannot merge into existing file: %w", err)
+	}
+	secretEntries, err := dotenv.Parse(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each secret's original lines, in first-seen order; later ones win
+	secretLines := splitLines(secrets)
+	blocks := map[string][]string{}
+	var order []string
+	for _, e := range secretEntries {
+		if _, ok := blocks[e.Key]; !ok {
+			order = append(order, e.Key)
+		}
+		blocks[e.Key] = secretLines[e.Line-1 : e.EndLine]
+	}
+
+	starts := map[int]dotenv.Entry{}
+	for _, e := range existingEntries {
+		starts[e.Line] = e
+	}
+
+	var out []string
+	written := map[string]bool{}
+	lines := splitLines(existing)
+	for i := 0; i < len(lines); i++ {
+		e, ok := starts[i+1]
+		if !ok {
+			out = append(out, lines[i])
+			continue
+		}
+		block, secret := blocks[e.Key]
+		if !secret {
+			out = append(out, lines[i:e.EndLine]...)
+		} else if !written[e.Key] {
+			out = append(out, block...)
+			written[e.Key] = true
+		}
+		i = e.EndLine - 1
+	}
+
+	for _, key := range order {
+		if !written[key] {
+			out = append(out, blocks[key]...)
+		}
+	}
+
+	return joinLines(out), nil
+}
+
+// appendSection puts secrets between the envault markers, replacing any
+// previous section or adding one at the end
+func appendSection(existing, secrets []byte) []byte {
+	var section []string
+	section = append(section, sectionBegin)
+	section = append(section, splitLines(secrets)...)
+	section = append(section, sectionEnd)
+
+	lines := splitLines(existing)
+	begin, end := findSection(lines)
+	if begin < 0 {
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		return joinLines(append(lines, section...))
+	}
+
+	out := append([]string{}, lines[:begin]...)
+	out = append(out, section...)
+	out = append(out, lines[end+1:]...)
+	return joinLines(out)
+}
+
+// stripRendered removes what a merge or append render added to a file,
+// returning the local content that remains
+func stripRendered(record *state.Target, data []byte) ([]byte, error) {
+	lines := splitLines(data)
+
+	switch record.Strategy {
+	case config.StrategyAppend:
+		begin, end := findSection(lines)
+		if begin < 0 {
+			return data, nil
+		}
+		out := append([]string{}, lines[:begin]...)
+		// Drop the blank line appendSection put before the section
+		if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+			out = out[:len(out)-1]
+		}
+		return joinLines(append(out, lines[end+1:]...)), nil
+	case config.StrategyMerge:
+		entries, err := dotenv.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		keys := map[string]bool{}
+		for _, key := range record.Keys {
+			keys[key] = true
+		}
+		drop := map[int]bool{}
+		for _, e := range entries {
+			if keys[e.Key] {
+				for line := e.Line; line <= e.EndLine; line++ {
+					drop[line] = true
+				}
+			}
+		}
+		var out []string
+		for i, line := range lines {
+			if !drop[i+1] {
+				out = append(out, line)
+			}
+		}
+		return joinLines(out), nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", record.Strategy)
+	}
+}
+
+// recordStrategy notes in the state how a target was written, so unload
+// can remove only what envault added
+func recordStrategy(st *state.State, target config.Target, plaintext []byte) error {
+	strategy := target.WriteStrategy()
+	if strategy == config.StrategyReplace {
+		return nil
+	}
+
+	record := st.Targets[target.Path]
+	record.Strategy = strategy
+	if strategy == config.StrategyMerge {
+		entries, err := dotenv.Parse(plaintext)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			record.Keys = appendUnique(record.Keys, e.Key)
+		}
+	}
+	return nil
+}
+
+// findSection returns the line indexes of the envault markers, or -1
+func findSection(lines []string) (int, int) {
+	begin := -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case sectionBegin:
+			if begin < 0 {
+				begin = i
+			}
+		case sectionEnd:
+			if begin >= 0 {
+				return begin, i
+			}
+		}
+	}
+	return -1, -1
+}
+
+// splitLines splits data into lines without their terminators
+func splitLines(data []byte) []string {
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// joinLines joins lines with a trailing newline
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
@@ -0,0 +1,52 @@
+package env
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/secmem"
+)
+
+// writeTemplate renders options.template, a text/template file such as
+// nginx.conf.tmpl, with the decrypted variables as its data, e.g.
+// {{ .DATABASE_URL }}. A variable the template uses but the environment
+// lacks is an error rather than an empty string.
+func writeTemplate(target config.Target, plaintext []byte) error {
+	source := target.Options["template"]
+	if source == "" {
+		return fmt.Errorf("options.template is required for template targets")
+	}
+	sourcePath, err := resolvePath(source)
+	if err != nil {
+		return err
+	}
+	text, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read template: %w", err)
+	}
+	tmpl, err := template.New(source).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid template %s: %w", source, err)
+	}
+
+	values, err := dotenv.ParseMap(plaintext)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, values)
+	defer secmem.Wipe(out.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to render %s: %w", source, err)
+	}
+
+	targetPath, err := outputPath(target)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(targetPath, out.Bytes())
+}
@@ -0,0 +1,211 @@
+package env
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/fsutil"
+	"github.com/orchard9/envault/internal/wsl"
+)
+
+// Writer renders decrypted secrets to a target. Implementations are
+// selected per target by the `type:` field in config.yaml. Write may be
+// called concurrently for different targets of the same environment.
+type Writer interface {
+	Write(target config.Target, plaintext []byte) error
+}
+
+// WriterFunc adapts a function to the Writer interface
+type WriterFunc func(target config.Target, plaintext []byte) error
+
+// Write calls f(target, plaintext)
+func (f WriterFunc) Write(target config.Target, plaintext []byte) error {
+	return f(target, plaintext)
+}
+
+var (
+	writersMu sync.RWMutex
+	writers   = map[string]Writer{}
+)
+
+func init() {
+	RegisterWriter("file", WriterFunc(writeFile))
+	RegisterWriter("json", WriterFunc(writeJSON))
+	RegisterWriter("docker-env", WriterFunc(writeDockerEnv))
+	RegisterWriter("template", WriterFunc(writeTemplate))
+}
+
+// RegisterWriter makes a target writer available under the given type name.
+// Registering the same name twice replaces the previous writer.
+func RegisterWriter(name string, w Writer) {
+	writersMu.Lock()
+	defer writersMu.Unlock()
+	writers[name] = w
+}
+
+// LookupWriter returns the writer for a target type ("" means "file")
+func LookupWriter(name string) (Writer, error) {
+	if name == "" {
+		name = "file"
+	}
+
+	writersMu.RLock()
+	defer writersMu.RUnlock()
+
+	w, ok := writers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown target type %q (available: %s)", name, writerNames())
+	}
+	return w, nil
+}
+
+// writerNames lists registered writer types; callers must hold writersMu
+func writerNames() string {
+	names := make([]string, 0, len(writers))
+	for name := range writers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprint(names)
+}
+
+// writeFile writes the decrypted plaintext verbatim to the target path,
+// or into the existing file under the merge and append strategies. With
+// options.multiline set to escape, multi-line values are written on one
+// line with \n escapes.
+func writeFile(target config.Target, plaintext []byte) error {
+	targetPath, err := outputPath(target)
+	if err != nil {
+		return err
+	}
+	switch target.Options["multiline"] {
+	case "", "keep":
+	case "escape":
+		if plaintext, err = escapeMultiline(plaintext); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown multiline option %q (use keep or escape)", target.Options["multiline"])
+	}
+	data, err := applyStrategy(target.WriteStrategy(), targetPath, plaintext)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(targetPath, data)
+}
+
+// writeJSON writes the decrypted variables as a JSON object
+func writeJSON(target config.Target, plaintext []byte) error {
+	values, err := dotenv.ParseMap(plaintext)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+
+	targetPath, err := outputPath(target)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(targetPath, append(data, '\n'))
+}
+
+// writeDockerEnv writes KEY=value lines in the unquoted format read by
+// `docker run --env-file` and devcontainers
+func writeDockerEnv(target config.Target, plaintext []byte) error {
+	data, err := FormatDockerEnv(plaintext)
+	if err != nil {
+		return err
+	}
+
+	targetPath, err := outputPath(target)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(targetPath, data)
+}
+
+// FormatDockerEnv renders dotenv plaintext as a Docker env file. Docker
+// does not unquote values and cannot represent newlines, so multi-line
+// values are rejected.
+func FormatDockerEnv(plaintext []byte) ([]byte, error) {
+	entries, err := dotenv.Parse(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return FormatDockerEntries(entries)
+}
+
+// FormatDockerEntries is FormatDockerEnv for already parsed entries
+func FormatDockerEntries(entries []dotenv.Entry) ([]byte, error) {
+	var b strings.Builder
+	for _, e := range entries {
+		if strings.ContainsAny(e.Value, "\r\n") {
+			return nil, fmt.Errorf("%s contains a newline, which Docker env files cannot represent (list it under as_file to pass its path instead)", e.Key)
+		}
+		fmt.Fprintf(&b, "%s=%s\n", e.Key, e.Value)
+	}
+	return []byte(b.String()), nil
+}
+
+// resolvePath resolves a target path relative to the current directory
+func resolvePath(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("target path is required")
+	}
+
+	// Targets written as C:\... point at the Windows host when under WSL
+	if wsl.IsWindowsPath(path) {
+		if !wsl.Detected() {
+			return "", fmt.Errorf("target path %s is a Windows path", path)
+		}
+		linuxPath, _ := wsl.ToLinuxPath(path)
+		return linuxPath, nil
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+	return filepath.Join(cwd, path), nil
+}
+
+// outputPath resolves where a target is written, applying its symlink
+// policy when the path is a symbolic link
+func outputPath(target config.Target) (string, error) {
+	path, err := resolvePath(target.Path)
+	if err != nil || !fsutil.IsSymlink(path) {
+		return path, err
+	}
+
+	switch target.SymlinkPolicy() {
+	case config.SymlinkReplace:
+		return path, nil
+	case config.SymlinkRefuse:
+		return "", fmt.Errorf("%s is a symlink (set symlink: follow or symlink: replace to write it)", target.Path)
+	case config.SymlinkFollow:
+		return fsutil.FollowSymlinks(path)
+	}
+	return "", fmt.Errorf("unknown symlink policy %q (use follow, replace, or refuse)", target.Symlink)
+}
+
+// writeAtomic writes data to path via a temp file and rename
+func writeAtomic(targetPath string, data []byte) error {
+	// Create parent directory if it doesn't exist
+	dir := filepath.Dir(targetPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	return fsutil.WriteFileAtomic(targetPath, data, 0600)
+}
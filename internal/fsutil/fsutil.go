@@ -0,0 +1,149 @@
+package fsutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// maxSymlinks bounds FollowSymlinks, as the kernel bounds path lookups
+const maxSymlinks = 40
+
+// WriteAtomic writes a file by streaming into a temp file in the same
+// directory, syncing it, and renaming it over path. If write fails, the
+// existing file at path is left untouched.
+//
+// A file that is itself a mount point, such as a Docker bind mount of a
+// single file, cannot be renamed over. Then the temp file is copied into
+// path and synced instead, which is not atomic: a crash during the copy
+// can leave path truncated.
+func WriteAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file in %s: %w", dir, err)
+	}
+	tmpPath := tmp.Name()
+
+	// Remove the temp file on any failure below
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		if !errors.Is(err, syscall.EXDEV) && !errors.Is(err, syscall.EBUSY) {
+			return fmt.Errorf("failed to rename %s: %w", path, err)
+		}
+		if err := copyInto(tmpPath, path, perm); err != nil {
+			return err
+		}
+		os.Remove(tmpPath)
+	}
+	committed = true
+
+	syncDir(dir)
+	return nil
+}
+
+// WriteFileAtomic is WriteAtomic for data already in memory
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return WriteAtomic(path, perm, func(w io.Writer) error {
+		if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// copyInto overwrites path in place with the content of src and syncs it
+func copyInto(src, path string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := out.Chmod(perm); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to set permissions on %s: %w", path, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return out.Close()
+}
+
+// FollowSymlinks returns the file path finally points to. Unlike
+// filepath.EvalSymlinks it also resolves a dangling link, so that a
+// render can create the missing destination.
+func FollowSymlinks(path string) (string, error) {
+	for i := 0; i < maxSymlinks; i++ {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+
+		dest, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(dest) {
+			dest = filepath.Join(filepath.Dir(path), dest)
+		}
+		path = dest
+	}
+	return "", fmt.Errorf("%s: too many levels of symbolic links", path)
+}
+
+// IsSymlink reports whether path is a symbolic link
+func IsSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// syncDir flushes a directory entry so a completed rename survives a crash.
+// Best effort: not every platform supports syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package fsutil
+
+import (
+	"os"
+	"syscall"
+)
+
+// OwnedByOther reports whether a file belongs to someone other than the
+// current user or root, who could change its permissions at will
+func OwnedByOther(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return int(st.Uid) != os.Getuid() && st.Uid != 0
+}
@@ -0,0 +1,320 @@
+package ide
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/manifest"
+	"github.com/orchard9/envault/internal/resolve"
+	"github.com/orchard9/envault/internal/schema"
+)
+
+// Diagnostic severities, as in the Language Server Protocol
+const (
+	SeverityError   = 1
+	SeverityWarning = 2
+)
+
+// Position is a zero-based line and UTF-16 character offset
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is a span of a document
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Diagnostic is a problem found in a buffer
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Code     string `json:"code"` // parse, name, schema, required or config
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+// Variable is a variable name known for an environment
+type Variable struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Sources     []string `json:"sources"` // schema and/or manifest
+}
+
+// Environment is an environment and its targets
+type Environment struct {
+	Name    string   `json:"name"`
+	Targets []string `json:"targets"`
+}
+
+// Key is an authorized key
+type Key struct {
+	Fingerprint string `json:"fingerprint"`
+	Type        string `json:"type"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+var (
+	lineNumber = regexp.MustCompile(`^line (\d+): `)
+	assignment = regexp.MustCompile(`^(\s*(?:export\s+)?)([^=\s#][^=\s]*)\s*=`)
+)
+
+// Diagnose checks a dotenv buffer as envault encrypt would: syntax,
+// portable names and the schema rules of envName (the shared rules when
+// empty). Messages name variables, never values.
+func Diagnose(text, envName string) []Diagnostic {
+	lines := strings.Split(text, "\n")
+	diagnostics := []Diagnostic{}
+
+	entries, err := dotenv.Parse([]byte(text))
+	if err != nil {
+		line := 0
+		message := err.Error()
+		if m := lineNumber.FindStringSubmatch(message); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			line--
+			message = strings.TrimPrefix(message, m[0])
+		}
+		return append(diagnostics, lineDiagnostic(lines, line, SeverityError, "parse", message))
+	}
+
+	for _, p := range dotenv.CheckNames(entries) {
+		diagnostics = append(diagnostics, keyDiagnostic(lines, p.Line-1, p.Key, SeverityWarning, "name", fmt.Sprintf("%s: %v", p.Key, p.Err)))
+	}
+
+	sch, err := schema.Load()
+	if err != nil {
+		return append(diagnostics, lineDiagnostic(lines, 0, SeverityWarning, "config", err.Error()))
+	}
+	values := map[string]string{}
+	lastLine := map[string]int{}
+	for _, e := range entries {
+		// References are exempt from the schema, as in envault check
+		if resolve.IsReference(e.Value) {
+			delete(values, e.Key)
+			continue
+		}
+		values[e.Key] = e.Value
+		lastLine[e.Key] = e.Line - 1
+	}
+	for _, v := range sch.Validate(envName, values) {
+		line, set := lastLine[v.Name]
+		if !set {
+			diagnostics = append(diagnostics, lineDiagnostic(lines, 0, SeverityError, "required", v.Error()))
+			continue
+		}
+		diagnostics = append(diagnostics, keyDiagnostic(lines, line, v.Name, SeverityError, "schema", v.Error()))
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Range.Start.Line < diagnostics[j].Range.Start.Line
+	})
+	return diagnostics
+}
+
+// Hover documents the variable assigned on a line from its schema
+// rules, or returns "" when the line assigns none or it has no rules
+func Hover(text string, line int, envName string) (string, Range) {
+	lines := strings.Split(text, "\n")
+	if line < 0 || line >= len(lines) {
+		return "", Range{}
+	}
+	m := assignment.FindStringSubmatch(lines[line])
+	if m == nil {
+		return "", Range{}
+	}
+	name := m[2]
+
+	sch, err := schema.Load()
+	if err != nil {
+		return "", Range{}
+	}
+	rule, ok := sch.ForEnvironment(envName)[name]
+	var consistency []string
+	for _, c := range sch.Consistency {
+		if c.Variable == name {
+			consistency = append(consistency, c.String())
+		}
+	}
+	if !ok && len(consistency) == 0 {
+		return "", Range{}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s**", name)
+	if envName != "" {
+		fmt.Fprintf(&b, " (%s)", envName)
+	}
+	b.WriteString("\n")
+	if rule.Description != "" {
+		fmt.Fprintf(&b, "\n%s\n", rule.Description)
+	}
+	var facts []string
+	if rule.Type != "" {
+		facts = append(facts, fmt.Sprintf("type: `%s`", rule.Type))
+	}
+	if rule.Pattern != "" {
+		facts = append(facts, fmt.Sprintf("pattern: `%s`", rule.Pattern))
+	}
+	if rule.Required {
+		facts = append(facts, "required")
+	}
+	if rule.RotateEvery != "" {
+		facts = append(facts, "rotate every "+rule.RotateEvery)
+	}
+	for _, c := range consistency {
+		facts = append(facts, strings.TrimPrefix(c, name+" "))
+	}
+	if len(facts) > 0 {
+		b.WriteString("\n")
+		for _, fact := range facts {
+			fmt.Fprintf(&b, "- %s\n", fact)
+		}
+	}
+
+	start := utf16Len(m[1])
+	return b.String(), Range{
+		Start: Position{Line: line, Character: start},
+		End:   Position{Line: line, Character: start + utf16Len(name)},
+	}
+}
+
+// Variables lists the names known for an environment: its schema rules
+// and the variables manifest.json tracks for it. Nothing is decrypted.
+func Variables(envName string) ([]Variable, error) {
+	sch, err := schema.Load()
+	if err != nil {
+		return nil, err
+	}
+	m, err := manifest.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*Variable{}
+	for name, rule := range sch.ForEnvironment(envName) {
+		byName[name] = &Variable{Name: name, Description: rule.Description, Type: rule.Type, Required: rule.Required, Sources: []string{"schema"}}
+	}
+	if env := m.Environments[envName]; env != nil {
+		for name := range env.Variables {
+			if v := byName[name]; v != nil {
+				v.Sources = append(v.Sources, "manifest")
+			} else {
+				byName[name] = &Variable{Name: name, Sources: []string{"manifest"}}
+			}
+		}
+	}
+
+	variables := make([]Variable, 0, len(byName))
+	for _, v := range byName {
+		variables = append(variables, *v)
+	}
+	sort.Slice(variables, func(i, j int) bool { return variables[i].Name < variables[j].Name })
+	return variables, nil
+}
+
+// Environments lists the environments in config.yaml with their targets
+func Environments() ([]Environment, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	environments := []Environment{}
+	for name := range cfg.Environments {
+		env := Environment{Name: name, Targets: []string{}}
+		targets, _ := cfg.ResolvedTargets(name)
+		for _, target := range targets {
+			if target.Path != "" {
+				env.Targets = append(env.Targets, target.Path)
+			}
+		}
+		environments = append(environments, env)
+	}
+	sort.Slice(environments, func(i, j int) bool { return environments[i].Name < environments[j].Name })
+	return environments, nil
+}
+
+// Keys lists authorized_keys. Only public keys are read.
+func Keys() ([]Key, error) {
+	authorized, err := keys.Load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Key, 0, len(authorized))
+	for _, k := range authorized {
+		list = append(list, Key{Fingerprint: k.Fingerprint, Type: k.Type, Comment: k.Comment})
+	}
+	return list, nil
+}
+
+// EnvironmentFor returns the environment a file belongs to: the one that
+// renders to it, or <env> for .envault/<env>.plaintext. It is empty for
+// any other file.
+func EnvironmentFor(path string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	if envaultDir, err := config.EnvaultDir(); err == nil && filepath.Dir(path) == envaultDir {
+		if name, ok := strings.CutSuffix(filepath.Base(path), ".plaintext"); ok {
+			return name
+		}
+	}
+	environments, err := Environments()
+	if err != nil {
+		return ""
+	}
+	for _, env := range environments {
+		for _, target := range env.Targets {
+			if filepath.Join(cwd, target) == path {
+				return env.Name
+			}
+		}
+	}
+	return ""
+}
+
+func lineDiagnostic(lines []string, line int, severity int, code, message string) Diagnostic {
+	if line < 0 || line >= len(lines) {
+		line = 0
+	}
+	end := 0
+	if line < len(lines) {
+		end = utf16Len(strings.TrimRight(lines[line], "\r"))
+	}
+	return Diagnostic{
+		Range:    Range{Start: Position{Line: line}, End: Position{Line: line, Character: end}},
+		Severity: severity, Code: code, Source: "envault", Message: message,
+	}
+}
+
+// keyDiagnostic marks the variable name of an assignment, or the whole
+// line when the name cannot be found on it
+func keyDiagnostic(lines []string, line int, key string, severity int, code, message string) Diagnostic {
+	d := lineDiagnostic(lines, line, severity, code, message)
+	if line < 0 || line >= len(lines) {
+		return d
+	}
+	if i := strings.Index(lines[line], key); i >= 0 {
+		start := utf16Len(lines[line][:i])
+		d.Range = Range{Start: Position{Line: line, Character: start}, End: Position{Line: line, Character: start + utf16Len(key)}}
+	}
+	return d
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
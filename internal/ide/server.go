@@ -0,0 +1,371 @@
+// Package ide serves envault's view of a project to editors: the
+// environments, their variable names and authorized keys, diagnostics for
+// dotenv buffers checked against the schema, and hover documentation from
+// schema descriptions. It speaks JSON-RPC 2.0 with the Content-Length
+// framing of the Language Server Protocol, implementing enough of LSP
+// (open/change/close, publishDiagnostics, hover, completion) for generic
+// clients plus envault/* requests for an extension.
+//
+// Nothing is decrypted: variable names come from schema.yaml and
+// manifest.json, and diagnostics only look at the editor's own buffer.
+package ide
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// maxMessage bounds a single message, far above any dotenv buffer
+const maxMessage = 16 << 20
+
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// exitError ends Serve after an exit notification
+type exitError struct{ shutdown bool }
+
+func (exitError) Error() string { return "exit" }
+
+type textDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type documentParams struct {
+	TextDocument   textDocument `json:"textDocument"`
+	Position       Position     `json:"position"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type envaultParams struct {
+	Environment string `json:"environment"`
+	URI         string `json:"uri"`
+	Text        string `json:"text"`
+}
+
+// Server is one editor connection
+type Server struct {
+	version     string
+	out         *bufio.Writer
+	docs        map[string]string // open buffers by URI
+	environment string            // for buffers no target matches
+	shutdown    bool
+}
+
+// Serve handles requests from r until the client sends exit or closes
+// the stream. environment applies to buffers that no target matches,
+// unless the client names another in initializationOptions.
+func Serve(r io.Reader, w io.Writer, version, environment string) error {
+	s := &Server{version: version, out: bufio.NewWriter(w), docs: map[string]string{}, environment: environment}
+	in := bufio.NewReader(r)
+	for {
+		body, err := readMessage(in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			s.reply(nil, nil, &rpcError{Code: codeParseError, Message: err.Error()})
+			continue
+		}
+		if msg.Method == "" {
+			// A response to something we never send
+			continue
+		}
+
+		result, err := s.handle(msg.Method, msg.Params)
+		var exit exitError
+		if errors.As(err, &exit) {
+			if !exit.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		if msg.ID == nil {
+			continue
+		}
+		var rpcErr *rpcError
+		if err != nil && !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: codeInternalError, Message: err.Error()}
+		}
+		s.reply(msg.ID, result, rpcErr)
+	}
+}
+
+func (s *Server) handle(method string, raw json.RawMessage) (any, error) {
+	switch method {
+	case "initialize":
+		var params struct {
+			RootURI               string `json:"rootUri"`
+			InitializationOptions struct {
+				Environment string `json:"environment"`
+			} `json:"initializationOptions"`
+		}
+		if err := decode(raw, &params); err != nil {
+			return nil, err
+		}
+		// Project paths in config.yaml are relative to the workspace
+		if dir := uriPath(params.RootURI); dir != "" {
+			if err := os.Chdir(dir); err != nil {
+				return nil, fmt.Errorf("failed to enter %s: %w", dir, err)
+			}
+		}
+		if params.InitializationOptions.Environment != "" {
+			s.environment = params.InitializationOptions.Environment
+		}
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   1, // full buffers
+				"hoverProvider":      true,
+				"completionProvider": map[string]any{},
+			},
+			"serverInfo": map[string]string{"name": "envault", "version": s.version},
+		}, nil
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "exit":
+		return nil, exitError{shutdown: s.shutdown}
+
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didClose":
+		var params documentParams
+		if err := decode(raw, &params); err != nil {
+			return nil, err
+		}
+		uri := params.TextDocument.URI
+		switch method {
+		case "textDocument/didOpen":
+			s.docs[uri] = params.TextDocument.Text
+		case "textDocument/didChange":
+			if n := len(params.ContentChanges); n > 0 {
+				s.docs[uri] = params.ContentChanges[n-1].Text
+			}
+		default:
+			delete(s.docs, uri)
+			s.publish(uri, []Diagnostic{})
+			return nil, nil
+		}
+		s.publish(uri, Diagnose(s.docs[uri], s.environmentFor(uri, "")))
+		return nil, nil
+	case "textDocument/hover":
+		var params documentParams
+		if err := decode(raw, &params); err != nil {
+			return nil, err
+		}
+		text, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			return nil, nil
+		}
+		contents, span := Hover(text, params.Position.Line, s.environmentFor(params.TextDocument.URI, ""))
+		if contents == "" {
+			return nil, nil
+		}
+		return map[string]any{"contents": map[string]string{"kind": "markdown", "value": contents}, "range": span}, nil
+	case "textDocument/completion":
+		var params documentParams
+		if err := decode(raw, &params); err != nil {
+			return nil, err
+		}
+		variables, err := Variables(s.environmentFor(params.TextDocument.URI, ""))
+		if err != nil {
+			return nil, err
+		}
+		items := make([]map[string]any, 0, len(variables))
+		for _, v := range variables {
+			item := map[string]any{"label": v.Name, "kind": 6, "insertText": v.Name + "="} // 6: Variable
+			if v.Type != "" {
+				item["detail"] = v.Type
+			}
+			if v.Description != "" {
+				item["documentation"] = v.Description
+			}
+			items = append(items, item)
+		}
+		return items, nil
+
+	case "envault/environments":
+		return Environments()
+	case "envault/keys":
+		return Keys()
+	case "envault/variables":
+		var params envaultParams
+		if err := decode(raw, &params); err != nil {
+			return nil, err
+		}
+		return Variables(s.environmentFor(params.URI, params.Environment))
+	case "envault/validate":
+		var params envaultParams
+		if err := decode(raw, &params); err != nil {
+			return nil, err
+		}
+		text := params.Text
+		if text == "" {
+			text = s.docs[params.URI]
+		}
+		return Diagnose(text, s.environmentFor(params.URI, params.Environment)), nil
+	case "envault/hover":
+		var params struct {
+			envaultParams
+			Line int `json:"line"`
+		}
+		if err := decode(raw, &params); err != nil {
+			return nil, err
+		}
+		text := params.Text
+		if text == "" {
+			text = s.docs[params.URI]
+		}
+		contents, span := Hover(text, params.Line, s.environmentFor(params.URI, params.Environment))
+		if contents == "" {
+			return nil, nil
+		}
+		return map[string]any{"contents": contents, "range": span}, nil
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "unknown method " + method}
+}
+
+// environmentFor picks the environment whose rules apply to a buffer: the
+// one asked for, the one rendering to the file, or the default from
+// initializationOptions
+func (s *Server) environmentFor(uri, explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	if path := uriPath(uri); path != "" {
+		if name := EnvironmentFor(path); name != "" {
+			return name
+		}
+	}
+	return s.environment
+}
+
+func (s *Server) publish(uri string, diagnostics []Diagnostic) {
+	s.send(message{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: mustMarshal(map[string]any{
+		"uri": uri, "diagnostics": diagnostics,
+	})})
+}
+
+func (s *Server) reply(id json.RawMessage, result any, err *rpcError) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	msg := message{JSONRPC: "2.0", ID: id, Error: err}
+	if err == nil {
+		// A null result must still be sent
+		if result == nil {
+			result = json.RawMessage("null")
+		}
+		msg.Result = result
+	}
+	s.send(msg)
+}
+
+func (s *Server) send(msg message) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		body, _ = json.Marshal(message{JSONRPC: "2.0", ID: msg.ID, Error: &rpcError{Code: codeInternalError, Message: err.Error()}})
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(body))
+	s.out.Write(body)
+	s.out.Flush()
+}
+
+// readMessage reads one Content-Length framed message
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length < 0 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("failed to read message header: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || n < 0 || n > maxMessage {
+				return nil, fmt.Errorf("invalid Content-Length %q", strings.TrimSpace(value))
+			}
+			length = n
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	return body, nil
+}
+
+func decode(raw json.RawMessage, v any) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func mustMarshal(v any) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+// uriPath returns the local path of a file:// URI, or "" for anything else
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	path := u.Path
+	// file:///C:/dir on Windows
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.Clean(filepath.FromSlash(path))
+}
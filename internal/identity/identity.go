@@ -0,0 +1,279 @@
+// Package identity keeps the user's private key in the user config dir,
+// encrypted under a passphrase, for users who would rather not leave a raw
+// key on disk. The key is unlocked once per login session (see Unlock) or
+// with a passphrase cached in the OS keychain.
+package identity
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseEnv unlocks the stored identity without a prompt
+const PassphraseEnv = "ENVAULT_IDENTITY_PASSPHRASE"
+
+// FileName is the sealed identity inside the user config dir's envault
+// directory
+const FileName = "identity.sealed.json"
+
+// scrypt work factors: 128 MiB and a few hundred milliseconds per unlock
+const (
+	scryptN = 1 << 17
+	scryptR = 8
+	scryptP = 1
+)
+
+// Bounds on the work factors read from a sealed file, so a tampered one
+// cannot exhaust memory or stall an unlock. They allow twice the memory
+// and four times the work of what Seal writes.
+const (
+	maxScryptMemory = 256 << 20 // 128*N*r bytes
+	maxScryptWork   = 1 << 22   // N*r*p
+	maxIterations   = 5000000
+)
+
+// KDFs of sealed files. Seal always uses scrypt; PBKDF2 is read for CI
+// identities sealed before the formats were merged.
+const (
+	kdfScrypt = "scrypt"
+	kdfPBKDF2 = "pbkdf2-sha256"
+)
+
+// ErrWrongPassphrase is returned by Open when the passphrase does not
+// decrypt the key; a corrupt file cannot be told apart
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// ErrNotStored is returned when no identity has been imported
+var ErrNotStored = errors.New("no protected identity (import one with: envault identity import --protect <key>)")
+
+// Sealed is a private key encrypted under a passphrase: AES-256-GCM with
+// the public key as additional data, under a scrypt-derived key. It is the
+// on-disk form of both the stored identity and the committed CI identity.
+type Sealed struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n,omitempty"`
+	R          int    `json:"r,omitempty"`
+	P          int    `json:"p,omitempty"`
+	Iterations int    `json:"iterations,omitempty"` // pbkdf2-sha256 only
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+	PublicKey  string `json:"public_key"`         // authorized_keys line, for reference
+	Source     string `json:"source,omitempty"`   // file the key was imported from
+	Keychain   bool   `json:"keychain,omitempty"` // the passphrase is cached in the keychain
+
+	name string // file name for messages
+}
+
+// Path returns where the sealed identity is stored
+func Path() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config directory: %w", err)
+	}
+	return filepath.Join(configDir, "envault", FileName), nil
+}
+
+// Load reads the sealed identity, returning ErrNotStored if there is none
+func Load() (*Sealed, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotStored
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return Parse(data, FileName)
+}
+
+// Parse reads a sealed key, checking its format and that its work factors
+// are within bounds. name is the file it came from, for messages.
+func Parse(data []byte, name string) (*Sealed, error) {
+	var sealed Sealed
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	sealed.name = name
+	if sealed.Version != 1 || (sealed.KDF != kdfScrypt && sealed.KDF != kdfPBKDF2) {
+		return nil, fmt.Errorf("unsupported %s (version %d, kdf %q)", name, sealed.Version, sealed.KDF)
+	}
+	if err := sealed.checkWork(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &sealed, nil
+}
+
+// checkWork bounds the KDF parameters
+func (s *Sealed) checkWork() error {
+	if s.KDF == kdfPBKDF2 {
+		if s.Iterations < 1 || s.Iterations > maxIterations {
+			return fmt.Errorf("iteration count %d is outside 1..%d", s.Iterations, maxIterations)
+		}
+		return nil
+	}
+	n, r, p := int64(s.N), int64(s.R), int64(s.P)
+	if n < 2 || n&(n-1) != 0 || r < 1 || p < 1 {
+		return fmt.Errorf("invalid scrypt parameters n=%d r=%d p=%d", s.N, s.R, s.P)
+	}
+	if n > maxScryptMemory/128/r || n*r > maxScryptWork/p {
+		return fmt.Errorf("scrypt parameters n=%d r=%d p=%d exceed the allowed work", s.N, s.R, s.P)
+	}
+	return nil
+}
+
+// deriveKey runs the sealed file's KDF over the passphrase, within the
+// bounds checkWork allows
+func (s *Sealed) deriveKey(passphrase, salt []byte, keyLen int) ([]byte, error) {
+	if err := s.checkWork(); err != nil {
+		return nil, err
+	}
+	if s.KDF == kdfPBKDF2 {
+		return pbkdf2.Key(passphrase, salt, s.Iterations, keyLen, sha256.New), nil
+	}
+	return scrypt.Key(passphrase, salt, s.N, s.R, s.P, keyLen)
+}
+
+// Marshal encodes the sealed key as written to disk
+func (s *Sealed) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode identity: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// Save writes the sealed identity, readable only by the user
+func (s *Sealed) Save() error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	data, err := s.Marshal()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Remove deletes the sealed identity
+func Remove() error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotStored
+		}
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
+
+// Seal encrypts a private key with a passphrase using AES-256-GCM under a
+// scrypt-derived key
+func Seal(private []byte, publicKey, source, passphrase string) (*Sealed, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	sealed := &Sealed{
+		Version:   1,
+		KDF:       kdfScrypt,
+		N:         scryptN,
+		R:         scryptR,
+		P:         scryptP,
+		Salt:      base64.StdEncoding.EncodeToString(salt),
+		PublicKey: publicKey,
+		Source:    source,
+		name:      FileName,
+	}
+	aead, err := sealed.newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	sealed.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, private, []byte(publicKey)))
+	return sealed, nil
+}
+
+// Open decrypts the sealed key. A wrong passphrase gives an error wrapping
+// ErrWrongPassphrase.
+func (s *Sealed) Open(passphrase string) ([]byte, error) {
+	name := s.name
+	if name == "" {
+		name = FileName
+	}
+	if err := s.checkWork(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	salt, errSalt := base64.StdEncoding.DecodeString(s.Salt)
+	nonce, errNonce := base64.StdEncoding.DecodeString(s.Nonce)
+	ciphertext, errCiphertext := base64.StdEncoding.DecodeString(s.Ciphertext)
+	if errSalt != nil || errNonce != nil || errCiphertext != nil {
+		return nil, fmt.Errorf("%s is corrupt", name)
+	}
+
+	aead, err := s.newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%s is corrupt", name)
+	}
+	private, err := aead.Open(nil, nonce, ciphertext, []byte(s.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w or corrupt %s", ErrWrongPassphrase, name)
+	}
+	return private, nil
+}
+
+// newAEAD derives the key with the sealed file's KDF
+func (s *Sealed) newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase is empty")
+	}
+	key, err := s.deriveKey([]byte(passphrase), salt, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,81 @@
+package identity
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Known answers from RFC 7914 section 11 (PBKDF2-HMAC-SHA256 with 64-byte
+// output) and the widely used RFC 6070-style SHA-256 vectors, so a change
+// of KDF library or of how sealed files name their parameters cannot go
+// unnoticed
+func TestDeriveKeyPBKDF2(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iter, keyLen   int
+		want           string
+	}{
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, 64, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 40, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+	}
+	for _, tt := range tests {
+		s := &Sealed{KDF: kdfPBKDF2, Iterations: tt.iter}
+		key, err := s.deriveKey([]byte(tt.password), []byte(tt.salt), tt.keyLen)
+		if err != nil {
+			t.Errorf("PBKDF2(%q, %q, %d, %d): %v", tt.password, tt.salt, tt.iter, tt.keyLen, err)
+			continue
+		}
+		if got := hex.EncodeToString(key); got != tt.want {
+			t.Errorf("PBKDF2(%q, %q, %d, %d) = %s, want %s", tt.password, tt.salt, tt.iter, tt.keyLen, got, tt.want)
+		}
+	}
+}
+
+// Known answers from RFC 7914 section 12, except the last vector, which
+// needs 1 GiB
+func TestDeriveKeyScrypt(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		N, r, p        int
+		want           string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+		{"pleaseletmein", "SodiumChloride", 16384, 8, 1, "7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2d5432955613f0fcf62d49705242a9af9e61e85dc0d651e40dfcf017b45575887"},
+	}
+	for _, tt := range tests {
+		s := &Sealed{KDF: kdfScrypt, N: tt.N, R: tt.r, P: tt.p}
+		key, err := s.deriveKey([]byte(tt.password), []byte(tt.salt), 64)
+		if err != nil {
+			t.Errorf("Scrypt(%q, %q, %d, %d, %d): %v", tt.password, tt.salt, tt.N, tt.r, tt.p, err)
+			continue
+		}
+		if got := hex.EncodeToString(key); got != tt.want {
+			t.Errorf("Scrypt(%q, %q, %d, %d, %d) = %s, want %s", tt.password, tt.salt, tt.N, tt.r, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestDeriveKeyRejectsBadParameters(t *testing.T) {
+	tests := []struct {
+		name    string
+		N, r, p int
+	}{
+		{"N not a power of two", 1000, 8, 1},
+		{"N of 1", 1, 8, 1},
+		{"r of 0", 16, 0, 1},
+		{"p of 0", 16, 8, 0},
+		{"r*p too large", 16, 1 << 15, 1 << 15},
+		{"N too large for r", 1 << 30, 8, 1},
+	}
+	for _, tt := range tests {
+		s := &Sealed{KDF: kdfScrypt, N: tt.N, R: tt.r, P: tt.p}
+		if _, err := s.deriveKey([]byte("pw"), []byte("salt"), 32); err == nil {
+			t.Errorf("%s: Scrypt(N=%d, r=%d, p=%d) succeeded, want an error", tt.name, tt.N, tt.r, tt.p)
+		}
+	}
+}
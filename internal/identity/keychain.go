@@ -0,0 +1,86 @@
+package identity
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// The passphrase is cached under this service and account: the macOS
+// keychain through security(1), the Secret Service (GNOME Keyring,
+// KWallet) through secret-tool(1) elsewhere
+const (
+	keychainService = "envault"
+	keychainAccount = "identity"
+)
+
+// KeychainStore caches the passphrase in the OS keychain. It never appears
+// on a command line.
+func KeychainStore(passphrase string) error {
+	if runtime.GOOS == "darwin" {
+		// security -i reads the command from stdin, keeping the passphrase
+		// out of the process list
+		command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", keychainService, keychainAccount, securityQuote(passphrase))
+		_, err := keychainRun(command, "security", "-i")
+		return err
+	}
+	_, err := keychainRun(passphrase, "secret-tool", "store", "--label=envault identity passphrase",
+		"service", keychainService, "account", keychainAccount)
+	return err
+}
+
+// KeychainLookup returns the cached passphrase
+func KeychainLookup() (string, error) {
+	var out string
+	var err error
+	if runtime.GOOS == "darwin" {
+		out, err = keychainRun("", "security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	} else {
+		out, err = keychainRun("", "secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+	}
+	if err != nil {
+		return "", err
+	}
+	passphrase := strings.TrimRight(out, "\r\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("no passphrase in the keychain")
+	}
+	return passphrase, nil
+}
+
+// KeychainDelete removes the cached passphrase
+func KeychainDelete() error {
+	if runtime.GOOS == "darwin" {
+		_, err := keychainRun("", "security", "delete-generic-password", "-s", keychainService, "-a", keychainAccount)
+		return err
+	}
+	_, err := keychainRun("", "secret-tool", "clear", "service", keychainService, "account", keychainAccount)
+	return err
+}
+
+func keychainRun(stdin string, name string, args ...string) (string, error) {
+	if runtime.GOOS == "windows" {
+		return "", fmt.Errorf("keychain caching is not supported on windows")
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return "", fmt.Errorf("keychain unavailable: %s not found", name)
+	}
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s failed: %w\nStderr: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// securityQuote quotes an argument for security -i, which splits its
+// input like a shell
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
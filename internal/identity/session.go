@@ -0,0 +1,121 @@
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// DefaultTTL is how long an unlock lasts unless told otherwise
+const DefaultTTL = 8 * time.Hour
+
+// unlocked records an unlock next to the key it wrote
+type unlocked struct {
+	PublicKey string    `json:"public_key"`
+	Expires   time.Time `json:"expires"`
+}
+
+// sessionDir returns the private directory holding the unlocked key:
+// $XDG_RUNTIME_DIR/envault, which the login session removes at logout, or
+// a per-user directory on tmpfs
+func sessionDir() (string, error) {
+	dir := ""
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		dir = filepath.Join(runtimeDir, "envault")
+	} else {
+		base := os.TempDir()
+		if runtime.GOOS == "linux" {
+			if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+				base = "/dev/shm"
+			}
+		}
+		dir = filepath.Join(base, fmt.Sprintf("envault-%d", os.Getuid()))
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	// A shared temp dir lets anyone create the directory first
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to check %s: %w", dir, err)
+	}
+	if !info.IsDir() || (runtime.GOOS != "windows" && info.Mode().Perm() != 0700) {
+		return "", fmt.Errorf("%s is not a private directory (mode %s); remove it and unlock again", dir, info.Mode())
+	}
+	return dir, nil
+}
+
+// Unlock writes the private key to the session directory until ttl passes
+// or Lock is called, and returns when it expires
+func Unlock(private []byte, publicKey string, ttl time.Duration) (time.Time, error) {
+	dir, err := sessionDir()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	record := unlocked{PublicKey: publicKey, Expires: time.Now().Add(ttl).Truncate(time.Second)}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	keyPath := filepath.Join(dir, "identity")
+	if err := os.WriteFile(keyPath, private, 0600); err != nil {
+		os.Remove(keyPath)
+		return time.Time{}, fmt.Errorf("failed to write %s: %w", keyPath, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "session.json"), data, 0600); err != nil {
+		os.Remove(keyPath)
+		return time.Time{}, fmt.Errorf("failed to write session: %w", err)
+	}
+	return record.Expires, nil
+}
+
+// Unlocked returns the unlocked key file and when it expires, if the
+// stored identity with publicKey is unlocked. An expired unlock, or one
+// of a key since replaced, is removed.
+func Unlocked(publicKey string) (string, time.Time, bool) {
+	dir, err := sessionDir()
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "session.json"))
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	var record unlocked
+	if err := json.Unmarshal(data, &record); err != nil || record.PublicKey != publicKey || !time.Now().Before(record.Expires) {
+		Lock()
+		return "", time.Time{}, false
+	}
+
+	keyPath := filepath.Join(dir, "identity")
+	if _, err := os.Stat(keyPath); err != nil {
+		return "", time.Time{}, false
+	}
+	return keyPath, record.Expires, true
+}
+
+// Lock removes the unlocked key, reporting whether there was one
+func Lock() (bool, error) {
+	dir, err := sessionDir()
+	if err != nil {
+		return false, err
+	}
+	keyPath := filepath.Join(dir, "identity")
+	err = os.Remove(keyPath)
+	os.Remove(filepath.Join(dir, "session.json"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to remove %s: %w", keyPath, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,145 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// BundleVersion is the recipient bundle format written by this build
+const BundleVersion = 1
+
+// Bundle is a signed copy of a recipient set, published by one repository
+// (or an org-wide keys repository) for others to adopt
+type Bundle struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Keys      string    `json:"keys"`      // authorized_keys in canonical form
+	Signer    string    `json:"signer"`    // public key that signed the bundle
+	Signature string    `json:"signature"` // SSH signature over Message
+
+	// Revocations travel with the bundle so importers drop and revoke
+	// those keys even with --merge. Each is signed on its own.
+	Revocations []*Revocation `json:"revocations,omitempty"`
+}
+
+// NewBundle captures the current authorized_keys, unsigned
+func NewBundle(name string) (*Bundle, error) {
+	keysPath, err := AuthorizedKeysPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := readAuthorizedKeys(keysPath)
+	if err != nil {
+		return nil, err
+	}
+	formatted, err := Format(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse authorized_keys: %w", err)
+	}
+	return &Bundle{
+		Version:   BundleVersion,
+		Name:      name,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Keys:      string(formatted),
+	}, nil
+}
+
+// ParseBundle reads a bundle. Its signature is not checked here.
+func ParseBundle(data []byte) (*Bundle, error) {
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	if b.Version != BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	if b.Signer == "" || b.Signature == "" {
+		return nil, fmt.Errorf("bundle is not signed")
+	}
+	return &b, nil
+}
+
+// Message returns the data the bundle's signature covers
+func (b *Bundle) Message() []byte {
+	sum := sha256.Sum256([]byte(b.Keys))
+	return []byte(fmt.Sprintf("envault-bundle:v1\nname=%s\ncreated=%s\nsha256=%s\n",
+		b.Name, b.CreatedAt.UTC().Format(time.RFC3339), hex.EncodeToString(sum[:])))
+}
+
+// Recipients parses the bundle's keys
+func (b *Bundle) Recipients() ([]Key, error) {
+	entries, _, err := parseEntries([]byte(b.Keys))
+	if err != nil {
+		return nil, fmt.Errorf("bundle keys: %w", err)
+	}
+	recipients := make([]Key, len(entries))
+	for i, e := range entries {
+		recipients[i] = e.key
+	}
+	return recipients, nil
+}
+
+// Adopt applies the bundle to authorized_keys. With replace, the bundle
+// becomes the recipient set and keys missing from it are dropped;
+// otherwise its keys are only added. Revoked keys are never adopted.
+func (b *Bundle) Adopt(replace bool) (added, removed, skipped []Key, err error) {
+	return AdoptKeys(b.Keys, replace)
+}
+
+// AdoptKeys applies authorized_keys text from elsewhere (a bundle, or the
+// recipients of envault apply) as Bundle.Adopt does
+func AdoptKeys(text string, replace bool) (added, removed, skipped []Key, err error) {
+	bundleEntries, _, err := parseEntries([]byte(text))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("keys to adopt: %w", err)
+	}
+	revoked, err := LoadRevoked()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	keysPath, err := AuthorizedKeysPath()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	data, err := readAuthorizedKeys(keysPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	entries, trailer, err := parseEntries(data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	inBundle := dataSet(bundleEntries)
+	inCurrent := dataSet(entries)
+
+	var kept []entry
+	for _, e := range entries {
+		if replace && !inBundle[e.key.Data] {
+			removed = append(removed, e.key)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	for _, e := range bundleEntries {
+		if inCurrent[e.key.Data] {
+			continue
+		}
+		if len(FindRevoked([]Key{e.key}, revoked)) > 0 {
+			skipped = append(skipped, e.key)
+			continue
+		}
+		kept = append(kept, e)
+		added = append(added, e.key)
+	}
+
+	if len(added)+len(removed) == 0 {
+		return nil, nil, skipped, nil
+	}
+	return added, removed, skipped, writeAuthorizedKeys(keysPath, kept, trailer)
+}
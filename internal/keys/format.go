@@ -0,0 +1,159 @@
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// entry is a key plus the comment lines directly above it, which move
+// with the key when the file is sorted
+type entry struct {
+	comments []string
+	key      Key
+}
+
+// parseEntries reads authorized_keys data, merging duplicate keys
+func parseEntries(data []byte) ([]entry, []string, error) {
+	var entries []entry
+	var pending []string
+	index := map[string]int{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			pending = append(pending, line)
+			continue
+		}
+
+		key, err := ParseKey(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		if i, dup := index[key.Data]; dup {
+			entries[i].comments = append(entries[i].comments, pending...)
+			pending = nil
+			continue
+		}
+
+		index[key.Data] = len(entries)
+		entries = append(entries, entry{comments: pending, key: *key})
+		pending = nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read authorized_keys: %w", err)
+	}
+
+	// Comments after the last key are kept at the end of the file
+	return entries, pending, nil
+}
+
+// render writes entries in canonical order: sorted by comment (usually
+// the owner's email), then fingerprint, one normalized line per key
+func render(entries []entry, trailer []string) []byte {
+	sorted := append([]entry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].key, sorted[j].key
+		if ca, cb := strings.ToLower(a.Comment), strings.ToLower(b.Comment); ca != cb {
+			return ca < cb
+		}
+		return a.Fingerprint < b.Fingerprint
+	})
+
+	var buf bytes.Buffer
+	for _, e := range sorted {
+		for _, c := range e.comments {
+			buf.WriteString(c + "\n")
+		}
+		buf.WriteString(e.key.Line() + "\n")
+	}
+	for _, c := range trailer {
+		buf.WriteString(c + "\n")
+	}
+	return buf.Bytes()
+}
+
+// Format normalizes authorized_keys data: one key per line, whitespace
+// collapsed, duplicates removed and entries sorted so that concurrent
+// additions land on different lines
+func Format(data []byte) ([]byte, error) {
+	entries, trailer, err := parseEntries(data)
+	if err != nil {
+		return nil, err
+	}
+	return render(entries, trailer), nil
+}
+
+// Merge performs a three-way merge of authorized_keys files. Keys added on
+// either side are kept; keys removed on either side stay removed, so a
+// merge never resurrects a key someone deliberately dropped.
+func Merge(base, ours, theirs []byte) ([]byte, error) {
+	baseEntries, _, err := parseEntries(base)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+	ourEntries, ourTrailer, err := parseEntries(ours)
+	if err != nil {
+		return nil, fmt.Errorf("ours: %w", err)
+	}
+	theirEntries, _, err := parseEntries(theirs)
+	if err != nil {
+		return nil, fmt.Errorf("theirs: %w", err)
+	}
+
+	inBase := dataSet(baseEntries)
+	inOurs := dataSet(ourEntries)
+	inTheirs := dataSet(theirEntries)
+
+	var merged []entry
+	for _, e := range ourEntries {
+		if inTheirs[e.key.Data] || !inBase[e.key.Data] {
+			merged = append(merged, e)
+		}
+	}
+	for _, e := range theirEntries {
+		if !inOurs[e.key.Data] && !inBase[e.key.Data] {
+			merged = append(merged, e)
+		}
+	}
+
+	return render(merged, ourTrailer), nil
+}
+
+func dataSet(entries []entry) map[string]bool {
+	set := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		set[e.key.Data] = true
+	}
+	return set
+}
+
+// readAuthorizedKeys returns the raw authorized_keys contents (empty if
+// the file does not exist)
+func readAuthorizedKeys(keysPath string) ([]byte, error) {
+	data, err := os.ReadFile(keysPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read authorized_keys: %w", err)
+	}
+	return data, nil
+}
+
+// writeAuthorizedKeys replaces authorized_keys with entries in canonical form
+func writeAuthorizedKeys(keysPath string, entries []entry, trailer []string) error {
+	if err := os.WriteFile(keysPath, render(entries, trailer), 0644); err != nil {
+		return fmt.Errorf("failed to write authorized_keys: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package keys
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"whitespace collapsed", "ssh-ed25519   AAAAB   bob@example.com  \n", "ssh-ed25519 AAAAB bob@example.com\n"},
+		{"sorted by comment", "ssh-ed25519 BBBB Carol\nssh-ed25519 AAAA alice\n", "ssh-ed25519 AAAA alice\nssh-ed25519 BBBB Carol\n"},
+		{"comments move with their key", "# carol's laptop\nssh-ed25519 CCCC carol\n# alice\nssh-ed25519 AAAA alice\n", "# alice\nssh-ed25519 AAAA alice\n# carol's laptop\nssh-ed25519 CCCC carol\n"},
+		{"duplicates merged", "ssh-ed25519 AAAA alice\n# again\nssh-ed25519 AAAA alice@work\n", "# again\nssh-ed25519 AAAA alice\n"},
+		{"blank lines dropped", "\n\nssh-ed25519 AAAA alice\n\n", "ssh-ed25519 AAAA alice\n"},
+		{"trailing comments kept at the end", "# end\nssh-ed25519 BBBB bob\n# trailer\n", "# end\nssh-ed25519 BBBB bob\n# trailer\n"},
+		{"age recipient", "age1qqqq  ci runner\n", "age1qqqq ci runner\n"},
+		{"no trailing newline", "ssh-ed25519 AAAA alice", "ssh-ed25519 AAAA alice\n"},
+	}
+	for _, tt := range tests {
+		got, err := Format([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: Format failed: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: Format(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+		again, _ := Format(got)
+		if string(again) != string(got) {
+			t.Errorf("%s: Format is not idempotent: %q then %q", tt.name, got, again)
+		}
+	}
+
+	if _, err := Format([]byte("ssh-ed25519 AAAA alice\nnot-a-key\n")); err == nil || err.Error() != "line 2: invalid key format (expected at least 2 fields)" {
+		t.Errorf("malformed line: got %v, want a line 2 error", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	const (
+		alice = "ssh-ed25519 AAAA alice\n"
+		bob   = "ssh-ed25519 BBBB bob\n"
+		carol = "ssh-ed25519 CCCC carol\n"
+		dave  = "ssh-ed25519 DDDD dave\n"
+	)
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+	}{
+		{"unchanged", alice + bob, alice + bob, alice + bob, alice + bob},
+		{"added on both sides", alice, alice + carol, alice + dave, alice + carol + dave},
+		{"same key added on both sides", alice, alice + carol, carol + alice, alice + carol},
+		{"removed by us", alice + bob, alice, alice + bob, alice},
+		{"removed by them", alice + bob, alice + bob, bob, bob},
+		{"removed by one, added by the other", alice + bob, alice + carol, bob + dave, carol + dave},
+		{"removed on both sides", alice + bob, alice, alice, alice},
+		{"no base", "", alice, bob, alice + bob},
+	}
+	for _, tt := range tests {
+		got, err := Merge([]byte(tt.base), []byte(tt.ours), []byte(tt.theirs))
+		if err != nil {
+			t.Errorf("%s: Merge failed: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: Merge = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := Merge([]byte(alice), []byte(alice), []byte("oops\n")); err == nil || err.Error() != "theirs: line 1: invalid key format (expected at least 2 fields)" {
+		t.Errorf("malformed theirs: got %v, want it named", err)
+	}
+}
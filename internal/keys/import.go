@@ -0,0 +1,187 @@
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// httpClient is used for fetching public keys from code hosts
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// FromGitHub fetches a user's public SSH keys from github.com/<user>.keys
+func FromGitHub(user string) ([]Key, error) {
+	return fetchKeys(fmt.Sprintf("https://github.com/%s.keys", user), nil)
+}
+
+// FromGitLab fetches a user's public SSH keys from a GitLab instance
+// (gitlab.com if host is empty) via the users API. ENVAULT_GITLAB_TOKEN
+// authenticates against private instances.
+func FromGitLab(host, user string) ([]Key, error) {
+	base := apiBase(host, "gitlab.com") + "/api/v4"
+
+	header := http.Header{}
+	if token := os.Getenv("ENVAULT_GITLAB_TOKEN"); token != "" {
+		header.Set("PRIVATE-TOKEN", token)
+	}
+
+	var users []struct {
+		ID int `json:"id"`
+	}
+	if err := fetchJSON(base+"/users?username="+url.QueryEscape(user), header, &users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("GitLab user %s not found", user)
+	}
+
+	return fetchAPIKeys(fmt.Sprintf("%s/users/%d/keys", base, users[0].ID), header, user)
+}
+
+// FromGitea fetches a user's public SSH keys from a Gitea (or Forgejo)
+// instance. ENVAULT_GITEA_TOKEN authenticates against private instances.
+func FromGitea(host, user string) ([]Key, error) {
+	if host == "" {
+		return nil, fmt.Errorf("a Gitea host is required (--host gitea.company.com)")
+	}
+	base := apiBase(host, "") + "/api/v1"
+
+	header := http.Header{}
+	if token := os.Getenv("ENVAULT_GITEA_TOKEN"); token != "" {
+		header.Set("Authorization", "token "+token)
+	}
+
+	return fetchAPIKeys(base+"/users/"+url.PathEscape(user)+"/keys", header, user)
+}
+
+// apiBase returns the https base URL for a host, allowing an explicit scheme
+func apiBase(host, fallback string) string {
+	if host == "" {
+		host = fallback
+	}
+	host = strings.TrimSuffix(host, "/")
+	if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
+		return host
+	}
+	return "https://" + host
+}
+
+// fetchAPIKeys reads a JSON list of {"key": "..."} objects, as returned by
+// the GitLab and Gitea APIs. Keys without a comment are labelled with user.
+func fetchAPIKeys(url string, header http.Header, user string) ([]Key, error) {
+	var entries []struct {
+		Key string `json:"key"`
+	}
+	if err := fetchJSON(url, header, &entries); err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	for _, e := range entries {
+		key, err := ParseKey(strings.TrimSpace(e.Key))
+		if err != nil {
+			return nil, err
+		}
+		if key.Comment == "" {
+			key.Comment = user
+		}
+		keys = append(keys, *key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found for %s", user)
+	}
+	return keys, nil
+}
+
+// fetchJSON downloads url and decodes the JSON response into v
+func fetchJSON(url string, header http.Header, v any) error {
+	resp, err := get(url, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse response from %s: %w", url, err)
+	}
+	return nil
+}
+
+// get performs an authenticated GET, failing on non-200 responses
+func get(url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// fetchKeys downloads a newline-separated list of public keys
+func fetchKeys(url string, header http.Header) ([]Key, error) {
+	resp, err := get(url, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return parseKeyList(resp.Body)
+}
+
+// ReadKeyFile reads public keys from a file with one key per line
+func ReadKeyFile(path string) ([]Key, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	return parseKeyList(file)
+}
+
+// ParseList parses authorized_keys content, e.g. from an older revision
+func ParseList(data []byte) ([]Key, error) {
+	return parseKeyList(bytes.NewReader(data))
+}
+
+// parseKeyList parses one public key per line, skipping blanks and comments
+func parseKeyList(r io.Reader) ([]Key, error) {
+	var keys []Key
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := ParseKey(line)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found")
+	}
+	return keys, nil
+}
@@ -28,13 +28,42 @@ func AuthorizedKeysPath() (string, error) {
 	return filepath.Join(envaultDir, "authorized_keys"), nil
 }
 
-// Load reads all authorized keys
+// RootKeysPath returns the authorized_keys of a root in config.yaml, or
+// .envault's own for ""
+func RootKeysPath(cfg *config.Config, root string) (string, error) {
+	dir, err := cfg.RootDir(root)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "authorized_keys"), nil
+}
+
+// Load reads all authorized keys: authorized_keys merged with the
+// key_sources in config.yaml, or authorized_keys alone before there is one
 func Load() ([]Key, error) {
-	keysPath, err := AuthorizedKeysPath()
+	cfg, err := config.Load()
+	if err != nil {
+		keysPath, perr := AuthorizedKeysPath()
+		if perr != nil {
+			return nil, perr
+		}
+		return LoadFile(keysPath)
+	}
+	return LoadRoot(cfg, "")
+}
+
+// LoadFor reads the keys an environment is encrypted to: the
+// authorized_keys of its root
+func LoadFor(cfg *config.Config, envName string) ([]Key, error) {
+	env, err := cfg.GetEnvironment(envName)
 	if err != nil {
 		return nil, err
 	}
+	return LoadRoot(cfg, env.Root)
+}
 
+// LoadFile reads the keys in an authorized_keys file, which may not exist
+func LoadFile(keysPath string) ([]Key, error) {
 	file, err := os.Open(keysPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -72,9 +101,18 @@ func Load() ([]Key, error) {
 	return keys, nil
 }
 
-// ParseKey parses an SSH public key from OpenSSH format
+// ParseKey parses an SSH public key from OpenSSH format, or a bare age
+// recipient (age1...) optionally followed by a comment
 func ParseKey(line string) (*Key, error) {
 	parts := strings.Fields(line)
+	if len(parts) > 0 && strings.HasPrefix(parts[0], "age1") {
+		return &Key{
+			Type:        "age",
+			Data:        parts[0],
+			Comment:     strings.Join(parts[1:], " "),
+			Fingerprint: generateFingerprint(parts[0]),
+		}, nil
+	}
 	if len(parts) < 2 {
 		return nil, fmt.Errorf("invalid key format (expected at least 2 fields)")
 	}
@@ -99,88 +137,140 @@ func ParseKey(line string) (*Key, error) {
 
 // Add adds a new SSH public key to authorized_keys
 func Add(keyString string) error {
+	keysPath, err := AuthorizedKeysPath()
+	if err != nil {
+		return err
+	}
+	return AddTo(keysPath, keyString)
+}
+
+// AddTo adds a new SSH public key to an authorized_keys file, such as a
+// root's
+func AddTo(keysPath, keyString string) error {
+	key, err := CheckAdd(keysPath, keyString)
+	if err != nil {
+		return err
+	}
+	return Append(keysPath, *key)
+}
+
+// Append writes a key checked by CheckAdd to an authorized_keys file
+func Append(keysPath string, key Key) error {
+	data, err := readAuthorizedKeys(keysPath)
+	if err != nil {
+		return err
+	}
+	entries, trailer, err := parseEntries(data)
+	if err != nil {
+		return err
+	}
+
+	// Write in canonical sorted order so concurrent adds rarely conflict
+	return writeAuthorizedKeys(keysPath, append(entries, entry{key: key}), trailer)
+}
+
+// CheckAdd returns the key AddTo would add, or the reason it would refuse
+func CheckAdd(keysPath, keyString string) (*Key, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return nil, err
+	}
+	return CheckAddIn(envaultDir, keysPath, keyString)
+}
+
+// CheckAddIn is CheckAdd for the vault at envaultDir, whose config.yaml
+// and revoked_keys apply
+func CheckAddIn(envaultDir, keysPath, keyString string) (*Key, error) {
 	// Parse the key to validate it
 	key, err := ParseKey(keyString)
 	if err != nil {
-		return fmt.Errorf("invalid key: %w", err)
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	if key.Type != "age" {
+		if _, err := key.ParseSSH(); err != nil {
+			return nil, fmt.Errorf("%w - %s", err, Advice(key.Type))
+		}
 	}
 
 	// Check if key already exists
-	existing, err := Load()
+	existing, err := LoadFile(keysPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for _, k := range existing {
 		if k.Data == key.Data {
-			return fmt.Errorf("key already exists (fingerprint: %s)", k.Fingerprint)
+			return nil, fmt.Errorf("key already exists (fingerprint: %s)", k.Fingerprint)
 		}
 	}
 
-	// Append to authorized_keys
-	keysPath, err := AuthorizedKeysPath()
+	revoked, err := LoadRevokedFile(filepath.Join(envaultDir, "revoked_keys"))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(FindRevoked([]Key{*key}, revoked)) > 0 {
+		return nil, fmt.Errorf("key %s is listed in revoked_keys", key.Fingerprint)
 	}
 
-	file, err := os.OpenFile(keysPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	cfg, err := config.LoadDir(envaultDir)
 	if err != nil {
-		return fmt.Errorf("failed to open authorized_keys: %w", err)
+		return nil, err
 	}
-	defer file.Close()
-
-	if _, err := file.WriteString(keyString + "\n"); err != nil {
-		return fmt.Errorf("failed to write key: %w", err)
+	if err := cfg.CheckKeyComment(key.Comment); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return key, nil
 }
 
 // Remove removes an SSH public key by fingerprint
 func Remove(fingerprint string) error {
-	keys, err := Load()
+	_, err := RemoveKey(fingerprint)
+	return err
+}
+
+// RemoveKey removes a key by fingerprint and returns the removed key
+func RemoveKey(fingerprint string) (*Key, error) {
+	keysPath, err := AuthorizedKeysPath()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return RemoveKeyFrom(keysPath, fingerprint)
+}
 
-	// Filter out the key to remove
-	var filtered []Key
-	found := false
-	for _, k := range keys {
-		if k.Fingerprint == fingerprint {
-			found = true
-			continue
-		}
-		filtered = append(filtered, k)
+// RemoveKeyFrom removes a key from an authorized_keys file by fingerprint
+// and returns the removed key
+func RemoveKeyFrom(keysPath, fingerprint string) (*Key, error) {
+	data, err := readAuthorizedKeys(keysPath)
+	if err != nil {
+		return nil, err
 	}
-
-	if !found {
-		return fmt.Errorf("key with fingerprint %s not found", fingerprint)
+	entries, trailer, err := parseEntries(data)
+	if err != nil {
+		return nil, err
 	}
 
-	// Rewrite authorized_keys
-	keysPath, err := AuthorizedKeysPath()
-	if err != nil {
-		return err
+	// Filter out the key to remove, along with its comment lines
+	var filtered []entry
+	var removed *Key
+	for _, e := range entries {
+		if e.key.Fingerprint == fingerprint {
+			k := e.key
+			removed = &k
+			continue
+		}
+		filtered = append(filtered, e)
 	}
 
-	file, err := os.Create(keysPath)
-	if err != nil {
-		return fmt.Errorf("failed to create authorized_keys: %w", err)
+	if removed == nil {
+		return nil, fmt.Errorf("key with fingerprint %s not found", fingerprint)
 	}
-	defer file.Close()
 
-	for _, k := range filtered {
-		line := fmt.Sprintf("%s %s", k.Type, k.Data)
-		if k.Comment != "" {
-			line += " " + k.Comment
-		}
-		if _, err := file.WriteString(line + "\n"); err != nil {
-			return fmt.Errorf("failed to write key: %w", err)
-		}
+	if err := writeAuthorizedKeys(keysPath, filtered, trailer); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return removed, nil
 }
 
 // generateFingerprint creates a SHA256 fingerprint of the key data
@@ -197,3 +287,38 @@ func (k *Key) String() string {
 	}
 	return s
 }
+
+// Recipient returns the key in the form passed to the encryption backend
+func (k *Key) Recipient() string {
+	if k.Type == "age" {
+		return k.Data
+	}
+	return fmt.Sprintf("%s %s", k.Type, k.Data)
+}
+
+// Line returns the key as an authorized_keys line
+func (k *Key) Line() string {
+	line := k.Recipient()
+	if k.Comment != "" {
+		line += " " + k.Comment
+	}
+	return line
+}
+
+// Plugin returns the age plugin that handles an age1<plugin>1... recipient,
+// e.g. "yubikey" for age1yubikey1..., or "" for X25519 and SSH keys
+func (k *Key) Plugin() string {
+	if k.Type != "age" {
+		return ""
+	}
+	// The bech32 separator is the last 1; the data part never contains one
+	hrp := strings.ToLower(k.Data)
+	if sep := strings.LastIndex(hrp, "1"); sep > 0 {
+		hrp = hrp[:sep]
+	}
+	name, ok := strings.CutPrefix(hrp, "age1")
+	if !ok {
+		return ""
+	}
+	return name
+}
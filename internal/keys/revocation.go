@@ -0,0 +1,115 @@
+package keys
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RevocationVersion is the revocation certificate format written by this
+// build
+const RevocationVersion = 1
+
+// Revocation is a signed statement that a key was removed and revoked
+// (envault remove-key --revoke-cert). Other repositories adopt it after
+// checking the signer against bundle_signers, so they can tell an
+// authorized removal from a bad merge.
+type Revocation struct {
+	Version   int       `json:"version"`
+	Key       string    `json:"key"` // the revoked public key line
+	Reason    string    `json:"reason,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+	Signer    string    `json:"signer"`    // public key that signed the statement
+	Signature string    `json:"signature"` // SSH signature over Message
+}
+
+// NewRevocation describes the revocation of key, unsigned
+func NewRevocation(key Key, reason string) (*Revocation, error) {
+	if strings.ContainsAny(reason, "\r\n") {
+		return nil, fmt.Errorf("revocation reason must be a single line")
+	}
+	return &Revocation{
+		Version:   RevocationVersion,
+		Key:       key.Line(),
+		Reason:    reason,
+		RevokedAt: time.Now().UTC().Truncate(time.Second),
+	}, nil
+}
+
+// ParseRevocation reads a revocation certificate. Its signature is not
+// checked here.
+func ParseRevocation(data []byte) (*Revocation, error) {
+	var r Revocation
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid revocation certificate: %w", err)
+	}
+	if r.Version != RevocationVersion {
+		return nil, fmt.Errorf("unsupported revocation certificate version %d", r.Version)
+	}
+	if strings.ContainsAny(r.Reason, "\r\n") {
+		return nil, fmt.Errorf("invalid revocation certificate: reason spans several lines")
+	}
+	if _, err := r.Revoked(); err != nil {
+		return nil, err
+	}
+	if r.Signer == "" || r.Signature == "" {
+		return nil, fmt.Errorf("revocation certificate is not signed")
+	}
+	return &r, nil
+}
+
+// Revoked parses the revoked key
+func (r *Revocation) Revoked() (*Key, error) {
+	key, err := ParseKey(r.Key)
+	if err != nil {
+		return nil, fmt.Errorf("revocation certificate key: %w", err)
+	}
+	return key, nil
+}
+
+// Message returns the data the certificate's signature covers. The
+// comment is left out, so editing it cannot invalidate the statement.
+func (r *Revocation) Message() []byte {
+	key, err := r.Revoked()
+	if err != nil {
+		return nil
+	}
+	return []byte(fmt.Sprintf("envault-revocation:v1\nkey=%s\nfingerprint=%s\nrevoked=%s\nreason=%s\n",
+		key.Recipient(), key.Fingerprint, r.RevokedAt.UTC().Format(time.RFC3339), r.Reason))
+}
+
+// ApplyRevocation removes a revoked key from authorized_keys, when it is
+// there, and adds it to revoked_keys unless it is already listed. It
+// reports whether either file changed.
+func ApplyRevocation(key Key) (removed, revoked bool, err error) {
+	keysPath, err := AuthorizedKeysPath()
+	if err != nil {
+		return false, false, err
+	}
+	current, err := LoadFile(keysPath)
+	if err != nil {
+		return false, false, err
+	}
+	for _, k := range current {
+		if k.Data == key.Data {
+			if _, err := RemoveKeyFrom(keysPath, k.Fingerprint); err != nil {
+				return false, false, err
+			}
+			removed = true
+			break
+		}
+	}
+
+	list, err := LoadRevoked()
+	if err != nil {
+		return removed, false, err
+	}
+	if len(FindRevoked([]Key{key}, list)) > 0 {
+		return removed, false, nil
+	}
+	if err := Revoke(key); err != nil {
+		return removed, false, err
+	}
+	return removed, true, nil
+}
@@ -0,0 +1,137 @@
+package keys
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// Revoked is an entry in .envault/revoked_keys: either a full public key
+// line or a bare fingerprint
+type Revoked struct {
+	Fingerprint string
+	Key         *Key // nil for fingerprint-only entries
+}
+
+// RevokedKeysPath returns the path to revoked_keys file
+func RevokedKeysPath() (string, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(envaultDir, "revoked_keys"), nil
+}
+
+// LoadRevoked reads revoked_keys. A missing file yields no entries.
+func LoadRevoked() ([]Revoked, error) {
+	revokedPath, err := RevokedKeysPath()
+	if err != nil {
+		return nil, err
+	}
+	return LoadRevokedFile(revokedPath)
+}
+
+// LoadRevokedFile reads a revoked_keys file, such as another vault's
+func LoadRevokedFile(revokedPath string) ([]Revoked, error) {
+	file, err := os.Open(revokedPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open revoked_keys: %w", err)
+	}
+	defer file.Close()
+
+	var revoked []Revoked
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 1 && !strings.HasPrefix(fields[0], "age1") {
+			revoked = append(revoked, Revoked{Fingerprint: fields[0]})
+			continue
+		}
+
+		key, err := ParseKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("revoked_keys line %d: %w", lineNum, err)
+		}
+		revoked = append(revoked, Revoked{Fingerprint: key.Fingerprint, Key: key})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read revoked_keys: %w", err)
+	}
+
+	return revoked, nil
+}
+
+// Matches reports whether the revocation entry covers key
+func (r Revoked) Matches(key Key) bool {
+	if r.Key != nil {
+		return r.Key.Data == key.Data
+	}
+	return r.Fingerprint == key.Fingerprint
+}
+
+// FindRevoked returns the keys that appear in the revocation list
+func FindRevoked(candidates []Key, revoked []Revoked) []Key {
+	var found []Key
+	for _, k := range candidates {
+		for _, r := range revoked {
+			if r.Matches(k) {
+				found = append(found, k)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// Revoke appends a key to revoked_keys
+func Revoke(key Key) error {
+	revokedPath, err := RevokedKeysPath()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(revokedPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open revoked_keys: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(key.Line() + "\n"); err != nil {
+		return fmt.Errorf("failed to write revoked key: %w", err)
+	}
+	return nil
+}
+
+// SSHTag returns the 4-byte recipient tag age writes in ssh-ed25519 and
+// ssh-rsa header stanzas, or "" for other key types
+func (k *Key) SSHTag() string {
+	if k.Type != "ssh-ed25519" && k.Type != "ssh-rsa" {
+		return ""
+	}
+	wire, err := base64.StdEncoding.DecodeString(k.Data)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(wire)
+	return base64.RawStdEncoding.EncodeToString(sum[:4])
+}
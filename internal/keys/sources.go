@@ -0,0 +1,141 @@
+package keys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// LocalSource names .envault/authorized_keys as the source of a key
+const LocalSource = "authorized_keys"
+
+// Sourced is an authorized key and the file that contributed it
+type Sourced struct {
+	Key
+	Source string   // LocalSource or a key_sources path as written in config.yaml
+	Trust  string   // config.LocalKeyTrust or the source's trust
+	Also   []string // lower-precedence sources that list the key too
+}
+
+// sourceFile is one file of the merged recipient set
+type sourceFile struct {
+	name     string
+	path     string
+	trust    string
+	optional bool
+}
+
+// sourceFiles returns .envault/authorized_keys followed by the key_sources
+// in config.yaml, in order of precedence
+func sourceFiles(cfg *config.Config) ([]sourceFile, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return nil, err
+	}
+	files := []sourceFile{{
+		name:     LocalSource,
+		path:     filepath.Join(envaultDir, "authorized_keys"),
+		trust:    config.LocalKeyTrust,
+		optional: true,
+	}}
+	for _, source := range cfg.KeySources {
+		path := source.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(envaultDir, path)
+		}
+		files = append(files, sourceFile{name: source.Path, path: filepath.Clean(path), trust: source.TrustLabel(), optional: source.Optional})
+	}
+	return files, nil
+}
+
+// LoadSourced reads the recipients of the default root: the keys in
+// .envault/authorized_keys and every key_sources file, merged by key data.
+// The first file to list a key wins, so a local line can override the
+// comment a shared list gives it.
+func LoadSourced(cfg *config.Config) ([]Sourced, error) {
+	files, err := sourceFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged []Sourced
+	seen := map[string]int{}
+	for _, file := range files {
+		if _, err := os.Stat(file.path); os.IsNotExist(err) {
+			if file.optional {
+				continue
+			}
+			return nil, fmt.Errorf("key source %s not found (mark it optional: true if it may be missing)", file.name)
+		}
+		fileKeys, err := LoadFile(file.path)
+		if err != nil {
+			if file.name == LocalSource {
+				return nil, err
+			}
+			return nil, fmt.Errorf("key source %s: %w", file.name, err)
+		}
+		for _, key := range fileKeys {
+			if i, ok := seen[key.Data]; ok {
+				if merged[i].Source != file.name {
+					merged[i].Also = append(merged[i].Also, file.name)
+				}
+				continue
+			}
+			seen[key.Data] = len(merged)
+			merged = append(merged, Sourced{Key: key, Source: file.name, Trust: file.trust})
+		}
+	}
+	return merged, nil
+}
+
+// LoadRoot reads the keys a root's environments are encrypted to: for the
+// default root "" the merged key_sources, for others their authorized_keys
+func LoadRoot(cfg *config.Config, root string) ([]Key, error) {
+	if root != "" {
+		keysPath, err := RootKeysPath(cfg, root)
+		if err != nil {
+			return nil, err
+		}
+		return LoadFile(keysPath)
+	}
+
+	sourced, err := LoadSourced(cfg)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Key, 0, len(sourced))
+	for _, k := range sourced {
+		list = append(list, k.Key)
+	}
+	return list, nil
+}
+
+// RootProblems is Problems for every file a root's recipients come from
+func RootProblems(cfg *config.Config, root string, validate func(Key) error) ([]Problem, error) {
+	if root != "" {
+		keysPath, err := RootKeysPath(cfg, root)
+		if err != nil {
+			return nil, err
+		}
+		return Problems(keysPath, validate)
+	}
+
+	files, err := sourceFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var all []Problem
+	for _, file := range files {
+		problems, err := Problems(file.path, validate)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.name, err)
+		}
+		for _, p := range problems {
+			p.File = file.name
+			all = append(all, p)
+		}
+	}
+	return all, nil
+}
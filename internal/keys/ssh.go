@@ -0,0 +1,166 @@
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// MinRSABits is the smallest RSA modulus age encrypts to
+const MinRSABits = 2048
+
+// unsupportedSSHTypes explains SSH key types that age cannot encrypt to
+var unsupportedSSHTypes = map[string]string{
+	"ecdsa-sha2-nistp256":                "ECDSA keys cannot be age recipients",
+	"ecdsa-sha2-nistp384":                "ECDSA keys cannot be age recipients",
+	"ecdsa-sha2-nistp521":                "ECDSA keys cannot be age recipients",
+	"ssh-dss":                            "DSA keys cannot be age recipients",
+	"sk-ssh-ed25519@openssh.com":         "FIDO security-key (sk-) keys cannot decrypt; only their authenticator can sign",
+	"sk-ecdsa-sha2-nistp256@openssh.com": "FIDO security-key (sk-) keys cannot decrypt; only their authenticator can sign",
+}
+
+// Advice suggests what to use instead of a key type age cannot encrypt to
+func Advice(keyType string) string {
+	if strings.HasPrefix(keyType, "sk-") {
+		return "keep it for SSH logins and add a separate ssh-ed25519 key for envault (ssh-keygen -t ed25519), or use a hardware age identity such as age-plugin-yubikey with the age backend"
+	}
+	return "generate an ssh-ed25519 key for envault: ssh-keygen -t ed25519"
+}
+
+// UnsupportedSSHType explains why age cannot encrypt to an SSH key type,
+// or returns "" for types it is not known to reject
+func UnsupportedSSHType(keyType string) string {
+	return unsupportedSSHTypes[keyType]
+}
+
+// SSHPublicKey is an ssh-ed25519 or ssh-rsa key decoded from its wire form
+type SSHPublicKey struct {
+	Type    string
+	Ed25519 []byte   // 32-byte public key, for ssh-ed25519
+	E, N    *big.Int // exponent and modulus, for ssh-rsa
+}
+
+// ParseSSH decodes an ssh-ed25519 or ssh-rsa key, checking that the data
+// is well formed and matches the key type. Other types return an error
+// explaining why age cannot use them.
+func (k *Key) ParseSSH() (*SSHPublicKey, error) {
+	if reason := UnsupportedSSHType(k.Type); reason != "" {
+		return nil, fmt.Errorf("%s is not supported: %s", k.Type, reason)
+	}
+	if k.Type != "ssh-ed25519" && k.Type != "ssh-rsa" {
+		return nil, fmt.Errorf("%s is not an SSH key type age supports (ssh-ed25519, ssh-rsa)", k.Type)
+	}
+
+	wire, err := base64.StdEncoding.DecodeString(k.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%s key data is not valid base64", k.Type)
+	}
+	r := &wireReader{data: wire}
+	typ := string(r.next())
+	if r.err != nil {
+		return nil, fmt.Errorf("%s key data is truncated or malformed", k.Type)
+	}
+	if typ != k.Type {
+		return nil, fmt.Errorf("key data is for %q, not %s", typ, k.Type)
+	}
+
+	pub := &SSHPublicKey{Type: k.Type}
+	switch k.Type {
+	case "ssh-ed25519":
+		pub.Ed25519 = r.next()
+		if r.err == nil && len(pub.Ed25519) != 32 {
+			return nil, fmt.Errorf("ssh-ed25519 key is %d bytes, not 32", len(pub.Ed25519))
+		}
+	case "ssh-rsa":
+		pub.E = new(big.Int).SetBytes(r.next())
+		pub.N = new(big.Int).SetBytes(r.next())
+		if r.err == nil && pub.N.BitLen() < MinRSABits {
+			return nil, fmt.Errorf("ssh-rsa key is %d bits; age needs at least %d", pub.N.BitLen(), MinRSABits)
+		}
+	}
+	if r.err != nil || len(r.data) != 0 {
+		return nil, fmt.Errorf("%s key data is truncated or malformed", k.Type)
+	}
+	return pub, nil
+}
+
+// wireReader reads length-prefixed strings from an SSH wire encoding,
+// remembering the first error
+type wireReader struct {
+	data []byte
+	err  error
+}
+
+func (r *wireReader) next() []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < 4 {
+		r.err = fmt.Errorf("truncated")
+		return nil
+	}
+	n := binary.BigEndian.Uint32(r.data)
+	if uint64(n) > uint64(len(r.data)-4) {
+		r.err = fmt.Errorf("truncated")
+		return nil
+	}
+	field := r.data[4 : 4+n]
+	r.data = r.data[4+n:]
+	return field
+}
+
+// Problem is an authorized_keys line that cannot be used as a recipient
+type Problem struct {
+	File string // key source the line is in, authorized_keys when empty
+	Line int
+	Key  Key
+	Err  error
+}
+
+func (p Problem) String() string {
+	file := p.File
+	if file == "" {
+		file = LocalSource
+	}
+	return fmt.Sprintf("%s line %d: %s: %v", file, p.Line, p.Key.String(), p.Err)
+}
+
+// Problems checks every key in an authorized_keys file with validate and
+// returns the lines it rejects, so a bad line can be named instead of
+// failing the whole file
+func Problems(keysPath string, validate func(Key) error) ([]Problem, error) {
+	data, err := os.ReadFile(keysPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open authorized_keys: %w", err)
+	}
+
+	var problems []Problem
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := ParseKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if err := validate(*key); err != nil {
+			problems = append(problems, Problem{Line: lineNum, Key: *key, Err: err})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read authorized_keys: %w", err)
+	}
+	return problems, nil
+}
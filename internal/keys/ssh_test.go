@@ -0,0 +1,99 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wire encodes fields as SSH length-prefixed strings
+func wire(fields ...[]byte) string {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		binary.Write(&buf, binary.BigEndian, uint32(len(f)))
+		buf.Write(f)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func rsaModulus(bits int) []byte {
+	n := new(big.Int).Lsh(big.NewInt(1), uint(bits-1))
+	return n.Add(n, big.NewInt(1)).Bytes()
+}
+
+func TestParseSSH(t *testing.T) {
+	ed := bytes.Repeat([]byte{7}, 32)
+	e := big.NewInt(65537).Bytes()
+	tests := []struct {
+		name    string
+		typ     string
+		data    string
+		wantErr string
+	}{
+		{"ed25519", "ssh-ed25519", wire([]byte("ssh-ed25519"), ed), ""},
+		{"rsa 2048", "ssh-rsa", wire([]byte("ssh-rsa"), e, rsaModulus(2048)), ""},
+		{"rsa 4096", "ssh-rsa", wire([]byte("ssh-rsa"), e, rsaModulus(4096)), ""},
+		{"rsa 1024", "ssh-rsa", wire([]byte("ssh-rsa"), e, rsaModulus(1024)), "1024 bits; age needs at least 2048"},
+		{"ed25519 too short", "ssh-ed25519", wire([]byte("ssh-ed25519"), ed[:31]), "31 bytes, not 32"},
+		{"type mismatch", "ssh-rsa", wire([]byte("ssh-ed25519"), ed), `key data is for "ssh-ed25519", not ssh-rsa`},
+		{"trailing data", "ssh-ed25519", wire([]byte("ssh-ed25519"), ed, []byte("x")), "truncated or malformed"},
+		{"truncated length", "ssh-ed25519", base64.StdEncoding.EncodeToString([]byte{0, 0, 0, 11, 's', 's', 'h'}), "truncated or malformed"},
+		{"missing modulus", "ssh-rsa", wire([]byte("ssh-rsa"), e), "truncated or malformed"},
+		{"not base64", "ssh-ed25519", "!!!", "not valid base64"},
+		{"ecdsa", "ecdsa-sha2-nistp256", wire([]byte("ecdsa-sha2-nistp256")), "ECDSA keys cannot be age recipients"},
+		{"security key", "sk-ssh-ed25519@openssh.com", wire([]byte("sk-ssh-ed25519@openssh.com")), "FIDO security-key"},
+		{"unknown type", "ssh-foo", wire([]byte("ssh-foo")), "not an SSH key type age supports"},
+	}
+	for _, tt := range tests {
+		k := &Key{Type: tt.typ, Data: tt.data}
+		pub, err := k.ParseSSH()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: ParseSSH failed: %v", tt.name, err)
+			} else if pub.Type != tt.typ {
+				t.Errorf("%s: Type = %q, want %q", tt.name, pub.Type, tt.typ)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: ParseSSH error = %v, want one containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestProblems(t *testing.T) {
+	good := "ssh-ed25519 " + wire([]byte("ssh-ed25519"), bytes.Repeat([]byte{7}, 32)) + " alice@example.com"
+	weak := "ssh-rsa " + wire([]byte("ssh-rsa"), big.NewInt(65537).Bytes(), rsaModulus(1024)) + " bob@example.com"
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	data := "# team keys\n" + good + "\n\n" + weak + "\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := Problems(path, func(k Key) error {
+		_, err := k.ParseSSH()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("got %d problems, want 1: %v", len(problems), problems)
+	}
+	if p := problems[0]; p.Line != 4 || p.Key.Comment != "bob@example.com" {
+		t.Errorf("problem is line %d (%s), want line 4 (bob@example.com)", p.Line, p.Key.Comment)
+	}
+	if s := problems[0].String(); !strings.HasPrefix(s, "authorized_keys line 4: ") {
+		t.Errorf("String() = %q, want it to name authorized_keys line 4", s)
+	}
+
+	missing, err := Problems(filepath.Join(t.TempDir(), "none"), func(Key) error { return nil })
+	if err != nil || missing != nil {
+		t.Errorf("missing file: got %v, %v; want no problems", missing, err)
+	}
+}
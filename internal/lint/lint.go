@@ -0,0 +1,356 @@
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/wsl"
+)
+
+// Diagnostic codes
+const (
+	CodeDuplicateTarget = "duplicate-target"        // two environments write the same file
+	CodeOutsideRepo     = "outside-repo"            // a target escapes the repository
+	CodeNotIgnored      = "not-ignored"             // a target would be committed
+	CodeUnreferenced    = "unreferenced-ciphertext" // ciphertext no environment uses
+	CodeAbsolutePath    = "absolute-path"           // a target path that should be relative
+	CodeIgnoredSetting  = "ignored-setting"         // a setting envault no longer reads from config.yaml
+)
+
+// Diagnostic is a single lint finding
+type Diagnostic struct {
+	Code        string
+	Environment string // empty for vault-wide findings
+	Path        string
+	Message     string
+	Fixable     bool
+
+	target int // index into the environment's targets, for fixes
+}
+
+func (d Diagnostic) String() string {
+	if d.Environment == "" {
+		return d.Message
+	}
+	return d.Environment + ": " + d.Message
+}
+
+// Project is the configuration being linted
+type Project struct {
+	Dir      string // directory containing .envault; targets resolve from here
+	VaultDir string
+	Config   *config.Config
+}
+
+// Load describes the project in the current directory
+func Load() (*Project, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+	vaultDir, err := config.ResolveDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.LoadDir(vaultDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Project{Dir: dir, VaultDir: vaultDir, Config: cfg}, nil
+}
+
+// Run lints the configuration, returning findings sorted by environment
+func (p *Project) Run() ([]Diagnostic, error) {
+	// git reports the repository root with symlinks resolved
+	base, err := filepath.EvalSymlinks(p.Dir)
+	if err != nil {
+		base = p.Dir
+	}
+	root := repoRoot(base)
+	inGit := isGitRepo(base)
+
+	var diags []Diagnostic
+	writers := map[string][]string{} // resolved path -> environments
+
+	for _, envName := range p.envNames() {
+		env := p.Config.Environments[envName]
+
+		for i, target := range env.Targets {
+			if target.Path == "" || wsl.IsWindowsPath(target.Path) {
+				continue
+			}
+			if filepath.IsAbs(target.Path) {
+				d := Diagnostic{
+					Code:        CodeAbsolutePath,
+					Environment: envName,
+					Path:        target.Path,
+					Message:     fmt.Sprintf("target %s is absolute; paths are relative to the project", target.Path),
+					target:      i,
+				}
+				if rel, ok := within(p.Dir, target.Path); ok {
+					d.Fixable = true
+					d.Message += fmt.Sprintf(" (use %s)", rel)
+				}
+				diags = append(diags, d)
+			}
+		}
+
+		targets, err := p.Config.ResolvedTargets(envName)
+		if err != nil {
+			return nil, fmt.Errorf("environment %s: %w", envName, err)
+		}
+
+		for _, target := range targets {
+			if target.Path == "" || wsl.IsWindowsPath(target.Path) {
+				continue
+			}
+
+			path := target.Path
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(base, path)
+			}
+			path = filepath.Clean(path)
+			writers[path] = appendUnique(writers[path], envName)
+
+			if _, ok := within(root, path); !ok {
+				diags = append(diags, Diagnostic{
+					Code:        CodeOutsideRepo,
+					Environment: envName,
+					Path:        target.Path,
+					Message:     fmt.Sprintf("target %s is outside the repository (%s)", target.Path, root),
+				})
+				continue
+			}
+
+			if inGit && !gitIgnored(base, path) {
+				rel, _ := within(base, path)
+				diags = append(diags, Diagnostic{
+					Code:        CodeNotIgnored,
+					Environment: envName,
+					Path:        rel,
+					Message:     fmt.Sprintf("target %s is not covered by .gitignore and could be committed", rel),
+					Fixable:     true,
+				})
+			}
+
+			// Overrides hold a developer's own values and must not be committed either
+			if target.Overrides != "" && !filepath.IsAbs(target.Overrides) && inGit {
+				overrides := filepath.Join(base, target.Overrides)
+				if _, ok := within(root, overrides); ok && !gitIgnored(base, overrides) {
+					rel, _ := within(base, overrides)
+					diags = append(diags, Diagnostic{
+						Code:        CodeNotIgnored,
+						Environment: envName,
+						Path:        rel,
+						Message:     fmt.Sprintf("overrides file %s is not covered by .gitignore and could be committed", rel),
+						Fixable:     true,
+					})
+				}
+			}
+		}
+	}
+
+	for _, path := range sortedKeys(writers) {
+		if envNames := writers[path]; len(envNames) > 1 {
+			rel, ok := within(base, path)
+			if !ok {
+				rel = path
+			}
+			diags = append(diags, Diagnostic{
+				Code:    CodeDuplicateTarget,
+				Path:    rel,
+				Message: fmt.Sprintf("target %s is written by several environments (%s); the last one loaded wins", rel, strings.Join(envNames, ", ")),
+			})
+		}
+	}
+
+	if p.Config.AgeBinary != "" {
+		diags = append(diags, Diagnostic{
+			Code:    CodeIgnoredSetting,
+			Message: fmt.Sprintf("age_binary (%s) is ignored in config.yaml, since it would pick the program everyone decrypts with; set ENVAULT_AGE_BIN or age_binary in the per-user config instead", p.Config.AgeBinary),
+		})
+	}
+
+	unreferenced, err := p.unreferencedCiphertext()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range unreferenced {
+		diags = append(diags, Diagnostic{
+			Code:    CodeUnreferenced,
+			Path:    name,
+			Message: fmt.Sprintf("%s is not the encrypted_file of any environment (remove it or add the environment back)", name),
+		})
+	}
+
+	return diags, nil
+}
+
+// Fix applies the mechanical fixes: absolute paths inside the project are
+// made relative, and unignored targets are added to .gitignore. It returns
+// the diagnostics it resolved.
+func (p *Project) Fix(diags []Diagnostic) ([]Diagnostic, error) {
+	var fixed, ignore []Diagnostic
+	configChanged := false
+
+	for _, d := range diags {
+		if !d.Fixable {
+			continue
+		}
+		switch d.Code {
+		case CodeAbsolutePath:
+			env := p.Config.Environments[d.Environment]
+			rel, _ := within(p.Dir, d.Path)
+			env.Targets[d.target].Path = rel
+			p.Config.Environments[d.Environment] = env
+			configChanged = true
+			fixed = append(fixed, d)
+		case CodeNotIgnored:
+			ignore = append(ignore, d)
+		}
+	}
+
+	if configChanged {
+		if err := p.Config.Save(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ignore) > 0 {
+		var paths []string
+		for _, d := range ignore {
+			paths = appendUnique(paths, d.Path)
+		}
+		if err := appendGitignore(filepath.Join(p.Dir, ".gitignore"), paths); err != nil {
+			return fixed, err
+		}
+		fixed = append(fixed, ignore...)
+	}
+
+	return fixed, nil
+}
+
+// unreferencedCiphertext lists .age files in the vault that no environment
+// reads
+func (p *Project) unreferencedCiphertext() ([]string, error) {
+	referenced := map[string]bool{"schema.yaml.age": true}
+	for _, env := range p.Config.Environments {
+		referenced[filepath.Clean(env.EncryptedFile)] = true
+		referenced[filepath.Clean(env.NotesFileName())] = true
+		for _, sv := range env.Subvaults {
+			referenced[filepath.Clean(sv.File)] = true
+		}
+	}
+
+	// The nested layout keeps ciphertext one directory down
+	var matches []string
+	for _, pattern := range []string{"*.age", filepath.Join("*", "*.age")} {
+		found, err := filepath.Glob(filepath.Join(p.VaultDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, found...)
+	}
+
+	var names []string
+	for _, match := range matches {
+		name, err := filepath.Rel(p.VaultDir, match)
+		if err != nil {
+			return nil, err
+		}
+		if !referenced[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (p *Project) envNames() []string {
+	return sortedKeys(p.Config.Environments)
+}
+
+// repoRoot is the top of the project's git repository, or the project
+// directory when it is not in one
+func repoRoot(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return dir
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func isGitRepo(dir string) bool {
+	return exec.Command("git", "-C", dir, "rev-parse", "--git-dir").Run() == nil
+}
+
+// gitIgnored reports whether git would ignore path. Tracked files are never
+// ignored. Errors count as ignored so a broken git setup does not produce
+// noise.
+func gitIgnored(dir, path string) bool {
+	err := exec.Command("git", "-C", dir, "check-ignore", "--quiet", "--", path).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false
+	}
+	return true
+}
+
+// appendGitignore adds anchored entries for paths to a .gitignore file
+func appendGitignore(gitignorePath string, paths []string) error {
+	data, err := os.ReadFile(gitignorePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read .gitignore: %w", err)
+	}
+
+	var b strings.Builder
+	b.Write(data)
+	if len(data) > 0 {
+		if !strings.HasSuffix(string(data), "\n") {
+			b.WriteByte('\n')
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString("# envault targets\n")
+	for _, path := range paths {
+		b.WriteString("/" + filepath.ToSlash(path) + "\n")
+	}
+
+	if err := os.WriteFile(gitignorePath, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write .gitignore: %w", err)
+	}
+	return nil
+}
+
+// within returns path relative to dir, and whether it lies inside dir
+func within(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,225 @@
+package manifest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// Manifest represents the .envault/manifest.json structure. It is committed
+// alongside the ciphertext and never contains secret values.
+type Manifest struct {
+	Version      int                     `json:"version"`
+	Environments map[string]*Environment `json:"environments"`
+
+	// Keys records when each authorized key (by fingerprint) last
+	// decrypted successfully, as reported by the machines that commit
+	// this file. KeysSince is when that tracking began.
+	Keys      map[string]*KeyUsage `json:"keys,omitempty"`
+	KeysSince *time.Time           `json:"keys_since,omitempty"`
+}
+
+// KeyUsage tracks the use of a single authorized key. Times are kept to
+// the day so routine decrypts do not churn the file.
+type KeyUsage struct {
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// CurrentVersion is the manifest.json format version written by this build
+const CurrentVersion = 1
+
+// Environment tracks metadata for a single encrypted environment
+type Environment struct {
+	Variables map[string]*Variable `json:"variables,omitempty"`
+	Approvals []Approval           `json:"approvals,omitempty"`
+
+	// Set with stable_ciphertext: a salted hash of the plaintext that only
+	// changes when the secrets do, and a hash of the recipient list
+	ContentHash    string `json:"content_sha256,omitempty"`
+	ContentSalt    string `json:"content_salt,omitempty"`
+	RecipientsHash string `json:"recipients_sha256,omitempty"`
+}
+
+// Approval is a signature by an authorized key over a specific ciphertext
+type Approval struct {
+	Fingerprint    string    `json:"fingerprint"`
+	CiphertextHash string    `json:"ciphertext_sha256"`
+	Signature      string    `json:"signature"` // armored SSH signature
+	SignedAt       time.Time `json:"signed_at"`
+}
+
+// Variable tracks metadata for a single secret
+type Variable struct {
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Path returns the path to manifest.json
+func Path() (string, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(envaultDir, "manifest.json"), nil
+}
+
+// Load reads manifest.json. A missing file yields an empty manifest.
+func Load() (*Manifest, error) {
+	manifestPath, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{Version: CurrentVersion, Environments: map[string]*Environment{}}
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, fmt.Errorf("failed to read manifest.json: %w", err)
+	}
+
+	m.Version = 0
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest.json: %w", err)
+	}
+	if m.Version > CurrentVersion {
+		return nil, fmt.Errorf("manifest.json version %d is newer than this envault supports (%d) - upgrade envault", m.Version, CurrentVersion)
+	}
+	if m.Environments == nil {
+		m.Environments = map[string]*Environment{}
+	}
+
+	return m, nil
+}
+
+// Save writes the manifest to manifest.json
+func (m *Manifest) Save() error {
+	manifestPath, err := Path()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := os.WriteFile(manifestPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest.json: %w", err)
+	}
+
+	return nil
+}
+
+// Environment returns the entry for an environment, creating it if needed
+func (m *Manifest) Environment(envName string) *Environment {
+	env, ok := m.Environments[envName]
+	if !ok {
+		env = &Environment{}
+		m.Environments[envName] = env
+	}
+	if env.Variables == nil {
+		env.Variables = map[string]*Variable{}
+	}
+	return env
+}
+
+// RecordChanges updates last-changed timestamps for an environment.
+// previous may be nil when the old plaintext is unknown, in which case
+// only newly seen variables are stamped.
+func (m *Manifest) RecordChanges(envName string, previous, current map[string]string, now time.Time) {
+	env := m.Environment(envName)
+
+	for name, value := range current {
+		meta, tracked := env.Variables[name]
+		if !tracked {
+			env.Variables[name] = &Variable{ChangedAt: now}
+			continue
+		}
+		if previous == nil {
+			continue
+		}
+		if old, ok := previous[name]; !ok || old != value {
+			meta.ChangedAt = now
+		}
+	}
+
+	// Drop variables that no longer exist
+	for name := range env.Variables {
+		if _, ok := current[name]; !ok {
+			delete(env.Variables, name)
+		}
+	}
+}
+
+// RecordContent stores the stable content and recipient hashes. The
+// content hash is an HMAC keyed by a random per-environment salt, so it
+// cannot be matched against hashes of guessed values from other repos.
+func (m *Manifest) RecordContent(envName string, plaintext []byte, recipientsHash string) error {
+	env := m.Environment(envName)
+
+	if env.ContentSalt == "" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		env.ContentSalt = hex.EncodeToString(salt)
+	}
+
+	env.ContentHash = env.contentHash(plaintext)
+	env.RecipientsHash = recipientsHash
+	return nil
+}
+
+// ContentMatches reports whether plaintext is what RecordContent last
+// recorded for the environment
+func (e *Environment) ContentMatches(plaintext []byte) bool {
+	if e.ContentHash == "" || e.ContentSalt == "" {
+		return false
+	}
+	return hmac.Equal([]byte(e.contentHash(plaintext)), []byte(e.ContentHash))
+}
+
+func (e *Environment) contentHash(plaintext []byte) string {
+	mac := hmac.New(sha256.New, []byte(e.ContentSalt))
+	mac.Write(plaintext)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RecordKeyUse stamps a key as used on now's day, reporting whether the
+// manifest changed
+func (m *Manifest) RecordKeyUse(fingerprint string, now time.Time) bool {
+	day := now.UTC().Truncate(24 * time.Hour)
+
+	if m.Keys == nil {
+		m.Keys = map[string]*KeyUsage{}
+	}
+	if m.KeysSince == nil {
+		m.KeysSince = &day
+	}
+
+	usage, ok := m.Keys[fingerprint]
+	if ok && !usage.LastUsedAt.Before(day) {
+		return false
+	}
+	m.Keys[fingerprint] = &KeyUsage{LastUsedAt: day}
+	return true
+}
+
+// LastUsed returns when a key last decrypted, and whether it ever has
+func (m *Manifest) LastUsed(fingerprint string) (time.Time, bool) {
+	usage, ok := m.Keys[fingerprint]
+	if !ok {
+		return time.Time{}, false
+	}
+	return usage.LastUsedAt, true
+}
@@ -0,0 +1,24 @@
+package manifest
+
+import "testing"
+
+// stable_ciphertext skips a write on ContentMatches, without decrypting
+// the previous ciphertext, so it must only match what was recorded
+func TestContentMatches(t *testing.T) {
+	m := &Manifest{Environments: map[string]*Environment{}}
+	env := m.Environment("dev")
+	if env.ContentMatches([]byte("A=1\n")) {
+		t.Error("ContentMatches before anything was recorded")
+	}
+	if err := m.RecordContent("dev", []byte("A=1\n"), "recipients"); err != nil {
+		t.Fatal(err)
+	}
+	if !env.ContentMatches([]byte("A=1\n")) {
+		t.Error("ContentMatches does not match the recorded plaintext")
+	}
+	for _, other := range []string{"A=2\n", "A=1", "", "A=1\nB=2\n"} {
+		if env.ContentMatches([]byte(other)) {
+			t.Errorf("ContentMatches(%q) after recording A=1", other)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// Move is a file relocated by ConvertLayout, relative to .envault
+type Move struct {
+	Env  string
+	From string
+	To   string
+}
+
+// ConvertLayout moves every environment's ciphertext (and notes, unless
+// notes_file is set explicitly) to the paths of the given layout, then
+// records the layout and new encrypted_file values in config.yaml,
+// keeping its comments. Returns the backup directory and the moves made;
+// the directory is empty when .envault already used the layout.
+func ConvertLayout(layout string) (string, []Move, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return "", nil, err
+	}
+
+	cfg, err := config.LoadDir(envaultDir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	moves, files, err := planLayout(cfg, envaultDir, layout)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(moves) == 0 && sameLayout(cfg, layout, files) {
+		return "", nil, nil
+	}
+
+	configPath := filepath.Join(envaultDir, "config.yaml")
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read config.yaml: %w", err)
+	}
+	updated, err := setLayout(data, layout, files)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to update config.yaml: %w", err)
+	}
+
+	backupDir, err := backup(envaultDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to back up .envault: %w", err)
+	}
+
+	for i, m := range moves {
+		if err := move(envaultDir, m.From, m.To, cfg.DirMode()); err != nil {
+			undo(envaultDir, moves[:i])
+			return backupDir, nil, err
+		}
+	}
+
+	if err := os.WriteFile(configPath, updated, 0644); err != nil {
+		undo(envaultDir, moves)
+		return backupDir, nil, fmt.Errorf("failed to write config.yaml: %w", err)
+	}
+
+	// Drop per-environment directories left empty by a move to flat
+	for _, m := range moves {
+		if dir := filepath.Dir(m.From); dir != "." {
+			os.Remove(filepath.Join(envaultDir, dir))
+		}
+	}
+
+	return backupDir, moves, nil
+}
+
+// planLayout lists the moves for a layout and each environment's new
+// encrypted_file, refusing to overwrite anything
+func planLayout(cfg *config.Config, envaultDir, layout string) ([]Move, map[string]string, error) {
+	envNames := make([]string, 0, len(cfg.Environments))
+	for name := range cfg.Environments {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+
+	var moves []Move
+	files := map[string]string{}
+	for _, envName := range envNames {
+		env := cfg.Environments[envName]
+
+		to, err := config.LayoutFile(layout, envName)
+		if err != nil {
+			return nil, nil, err
+		}
+		files[envName] = to
+		if filepath.Clean(env.EncryptedFile) == filepath.Clean(to) {
+			continue
+		}
+
+		moves = append(moves, Move{Env: envName, From: env.EncryptedFile, To: to})
+		if env.NotesFile == "" {
+			moved := config.Environment{EncryptedFile: to}
+			moves = append(moves, Move{Env: envName, From: env.NotesFileName(), To: moved.NotesFileName()})
+		}
+	}
+
+	// Only move what exists, and never onto an existing file
+	var existing []Move
+	for _, m := range moves {
+		if _, err := os.Stat(filepath.Join(envaultDir, m.From)); os.IsNotExist(err) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(envaultDir, m.To)); err == nil {
+			return nil, nil, fmt.Errorf("cannot move %s to %s: destination exists", m.From, m.To)
+		}
+		existing = append(existing, m)
+	}
+
+	return existing, files, nil
+}
+
+// setLayout sets the top-level layout key and every environment's
+// encrypted_file in config.yaml
+func setLayout(data []byte, layout string, files map[string]string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a YAML mapping")
+	}
+	root := doc.Content[0]
+
+	if mappingValue(root, "layout") == nil {
+		insertAfterKey(root, "version", "layout", layout)
+	} else {
+		setMappingKey(root, "layout", layout)
+	}
+
+	environments := mappingValue(root, "environments")
+	if environments == nil || environments.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("environments is not a mapping")
+	}
+	for envName, file := range files {
+		env := mappingValue(environments, envName)
+		if env == nil || env.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("environment %s is not a mapping", envName)
+		}
+		setMappingKey(env, "encrypted_file", file)
+	}
+
+	return encodeYAML(&doc)
+}
+
+// mappingValue returns the value node for key in a mapping, or nil
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingKey sets a scalar in a mapping, appending it if absent
+func setMappingKey(mapping *yaml.Node, key, value string) {
+	if node := mappingValue(mapping, key); node != nil {
+		node.Kind = yaml.ScalarNode
+		node.Value = value
+		node.Tag = ""
+		return
+	}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Value: value},
+	)
+}
+
+// insertAfterKey adds a scalar to a mapping just after an existing key,
+// or at the end
+func insertAfterKey(mapping *yaml.Node, after, key, value string) {
+	pair := []*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: key},
+		{Kind: yaml.ScalarNode, Value: value},
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == after {
+			rest := append(pair, mapping.Content[i+2:]...)
+			mapping.Content = append(mapping.Content[:i+2], rest...)
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, pair...)
+}
+
+// sameLayout reports whether the configuration already records the layout
+// and its file names
+func sameLayout(cfg *config.Config, layout string, files map[string]string) bool {
+	if cfg.Layout != layout && !(cfg.Layout == "" && layout == config.LayoutFlat) {
+		return false
+	}
+	for envName, file := range files {
+		if cfg.Environments[envName].EncryptedFile != file {
+			return false
+		}
+	}
+	return true
+}
+
+func move(envaultDir, from, to string, dirMode os.FileMode) error {
+	dst := filepath.Join(envaultDir, to)
+	if err := os.MkdirAll(filepath.Dir(dst), dirMode); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(to), err)
+	}
+	if err := os.Rename(filepath.Join(envaultDir, from), dst); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", from, to, err)
+	}
+	return nil
+}
+
+// undo reverses completed moves after a failure
+func undo(envaultDir string, moves []Move) {
+	for i := len(moves) - 1; i >= 0; i-- {
+		os.Rename(filepath.Join(envaultDir, moves[i].To), filepath.Join(envaultDir, moves[i].From))
+	}
+}
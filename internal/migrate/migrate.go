@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// Step upgrades the .envault directory from one layout version to the next
+type Step struct {
+	From        int
+	Description string
+	Apply       func(envaultDir string) error
+}
+
+// steps lists every migration in order. Step i upgrades version i to i+1.
+var steps = []Step{
+	{
+		From:        0,
+		Description: "add version field to config.yaml and manifest.json",
+		Apply:       addVersionFields,
+	},
+}
+
+// backupFiles are copied before any migration runs
+var backupFiles = []string{"config.yaml", "manifest.json", "authorized_keys", "schema.yaml", "schema.yaml.age"}
+
+// Pending returns the steps needed to bring the given version up to date
+func Pending(version int) []Step {
+	var pending []Step
+	for _, s := range steps {
+		if s.From >= version {
+			pending = append(pending, s)
+		}
+	}
+	return pending
+}
+
+// CurrentVersion reads the layout version from config.yaml
+func CurrentVersion() (int, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Version, nil
+}
+
+// Run backs up the .envault metadata files and applies all pending steps.
+// Returns the backup directory and the steps applied.
+func Run() (string, []Step, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return "", nil, err
+	}
+
+	version, err := CurrentVersion()
+	if err != nil {
+		return "", nil, err
+	}
+
+	pending := Pending(version)
+	if len(pending) == 0 {
+		return "", nil, nil
+	}
+
+	backupDir, err := backup(envaultDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to back up .envault: %w", err)
+	}
+
+	for i, s := range pending {
+		if err := s.Apply(envaultDir); err != nil {
+			return backupDir, pending[:i], fmt.Errorf("migration from version %d failed (backup in %s): %w", s.From, backupDir, err)
+		}
+	}
+
+	return backupDir, pending, nil
+}
+
+// backup copies metadata files into .envault/backups/<timestamp>
+func backup(envaultDir string) (string, error) {
+	backupDir := filepath.Join(envaultDir, "backups", time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return "", err
+	}
+
+	for _, name := range backupFiles {
+		data, err := os.ReadFile(filepath.Join(envaultDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(backupDir, name), data, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	return backupDir, nil
+}
+
+// addVersionFields sets version: 1 at the top of config.yaml (preserving
+// comments and key order) and stamps manifest.json
+func addVersionFields(envaultDir string) error {
+	configPath := filepath.Join(envaultDir, "config.yaml")
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config.yaml: %w", err)
+	}
+
+	updated, err := setTopLevelKey(data, "version", "1")
+	if err != nil {
+		return fmt.Errorf("failed to update config.yaml: %w", err)
+	}
+	if err := os.WriteFile(configPath, updated, 0644); err != nil {
+		return fmt.Errorf("failed to write config.yaml: %w", err)
+	}
+
+	manifestPath := filepath.Join(envaultDir, "manifest.json")
+	data, err = os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read manifest.json: %w", err)
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("failed to parse manifest.json: %w", err)
+	}
+	m["version"] = 1
+
+	data, err = json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	return os.WriteFile(manifestPath, append(data, '\n'), 0644)
+}
+
+// setTopLevelKey sets a scalar key in a YAML mapping document, inserting it
+// first if absent
+func setTopLevelKey(data []byte, key, value string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a YAML mapping")
+	}
+
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == key {
+			root.Content[i+1].Value = value
+			root.Content[i+1].Tag = ""
+			return encodeYAML(&doc)
+		}
+	}
+
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: key}
+	valueNode := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+
+	// Keep a leading file comment at the top of the document
+	if len(root.Content) > 0 {
+		keyNode.HeadComment = root.Content[0].HeadComment
+		root.Content[0].HeadComment = ""
+	}
+	root.Content = append([]*yaml.Node{keyNode, valueNode}, root.Content...)
+
+	return encodeYAML(&doc)
+}
+
+func encodeYAML(doc *yaml.Node) ([]byte, error) {
+	return yaml.Marshal(doc)
+}
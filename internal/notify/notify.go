@@ -0,0 +1,192 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/keys"
+)
+
+// Operations reported to webhooks
+const (
+	OpAddKey    = "add-key"
+	OpRemoveKey = "remove-key"
+	OpEncrypt   = "encrypt"
+	OpReencrypt = "reencrypt"
+)
+
+// httpClient bounds how long a slow webhook can delay a command
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+// Event describes a change. It never carries secret values.
+type Event struct {
+	Operation   string    `json:"operation"`
+	Environment string    `json:"environment,omitempty"`
+	Key         string    `json:"key,omitempty"`   // fingerprint of the added or removed key
+	Actor       string    `json:"actor,omitempty"` // fingerprint of the local identity
+	Repository  string    `json:"repository,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// Text returns a one-line human summary for chat webhooks
+func (e Event) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "envault %s", e.Operation)
+	if e.Environment != "" {
+		fmt.Fprintf(&b, " %s", e.Environment)
+	}
+	if e.Key != "" {
+		fmt.Fprintf(&b, " key %s", e.Key)
+	}
+	if e.Repository != "" {
+		fmt.Fprintf(&b, " in %s", e.Repository)
+	}
+	if e.Actor != "" {
+		fmt.Fprintf(&b, " by %s", e.Actor)
+	}
+	return b.String()
+}
+
+// Send posts an event to every configured webhook subscribed to its
+// operation. Delivery is best effort; the returned error lists failures.
+func Send(cfg *config.Config, event Event) error {
+	if len(cfg.Notifications.Webhooks) == 0 {
+		return nil
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.Actor == "" {
+		event.Actor = actor()
+	}
+	if event.Repository == "" {
+		event.Repository = repository()
+	}
+
+	var errs []error
+	for _, hook := range cfg.Notifications.Webhooks {
+		if !subscribed(hook, event.Operation) {
+			continue
+		}
+		if err := post(hook, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Destinations returns where Send would post an operation, with each URL
+// reduced to its host or named by its variable, e.g. for --dry-run
+func Destinations(cfg *config.Config, operation string) []string {
+	var destinations []string
+	for _, hook := range cfg.Notifications.Webhooks {
+		if !subscribed(hook, operation) {
+			continue
+		}
+		if hook.URLEnv != "" {
+			destinations = append(destinations, "$"+hook.URLEnv)
+		} else {
+			destinations = append(destinations, redact(hook.URL))
+		}
+	}
+	return destinations
+}
+
+func subscribed(hook config.Webhook, operation string) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == operation {
+			return true
+		}
+	}
+	return false
+}
+
+func post(hook config.Webhook, event Event) error {
+	url := hook.URL
+	if hook.URLEnv != "" {
+		url = os.Getenv(hook.URLEnv)
+		if url == "" {
+			return fmt.Errorf("webhook %s is not set", hook.URLEnv)
+		}
+	}
+	if url == "" {
+		return fmt.Errorf("webhook has no url or url_env")
+	}
+
+	var payload any
+	switch hook.Format {
+	case "", "generic":
+		payload = event
+	case "slack", "teams":
+		// Both accept a minimal {"text": ...} message
+		payload = map[string]string{"text": event.Text()}
+	default:
+		return fmt.Errorf("unknown webhook format %q (use generic, slack, or teams)", hook.Format)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The URL may itself be a secret; report the host only
+		return fmt.Errorf("webhook %s: request failed", redact(url))
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s: %s", redact(url), resp.Status)
+	}
+	return nil
+}
+
+// redact strips the path (often carrying a token) from a webhook URL
+func redact(url string) string {
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok {
+		return "(invalid url)"
+	}
+	host, _, _ := strings.Cut(rest, "/")
+	return scheme + "://" + host
+}
+
+// actor returns the fingerprint of the local SSH identity, if any
+func actor() string {
+	privateKey, err := crypto.FindSSHPrivateKey()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(privateKey + ".pub")
+	if err != nil {
+		return ""
+	}
+	key, err := keys.ParseKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		return ""
+	}
+	return key.Fingerprint
+}
+
+// repository names the project by its directory
+func repository() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(cwd)
+}
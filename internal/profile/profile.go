@@ -0,0 +1,202 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// EnvVar selects a profile by name, like --profile
+const EnvVar = "ENVAULT_PROFILE"
+
+// File is the per-user config.yaml in <user config dir>/envault. Unlike
+// .envault/config.yaml it is never committed: it describes this machine.
+type File struct {
+	// Profiles are tried in order; the first whose hosts match is active
+	Profiles []Profile `yaml:"profiles"`
+
+	// AgeBinary is the age executable for every profile that does not set
+	// its own. It lives here rather than in .envault/config.yaml because
+	// it chooses a program run with plaintext on its stdin.
+	AgeBinary string `yaml:"age_binary,omitempty"`
+}
+
+// Profile holds the settings for one kind of machine, e.g. a work laptop
+// or CI
+type Profile struct {
+	Name  string   `yaml:"name"`
+	Hosts []string `yaml:"hosts,omitempty"` // hostname globs, e.g. "corp-*"
+
+	// Identity is the identity file to decrypt with; ENVAULT_IDENTITY
+	// still wins
+	Identity string `yaml:"identity,omitempty"`
+
+	// Identities is this machine's decryption chain, used for environments
+	// whose config.yaml entry does not set its own
+	Identities []string `yaml:"identities,omitempty"`
+
+	// Cache keeps decrypted environments in memory: load decrypts them
+	// together up front and serve/agent answer from memory until the
+	// ciphertext changes. Defaults to true.
+	Cache *bool `yaml:"cache,omitempty"`
+
+	// DefaultEnvironments are loaded by envault load without arguments
+	DefaultEnvironments []string `yaml:"default_environments,omitempty"`
+
+	// AgeBinary overrides the file's age_binary on this kind of machine
+	AgeBinary string `yaml:"age_binary,omitempty"`
+}
+
+// Caches reports whether decrypted environments may be kept in memory
+func (p *Profile) Caches() bool {
+	return p == nil || p.Cache == nil || *p.Cache
+}
+
+// IdentityPath returns Identity with a leading ~ expanded
+func (p *Profile) IdentityPath() string {
+	if p == nil || p.Identity == "" {
+		return ""
+	}
+	return ExpandHome(p.Identity)
+}
+
+// ExpandHome expands a leading ~/ to the home directory
+func ExpandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// active is the profile chosen by Activate, nil when none applies, and
+// source says why it was chosen. ageBinary is the file's age_binary.
+var (
+	active    *Profile
+	source    string
+	ageBinary string
+)
+
+// Current returns the active profile, or nil
+func Current() *Profile {
+	return active
+}
+
+// AgeBinary returns the age executable the user configured, the active
+// profile's first, with a leading ~ expanded; "" when none is set
+func AgeBinary() string {
+	if active != nil && active.AgeBinary != "" {
+		return ExpandHome(active.AgeBinary)
+	}
+	if ageBinary != "" {
+		return ExpandHome(ageBinary)
+	}
+	return ""
+}
+
+// Source describes how the active profile was chosen, e.g. "--profile"
+func Source() string {
+	return source
+}
+
+// Path returns the location of the per-user config file
+func Path() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config directory: %w", err)
+	}
+	return filepath.Join(configDir, "envault", "config.yaml"), nil
+}
+
+// Load reads the per-user config file; a missing file has no profiles
+func Load() (*File, error) {
+	configPath, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return &File{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+	seen := map[string]bool{}
+	for i, p := range f.Profiles {
+		if p.Name == "" {
+			return nil, fmt.Errorf("%s: profile %d has no name", configPath, i+1)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("%s: profile %s is defined twice", configPath, p.Name)
+		}
+		seen[p.Name] = true
+		for _, pattern := range p.Hosts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%s: profile %s: invalid host pattern %q", configPath, p.Name, pattern)
+			}
+		}
+		if err := config.ValidateIdentities(p.Identities); err != nil {
+			return nil, fmt.Errorf("%s: profile %s: identities: %w", configPath, p.Name, err)
+		}
+	}
+	return &f, nil
+}
+
+// Activate selects the profile for this process: the named one (from
+// --profile, else ENVAULT_PROFILE), or the first whose hosts match the
+// hostname. Naming a profile that does not exist is an error.
+func Activate(name string) error {
+	source = "--profile"
+	if name == "" {
+		name, source = os.Getenv(EnvVar), EnvVar
+	}
+
+	f, err := Load()
+	if err != nil {
+		return err
+	}
+	ageBinary = f.AgeBinary
+
+	if name != "" {
+		for i := range f.Profiles {
+			if f.Profiles[i].Name == name {
+				active = &f.Profiles[i]
+				return nil
+			}
+		}
+		return fmt.Errorf("profile %s is not defined in the user config (envault profile list)", name)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil
+	}
+	active, source = Match(f.Profiles, hostname), "hostname "+hostname
+	return nil
+}
+
+// Match returns the first profile with a host pattern matching hostname,
+// compared case-insensitively, or nil
+func Match(profiles []Profile, hostname string) *Profile {
+	hostname = strings.ToLower(hostname)
+	for i, p := range profiles {
+		for _, pattern := range p.Hosts {
+			if ok, _ := path.Match(strings.ToLower(pattern), hostname); ok {
+				return &profiles[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/state"
+)
+
+// ErrConflict means the remote no longer holds the copy a local change was
+// made on, so uploading it would overwrite someone else's ciphertext
+var ErrConflict = errors.New("the remote changed since this copy was fetched")
+
+// Divergence compares an environment's encrypted_file and its remote with
+// the copy last fetched or pushed
+type Divergence struct {
+	URL       string
+	FetchedAt time.Time // when the recorded copy was fetched or pushed; zero if never
+
+	NoLocal       bool // there is no encrypted_file; the next read fetches it
+	LocalChanged  bool // encrypted_file is not the recorded copy
+	RemoteChanged bool // the remote holds something other than the recorded copy
+	RemoteMissing bool // the remote has no copy at all
+	Checked       bool // the remote was asked; false with Compare(..., true)
+}
+
+// Conflict reports whether both sides changed. Ciphertext cannot be
+// merged, so one side's change has to be made again.
+func (d *Divergence) Conflict() bool {
+	return d.LocalChanged && d.RemoteChanged
+}
+
+// Upload is a local change waiting to be pushed, with the precondition it
+// is sent with
+type Upload struct {
+	URL       string
+	Path      string // encrypted_file
+	Condition string // "If-Match", "If-None-Match" or "" when forced
+	ETag      string // the value for Condition
+
+	envName string
+	remote  *config.Remote
+	data    []byte
+}
+
+// Describe renders the precondition, e.g. If-Match: "abc"
+func (u *Upload) Describe() string {
+	if u.Condition == "" {
+		return "unconditionally (--force)"
+	}
+	return u.Condition + ": " + u.ETag
+}
+
+// lookup resolves an environment's remote and cache, and the record of the
+// copy last fetched or pushed when it is for the same URL
+func lookup(cfg *config.Config, envName string) (*config.Remote, string, *state.Remote, error) {
+	r := cfg.RemoteFor(envName)
+	if r == nil {
+		return nil, "", nil, fmt.Errorf("environment %s has no remote", envName)
+	}
+	cachePath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	st, err := state.Load()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	record := st.Remotes[envName]
+	if record != nil && record.URL != r.URL {
+		record = nil
+	}
+	return r, cachePath, record, nil
+}
+
+// Compare reports how an environment's encrypted_file and its remote have
+// moved since the copy last fetched or pushed. Offline, only the local
+// side is compared.
+func Compare(cfg *config.Config, envName string, offline bool) (*Divergence, error) {
+	r, cachePath, record, err := lookup(cfg, envName)
+	if err != nil {
+		return nil, err
+	}
+	d := &Divergence{URL: r.URL}
+	etag := ""
+	if record != nil {
+		d.FetchedAt = record.FetchedAt
+		etag = record.ETag
+	}
+
+	localHash := ""
+	local, err := os.ReadFile(cachePath)
+	switch {
+	case os.IsNotExist(err):
+		d.NoLocal = true
+	case err != nil:
+		return nil, fmt.Errorf("failed to read %s: %w", cachePath, err)
+	default:
+		localHash = state.Hash(local)
+		d.LocalChanged = record == nil || localHash != record.SHA256
+	}
+	if offline {
+		return d, nil
+	}
+
+	remoteHash, remoteETag, unchanged, err := probe(r, etag)
+	if err != nil {
+		return nil, err
+	}
+	d.Checked = true
+	switch {
+	case unchanged:
+	case remoteHash == "":
+		d.RemoteMissing = true
+		d.RemoteChanged = record != nil
+	default:
+		// A new ETag means the copy was rewritten, and a push against the
+		// recorded one would be refused, even if the bytes are the same
+		d.RemoteChanged = record == nil || remoteHash != record.SHA256 || (etag != "" && remoteETag != etag)
+	}
+	// The same bytes on both sides are in sync, whatever was recorded
+	if remoteHash != "" && remoteHash == localHash {
+		d.LocalChanged, d.RemoteChanged = false, false
+	}
+	return d, nil
+}
+
+// probe asks the remote for its copy without caching it, returning its
+// hash and ETag. It reports unchanged when the server answers 304 for
+// etag, and an empty hash when the remote has no copy.
+func probe(r *config.Remote, etag string) (string, string, bool, error) {
+	req, err := newRequest(r, http.MethodGet, nil)
+	if err != nil {
+		return "", "", false, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	client := &http.Client{Timeout: Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return "", etag, true, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return "", "", false, nil
+	case resp.StatusCode != http.StatusOK:
+		return "", "", false, fmt.Errorf("server returned %s", resp.Status)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", "", false, fmt.Errorf("failed to read %s: %w", r.URL, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), resp.Header.Get("ETag"), false, nil
+}
+
+// Pending returns the upload that would publish an environment's local
+// change, or nil when encrypted_file is the copy last fetched or pushed.
+// The upload only lands if the remote still holds that copy (If-Match
+// with its ETag), or holds nothing when there is no record of one
+// (If-None-Match: *). A server that sent no ETag cannot be checked, so
+// that needs force, as does overwriting a conflicting change.
+func Pending(cfg *config.Config, envName string, force bool) (*Upload, error) {
+	r, cachePath, record, err := lookup(cfg, envName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(cachePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", cachePath, err)
+	}
+	if record != nil && state.Hash(data) == record.SHA256 {
+		return nil, nil
+	}
+
+	u := &Upload{URL: r.URL, Path: cachePath, envName: envName, remote: r, data: data}
+	switch {
+	case force:
+	case record == nil:
+		u.Condition, u.ETag = "If-None-Match", "*"
+	case record.ETag == "":
+		return nil, fmt.Errorf("%s sent no ETag for the copy last fetched, so an upload cannot be checked against it (--force uploads anyway)", r.URL)
+	default:
+		u.Condition, u.ETag = "If-Match", record.ETag
+	}
+	return u, nil
+}
+
+// Send uploads the change with PUT and records it as the copy the remote
+// holds. It returns ErrConflict when the server answers 412 Precondition
+// Failed, leaving both copies as they are.
+func (u *Upload) Send() error {
+	req, err := newRequest(u.remote, http.MethodPut, bytes.NewReader(u.data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if u.Condition != "" {
+		req.Header.Set(u.Condition, u.ETag)
+	}
+
+	client := &http.Client{Timeout: Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed && u.Condition == "If-None-Match":
+		return fmt.Errorf("%w: %s already has a copy this one was not made from", ErrConflict, u.URL)
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %s no longer holds %s", ErrConflict, u.URL, u.ETag)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	st, err := state.Load()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if st.Remotes == nil {
+		st.Remotes = map[string]*state.Remote{}
+	}
+	st.Remotes[u.envName] = &state.Remote{URL: u.URL, ETag: resp.Header.Get("ETag"), SHA256: state.Hash(u.data), FetchedAt: now, CheckedAt: now}
+	if err := st.Save(); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	delete(refreshed, u.envName)
+	mu.Unlock()
+	return nil
+}
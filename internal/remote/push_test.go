@@ -0,0 +1,277 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// store is an object store that honors If-Match and If-None-Match
+type store struct {
+	mu      sync.Mutex
+	data    []byte
+	version int
+	noETag  bool
+}
+
+func (s *store) etag() string {
+	if s.noETag || s.data == nil {
+		return ""
+	}
+	return fmt.Sprintf(`"v%d"`, s.version)
+}
+
+func (s *store) put(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = data
+	s.version++
+}
+
+func (s *store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		if s.data == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if etag := s.etag(); etag != "" {
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Write(s.data)
+	case http.MethodPut:
+		if m := r.Header.Get("If-Match"); m != "" && (s.data == nil || m != s.etag()) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && s.data != nil {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.data = data
+		s.version++
+		if etag := s.etag(); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// project makes a temporary project whose prod environment is fetched
+// from srv, and returns its config and the path of the cached copy
+func project(t *testing.T, srv *httptest.Server) (*config.Config, string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ".envault"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(cwd)
+		mu.Lock()
+		refreshed = map[string]*Status{}
+		mu.Unlock()
+	})
+
+	cfg := &config.Config{Environments: map[string]config.Environment{
+		"prod": {EncryptedFile: "prod.age", Remote: &config.Remote{URL: srv.URL + "/prod.age"}},
+	}}
+	return cfg, filepath.Join(dir, ".envault", "prod.age")
+}
+
+func compare(t *testing.T, cfg *config.Config) *Divergence {
+	t.Helper()
+	d, err := Compare(cfg, "prod", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestPushIsConditional(t *testing.T) {
+	s := &store{}
+	s.put([]byte("first"))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	cfg, cachePath := project(t, srv)
+
+	if _, err := Refresh(cfg, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if d := compare(t, cfg); d.LocalChanged || d.RemoteChanged {
+		t.Fatalf("after fetching: %+v, want in sync", d)
+	}
+	if u, err := Pending(cfg, "prod", false); err != nil || u != nil {
+		t.Fatalf("Pending after fetching = %+v, %v; want nothing to push", u, err)
+	}
+
+	// A local change is pushed against the ETag it was made from
+	if err := os.WriteFile(cachePath, []byte("mine"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d := compare(t, cfg); !d.LocalChanged || d.RemoteChanged {
+		t.Fatalf("after a local change: %+v, want only LocalChanged", d)
+	}
+	u, err := Pending(cfg, "prod", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Condition != "If-Match" || u.ETag != `"v1"` {
+		t.Fatalf("upload sent with %s, want If-Match: \"v1\"", u.Describe())
+	}
+
+	// Another laptop pushes first: the upload is refused and nothing changes
+	s.put([]byte("theirs"))
+	if d := compare(t, cfg); !d.Conflict() {
+		t.Fatalf("after both changed: %+v, want a conflict", d)
+	}
+	if err := u.Send(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Send over a newer copy: got %v, want ErrConflict", err)
+	}
+	if string(s.data) != "theirs" {
+		t.Fatalf("remote holds %q after a refused push, want %q", s.data, "theirs")
+	}
+
+	// --force replaces it, and records the new copy
+	u, err = Pending(cfg, "prod", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if string(s.data) != "mine" {
+		t.Fatalf("remote holds %q after a forced push, want %q", s.data, "mine")
+	}
+	if d := compare(t, cfg); d.LocalChanged || d.RemoteChanged {
+		t.Fatalf("after pushing: %+v, want in sync", d)
+	}
+	if u, err := Pending(cfg, "prod", false); err != nil || u != nil {
+		t.Fatalf("Pending after pushing = %+v, %v; want nothing to push", u, err)
+	}
+
+	s.put([]byte("newer"))
+	if d := compare(t, cfg); d.LocalChanged || !d.RemoteChanged {
+		t.Fatalf("after a remote change: %+v, want only RemoteChanged", d)
+	}
+}
+
+func TestRewriteUnderNewETag(t *testing.T) {
+	s := &store{}
+	s.put([]byte("first"))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	cfg, cachePath := project(t, srv)
+
+	if _, err := Refresh(cfg, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cachePath, []byte("mine"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u, err := Pending(cfg, "prod", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The bytes are the same, but the push would be refused, so say so
+	s.put([]byte("first"))
+	if d := compare(t, cfg); !d.Conflict() {
+		t.Fatalf("after a rewrite under a new ETag: %+v, want a conflict", d)
+	}
+	if err := u.Send(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Send after a rewrite: got %v, want ErrConflict", err)
+	}
+}
+
+func TestPushNeverFetched(t *testing.T) {
+	s := &store{}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	cfg, cachePath := project(t, srv)
+
+	if err := os.WriteFile(cachePath, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := compare(t, cfg)
+	if !d.LocalChanged || !d.RemoteMissing || d.Conflict() {
+		t.Fatalf("before the first push: %+v, want a local change and no remote copy", d)
+	}
+
+	u, err := Pending(cfg, "prod", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Condition != "If-None-Match" || u.ETag != "*" {
+		t.Fatalf("first upload sent with %s, want If-None-Match: *", u.Describe())
+	}
+
+	// Someone else creates it first
+	s.put([]byte("theirs"))
+	if err := u.Send(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Send over an existing copy: got %v, want ErrConflict", err)
+	}
+	if d := compare(t, cfg); !d.Conflict() {
+		t.Fatalf("with a different remote copy: %+v, want a conflict", d)
+	}
+
+	// Identical bytes are in sync even without a record
+	if err := os.WriteFile(cachePath, []byte("theirs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d := compare(t, cfg); d.LocalChanged || d.RemoteChanged {
+		t.Fatalf("with identical copies: %+v, want in sync", d)
+	}
+}
+
+func TestPushWithoutETag(t *testing.T) {
+	s := &store{noETag: true}
+	s.put([]byte("first"))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	cfg, cachePath := project(t, srv)
+
+	if _, err := Refresh(cfg, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cachePath, []byte("mine"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Pending(cfg, "prod", false); err == nil {
+		t.Fatal("Pending without an ETag succeeded, want an error")
+	}
+	u, err := Pending(cfg, "prod", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Condition != "" {
+		t.Fatalf("forced upload sent with %s, want no precondition", u.Describe())
+	}
+}
@@ -0,0 +1,240 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/fsutil"
+	"github.com/orchard9/envault/internal/state"
+)
+
+// Timeout bounds a fetch, so an unreachable remote falls back to the
+// cached copy quickly
+const Timeout = 10 * time.Second
+
+// Status says which copy of an environment's ciphertext is in use
+type Status struct {
+	URL       string
+	FetchedAt time.Time // when the copy in encrypted_file was fetched or pushed
+	Fetched   bool      // a new copy was downloaded just now
+
+	// Err is why the remote could not be reached; the cached copy is used
+	Err error
+
+	// Modified is set when encrypted_file differs from what was fetched,
+	// e.g. after envault encrypt, so it was kept rather than replaced
+	Modified bool
+
+	reported bool
+}
+
+// Warning describes a copy that may be out of date, or "" when the
+// remote confirmed it
+func (s *Status) Warning(envName string) string {
+	switch {
+	case s.Modified && s.FetchedAt.IsZero():
+		return fmt.Sprintf("%s: using local ciphertext that was not fetched from %s, and not replacing it (push it with envault sync push, or delete it to fetch)", envName, s.URL)
+	case s.Modified:
+		return fmt.Sprintf("%s: local ciphertext differs from what was fetched from %s; using it and not replacing it (push it with envault sync push, or delete it to fetch again)", envName, s.URL)
+	case s.Err != nil:
+		return fmt.Sprintf("%s: using a cached copy fetched %s ago; %s is unreachable: %v", envName, Age(time.Since(s.FetchedAt)), s.URL, s.Err)
+	}
+	return ""
+}
+
+// Report returns Warning the first time it is called and "" after, so a
+// stale copy is reported once per process
+func (s *Status) Report(envName string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	if s.reported {
+		return ""
+	}
+	s.reported = true
+	return s.Warning(envName)
+}
+
+var (
+	mu        sync.Mutex
+	refreshed = map[string]*Status{} // by environment, once per process
+)
+
+// Refresh brings an environment's encrypted_file up to date from its
+// remote, at most once per process. When the remote cannot be reached,
+// an earlier copy is used and the status says how old it is. Without a
+// remote it returns nil.
+func Refresh(cfg *config.Config, envName string) (*Status, error) {
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		return nil, err
+	}
+	// env is a copy; give it the remote inherited from its root, if any
+	if env.Remote = cfg.RemoteFor(envName); env.Remote == nil {
+		return nil, nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if s, ok := refreshed[envName]; ok {
+		return s, nil
+	}
+
+	s, err := refresh(cfg, envName, env)
+	if err != nil {
+		return nil, err
+	}
+	refreshed[envName] = s
+	return s, nil
+}
+
+func refresh(cfg *config.Config, envName string, env *config.Environment) (*Status, error) {
+	cachePath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		return nil, err
+	}
+	st, err := state.Load()
+	if err != nil {
+		return nil, err
+	}
+	record := st.Remotes[envName]
+	if record != nil && record.URL != env.Remote.URL {
+		record = nil
+	}
+
+	cached, err := os.ReadFile(cachePath)
+	hasCache := err == nil
+	if hasCache && (record == nil || state.Hash(cached) != record.SHA256) {
+		s := &Status{URL: env.Remote.URL, Modified: true}
+		if record != nil {
+			s.FetchedAt = record.FetchedAt
+		}
+		return s, nil
+	}
+
+	etag := ""
+	if hasCache {
+		etag = record.ETag
+	}
+	fetched, err := fetch(cfg, env, cachePath, etag)
+	if err != nil {
+		if !hasCache {
+			return nil, fmt.Errorf("failed to fetch %s from %s, and there is no cached copy: %w", envName, env.Remote.URL, err)
+		}
+		return &Status{URL: env.Remote.URL, FetchedAt: record.FetchedAt, Err: err}, nil
+	}
+
+	now := time.Now().UTC()
+	if fetched == nil {
+		record.CheckedAt = now
+	} else {
+		record = &state.Remote{URL: env.Remote.URL, ETag: fetched.etag, SHA256: fetched.sha256, FetchedAt: now, CheckedAt: now}
+	}
+	if st.Remotes == nil {
+		st.Remotes = map[string]*state.Remote{}
+	}
+	st.Remotes[envName] = record
+	if err := st.Save(); err != nil {
+		return nil, err
+	}
+
+	// The cache is this machine's copy; the remote is the source of truth
+	dir, err := cfg.RootDir(env.Root)
+	if err != nil {
+		return nil, err
+	}
+	if err := state.EnsureIgnored(dir, filepath.ToSlash(env.EncryptedFile)); err != nil {
+		return nil, fmt.Errorf("failed to update .gitignore: %w", err)
+	}
+
+	return &Status{URL: env.Remote.URL, FetchedAt: record.FetchedAt, Fetched: fetched != nil}, nil
+}
+
+type download struct {
+	etag   string
+	sha256 string
+}
+
+// fetch downloads the ciphertext into cachePath, replacing it atomically.
+// It returns nil when the server answers 304 for etag.
+func fetch(cfg *config.Config, env *config.Environment, cachePath, etag string) (*download, error) {
+	req, err := newRequest(env.Remote, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	client := &http.Client{Timeout: Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), cfg.DirMode()); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(cachePath), err)
+	}
+	hash := sha256.New()
+	err = fsutil.WriteAtomic(cachePath, cfg.FileMode(), func(w io.Writer) error {
+		if _, err := io.Copy(io.MultiWriter(w, hash), resp.Body); err != nil {
+			return fmt.Errorf("failed to download: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &download{etag: resp.Header.Get("ETag"), sha256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// newRequest builds a request to a remote, with its bearer token if it
+// has one
+func newRequest(r *config.Remote, method string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, r.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	if r.AuthEnv != "" {
+		token := os.Getenv(r.AuthEnv)
+		if token == "" {
+			return nil, fmt.Errorf("%s is not set", r.AuthEnv)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// Age renders a duration as a rough age, e.g. "3 hours"
+func Age(d time.Duration) string {
+	unit := func(n int, name string) string {
+		if n == 1 {
+			return "1 " + name
+		}
+		return fmt.Sprintf("%d %ss", n, name)
+	}
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < time.Hour:
+		return unit(int(d/time.Minute), "minute")
+	case d < 48*time.Hour:
+		return unit(int(d/time.Hour), "hour")
+	}
+	return unit(int(d/(24*time.Hour)), "day")
+}
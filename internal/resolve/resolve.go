@@ -0,0 +1,294 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orchard9/envault/internal/dotenv"
+)
+
+// PluginPrefix names external providers: a reference with scheme foo is
+// resolved by running envault-provider-foo <reference> from PATH and
+// reading the value from stdout
+const PluginPrefix = "envault-provider-"
+
+// timeout bounds a single provider call
+const timeout = 30 * time.Second
+
+// Provider fetches the value a reference points to from another secret
+// manager. Built-in providers shell out to the manager's own CLI.
+type Provider interface {
+	// Resolve returns the value for a reference such as op://vault/item/field
+	Resolve(ctx context.Context, ref string) (string, error)
+
+	// Available reports whether the provider can run on this machine
+	Available() error
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{}
+)
+
+func init() {
+	Register("op", onePassword{})
+	Register("aws-sm", awsSecretsManager{})
+	Register("vault", hashicorpVault{})
+}
+
+// Register makes a provider available for a reference scheme
+func Register(scheme string, p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[scheme] = p
+}
+
+var referencePattern = regexp.MustCompile(`^([a-z][a-z0-9+.-]*)://\S+$`)
+
+// lookup returns the provider for a value's scheme, if the value is a
+// reference envault can resolve. Values with other schemes, such as
+// postgres:// URLs, are left alone.
+func lookup(value string) (string, Provider, bool) {
+	m := referencePattern.FindStringSubmatch(value)
+	if m == nil {
+		return "", nil, false
+	}
+	scheme := m[1]
+
+	providersMu.RLock()
+	p, ok := providers[scheme]
+	providersMu.RUnlock()
+	if ok {
+		return scheme, p, true
+	}
+	if _, err := exec.LookPath(PluginPrefix + scheme); err == nil {
+		return scheme, plugin{scheme: scheme}, true
+	}
+	return "", nil, false
+}
+
+// IsReference reports whether a value is resolved by a provider
+func IsReference(value string) bool {
+	_, _, ok := lookup(value)
+	return ok
+}
+
+// Values replaces references in values with what they point to. Each
+// distinct reference is fetched once.
+func Values(values map[string]string) error {
+	resolved := map[string]string{}
+	for _, key := range sortedKeys(values) {
+		ref := values[key]
+		scheme, p, ok := lookup(ref)
+		if !ok {
+			continue
+		}
+		value, done := resolved[ref]
+		if !done {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			var err error
+			value, err = p.Resolve(ctx, ref)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("%s: cannot resolve %s reference: %w", key, scheme, err)
+			}
+			resolved[ref] = value
+		}
+		values[key] = value
+	}
+	return nil
+}
+
+// Plaintext resolves the references in dotenv plaintext, rewriting only
+// the assignments that held one. Plaintext without references is
+// returned as is.
+func Plaintext(plaintext []byte) ([]byte, error) {
+	values, err := dotenv.ParseMap(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	refs := map[string]string{}
+	for key, value := range values {
+		if IsReference(value) {
+			refs[key] = value
+		}
+	}
+	if len(refs) == 0 {
+		return plaintext, nil
+	}
+
+	if err := Values(refs); err != nil {
+		return nil, err
+	}
+	return dotenv.ReplaceValues(plaintext, refs)
+}
+
+// Entries resolves references in the values of dotenv entries
+func Entries(entries []dotenv.Entry) error {
+	values := map[string]string{}
+	for _, e := range entries {
+		if IsReference(e.Value) {
+			values[e.Key] = e.Value
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	if err := Values(values); err != nil {
+		return err
+	}
+	for i, e := range entries {
+		if value, ok := values[e.Key]; ok {
+			entries[i].Value = value
+		}
+	}
+	return nil
+}
+
+// Check reports references whose provider cannot run here, without
+// fetching anything
+func Check(values map[string]string) []error {
+	var errs []error
+	for _, key := range sortedKeys(values) {
+		scheme, p, ok := lookup(values[key])
+		if !ok {
+			continue
+		}
+		if err := p.Available(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s reference: %w", key, scheme, err))
+		}
+	}
+	return errs
+}
+
+// run executes a provider command, returning stdout without the trailing
+// newline CLIs print
+func run(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s failed: %s", name, msg)
+		}
+		return "", fmt.Errorf("%s failed: %w", name, err)
+	}
+	out := strings.TrimSuffix(stdout.String(), "\n")
+	return strings.TrimSuffix(out, "\r"), nil
+}
+
+// positional refuses a reference part that the provider's CLI would
+// parse as a flag. References live in the ciphertext, so anyone who can
+// commit a value could otherwise point the CLI, and the operator's
+// credentials, at a server of their choosing.
+func positional(what, part string) error {
+	if strings.HasPrefix(part, "-") {
+		return fmt.Errorf("%s %q starts with '-'", what, part)
+	}
+	return nil
+}
+
+func available(name, install string) error {
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("%s is not installed (%s)", name, install)
+	}
+	return nil
+}
+
+// onePassword resolves op://vault/item/field with the 1Password CLI
+type onePassword struct{}
+
+func (onePassword) Resolve(ctx context.Context, ref string) (string, error) {
+	return run(ctx, "op", "read", "--no-newline", "--", ref)
+}
+
+func (onePassword) Available() error {
+	return available("op", "https://developer.1password.com/docs/cli")
+}
+
+// awsSecretsManager resolves aws-sm://<name>[#json-key]. Without a key the
+// whole SecretString is the value.
+type awsSecretsManager struct{}
+
+func (awsSecretsManager) Resolve(ctx context.Context, ref string) (string, error) {
+	name, field, _ := strings.Cut(strings.TrimPrefix(ref, "aws-sm://"), "#")
+	if err := positional("secret name", name); err != nil {
+		return "", err
+	}
+	secret, err := run(ctx, "aws", "secretsmanager", "get-secret-value", "--secret-id="+name, "--query", "SecretString", "--output", "text")
+	if err != nil || field == "" {
+		return secret, err
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object, so #%s cannot be selected", name, field)
+	}
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %q", name, field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded), nil
+}
+
+func (awsSecretsManager) Available() error {
+	return available("aws", "https://aws.amazon.com/cli/")
+}
+
+// hashicorpVault resolves vault://<path>#<field> with the Vault CLI, which
+// reads VAULT_ADDR and VAULT_TOKEN as usual
+type hashicorpVault struct{}
+
+func (hashicorpVault) Resolve(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(strings.TrimPrefix(ref, "vault://"), "#")
+	if !ok || field == "" {
+		return "", fmt.Errorf("vault references need a field: vault://<path>#<field>")
+	}
+	if err := positional("path", path); err != nil {
+		return "", err
+	}
+	if err := positional("field", field); err != nil {
+		return "", err
+	}
+	return run(ctx, "vault", "kv", "get", "-field="+field, "--", path)
+}
+
+func (hashicorpVault) Available() error {
+	return available("vault", "https://developer.hashicorp.com/vault/install")
+}
+
+// plugin runs an envault-provider-<scheme> executable
+type plugin struct {
+	scheme string
+}
+
+func (p plugin) Resolve(ctx context.Context, ref string) (string, error) {
+	return run(ctx, PluginPrefix+p.scheme, ref)
+}
+
+func (p plugin) Available() error {
+	return available(PluginPrefix+p.scheme, "a provider plugin on PATH")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,85 @@
+package resolve
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeCLIs puts scripts named after the providers' CLIs first on PATH.
+// Each prints its arguments one per line, so the test sees the argv the
+// reference produced.
+func fakeCLIs(t *testing.T, names ...string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake CLIs are shell scripts")
+	}
+	dir := t.TempDir()
+	for _, name := range names {
+		script := "#!/bin/sh\nfor arg in \"$@\"; do echo \"$arg\"; done | tr '\\n' ' '\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestResolveArguments(t *testing.T) {
+	fakeCLIs(t, "op", "aws", "vault", PluginPrefix+"foo")
+	tests := []struct {
+		ref  string
+		argv string
+	}{
+		{"op://Engineering/Stripe/api-key", "read --no-newline -- op://Engineering/Stripe/api-key"},
+		{"op://--account=evil/item/field", "read --no-newline -- op://--account=evil/item/field"},
+		{"aws-sm://prod/db", "secretsmanager get-secret-value --secret-id=prod/db --query SecretString --output text"},
+		{"vault://secret/payments#signing_key", "kv get -field=signing_key -- secret/payments"},
+		{"foo://anything", "foo://anything"},
+		{"foo://--flag", "foo://--flag"},
+	}
+	for _, tt := range tests {
+		values := map[string]string{"KEY": tt.ref}
+		if err := Values(values); err != nil {
+			t.Errorf("%s: %v", tt.ref, err)
+			continue
+		}
+		if got := strings.TrimSpace(values["KEY"]); got != tt.argv {
+			t.Errorf("%s ran with %q, want %q", tt.ref, got, tt.argv)
+		}
+	}
+}
+
+// References come from the ciphertext, which anyone who can commit may
+// write: none of them may smuggle a flag into the provider's CLI
+func TestHostileReferences(t *testing.T) {
+	fakeCLIs(t, "op", "aws", "vault", PluginPrefix+"foo")
+	tests := []string{
+		"vault://-address=https://attacker.example#x",
+		"vault://-address=https://attacker.example#",
+		"vault://secret/app#-address=https://attacker.example",
+		"vault://--#x",
+		"aws-sm://--endpoint-url=https://attacker.example",
+		"aws-sm://-x#password",
+	}
+	for _, ref := range tests {
+		values := map[string]string{"KEY": ref}
+		err := Values(values)
+		if err == nil {
+			t.Errorf("%s resolved to %q, want an error", ref, values["KEY"])
+			continue
+		}
+		if !strings.Contains(err.Error(), "KEY") {
+			t.Errorf("%s: error %q does not name the variable", ref, err)
+		}
+	}
+
+	// A reference cannot contain whitespace, so plugins always get exactly
+	// one argument that starts with their scheme
+	for _, value := range []string{"foo://x --evil", "foo://x\n--evil", "op://a/b/c -x"} {
+		if IsReference(value) {
+			t.Errorf("%q is treated as a reference", value)
+		}
+	}
+}
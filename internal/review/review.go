@@ -0,0 +1,453 @@
+package review
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/internal/vault"
+)
+
+// Environment statuses
+const (
+	StatusAdded     = "added"
+	StatusRemoved   = "removed"
+	StatusChanged   = "changed"
+	StatusUnchanged = "unchanged"
+)
+
+// Recipient is a key an environment is encrypted to. Keys missing from
+// authorized_keys on both sides are known only by their SSH tag.
+type Recipient struct {
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Type        string `json:"type"`
+	Comment     string `json:"comment,omitempty"`
+	Tag         string `json:"tag,omitempty"`
+}
+
+func (r Recipient) String() string {
+	switch {
+	case r.Fingerprint == "":
+		return fmt.Sprintf("unknown %s key (tag %s)", r.Type, r.Tag)
+	case r.Comment == "":
+		return r.Fingerprint
+	}
+	return r.Comment + " (" + r.Fingerprint + ")"
+}
+
+// EnvReport describes how one environment changed. It names variables but
+// never holds their values.
+type EnvReport struct {
+	Environment string   `json:"environment"`
+	Status      string   `json:"status"`
+	Added       []string `json:"added,omitempty"`
+	Removed     []string `json:"removed,omitempty"`
+	Changed     []string `json:"changed,omitempty"`
+	// Opaque is set for payloads that are not dotenv, which are compared
+	// as a whole
+	Opaque bool `json:"opaque,omitempty"`
+
+	RecipientsAdded   []Recipient `json:"recipients_added,omitempty"`
+	RecipientsRemoved []Recipient `json:"recipients_removed,omitempty"`
+	// X25519 recipients are anonymous, so only their number is compared
+	AnonymousBefore int `json:"anonymous_recipients_before,omitempty"`
+	AnonymousAfter  int `json:"anonymous_recipients_after,omitempty"`
+
+	Error string `json:"error,omitempty"` // why the values could not be compared
+}
+
+// Report is the redacted difference between the vault at a base revision
+// and the working tree
+type Report struct {
+	Base         string       `json:"base"`
+	Environments []*EnvReport `json:"environments"`
+	KeysAdded    []Recipient  `json:"authorized_keys_added,omitempty"`
+	KeysRemoved  []Recipient  `json:"authorized_keys_removed,omitempty"`
+}
+
+// Failed reports whether any environment could not be compared
+func (r *Report) Failed() bool {
+	for _, e := range r.Environments {
+		if e.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// side is the vault at one revision
+type side struct {
+	cfg  *config.Config
+	keys []keys.Key
+	read func(name string) ([]byte, bool, error)
+}
+
+// Compare reports how the vault changed since base. envNames limits the
+// environments compared; by default they are every environment in either
+// revision's config.yaml. Both sides are decrypted in memory.
+func Compare(info *vault.Info, base string, envNames []string) (*Report, error) {
+	before, err := baseSide(info, base)
+	if err != nil {
+		return nil, err
+	}
+	after, err := workingSide(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(envNames) == 0 {
+		seen := map[string]bool{}
+		for _, cfg := range []*config.Config{before.cfg, after.cfg} {
+			for name := range cfg.Environments {
+				if !seen[name] {
+					seen[name] = true
+					envNames = append(envNames, name)
+				}
+			}
+		}
+	}
+	sort.Strings(envNames)
+
+	report := &Report{Base: base}
+	report.KeysAdded, report.KeysRemoved = compareKeys(before.keys, after.keys)
+
+	known := append(append([]keys.Key{}, after.keys...), before.keys...)
+	for _, envName := range envNames {
+		e, err := compareEnv(envName, before, after, known)
+		if err != nil {
+			return nil, err
+		}
+		report.Environments = append(report.Environments, e)
+	}
+	return report, nil
+}
+
+func baseSide(info *vault.Info, base string) (*side, error) {
+	data, ok, err := info.ShowFile(base, "config.yaml")
+	if err != nil {
+		return nil, err
+	}
+	s := &side{read: func(name string) ([]byte, bool, error) { return info.ShowFile(base, name) }}
+	if !ok {
+		// The vault did not exist yet: everything is added
+		s.cfg = &config.Config{Environments: map[string]config.Environment{}}
+		return s, nil
+	}
+	if s.cfg, err = config.Parse(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", base, err)
+	}
+
+	if data, ok, err = info.ShowFile(base, "authorized_keys"); err != nil {
+		return nil, err
+	} else if ok {
+		if s.keys, err = keys.ParseList(data); err != nil {
+			return nil, fmt.Errorf("%s: authorized_keys: %w", base, err)
+		}
+	}
+	return s, nil
+}
+
+func workingSide(info *vault.Info) (*side, error) {
+	cfg, err := config.LoadDir(info.Dir)
+	if err != nil {
+		return nil, err
+	}
+	// Like the base side: key_sources outside the vault are not compared
+	authorized, err := keys.LoadFile(filepath.Join(info.Dir, "authorized_keys"))
+	if err != nil {
+		return nil, err
+	}
+	return &side{cfg: cfg, keys: authorized, read: func(name string) ([]byte, bool, error) {
+		data, err := os.ReadFile(filepath.Join(info.Dir, name))
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return data, err == nil, err
+	}}, nil
+}
+
+// ciphertext returns an environment's ciphertext on one side; nil if the
+// environment or its file does not exist
+func (s *side) ciphertext(envName string) ([]byte, error) {
+	env, ok := s.cfg.Environments[envName]
+	if !ok {
+		return nil, nil
+	}
+	data, _, err := s.read(env.EncryptedFile)
+	return data, err
+}
+
+func compareEnv(envName string, before, after *side, known []keys.Key) (*EnvReport, error) {
+	e := &EnvReport{Environment: envName}
+	old, err := before.ciphertext(envName)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := after.ciphertext(envName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case old == nil && cur == nil:
+		return nil, fmt.Errorf("environment %s has no ciphertext in either revision", envName)
+	case old == nil:
+		e.Status = StatusAdded
+	case cur == nil:
+		e.Status = StatusRemoved
+	case bytes.Equal(old, cur):
+		e.Status = StatusUnchanged
+	}
+
+	oldStanzas, err := stanzas(old, envName, "base")
+	if err != nil {
+		return nil, err
+	}
+	curStanzas, err := stanzas(cur, envName, "working tree")
+	if err != nil {
+		return nil, err
+	}
+	e.RecipientsAdded, e.RecipientsRemoved, e.AnonymousBefore, e.AnonymousAfter = compareRecipients(oldStanzas, curStanzas, known)
+
+	if e.Status == StatusUnchanged {
+		return e, nil
+	}
+
+	// Only what is on each side is decrypted: an added environment lists
+	// every variable as added
+	var oldPlain, curPlain []byte
+	defer func() {
+		secmem.Wipe(oldPlain)
+		secmem.Wipe(curPlain)
+	}()
+	// On failure the status still says the content differs
+	if e.Status == "" {
+		e.Status = StatusChanged
+	}
+	if old != nil {
+		if oldPlain, err = crypto.DecryptData(before.cfg, envName, old); err != nil {
+			e.Error = fmt.Sprintf("cannot decrypt base: %v", err)
+			return e, nil
+		}
+	}
+	if cur != nil {
+		if curPlain, err = crypto.DecryptData(after.cfg, envName, cur); err != nil {
+			e.Error = fmt.Sprintf("cannot decrypt working tree: %v", err)
+			return e, nil
+		}
+	}
+
+	compareValues(e, oldPlain, curPlain)
+	// Re-encrypting changes the ciphertext but not necessarily the values
+	if e.Status == StatusChanged && len(e.Added)+len(e.Removed)+len(e.Changed) == 0 && (!e.Opaque || bytes.Equal(oldPlain, curPlain)) {
+		e.Status = StatusUnchanged
+	}
+	return e, nil
+}
+
+// compareValues fills in which variables changed. Plaintext that is not
+// dotenv on either side is marked opaque instead.
+func compareValues(e *EnvReport, oldPlain, curPlain []byte) {
+	before, errBefore := dotenv.ParseMap(oldPlain)
+	after, errAfter := dotenv.ParseMap(curPlain)
+	if errBefore != nil || errAfter != nil {
+		e.Opaque = true
+		return
+	}
+
+	for name, value := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			e.Added = append(e.Added, name)
+		case old != value:
+			e.Changed = append(e.Changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			e.Removed = append(e.Removed, name)
+		}
+	}
+	sort.Strings(e.Added)
+	sort.Strings(e.Removed)
+	sort.Strings(e.Changed)
+}
+
+func stanzas(ciphertext []byte, envName, where string) ([]crypto.Stanza, error) {
+	if ciphertext == nil {
+		return nil, nil
+	}
+	return crypto.ParseHeader(bytes.NewReader(ciphertext), envName+" ("+where+")")
+}
+
+// compareRecipients matches SSH stanzas to known keys by their tag
+func compareRecipients(before, after []crypto.Stanza, known []keys.Key) (added, removed []Recipient, anonBefore, anonAfter int) {
+	byTag := map[string]keys.Key{}
+	for _, k := range known {
+		if tag := k.SSHTag(); tag != "" {
+			if _, ok := byTag[k.Type+" "+tag]; !ok {
+				byTag[k.Type+" "+tag] = k
+			}
+		}
+	}
+
+	collect := func(list []crypto.Stanza) (map[string]Recipient, int) {
+		recipients := map[string]Recipient{}
+		anonymous := 0
+		for _, s := range list {
+			tag := s.Tag()
+			if tag == "" {
+				if s.Type == "X25519" {
+					anonymous++
+				}
+				continue
+			}
+			r := Recipient{Type: s.Type, Tag: tag}
+			if k, ok := byTag[s.Type+" "+tag]; ok {
+				r = keyRecipient(k)
+			}
+			recipients[s.Type+" "+tag] = r
+		}
+		return recipients, anonymous
+	}
+
+	old, anonBefore := collect(before)
+	cur, anonAfter := collect(after)
+	for id, r := range cur {
+		if _, ok := old[id]; !ok {
+			added = append(added, r)
+		}
+	}
+	for id, r := range old {
+		if _, ok := cur[id]; !ok {
+			removed = append(removed, r)
+		}
+	}
+	sortRecipients(added)
+	sortRecipients(removed)
+	return added, removed, anonBefore, anonAfter
+}
+
+// compareKeys lists keys added to and removed from authorized_keys
+func compareKeys(before, after []keys.Key) (added, removed []Recipient) {
+	fingerprints := func(list []keys.Key) map[string]bool {
+		set := map[string]bool{}
+		for _, k := range list {
+			set[k.Fingerprint] = true
+		}
+		return set
+	}
+	old, cur := fingerprints(before), fingerprints(after)
+	for _, k := range after {
+		if !old[k.Fingerprint] {
+			added = append(added, keyRecipient(k))
+		}
+	}
+	for _, k := range before {
+		if !cur[k.Fingerprint] {
+			removed = append(removed, keyRecipient(k))
+		}
+	}
+	sortRecipients(added)
+	sortRecipients(removed)
+	return added, removed
+}
+
+func keyRecipient(k keys.Key) Recipient {
+	return Recipient{Fingerprint: k.Fingerprint, Type: k.Type, Comment: k.Comment}
+}
+
+func sortRecipients(list []Recipient) {
+	sort.Slice(list, func(i, j int) bool { return list[i].String() < list[j].String() })
+}
+
+// Markdown renders the report for a pull request comment
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Secret changes against `%s`\n\n", r.Base)
+
+	changed := 0
+	for _, e := range r.Environments {
+		if e.Status != StatusUnchanged || len(e.RecipientsAdded)+len(e.RecipientsRemoved) > 0 || e.AnonymousBefore != e.AnonymousAfter {
+			changed++
+		}
+	}
+	if changed == 0 && len(r.KeysAdded)+len(r.KeysRemoved) == 0 {
+		b.WriteString("No environment or authorized key changed.\n")
+		return b.String()
+	}
+
+	b.WriteString("| Environment | Status | Added | Removed | Changed | Recipients |\n")
+	b.WriteString("|---|---|---|---|---|---|\n")
+	for _, e := range r.Environments {
+		recipients := fmt.Sprintf("+%d / -%d", len(e.RecipientsAdded), len(e.RecipientsRemoved))
+		if e.AnonymousBefore != e.AnonymousAfter {
+			recipients += fmt.Sprintf(", X25519 %d → %d", e.AnonymousBefore, e.AnonymousAfter)
+		}
+		counts := fmt.Sprintf("%d | %d | %d", len(e.Added), len(e.Removed), len(e.Changed))
+		if e.Opaque || e.Error != "" {
+			counts = "- | - | -"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", e.Environment, e.Status, counts, recipients)
+	}
+
+	for _, e := range r.Environments {
+		var lines []string
+		if e.Error != "" {
+			lines = append(lines, "- ⚠️ "+e.Error)
+		}
+		if e.Opaque && e.Status == StatusChanged {
+			lines = append(lines, "- Payload is not dotenv; its content changed")
+		}
+		lines = appendNames(lines, "Added", e.Added)
+		lines = appendNames(lines, "Removed", e.Removed)
+		lines = appendNames(lines, "Changed", e.Changed)
+		lines = appendRecipients(lines, "Recipients added", e.RecipientsAdded)
+		lines = appendRecipients(lines, "Recipients removed", e.RecipientsRemoved)
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n#### %s\n\n%s\n", e.Environment, strings.Join(lines, "\n"))
+	}
+
+	if len(r.KeysAdded)+len(r.KeysRemoved) > 0 {
+		var lines []string
+		lines = appendRecipients(lines, "Added", r.KeysAdded)
+		lines = appendRecipients(lines, "Removed", r.KeysRemoved)
+		fmt.Fprintf(&b, "\n#### authorized_keys\n\n%s\n", strings.Join(lines, "\n"))
+	}
+
+	b.WriteString("\n_Values are never shown; only variable names and recipients are compared._\n")
+	return b.String()
+}
+
+func appendNames(lines []string, label string, names []string) []string {
+	if len(names) == 0 {
+		return lines
+	}
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "`" + name + "`"
+	}
+	return append(lines, "- "+label+": "+strings.Join(quoted, ", "))
+}
+
+func appendRecipients(lines []string, label string, recipients []Recipient) []string {
+	if len(recipients) == 0 {
+		return lines
+	}
+	names := make([]string, len(recipients))
+	for i, r := range recipients {
+		names[i] = r.String()
+	}
+	return append(lines, "- "+label+": "+strings.Join(names, ", "))
+}
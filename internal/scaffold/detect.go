@@ -0,0 +1,303 @@
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/orchard9/envault/internal/dotenv"
+)
+
+// Found is an environment suggested by an existing project's dotenv files
+type Found struct {
+	Env     string   // environment name
+	Source  string   // file whose variables become the environment; empty when only referenced
+	Keys    []string // variable names in Source
+	Targets []string // where the environment is rendered, relative to the project
+}
+
+// Detection is what Detect found in a project
+type Detection struct {
+	Environments []*Found
+	Examples     []string // .env.example and similar files, which hold no secrets
+	ExampleKeys  []string // variable names listed in the examples
+	Compose      []string // compose files whose env_file references became targets
+}
+
+// detectDepth limits how far below the project Detect looks, enough for
+// apps/<name>/.env in a monorepo
+const detectDepth = 4
+
+// skipDirs are never searched for dotenv files
+var skipDirs = map[string]bool{
+	"node_modules": true, "vendor": true, "dist": true, "build": true, "target": true,
+}
+
+var composeFiles = map[string]bool{
+	"docker-compose.yml": true, "docker-compose.yaml": true, "compose.yml": true, "compose.yaml": true,
+}
+
+// exampleSuffixes mark dotenv files that document variables rather than
+// hold them
+var exampleSuffixes = []string{"example", "sample", "template", "dist", "defaults"}
+
+// envAliases maps the suffixes frameworks use to envault's usual names
+var envAliases = map[string]string{"development": "dev", "production": "prod", "stage": "staging"}
+
+var envNameUnsafe = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// Detect looks for dotenv files (.env, .env.<name>), their examples and
+// docker compose env_file references under projectDir. Files with the same
+// environment suffix and identical contents become one environment with
+// several targets; different contents get an environment per directory.
+func Detect(projectDir string) (*Detection, error) {
+	var dotenvFiles, composePaths []string
+	d := &Detection{}
+	err := filepath.WalkDir(projectDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(projectDir, path)
+		if entry.IsDir() {
+			name := entry.Name()
+			if rel != "." && (strings.HasPrefix(name, ".") || skipDirs[name] || strings.Count(rel, string(filepath.Separator)) >= detectDepth-1) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		name := entry.Name()
+		switch {
+		case composeFiles[name]:
+			composePaths = append(composePaths, rel)
+		case name == ".env" || strings.HasPrefix(name, ".env."):
+			if isExample(name) {
+				d.Examples = append(d.Examples, rel)
+			} else {
+				dotenvFiles = append(dotenvFiles, rel)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Shallow files first, so the project root's .env claims "dev"
+	sortByDepth(dotenvFiles)
+	byEnv := map[string]*Found{}
+	contents := map[string][]byte{}
+	for _, rel := range dotenvFiles {
+		d.add(projectDir, rel, byEnv, contents)
+	}
+
+	sort.Strings(composePaths)
+	for _, composePath := range composePaths {
+		refs := composeEnvFiles(filepath.Join(projectDir, composePath))
+		if len(refs) == 0 {
+			continue
+		}
+		d.Compose = append(d.Compose, composePath)
+		for _, ref := range refs {
+			rel := filepath.Join(filepath.Dir(composePath), ref)
+			if filepath.IsAbs(ref) || strings.HasPrefix(rel, "..") || d.hasTarget(rel) {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(projectDir, rel)); err == nil {
+				d.add(projectDir, rel, byEnv, contents)
+				continue
+			}
+			// Not created yet: it is where the environment will be rendered
+			name := envNameFor(filepath.Base(rel))
+			found := byEnv[name]
+			if found == nil {
+				found = &Found{Env: name}
+				byEnv[name] = found
+				d.Environments = append(d.Environments, found)
+			}
+			found.Targets = append(found.Targets, rel)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, rel := range d.Examples {
+		data, err := os.ReadFile(filepath.Join(projectDir, rel))
+		if err != nil {
+			continue
+		}
+		values, err := dotenv.ParseMap(data)
+		if err != nil {
+			continue
+		}
+		for key := range values {
+			if !seen[key] {
+				seen[key] = true
+				d.ExampleKeys = append(d.ExampleKeys, key)
+			}
+		}
+	}
+	sort.Strings(d.ExampleKeys)
+
+	sort.Slice(d.Environments, func(i, j int) bool { return d.Environments[i].Env < d.Environments[j].Env })
+	return d, nil
+}
+
+// add files a dotenv file under its environment: as another target when
+// the environment's source has the same contents, otherwise as the source
+// of an environment of its own
+func (d *Detection) add(projectDir, rel string, byEnv map[string]*Found, contents map[string][]byte) {
+	data, err := os.ReadFile(filepath.Join(projectDir, rel))
+	if err != nil {
+		return
+	}
+	values, err := dotenv.ParseMap(data)
+	if err != nil {
+		return
+	}
+
+	name := envNameFor(filepath.Base(rel))
+	if found := byEnv[name]; found != nil {
+		if found.Source == "" {
+			found.Source = rel
+			found.Keys = sortedKeys(values)
+			found.Targets = append(found.Targets, rel)
+			contents[name] = data
+			return
+		}
+		if bytes.Equal(contents[name], data) {
+			found.Targets = append(found.Targets, rel)
+			return
+		}
+		if dir := filepath.Dir(rel); dir != "." {
+			name = envNameUnsafe.ReplaceAllString(strings.ToLower(dir), "-") + "-" + name
+		}
+		for base, i := name, 2; byEnv[name] != nil; i++ {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+	}
+
+	found := &Found{Env: name, Source: rel, Keys: sortedKeys(values), Targets: []string{rel}}
+	byEnv[name] = found
+	contents[name] = data
+	d.Environments = append(d.Environments, found)
+}
+
+func (d *Detection) hasTarget(rel string) bool {
+	for _, found := range d.Environments {
+		for _, target := range found.Targets {
+			if target == rel {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// envNameFor maps a dotenv file name to an environment: .env is dev,
+// .env.<name> is <name>, and anything else referenced by compose is dev
+func envNameFor(base string) string {
+	suffix, ok := strings.CutPrefix(base, ".env.")
+	if !ok || suffix == "" {
+		return "dev"
+	}
+	suffix = strings.ToLower(suffix)
+	if alias, ok := envAliases[suffix]; ok {
+		return alias
+	}
+	if name := strings.Trim(envNameUnsafe.ReplaceAllString(suffix, "-"), "-"); name != "" {
+		return name
+	}
+	return "dev"
+}
+
+func isExample(name string) bool {
+	for _, suffix := range exampleSuffixes {
+		if strings.HasSuffix(name, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// composeEnvFiles returns the env_file entries of a compose file's
+// services, in the short (string or list) and long (path:) syntax
+func composeEnvFiles(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var compose struct {
+		Services map[string]struct {
+			EnvFile yaml.Node `yaml:"env_file"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var refs []string
+	for _, name := range names {
+		node := compose.Services[name].EnvFile
+		switch node.Kind {
+		case yaml.ScalarNode:
+			refs = append(refs, node.Value)
+		case yaml.SequenceNode:
+			for _, item := range node.Content {
+				switch item.Kind {
+				case yaml.ScalarNode:
+					refs = append(refs, item.Value)
+				case yaml.MappingNode:
+					var long struct {
+						Path string `yaml:"path"`
+					}
+					if item.Decode(&long) == nil && long.Path != "" {
+						refs = append(refs, long.Path)
+					}
+				}
+			}
+		}
+	}
+
+	// Compose files can reference variables (${ENV}/.env); those are skipped
+	kept := refs[:0]
+	for _, ref := range refs {
+		if ref != "" && !strings.Contains(ref, "$") {
+			kept = append(kept, filepath.Clean(filepath.FromSlash(ref)))
+		}
+	}
+	return kept
+}
+
+func sortByDepth(paths []string) {
+	sort.Slice(paths, func(i, j int) bool {
+		di := strings.Count(paths[i], string(filepath.Separator))
+		dj := strings.Count(paths[j], string(filepath.Separator))
+		if di != dj {
+			return di < dj
+		}
+		return paths[i] < paths[j]
+	})
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,123 @@
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// Result describes what a template contributed to a new .envault directory
+type Result struct {
+	Schema       bool
+	Placeholders []string // plaintext files written, relative to .envault
+}
+
+// Fetch makes a template available on disk. src is a local directory or a
+// git URL, optionally followed by #subdir. The returned cleanup removes
+// any temporary clone.
+func Fetch(src string) (string, func(), error) {
+	noop := func() {}
+
+	repo, subdir, _ := strings.Cut(src, "#")
+	if !isGitURL(repo) {
+		if _, err := os.Stat(src); err != nil {
+			return "", noop, fmt.Errorf("template %s not found", src)
+		}
+		return src, noop, nil
+	}
+
+	tmp, err := os.MkdirTemp("", "envault-template-")
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+
+	cmd := exec.Command("git", "clone", "--quiet", "--depth", "1", repo, tmp)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return "", noop, fmt.Errorf("git clone %s failed: %w\nStderr: %s", repo, err, stderr.String())
+	}
+
+	dir := filepath.Join(tmp, subdir)
+	if _, err := os.Stat(dir); err != nil {
+		cleanup()
+		return "", noop, fmt.Errorf("template path %s not found in %s", subdir, repo)
+	}
+	return dir, cleanup, nil
+}
+
+func isGitURL(s string) bool {
+	return strings.Contains(s, "://") || strings.HasPrefix(s, "git@") || strings.HasSuffix(s, ".git")
+}
+
+// Apply copies a template into envaultDir. The template root (or its
+// .envault subdirectory) must contain config.yaml and may contain
+// schema.yaml and environments/<env>.env placeholder files, which are
+// staged as <env>.plaintext for the admin to fill in and encrypt.
+func Apply(templateDir, envaultDir string) (*Result, error) {
+	if _, err := os.Stat(filepath.Join(templateDir, ".envault", "config.yaml")); err == nil {
+		templateDir = filepath.Join(templateDir, ".envault")
+	}
+
+	data, err := os.ReadFile(filepath.Join(templateDir, "config.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("template has no config.yaml: %w", err)
+	}
+
+	var cfg config.Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("template config.yaml is invalid: %w", err)
+	}
+	if cfg.Version > config.CurrentVersion {
+		return nil, fmt.Errorf("template config.yaml version %d is newer than this envault supports", cfg.Version)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("template config.yaml is invalid: %w", err)
+	}
+
+	// Copy verbatim to keep the template's comments
+	if err := os.WriteFile(filepath.Join(envaultDir, "config.yaml"), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write config.yaml: %w", err)
+	}
+
+	result := &Result{}
+
+	if data, err := os.ReadFile(filepath.Join(templateDir, "schema.yaml")); err == nil {
+		if err := os.WriteFile(filepath.Join(envaultDir, "schema.yaml"), data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write schema.yaml: %w", err)
+		}
+		result.Schema = true
+	}
+
+	placeholders, _ := filepath.Glob(filepath.Join(templateDir, "environments", "*.env"))
+	sort.Strings(placeholders)
+	for _, path := range placeholders {
+		envName := strings.TrimSuffix(filepath.Base(path), ".env")
+		if _, ok := cfg.Environments[envName]; !ok {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		name := envName + ".plaintext"
+		if err := os.WriteFile(filepath.Join(envaultDir, name), data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		result.Placeholders = append(result.Placeholders, name)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,228 @@
+package scan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kinds of places a leaked value was found
+const (
+	KindFile   = "file"   // a tracked file
+	KindCI     = "ci"     // a tracked CI configuration file
+	KindCommit = "commit" // a commit message
+)
+
+// DefaultMinLength skips short values such as "true" or "3000" that would
+// match all over a repository
+const DefaultMinLength = 8
+
+// maxFileSize bounds the tracked files read; larger files are skipped
+const maxFileSize = 10 << 20
+
+// ciPaths match CI configuration by path prefix or exact name
+var ciPaths = []string{
+	".github/workflows/", ".gitlab-ci.yml", ".circleci/", "Jenkinsfile",
+	"azure-pipelines.yml", "bitbucket-pipelines.yml", ".buildkite/",
+	".travis.yml", ".drone.yml", "cloudbuild.yaml",
+}
+
+// Secret is a decrypted value to look for
+type Secret struct {
+	Env   string
+	Key   string
+	Value string
+}
+
+// Finding is a secret found verbatim outside the ciphertext. It never
+// carries the value itself.
+type Finding struct {
+	Env      string
+	Key      string
+	Kind     string
+	Location string // file path or commit hash
+	Line     int    // 1-based; 0 for commit messages
+}
+
+func (f Finding) String() string {
+	where := f.Location
+	if f.Line > 0 {
+		where = fmt.Sprintf("%s:%d", f.Location, f.Line)
+	}
+	if f.Kind == KindCommit {
+		where = "commit message " + f.Location
+	}
+	return fmt.Sprintf("%s (%s) in %s", f.Key, f.Env, where)
+}
+
+// Filter drops secrets shorter than minLength and returns how many
+// were dropped
+func Filter(secrets []Secret, minLength int) ([]Secret, int) {
+	var kept []Secret
+	for _, s := range secrets {
+		if len(strings.TrimSpace(s.Value)) >= minLength {
+			kept = append(kept, s)
+		}
+	}
+	return kept, len(secrets) - len(kept)
+}
+
+// TrackedFiles searches every file tracked by git in dir, except the
+// vault itself
+func TrackedFiles(dir, vaultDir string, secrets []Secret) ([]Finding, error) {
+	out, err := exec.Command("git", "-C", dir, "ls-files", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tracked files (is this a git repository?): %w", err)
+	}
+
+	var findings []Finding
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if inside(vaultDir, path) {
+			continue
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxFileSize {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		kind := KindFile
+		if isCI(name) {
+			kind = KindCI
+		}
+		for _, s := range secrets {
+			if i := bytes.Index(data, []byte(s.Value)); i >= 0 {
+				findings = append(findings, Finding{
+					Env:      s.Env,
+					Key:      s.Key,
+					Kind:     kind,
+					Location: filepath.ToSlash(name),
+					Line:     bytes.Count(data[:i], []byte("\n")) + 1,
+				})
+			}
+		}
+	}
+
+	sortFindings(findings)
+	return findings, nil
+}
+
+// CommitMessages searches the messages of every commit reachable from
+// any ref
+func CommitMessages(dir string, secrets []Secret) ([]Finding, error) {
+	out, err := exec.Command("git", "-C", dir, "log", "--all", "--format=%H%x00%B%x00").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit messages: %w", err)
+	}
+
+	var findings []Finding
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		hash := strings.TrimSpace(fields[i])
+		message := fields[i+1]
+		for _, s := range secrets {
+			if strings.Contains(message, s.Value) {
+				findings = append(findings, Finding{Env: s.Env, Key: s.Key, Kind: KindCommit, Location: shortHash(hash)})
+			}
+		}
+	}
+
+	sortFindings(findings)
+	return findings, nil
+}
+
+// engineArgs returns the arguments for a supported external scanner
+func engineArgs(name, dir string) ([]string, error) {
+	switch name {
+	case "gitleaks":
+		return []string{"detect", "--no-banner", "--redact", "--source", dir}, nil
+	case "trufflehog":
+		return []string{"git", "file://" + dir, "--fail", "--no-update"}, nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q (use gitleaks or trufflehog)", name)
+	}
+}
+
+// CheckEngine verifies an external scanner is supported and installed
+func CheckEngine(name string) error {
+	if _, err := engineArgs(name, "."); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("%s is not installed", name)
+	}
+	return nil
+}
+
+// Engine runs an external secret scanner over dir with its output shown
+// to the user. It reports whether the engine found anything.
+func Engine(name, dir string) (bool, error) {
+	if err := CheckEngine(name); err != nil {
+		return false, err
+	}
+	args, _ := engineArgs(name, dir)
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		// Both engines exit non-zero when they find leaks
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s failed: %w", name, err)
+	}
+	return false, nil
+}
+
+func isCI(name string) bool {
+	for _, p := range ciPaths {
+		if strings.HasSuffix(p, "/") && strings.HasPrefix(name, p) {
+			return true
+		}
+		if name == p || strings.HasSuffix(name, "/"+p) {
+			return true
+		}
+	}
+	return false
+}
+
+func inside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Location != b.Location {
+			return a.Location < b.Location
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Key < b.Key
+	})
+}
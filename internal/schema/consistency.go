@@ -0,0 +1,141 @@
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Consistency compares one variable across environments: it must hold the
+// same value in every environment listed under Same, or a different value
+// in each pair listed under Differ. A rule sets one of the two.
+type Consistency struct {
+	Variable    string   `yaml:"variable"`
+	Same        []string `yaml:"same,omitempty"`   // e.g. a feature flag shared by dev and staging
+	Differ      []string `yaml:"differ,omitempty"` // e.g. a database password that must not leak from staging to prod
+	Description string   `yaml:"description,omitempty"`
+}
+
+// Environments returns the environments the rule compares
+func (c Consistency) Environments() []string {
+	if len(c.Same) > 0 {
+		return c.Same
+	}
+	return c.Differ
+}
+
+func (c Consistency) String() string {
+	if len(c.Same) > 0 {
+		return fmt.Sprintf("%s same in %s", c.Variable, strings.Join(c.Same, ", "))
+	}
+	return fmt.Sprintf("%s differs across %s", c.Variable, strings.Join(c.Differ, ", "))
+}
+
+// Valid reports whether the rule can be applied
+func (c Consistency) Valid() error {
+	if c.Variable == "" {
+		return fmt.Errorf("consistency rule has no variable")
+	}
+	if (len(c.Same) > 0) == (len(c.Differ) > 0) {
+		return fmt.Errorf("consistency rule for %s must set one of same or differ", c.Variable)
+	}
+	envNames := c.Environments()
+	if len(envNames) < 2 {
+		return fmt.Errorf("consistency rule for %s compares fewer than two environments", c.Variable)
+	}
+	seen := map[string]bool{}
+	for _, envName := range envNames {
+		if seen[envName] {
+			return fmt.Errorf("consistency rule for %s lists %s twice", c.Variable, envName)
+		}
+		seen[envName] = true
+	}
+	return nil
+}
+
+// Fingerprint hashes a value so environments can be compared without
+// keeping their plaintext side by side
+func Fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check applies the rule to value fingerprints keyed by environment, then
+// variable. Every environment the rule names must be present; a variable
+// missing from one is unset there. Values are never included in the error.
+func (c Consistency) Check(fingerprints map[string]map[string]string) error {
+	if len(c.Same) > 0 {
+		var unset, differ []string
+		first := ""
+		for _, envName := range c.Same {
+			fp, ok := fingerprints[envName][c.Variable]
+			switch {
+			case !ok:
+				unset = append(unset, envName)
+			case first == "":
+				first = envName
+			case fp != fingerprints[first][c.Variable]:
+				differ = append(differ, envName)
+			}
+		}
+		switch {
+		case len(unset) > 0:
+			return fmt.Errorf("is not set in %s (must be the same in %s)", strings.Join(unset, ", "), strings.Join(c.Same, ", "))
+		case len(differ) > 0:
+			return fmt.Errorf("differs in %s from %s (must be the same in %s)", strings.Join(differ, ", "), first, strings.Join(c.Same, ", "))
+		}
+		return nil
+	}
+
+	// An environment without the variable cannot share its value
+	var identical []string
+	for i, a := range c.Differ {
+		fpA, ok := fingerprints[a][c.Variable]
+		if !ok {
+			continue
+		}
+		for _, b := range c.Differ[i+1:] {
+			if fpB, ok := fingerprints[b][c.Variable]; ok && fpA == fpB {
+				identical = append(identical, a+" and "+b)
+			}
+		}
+	}
+	if len(identical) > 0 {
+		return fmt.Errorf("is identical in %s (must differ across %s)", strings.Join(identical, ", "), strings.Join(c.Differ, ", "))
+	}
+	return nil
+}
+
+// SetConsistency adds a rule, replacing the variable's rule of the same
+// kind, and reports whether it replaced one
+func (s *Schema) SetConsistency(rule Consistency) bool {
+	for i, existing := range s.Consistency {
+		if existing.Variable == rule.Variable && (len(existing.Same) > 0) == (len(rule.Same) > 0) {
+			if rule.Description == "" {
+				rule.Description = existing.Description
+			}
+			s.Consistency[i] = rule
+			return true
+		}
+	}
+	s.Consistency = append(s.Consistency, rule)
+	return false
+}
+
+// RemoveConsistency deletes a variable's consistency rules and returns how
+// many there were
+func (s *Schema) RemoveConsistency(name string) int {
+	kept := s.Consistency[:0]
+	for _, rule := range s.Consistency {
+		if rule.Variable != name {
+			kept = append(kept, rule)
+		}
+	}
+	removed := len(s.Consistency) - len(kept)
+	s.Consistency = kept
+	if len(s.Consistency) == 0 {
+		s.Consistency = nil
+	}
+	return removed
+}
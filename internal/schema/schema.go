@@ -0,0 +1,275 @@
+package schema
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/secmem"
+)
+
+// Schema represents the .envault/schema.yaml structure
+type Schema struct {
+	Variables    map[string]Variable    `yaml:"variables,omitempty"`
+	Environments map[string]Environment `yaml:"environments,omitempty"`
+	Consistency  []Consistency          `yaml:"consistency,omitempty"` // rules comparing a variable across environments
+}
+
+// Environment holds variable rules that apply to a single environment
+type Environment struct {
+	Variables map[string]Variable `yaml:"variables,omitempty"`
+}
+
+// Variable describes the expectations for a single secret
+type Variable struct {
+	Description string `yaml:"description,omitempty"`
+	RotateEvery string `yaml:"rotate_every,omitempty"` // e.g. "90d", "12w", "720h"
+	Type        string `yaml:"type,omitempty"`         // url, int, bool, email, uuid, pem, json
+	Pattern     string `yaml:"pattern,omitempty"`      // regular expression the whole value must match
+	Required    bool   `yaml:"required,omitempty"`     // the variable must be set
+}
+
+// EncryptedFileName is the schema encrypted to every authorized key (envault
+// schema encrypt), for teams whose variable names or patterns are sensitive
+const EncryptedFileName = "schema.yaml.age"
+
+// Path returns the path to schema.yaml
+func Path() (string, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(envaultDir, "schema.yaml"), nil
+}
+
+// EncryptedPath returns the path to schema.yaml.age
+func EncryptedPath() (string, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(envaultDir, EncryptedFileName), nil
+}
+
+// Encrypted reports whether the schema is kept in schema.yaml.age. Having
+// both files is an error, since it is unclear which one is current.
+func Encrypted() (bool, error) {
+	encryptedPath, err := EncryptedPath()
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(encryptedPath); err != nil {
+		return false, nil
+	}
+	schemaPath, err := Path()
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(schemaPath); err == nil {
+		return false, fmt.Errorf("both schema.yaml and %s exist; remove the stale one", EncryptedFileName)
+	}
+	return true, nil
+}
+
+// Load reads schema.yaml, or decrypts schema.yaml.age. A missing file
+// yields an empty schema.
+func Load() (*Schema, error) {
+	encrypted, err := Encrypted()
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		encryptedPath, err := EncryptedPath()
+		if err != nil {
+			return nil, err
+		}
+		data, err := crypto.DecryptShared(encryptedPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", EncryptedFileName, err)
+		}
+		defer secmem.Wipe(data)
+		return parse(data)
+	}
+
+	schemaPath, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(schemaPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Schema{}, nil
+		}
+		return nil, fmt.Errorf("failed to read schema.yaml: %w", err)
+	}
+	return parse(data)
+}
+
+func parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse schema.yaml: %w", err)
+	}
+
+	return &s, nil
+}
+
+// Save writes the schema back to whichever file holds it: schema.yaml, or
+// schema.yaml.age re-encrypted to the current authorized keys. Comments
+// in schema.yaml are not preserved.
+func (s *Schema) Save() error {
+	encrypted, err := Encrypted()
+	if err != nil {
+		return err
+	}
+	return s.write(encrypted)
+}
+
+// SetEncrypted moves the schema between schema.yaml and schema.yaml.age
+func (s *Schema) SetEncrypted(encrypt bool) error {
+	if err := s.write(encrypt); err != nil {
+		return err
+	}
+	old, err := Path()
+	if !encrypt {
+		old, err = EncryptedPath()
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", filepath.Base(old), err)
+	}
+	return nil
+}
+
+func (s *Schema) write(encrypt bool) error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode schema: %w", err)
+	}
+
+	if encrypt {
+		encryptedPath, err := EncryptedPath()
+		if err != nil {
+			return err
+		}
+		defer secmem.Wipe(data)
+		if err := crypto.EncryptShared(encryptedPath, data); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", EncryptedFileName, err)
+		}
+		return nil
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	schemaPath, err := Path()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(schemaPath, data, cfg.FileMode()); err != nil {
+		return fmt.Errorf("failed to write schema.yaml: %w", err)
+	}
+	return nil
+}
+
+// Rules returns the rules map for an environment, or the shared rules
+// when envName is empty, creating it if needed
+func (s *Schema) Rules(envName string) map[string]Variable {
+	if envName == "" {
+		if s.Variables == nil {
+			s.Variables = map[string]Variable{}
+		}
+		return s.Variables
+	}
+	if s.Environments == nil {
+		s.Environments = map[string]Environment{}
+	}
+	env := s.Environments[envName]
+	if env.Variables == nil {
+		env.Variables = map[string]Variable{}
+		s.Environments[envName] = env
+	}
+	return env.Variables
+}
+
+// Remove deletes a variable's rule for an environment, or the shared rule
+// when envName is empty, and reports whether there was one
+func (s *Schema) Remove(envName, name string) bool {
+	if envName == "" {
+		if _, ok := s.Variables[name]; !ok {
+			return false
+		}
+		delete(s.Variables, name)
+		return true
+	}
+
+	env, ok := s.Environments[envName]
+	if _, found := env.Variables[name]; !ok || !found {
+		return false
+	}
+	delete(env.Variables, name)
+	if len(env.Variables) == 0 {
+		delete(s.Environments, envName)
+	}
+	return true
+}
+
+// ForEnvironment returns the variable rules for an environment, with
+// environment-specific entries overriding the shared ones
+func (s *Schema) ForEnvironment(envName string) map[string]Variable {
+	vars := make(map[string]Variable, len(s.Variables))
+	for name, v := range s.Variables {
+		vars[name] = v
+	}
+	if env, ok := s.Environments[envName]; ok {
+		for name, v := range env.Variables {
+			vars[name] = v
+		}
+	}
+	return vars
+}
+
+// RotationWindow returns the parsed rotate_every duration, or zero if unset
+func (v Variable) RotationWindow() (time.Duration, error) {
+	if v.RotateEvery == "" {
+		return 0, nil
+	}
+	return ParseDuration(v.RotateEvery)
+}
+
+// ParseDuration parses durations like "90d" and "2w" in addition to the
+// units understood by time.ParseDuration
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+
+	for suffix, unit := range units {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
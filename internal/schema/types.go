@@ -0,0 +1,141 @@
+package schema
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types lists the value types understood by the type: field
+var Types = []string{"url", "int", "bool", "email", "uuid", "pem", "json"}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Violation is a value that does not satisfy its schema rules
+type Violation struct {
+	Name string
+	Err  error
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf("%s: %v", v.Name, v.Err)
+}
+
+// Validate checks the variables of an environment against the schema and
+// returns the violations, sorted by variable name. Values are never
+// included in the errors.
+func (s *Schema) Validate(envName string, values map[string]string) []Violation {
+	vars := s.ForEnvironment(envName)
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var violations []Violation
+	for _, name := range names {
+		value, ok := values[name]
+		if !ok {
+			if vars[name].Required {
+				violations = append(violations, Violation{Name: name, Err: fmt.Errorf("is required but not set")})
+			}
+			continue
+		}
+		if err := vars[name].Check(value); err != nil {
+			violations = append(violations, Violation{Name: name, Err: err})
+		}
+	}
+	return violations
+}
+
+// Valid checks the rule itself: a known type, a pattern that compiles and
+// a rotation window that parses
+func (v Variable) Valid() error {
+	if v.Type != "" {
+		known := false
+		for _, typ := range Types {
+			known = known || typ == v.Type
+		}
+		if !known {
+			return fmt.Errorf("unknown type %q (supported: %s)", v.Type, strings.Join(Types, ", "))
+		}
+	}
+	if v.Pattern != "" {
+		if _, err := regexp.Compile(`^(?:` + v.Pattern + `)$`); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", v.Pattern, err)
+		}
+	}
+	if _, err := v.RotationWindow(); err != nil {
+		return fmt.Errorf("rotate_every: %w", err)
+	}
+	return nil
+}
+
+// Check validates a single value against the variable's type and pattern
+func (v Variable) Check(value string) error {
+	if v.Type != "" {
+		if err := checkType(v.Type, value); err != nil {
+			return err
+		}
+	}
+
+	if v.Pattern != "" {
+		re, err := regexp.Compile(`^(?:` + v.Pattern + `)$`)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", v.Pattern, err)
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("does not match pattern %q", v.Pattern)
+		}
+	}
+
+	return nil
+}
+
+func checkType(typ, value string) error {
+	switch typ {
+	case "url":
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Opaque != "" || (u.Host == "" && u.Path == "") {
+			return fmt.Errorf("is not a valid URL")
+		}
+	case "int":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("is not an integer")
+		}
+	case "bool":
+		switch strings.ToLower(value) {
+		case "true", "false", "1", "0", "yes", "no", "on", "off":
+		default:
+			return fmt.Errorf("is not a boolean (true/false, 1/0, yes/no, on/off)")
+		}
+	case "email":
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Address != value {
+			return fmt.Errorf("is not an email address")
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(value) {
+			return fmt.Errorf("is not a UUID")
+		}
+	case "pem":
+		block, rest := pem.Decode([]byte(value))
+		if block == nil || strings.TrimSpace(string(rest)) != "" {
+			return fmt.Errorf("is not a PEM block")
+		}
+	case "json":
+		if !json.Valid([]byte(value)) {
+			return fmt.Errorf("is not valid JSON")
+		}
+	default:
+		return fmt.Errorf("unknown type %q (supported: %s)", typ, strings.Join(Types, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package secmem
+
+import "syscall"
+
+// disableCoreDumps lowers only the soft core limit. The hard limit stays,
+// so programs envault starts inherit no core dumps but may raise the
+// limit again; a hard limit of 0 could never be raised without root.
+func disableCoreDumps() {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CORE, &limit); err == nil {
+		limit.Cur = 0
+		syscall.Setrlimit(syscall.RLIMIT_CORE, &limit)
+	}
+	setUndumpable()
+}
@@ -0,0 +1,20 @@
+package secmem
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// procctl(2) arguments
+const (
+	pPID                    = 0
+	procTraceCtl            = 7
+	procTraceCtlDisableExec = 3
+)
+
+// setUndumpable denies ptrace and procfs access to the process, like
+// PR_SET_DUMPABLE on Linux. Programs started with exec are traceable again.
+func setUndumpable() {
+	arg := int32(procTraceCtlDisableExec)
+	syscall.Syscall6(syscall.SYS_PROCCTL, pPID, uintptr(syscall.Getpid()), procTraceCtl, uintptr(unsafe.Pointer(&arg)), 0, 0)
+}
@@ -0,0 +1,12 @@
+package secmem
+
+import "syscall"
+
+const prSetDumpable = 4
+
+// setUndumpable clears the dumpable flag, which also denies ptrace and
+// /proc/<pid>/mem to other processes running as the same user. Programs
+// started with exec get the flag back.
+func setUndumpable() {
+	syscall.RawSyscall(syscall.SYS_PRCTL, prSetDumpable, 0, 0)
+}
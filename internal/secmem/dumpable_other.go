@@ -0,0 +1,5 @@
+//go:build !windows && !linux && !freebsd
+
+package secmem
+
+func setUndumpable() {}
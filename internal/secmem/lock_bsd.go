@@ -0,0 +1,22 @@
+//go:build dragonfly || freebsd || netbsd
+
+package secmem
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// The syscall package has no Mlock on the BSDs, so lock and unlock call
+// mlock(2) and munlock(2) directly
+func lock(b []byte) {
+	if len(b) > 0 {
+		syscall.Syscall(syscall.SYS_MLOCK, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), 0)
+	}
+}
+
+func unlock(b []byte) {
+	if len(b) > 0 {
+		syscall.Syscall(syscall.SYS_MUNLOCK, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), 0)
+	}
+}
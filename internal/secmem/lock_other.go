@@ -0,0 +1,8 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd)
+
+package secmem
+
+// Memory is not locked here. On OpenBSD only libc may make system calls,
+// and the syscall package has no mlock wrapper there.
+func lock(b []byte)   {}
+func unlock(b []byte) {}
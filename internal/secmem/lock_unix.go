@@ -0,0 +1,19 @@
+//go:build darwin || linux
+
+package secmem
+
+import "syscall"
+
+// lock pins b's pages in RAM. Go does not move heap objects, so the lock
+// holds for the slice's lifetime. It fails silently over RLIMIT_MEMLOCK.
+func lock(b []byte) {
+	if len(b) > 0 {
+		syscall.Mlock(b)
+	}
+}
+
+func unlock(b []byte) {
+	if len(b) > 0 {
+		syscall.Munlock(b)
+	}
+}
@@ -0,0 +1,58 @@
+package secmem
+
+import "runtime"
+
+// Buffer collects plaintext in locked memory. Growing it moves the data to
+// a larger locked slice and zeroes the old one, so no stale copy is left
+// behind the way bytes.Buffer leaves one.
+type Buffer struct {
+	buf []byte
+}
+
+// NewBuffer returns a buffer with room for size bytes
+func NewBuffer(size int) *Buffer {
+	return &Buffer{buf: alloc(size)}
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	if len(b.buf)+len(p) > cap(b.buf) {
+		grown := alloc(2*cap(b.buf) + len(p))
+		grown = append(grown, b.buf...)
+		Wipe(b.buf)
+		b.buf = grown
+	}
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+// Bytes returns the buffer's contents. The caller owns them and should
+// Wipe them when done.
+func (b *Buffer) Bytes() []byte {
+	return b.buf
+}
+
+// Wipe zeroes b, including any capacity beyond its length, and unlocks it
+func Wipe(b []byte) {
+	b = b[:cap(b)]
+	clear(b)
+	runtime.KeepAlive(b)
+	unlock(b)
+}
+
+// Protect sets the soft core dump limit to 0 on Unix systems. On Linux
+// and FreeBSD it also marks the process undumpable, which is what keeps
+// other processes of the same user from attaching with ptrace or reading
+// its memory; other systems get no such protection. On Windows it does
+// nothing.
+func Protect() {
+	disableCoreDumps()
+}
+
+func alloc(size int) []byte {
+	if size < 512 {
+		size = 512
+	}
+	b := make([]byte, 0, size)
+	lock(b[:cap(b)])
+	return b
+}
@@ -0,0 +1,53 @@
+package semver
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Newer reports whether version a is newer than version b. Versions are
+// compared numerically by dot-separated component; a leading "v" and
+// pre-release/build suffixes are ignored.
+func Newer(a, b string) bool {
+	pa, pb := parts(a), parts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
+
+// AtLeast reports whether version is equal to or newer than min
+func AtLeast(version, min string) bool {
+	return !Newer(min, version)
+}
+
+// Valid reports whether s looks like a dotted numeric version
+func Valid(s string) bool {
+	s = strings.TrimPrefix(s, "v")
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return false
+	}
+	return true
+}
+
+func parts(version string) []int {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	var out []int
+	for _, p := range strings.Split(version, ".") {
+		n, _ := strconv.Atoi(p)
+		out = append(out, n)
+	}
+	return out
+}
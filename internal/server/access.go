@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// peer identifies the process on the other end of a unix socket
+type peer struct {
+	unix bool
+	uid  int // -1 when the platform cannot report it
+}
+
+type peerKey struct{}
+
+// connContext records the socket peer of each connection for authorize
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	p := peer{unix: true, uid: -1}
+	if uid, err := peerUID(uc); err == nil {
+		p.uid = uid
+	}
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// authorize checks an environment's access list against the requester.
+// Unix socket peers are identified by uid and TLS clients by the common
+// name of their verified certificate.
+func (s *Server) authorize(r *http.Request, envName string, env config.Environment) error {
+	if p, ok := r.Context().Value(peerKey{}).(peer); ok && p.unix {
+		if p.uid < 0 {
+			if env.Access == nil && !s.SharedSocket {
+				return nil // the socket's mode is the only check available
+			}
+			return fmt.Errorf("cannot identify the connecting process on this platform")
+		}
+		if p.uid == os.Getuid() {
+			return nil
+		}
+		if env.Access != nil && containsInt(env.Access.UIDs, p.uid) {
+			return nil
+		}
+		return fmt.Errorf("uid %d may not read environment %s", p.uid, envName)
+	}
+
+	if env.Access == nil {
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return fmt.Errorf("environment %s requires an authenticated client (serve with --client-ca)", envName)
+	}
+	name := r.TLS.PeerCertificates[0].Subject.CommonName
+	for _, client := range env.Access.Clients {
+		if client == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("client %q may not read environment %s", name, envName)
+}
+
+// clientName identifies the requester for rate limits and the audit log:
+// its bearer token, else its socket uid or certificate name, else its
+// address
+func (s *Server) clientName(r *http.Request) string {
+	if s.Tokens != nil {
+		if secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if token := s.Tokens.Authenticate(strings.TrimSpace(secret)); token != nil {
+				return "token:" + token.Name
+			}
+		}
+	}
+	if p, ok := r.Context().Value(peerKey{}).(peer); ok && p.unix {
+		if p.uid < 0 {
+			return "socket"
+		}
+		return fmt.Sprintf("uid:%d", p.uid)
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return "cert:" + r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return "addr:" + host
+	}
+	return "addr:" + r.RemoteAddr
+}
+
+// checkHost refuses TCP requests whose Host header names neither a
+// loopback address nor one of s.Hosts. Without it a web page could rebind
+// its own domain to 127.0.0.1 and read secrets through the browser. Unix
+// socket requests are not reachable that way and pass.
+func (s *Server) checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := r.Context().Value(peerKey{}).(peer); ok && p.unix {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !s.hostAllowed(r.Host) {
+			http.Error(w, fmt.Sprintf("host %q is not served (serve --allow-host adds names)", r.Host), http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) hostAllowed(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, allowed := range s.Hosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// SharedSocket reports whether any environment grants access to other
+// users, in which case the agent socket must be reachable by them
+func SharedSocket(cfg *config.Config) bool {
+	for _, env := range cfg.Environments {
+		if env.Access != nil && len(env.Access.UIDs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SocketMode returns the mode for the agent socket: 0666 when some
+// environment grants access to other users, else 0600. The socket is
+// only shared where peers can be identified (PeerCredentials); elsewhere
+// anyone who could connect would be served.
+func SocketMode(cfg *config.Config) os.FileMode {
+	return socketMode(SharedSocket(cfg), PeerCredentials)
+}
+
+func socketMode(shared, peerCredentials bool) os.FileMode {
+	if shared && peerCredentials {
+		return 0666
+	}
+	return 0600
+}
+
+func containsInt(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
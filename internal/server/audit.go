@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry is one line of the audit log: who asked for what, and
+// whether they got it. Values are never logged, only variable names.
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	Client      string    `json:"client"` // e.g. token:billing, uid:1001, cert:worker
+	Remote      string    `json:"remote,omitempty"`
+	Op          string    `json:"op"` // a scope, or watch
+	Environment string    `json:"environment"`
+	Keys        []string  `json:"keys,omitempty"`
+	Status      int       `json:"status"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// AuditLog appends JSON lines to a file, rotating it by size
+type AuditLog struct {
+	path    string
+	maxSize int64 // rotate before a write would exceed this
+	keep    int   // rotated files kept, path.1 being the newest
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenAudit opens (or creates, mode 0600) the audit log at path
+func OpenAudit(path string, maxSize int64, keep int) (*AuditLog, error) {
+	a := &AuditLog{path: path, maxSize: maxSize, keep: keep}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	a.f, a.size = f, info.Size()
+	return nil
+}
+
+// Write appends an entry. Callers must not hand out secrets when it fails.
+func (a *AuditLog) Write(e AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest beyond keep, and
+// starts a fresh file
+func (a *AuditLog) rotate() error {
+	a.f.Close()
+	if a.keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", a.path, a.keep))
+		for i := a.keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+		}
+		if err := os.Rename(a.path, a.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	} else if err := os.Remove(a.path); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return a.open()
+}
+
+// Close closes the log file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/pkg/agentpb"
+)
+
+// requestKey holds the HTTP/2 request a gRPC call arrived in
+type requestKey struct{}
+
+// grpcHandler serves the agentpb.Agent service on the server's own
+// listener. Each call keeps its HTTP request, so bearer tokens (the
+// authorization metadata), socket peers, client certificates, the audit
+// log and rate limits apply exactly as they do to /v1.
+func (s *Server) grpcHandler() http.Handler {
+	g := grpc.NewServer()
+	agentpb.RegisterAgentServer(g, &agentService{s: s})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestKey{}, r)))
+	})
+}
+
+type agentService struct {
+	agentpb.UnimplementedAgentServer
+	s *Server
+}
+
+func (a *agentService) GetSecret(ctx context.Context, req *agentpb.GetSecretRequest) (*agentpb.GetSecretResponse, error) {
+	r := callRequest(ctx)
+	envName := req.GetEnvironment()
+	if err := a.admit(ctx, r, envName, ScopeReadKey); err != nil {
+		return nil, err
+	}
+	values, code, err := a.s.values(r, envName, ScopeReadKey)
+	if err != nil {
+		return nil, a.refuse(r, envName, ScopeReadKey, code, err)
+	}
+	value, ok := values[req.GetKey()]
+	if !ok {
+		return nil, a.refuse(r, envName, ScopeReadKey, http.StatusNotFound, fmt.Errorf("key not found"))
+	}
+
+	body := []byte(value)
+	defer secmem.Wipe(body)
+	out, encrypted, code, err := a.s.seal(r, envName, body)
+	if err != nil {
+		return nil, a.refuse(r, envName, ScopeReadKey, code, err)
+	}
+	if err := a.audited(r, envName, ScopeReadKey, []string{req.GetKey()}); err != nil {
+		return nil, err
+	}
+	if encrypted {
+		return &agentpb.GetSecretResponse{EncryptedValue: out}, nil
+	}
+	return &agentpb.GetSecretResponse{Value: value}, nil
+}
+
+func (a *agentService) ListKeys(ctx context.Context, req *agentpb.ListKeysRequest) (*agentpb.ListKeysResponse, error) {
+	r := callRequest(ctx)
+	envName := req.GetEnvironment()
+	if err := a.admit(ctx, r, envName, ScopeList); err != nil {
+		return nil, err
+	}
+	values, code, err := a.s.values(r, envName, ScopeList)
+	if err != nil {
+		return nil, a.refuse(r, envName, ScopeList, code, err)
+	}
+	if err := a.audited(r, envName, ScopeList, nil); err != nil {
+		return nil, err
+	}
+	return &agentpb.ListKeysResponse{Keys: sortedKeys(values)}, nil
+}
+
+func (a *agentService) WatchEnvironment(req *agentpb.WatchEnvironmentRequest, stream agentpb.Agent_WatchEnvironmentServer) error {
+	ctx := stream.Context()
+	r := callRequest(ctx)
+	envName := req.GetEnvironment()
+	if err := a.admit(ctx, r, envName, opWatch); err != nil {
+		return err
+	}
+	current, code, err := a.s.values(r, envName, ScopeList)
+	if err != nil {
+		return a.refuse(r, envName, opWatch, code, err)
+	}
+	if err := a.audited(r, envName, opWatch, nil); err != nil {
+		return err
+	}
+
+	ready := &agentpb.Change{Type: agentpb.Change_TYPE_READY, Environment: envName, Added: sortedKeys(current)}
+	if err := stream.Send(ready); err != nil {
+		return err
+	}
+	code, err = a.s.watch(ctx, r, envName, current, func(change *Change, err error) error {
+		if err != nil {
+			return stream.Send(&agentpb.Change{Type: agentpb.Change_TYPE_ERROR, Environment: envName, Error: err.Error()})
+		}
+		return stream.Send(&agentpb.Change{
+			Type:        agentpb.Change_TYPE_UPDATE,
+			Environment: envName,
+			Added:       change.Added,
+			Changed:     change.Changed,
+			Removed:     change.Removed,
+		})
+	})
+	if code == http.StatusForbidden {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return err
+}
+
+// callRequest returns the HTTP request a call arrived in
+func callRequest(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(requestKey{}).(*http.Request)
+	return r
+}
+
+// admit applies the client's rate limit, sending retry-after when it is
+// spent
+func (a *agentService) admit(ctx context.Context, r *http.Request, envName, op string) error {
+	wait, err := a.s.overLimit(r, envName)
+	if err == nil {
+		return nil
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(wait)))
+	return a.refuse(r, envName, op, http.StatusTooManyRequests, err)
+}
+
+// refuse records a failed call and returns err as a gRPC status
+func (a *agentService) refuse(r *http.Request, envName, op string, httpStatus int, err error) error {
+	a.s.audit(r, envName, op, nil, httpStatus, err)
+	return status.Error(grpcCode(httpStatus), err.Error())
+}
+
+// audited records a call about to succeed, or fails it when the record
+// cannot be written, so no secret leaves unlogged
+func (a *agentService) audited(r *http.Request, envName, op string, keys []string) error {
+	if err := a.s.audit(r, envName, op, keys, http.StatusOK, nil); err != nil {
+		a.s.metrics.Error(envName)
+		return status.Error(codes.Internal, "audit log unavailable")
+	}
+	return nil
+}
+
+// grpcCode maps the HTTP status the shared checks fail with to a gRPC code
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
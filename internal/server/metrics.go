@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics collects counters exposed on /metrics in the Prometheus text format
+type Metrics struct {
+	mu          sync.Mutex
+	decryptions map[string]uint64
+	cacheHits   map[string]uint64
+	errors      map[string]uint64
+	lastReload  map[string]time.Time
+	started     time.Time
+}
+
+// NewMetrics creates an empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		decryptions: map[string]uint64{},
+		cacheHits:   map[string]uint64{},
+		errors:      map[string]uint64{},
+		lastReload:  map[string]time.Time{},
+		started:     time.Now(),
+	}
+}
+
+// Decrypted records a successful decryption (cache reload) of an environment
+func (m *Metrics) Decrypted(envName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decryptions[envName]++
+	m.lastReload[envName] = time.Now()
+}
+
+// CacheHit records a request served from the decrypted cache
+func (m *Metrics) CacheHit(envName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheHits[envName]++
+}
+
+// Error records a failed request for an environment
+func (m *Metrics) Error(envName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[envName]++
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+
+	writeCounter(cw, "envault_decryptions_total", "Successful decryptions per environment.", m.decryptions)
+	writeCounter(cw, "envault_cache_hits_total", "Requests served from the decrypted cache.", m.cacheHits)
+	writeCounter(cw, "envault_errors_total", "Failed requests per environment.", m.errors)
+
+	fmt.Fprintln(cw, "# HELP envault_last_reload_timestamp_seconds Unix time of the last decryption per environment.")
+	fmt.Fprintln(cw, "# TYPE envault_last_reload_timestamp_seconds gauge")
+	for _, envName := range sortedKeys(m.lastReload) {
+		fmt.Fprintf(cw, "envault_last_reload_timestamp_seconds{env=%q} %d\n", envName, m.lastReload[envName].Unix())
+	}
+
+	fmt.Fprintln(cw, "# HELP envault_start_timestamp_seconds Unix time the server started.")
+	fmt.Fprintln(cw, "# TYPE envault_start_timestamp_seconds gauge")
+	fmt.Fprintf(cw, "envault_start_timestamp_seconds %d\n", m.started.Unix())
+
+	return cw.n, cw.err
+}
+
+func writeCounter(w io.Writer, name, help string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, envName := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{env=%q} %d\n", name, envName, values[envName])
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingWriter tracks bytes written and the first error encountered
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// PeerCredentials reports whether socket peers can be identified by uid
+const PeerCredentials = true
+
+// peerUID returns the uid of the process connected to a unix socket
+func peerUID(c *net.UnixConn) (int, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if credErr != nil {
+		return -1, credErr
+	}
+	return int(cred.Uid), nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// PeerCredentials reports whether socket peers can be identified by uid
+const PeerCredentials = false
+
+// peerUID is only implemented on Linux (SO_PEERCRED), macOS and FreeBSD
+// (LOCAL_PEERCRED)
+func peerUID(c *net.UnixConn) (int, error) {
+	return -1, errors.ErrUnsupported
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket per client: each client may burst up to
+// its per-minute allowance, which then refills continuously
+type RateLimiter struct {
+	perMinute float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows each client perMinute requests a minute
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{perMinute: float64(perMinute), buckets: map[string]*bucket{}}
+}
+
+// Allow takes a request from the client's bucket. When it is empty, it
+// reports how long until the next request is allowed.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.perMinute, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.perMinute, b.tokens+now.Sub(b.last).Minutes()*l.perMinute)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.perMinute * float64(time.Minute))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
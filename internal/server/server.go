@@ -0,0 +1,338 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/resolve"
+	"github.com/orchard9/envault/internal/secmem"
+	"github.com/orchard9/envault/pkg/agentpb"
+)
+
+// Server serves decrypted environments over HTTP, and over gRPC on the
+// same listener. It backs both `envault serve` (TCP) and `envault agent`
+// (unix socket).
+type Server struct {
+	allowed map[string]bool // empty means every configured environment
+	metrics *Metrics
+
+	// NoCache decrypts on every request instead of keeping values in
+	// memory until the ciphertext changes
+	NoCache bool
+
+	// Tokens, when set, requires every /v1 request to present a bearer
+	// token scoped to the environment and operation
+	Tokens *Tokens
+
+	// Audit, when set, records every /v1 request; a request whose record
+	// cannot be written gets no secrets
+	Audit *AuditLog
+
+	// Limiter, when set, caps the requests each client may make
+	Limiter *RateLimiter
+
+	// Hosts are the names besides loopback addresses that TCP requests
+	// may give in their Host header (see checkHost)
+	Hosts []string
+
+	// SharedSocket says other users can reach the unix socket (SocketMode).
+	// Peers whose uid cannot be determined are then refused outright.
+	SharedSocket bool
+
+	// RequireTransit refuses to send values unless the client asks for
+	// them encrypted to its own recipient (RecipientHeader)
+	RequireTransit bool
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+}
+
+// cacheEntry holds decrypted values until the ciphertext changes on disk
+type cacheEntry struct {
+	values  map[string]string
+	modTime time.Time
+	size    int64
+}
+
+// New creates a server limited to the given environments (all if empty)
+func New(envNames []string) *Server {
+	allowed := make(map[string]bool, len(envNames))
+	for _, name := range envNames {
+		allowed[name] = true
+	}
+
+	return &Server{
+		allowed: allowed,
+		metrics: NewMetrics(),
+		cache:   map[string]*cacheEntry{},
+	}
+}
+
+// Handler returns the HTTP routes for the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("GET /v1/environments/{env}", s.handleEnvironment)
+	mux.HandleFunc("GET /v1/environments/{env}/{key}", s.handleKey)
+	mux.HandleFunc("GET /v1/keys/{env}", s.handleKeys)
+	mux.HandleFunc("GET /v1/watch/{env}", s.handleWatch)
+	mux.Handle("POST /"+agentpb.Agent_ServiceDesc.ServiceName+"/", s.grpcHandler())
+	return s.checkHost(mux)
+}
+
+// Serve accepts connections on l until it fails. Plaintext connections
+// may speak HTTP/2 without TLS (h2c), as gRPC clients do on the agent
+// socket.
+func (s *Server) Serve(l net.Listener) error {
+	srv := &http.Server{
+		Handler:           h2c.NewHandler(s.Handler(), &http2.Server{}),
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext:       connContext,
+	}
+	return srv.Serve(l)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if _, err := config.Load(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.metrics.WriteTo(w)
+}
+
+func (s *Server) handleEnvironment(w http.ResponseWriter, r *http.Request) {
+	envName := r.PathValue("env")
+	if !s.admit(w, r, envName, ScopeRead) {
+		return
+	}
+	values, status, err := s.values(r, envName, ScopeRead)
+	if err != nil {
+		s.refuse(w, r, envName, ScopeRead, status, err)
+		return
+	}
+	body, err := json.Marshal(values)
+	if err != nil {
+		s.refuse(w, r, envName, ScopeRead, http.StatusInternalServerError, err)
+		return
+	}
+	s.reply(w, r, envName, ScopeRead, sortedKeys(values), "application/json", append(body, '\n'))
+}
+
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
+	envName, key := r.PathValue("env"), r.PathValue("key")
+	if !s.admit(w, r, envName, ScopeReadKey) {
+		return
+	}
+	values, status, err := s.values(r, envName, ScopeReadKey)
+	if err != nil {
+		s.refuse(w, r, envName, ScopeReadKey, status, err)
+		return
+	}
+
+	value, ok := values[key]
+	if !ok {
+		s.refuse(w, r, envName, ScopeReadKey, http.StatusNotFound, fmt.Errorf("key not found"))
+		return
+	}
+	s.reply(w, r, envName, ScopeReadKey, []string{key}, "text/plain", []byte(value))
+}
+
+// handleKeys lists the variable names of an environment without values
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	envName := r.PathValue("env")
+	if !s.admit(w, r, envName, ScopeList) {
+		return
+	}
+	values, status, err := s.values(r, envName, ScopeList)
+	if err != nil {
+		s.refuse(w, r, envName, ScopeList, status, err)
+		return
+	}
+	if !s.audited(w, r, envName, ScopeList, nil) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sortedKeys(values))
+}
+
+// admit applies the client's rate limit, answering 429 when it is spent
+func (s *Server) admit(w http.ResponseWriter, r *http.Request, envName, op string) bool {
+	wait, err := s.overLimit(r, envName)
+	if err == nil {
+		return true
+	}
+	w.Header().Set("Retry-After", retryAfter(wait))
+	s.refuse(w, r, envName, op, http.StatusTooManyRequests, err)
+	return false
+}
+
+// overLimit spends one of the client's requests. When none are left it
+// returns how long to wait and the error to refuse the request with.
+func (s *Server) overLimit(r *http.Request, envName string) (time.Duration, error) {
+	if s.Limiter == nil {
+		return 0, nil
+	}
+	ok, wait := s.Limiter.Allow(s.clientName(r))
+	if ok {
+		return 0, nil
+	}
+	s.failed(envName)
+	return wait, fmt.Errorf("rate limit exceeded; retry in %s", wait.Round(time.Second))
+}
+
+// retryAfter renders a wait in whole seconds, rounded up
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// unknownEnv labels the errors of requests for names that are not
+// served environments, so made-up names cannot add metric series
+const unknownEnv = "unknown"
+
+// failed counts an error for a request whose environment name has not
+// been checked yet
+func (s *Server) failed(envName string) {
+	s.metrics.Error(s.metricEnv(envName))
+}
+
+// metricEnv returns envName if it is a served environment, else unknownEnv
+func (s *Server) metricEnv(envName string) string {
+	if len(s.allowed) > 0 {
+		if s.allowed[envName] {
+			return envName
+		}
+		return unknownEnv
+	}
+	if cfg, err := config.Load(); err == nil {
+		if _, ok := cfg.Environments[envName]; ok {
+			return envName
+		}
+	}
+	return unknownEnv
+}
+
+// refuse records a failed request and answers it with err
+func (s *Server) refuse(w http.ResponseWriter, r *http.Request, envName, op string, status int, err error) {
+	s.audit(r, envName, op, nil, status, err)
+	http.Error(w, err.Error(), status)
+}
+
+// audited records a request about to succeed. It answers 500 instead
+// when the record cannot be written, so no secret leaves unlogged.
+func (s *Server) audited(w http.ResponseWriter, r *http.Request, envName, op string, keys []string) bool {
+	if err := s.audit(r, envName, op, keys, http.StatusOK, nil); err != nil {
+		s.metrics.Error(envName)
+		http.Error(w, "audit log unavailable", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (s *Server) audit(r *http.Request, envName, op string, keys []string, status int, reqErr error) error {
+	if s.Audit == nil {
+		return nil
+	}
+	e := AuditEntry{
+		Time:        time.Now().UTC(),
+		Client:      s.clientName(r),
+		Remote:      r.RemoteAddr,
+		Op:          op,
+		Environment: envName,
+		Keys:        keys,
+		Status:      status,
+	}
+	if reqErr != nil {
+		e.Error = reqErr.Error()
+	}
+	return s.Audit.Write(e)
+}
+
+// values returns the decrypted variables for an environment, decrypting
+// again only when the ciphertext on disk has changed. scope is what the
+// caller will do with them, checked against its bearer token.
+func (s *Server) values(r *http.Request, envName, scope string) (map[string]string, int, error) {
+	if status, err := s.checkToken(r, envName, scope); err != nil {
+		s.failed(envName)
+		return nil, status, err
+	}
+
+	if len(s.allowed) > 0 && !s.allowed[envName] {
+		s.failed(envName)
+		return nil, http.StatusNotFound, fmt.Errorf("environment %s is not served", envName)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		s.failed(envName)
+		return nil, http.StatusInternalServerError, err
+	}
+
+	encryptedPath, err := cfg.EncryptedPath(envName)
+	if err != nil {
+		s.failed(envName)
+		return nil, http.StatusNotFound, err
+	}
+
+	if err := s.authorize(r, envName, cfg.Environments[envName]); err != nil {
+		s.metrics.Error(envName)
+		return nil, http.StatusForbidden, err
+	}
+
+	info, err := os.Stat(encryptedPath)
+	if err != nil {
+		s.metrics.Error(envName)
+		return nil, http.StatusNotFound, fmt.Errorf("encrypted file for %s does not exist", envName)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.cache[envName]; ok && !s.NoCache && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		s.metrics.CacheHit(envName)
+		return entry.values, http.StatusOK, nil
+	}
+
+	plaintext, err := crypto.Decrypt(envName)
+	if err != nil {
+		s.metrics.Error(envName)
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to decrypt %s", envName)
+	}
+
+	values, err := dotenv.ParseMap(plaintext)
+	secmem.Wipe(plaintext)
+	if err != nil {
+		s.metrics.Error(envName)
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to parse %s: %w", envName, err)
+	}
+	if err := resolve.Values(values); err != nil {
+		s.metrics.Error(envName)
+		return nil, http.StatusBadGateway, err
+	}
+
+	if !s.NoCache {
+		s.cache[envName] = &cacheEntry{values: values, modTime: info.ModTime(), size: info.Size()}
+	}
+	s.metrics.Decrypted(envName)
+
+	return values, http.StatusOK, nil
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/testvault"
+	"github.com/orchard9/envault/pkg/agentpb"
+)
+
+// newVault creates a vault holding a dev environment and makes it the
+// current project
+func newVault(t *testing.T) *testvault.Vault {
+	t.Helper()
+	for _, bin := range []string{"ssh-keygen", "age"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not installed", bin)
+		}
+	}
+	vault, err := testvault.Create(t.TempDir(), filepath.Join(t.TempDir(), "id_ed25519"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.SetEnvironment("dev", map[string]string{"API_KEY": "sk-test-1", "PORT": "3000"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENVAULT_IDENTITY", vault.Identity)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(vault.Dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	return vault
+}
+
+// serveSocket runs s on a unix socket and returns its path
+func serveSocket(t *testing.T, s *Server) string {
+	t.Helper()
+	// Socket paths are limited to about 100 bytes, too short for TempDir
+	dir, err := os.MkdirTemp("", "envault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+	return path
+}
+
+func dialAgent(t *testing.T, target string) agentpb.AgentClient {
+	t.Helper()
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return agentpb.NewAgentClient(conn)
+}
+
+func TestAgentSocket(t *testing.T) {
+	vault := newVault(t)
+	path := serveSocket(t, New(nil))
+
+	// HTTP/1.1 and gRPC share the socket
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := httpClient.Get("http://agent/v1/keys/dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	err = json.NewDecoder(resp.Body).Decode(&names)
+	resp.Body.Close()
+	if err != nil || len(names) != 2 {
+		t.Fatalf("GET /v1/keys/dev: %v %v, want API_KEY and PORT", names, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	client := dialAgent(t, "unix:"+path)
+
+	keys, err := client.ListKeys(ctx, &agentpb.ListKeysRequest{Environment: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys.GetKeys(); len(got) != 2 || got[0] != "API_KEY" || got[1] != "PORT" {
+		t.Errorf("ListKeys = %v, want [API_KEY PORT]", got)
+	}
+
+	secret, err := client.GetSecret(ctx, &agentpb.GetSecretRequest{Environment: "dev", Key: "API_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.GetValue() != "sk-test-1" || secret.GetEncryptedValue() != nil {
+		t.Errorf("GetSecret = %q, want sk-test-1 in plaintext", secret.GetValue())
+	}
+
+	tests := []struct {
+		env, key string
+		want     codes.Code
+	}{
+		{"dev", "MISSING", codes.NotFound},
+		{"prod", "API_KEY", codes.NotFound},
+	}
+	for _, tt := range tests {
+		_, err := client.GetSecret(ctx, &agentpb.GetSecretRequest{Environment: tt.env, Key: tt.key})
+		if status.Code(err) != tt.want {
+			t.Errorf("GetSecret(%s, %s): got %v, want %v", tt.env, tt.key, err, tt.want)
+		}
+	}
+
+	stream, err := client.WatchEnvironment(ctx, &agentpb.WatchEnvironmentRequest{Environment: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready.GetType() != agentpb.Change_TYPE_READY || len(ready.GetAdded()) != 2 {
+		t.Errorf("first change = %v, want READY with both names", ready)
+	}
+	if err := vault.SetEnvironment("dev", map[string]string{"API_KEY": "sk-test-2", "DEBUG": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	update, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.GetType() != agentpb.Change_TYPE_UPDATE || !equal(update.GetAdded(), "DEBUG") || !equal(update.GetChanged(), "API_KEY") || !equal(update.GetRemoved(), "PORT") {
+		t.Errorf("update = %v, want DEBUG added, API_KEY changed and PORT removed", update)
+	}
+}
+
+func TestTokensOverGRPC(t *testing.T) {
+	newVault(t)
+	listSecret, listHash, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(nil)
+	s.Tokens = &Tokens{Tokens: []Token{{Name: "lister", Hash: listHash, Environments: []string{"dev"}, Scopes: []string{ScopeList}}}}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+	client := dialAgent(t, l.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	authorized := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+listSecret)
+
+	if _, err := client.ListKeys(ctx, &agentpb.ListKeysRequest{Environment: "dev"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ListKeys without a token: got %v, want Unauthenticated", err)
+	}
+	if _, err := client.ListKeys(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer evt_wrong"), &agentpb.ListKeysRequest{Environment: "dev"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ListKeys with a bad token: got %v, want Unauthenticated", err)
+	}
+	if _, err := client.ListKeys(authorized, &agentpb.ListKeysRequest{Environment: "dev"}); err != nil {
+		t.Errorf("ListKeys with a list token: %v", err)
+	}
+	if _, err := client.GetSecret(authorized, &agentpb.GetSecretRequest{Environment: "dev", Key: "API_KEY"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetSecret with a list token: got %v, want PermissionDenied", err)
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	s := New(nil)
+	s.Hosts = []string{"secrets.internal"}
+	tests := []struct {
+		host string
+		want int
+	}{
+		{"127.0.0.1:8080", http.StatusOK},
+		{"localhost:8080", http.StatusOK},
+		{"[::1]:8080", http.StatusOK},
+		{"secrets.internal", http.StatusOK},
+		{"evil.example:8080", http.StatusMisdirectedRequest},
+		{"127.0.0.1.evil.example", http.StatusMisdirectedRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/healthz", nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		s.checkHost(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("Host %s: got %d, want %d", tt.host, w.Code, tt.want)
+		}
+	}
+}
+
+// An unidentified peer is only served while the socket is private to the
+// agent's user: a shared socket would otherwise serve anyone
+func TestAuthorizeUnknownPeer(t *testing.T) {
+	tests := []struct {
+		name   string
+		shared bool
+		access *config.Access
+		ok     bool
+	}{
+		{"private socket", false, nil, true},
+		{"private socket, access list", false, &config.Access{UIDs: []int{1001}}, false},
+		{"shared socket", true, nil, false},
+		{"shared socket, access list", true, &config.Access{UIDs: []int{1001}}, false},
+	}
+	for _, tt := range tests {
+		s := New(nil)
+		s.SharedSocket = tt.shared
+		r := httptest.NewRequest("GET", "/v1/keys/dev", nil)
+		r = r.WithContext(context.WithValue(r.Context(), peerKey{}, peer{unix: true, uid: -1}))
+		err := s.authorize(r, "dev", config.Environment{Access: tt.access})
+		if tt.ok && err != nil {
+			t.Errorf("%s: refused: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: served, want a refusal", tt.name)
+		}
+	}
+}
+
+func TestSocketMode(t *testing.T) {
+	tests := []struct {
+		shared, peerCredentials bool
+		want                    os.FileMode
+	}{
+		{false, true, 0600},
+		{true, true, 0666},
+		{false, false, 0600},
+		{true, false, 0600}, // peers could not be told apart
+	}
+	for _, tt := range tests {
+		if got := socketMode(tt.shared, tt.peerCredentials); got != tt.want {
+			t.Errorf("socketMode(%v, %v) = %04o, want %04o", tt.shared, tt.peerCredentials, got, tt.want)
+		}
+	}
+}
+
+func equal(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/state"
+)
+
+// Scopes a bearer token can be granted
+const (
+	ScopeRead    = "read"     // every value: GET /v1/environments/<env>
+	ScopeReadKey = "read-key" // one value at a time: GET /v1/environments/<env>/<key>, GetSecret
+	ScopeList    = "list"     // names only: GET /v1/keys/<env>, /v1/watch/<env>, ListKeys, WatchEnvironment
+)
+
+// Scopes lists every valid scope
+var Scopes = []string{ScopeRead, ScopeReadKey, ScopeList}
+
+// TokenPrefix starts every token envault issues, so leaks are easy to spot
+const TokenPrefix = "evt_"
+
+// tokensFileName is the default tokens file, under .envault
+const tokensFileName = "tokens.yaml"
+
+// Token is one consumer's credential. Only the hash of the token is
+// stored; the token itself is shown once, when it is created.
+type Token struct {
+	Name         string   `yaml:"name"`
+	Hash         string   `yaml:"hash"`         // sha256:<hex> of the token
+	Environments []string `yaml:"environments"` // "*" for every served environment
+	Scopes       []string `yaml:"scopes"`
+}
+
+// Tokens is a tokens file
+type Tokens struct {
+	Tokens []Token `yaml:"tokens"`
+}
+
+// TokensPath returns the default tokens file, .envault/tokens.yaml
+func TokensPath() (string, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(envaultDir, tokensFileName), nil
+}
+
+// LoadTokens reads and validates a tokens file. A missing file is an
+// error wrapping fs.ErrNotExist.
+func LoadTokens(path string) (*Tokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+
+	var t Tokens
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Validate checks names, hashes and scopes
+func (t *Tokens) Validate() error {
+	seen := map[string]bool{}
+	for i, token := range t.Tokens {
+		if token.Name == "" {
+			return fmt.Errorf("token %d has no name", i+1)
+		}
+		if seen[token.Name] {
+			return fmt.Errorf("token %s is defined twice", token.Name)
+		}
+		seen[token.Name] = true
+
+		digest, ok := strings.CutPrefix(token.Hash, "sha256:")
+		if raw, err := hex.DecodeString(digest); !ok || err != nil || len(raw) != sha256.Size {
+			return fmt.Errorf("token %s has an invalid hash (expected sha256:<hex>)", token.Name)
+		}
+		if len(token.Environments) == 0 {
+			return fmt.Errorf("token %s lists no environments", token.Name)
+		}
+		if len(token.Scopes) == 0 {
+			return fmt.Errorf("token %s has no scopes", token.Name)
+		}
+		for _, scope := range token.Scopes {
+			if !contains(Scopes, scope) {
+				return fmt.Errorf("token %s has invalid scope %q (use %s)", token.Name, scope, strings.Join(Scopes, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// Save writes the tokens file with mode 0600. Inside .envault it is also
+// gitignored: the hashes are safe at rest, but they describe one host.
+func (t *Tokens) Save(path string) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tokens: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	absPath, _ := filepath.Abs(path)
+	if defaultPath, err := TokensPath(); err == nil && absPath == defaultPath {
+		return state.EnsureIgnored(filepath.Dir(path), tokensFileName)
+	}
+	return nil
+}
+
+// Find returns the token with the given name, or nil
+func (t *Tokens) Find(name string) *Token {
+	for i := range t.Tokens {
+		if t.Tokens[i].Name == name {
+			return &t.Tokens[i]
+		}
+	}
+	return nil
+}
+
+// Authenticate returns the token whose hash matches secret, or nil
+func (t *Tokens) Authenticate(secret string) *Token {
+	presented := HashToken(secret)
+	var match *Token
+	for i := range t.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Tokens[i].Hash), []byte(presented)) == 1 {
+			match = &t.Tokens[i]
+		}
+	}
+	return match
+}
+
+// Allows reports whether the token grants scope on an environment
+func (t *Token) Allows(envName, scope string) bool {
+	if !contains(t.Scopes, scope) {
+		return false
+	}
+	return contains(t.Environments, "*") || contains(t.Environments, envName)
+}
+
+// NewToken generates a random token and returns it with its hash
+func NewToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	secret := TokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	return secret, HashToken(secret), nil
+}
+
+// HashToken returns the form a token is stored in
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// checkToken requires a bearer token granting scope on the environment,
+// when the server has a tokens file
+func (s *Server) checkToken(r *http.Request, envName, scope string) (int, error) {
+	if s.Tokens == nil {
+		return http.StatusOK, nil
+	}
+
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || secret == "" {
+		return http.StatusUnauthorized, fmt.Errorf("a bearer token is required")
+	}
+	token := s.Tokens.Authenticate(strings.TrimSpace(secret))
+	if token == nil {
+		return http.StatusUnauthorized, fmt.Errorf("invalid bearer token")
+	}
+	if !token.Allows(envName, scope) {
+		return http.StatusForbidden, fmt.Errorf("token %s does not grant %s on environment %s", token.Name, scope, envName)
+	}
+	return http.StatusOK, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
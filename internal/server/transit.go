@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/secmem"
+)
+
+// RecipientHeader carries an age recipient from the client (gRPC clients
+// send it as envault-recipient metadata). Responses holding values are
+// then encrypted to it, and the client decrypts them with the matching,
+// usually throwaway, identity.
+const RecipientHeader = "Envault-Recipient"
+
+// TypeHeader gives the media type of an encrypted response's plaintext
+const TypeHeader = "Envault-Content-Type"
+
+// ContentTypeAge is the media type of a response encrypted to the client
+const ContentTypeAge = "application/age"
+
+// transitRecipient returns the recipient the client asked for, or nil.
+// Only native X25519 recipients are accepted: encrypting to a plugin
+// recipient would run a plugin the client named.
+func transitRecipient(r *http.Request) (*keys.Key, error) {
+	line := strings.TrimSpace(r.Header.Get(RecipientHeader))
+	if line == "" {
+		return nil, nil
+	}
+	key, err := keys.ParseKey(line)
+	if err != nil || key.Type != "age" || key.Plugin() != "" || len(key.Data) != 62 || key.Comment != "" {
+		return nil, fmt.Errorf("%s must be a single age X25519 recipient (age1...)", RecipientHeader)
+	}
+	return key, nil
+}
+
+// reply sends a response that carries values, encrypted when the client
+// sent a recipient. Without one it is refused if RequireTransit is set.
+// body is wiped once written.
+func (s *Server) reply(w http.ResponseWriter, r *http.Request, envName, op string, keyNames []string, contentType string, body []byte) {
+	defer secmem.Wipe(body)
+
+	out, encrypted, status, err := s.seal(r, envName, body)
+	if err != nil {
+		s.refuse(w, r, envName, op, status, err)
+		return
+	}
+	if encrypted {
+		w.Header().Set(TypeHeader, contentType)
+		contentType = ContentTypeAge
+	}
+
+	if !s.audited(w, r, envName, op, keyNames) {
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(out)
+}
+
+// seal encrypts body to the recipient the client sent, reporting whether
+// it did. Without a recipient body is returned as is, unless
+// RequireTransit refuses it.
+func (s *Server) seal(r *http.Request, envName string, body []byte) ([]byte, bool, int, error) {
+	recipient, err := transitRecipient(r)
+	if err == nil && recipient == nil && s.RequireTransit {
+		err = fmt.Errorf("values are only sent encrypted; put an age recipient in the %s header (envault-recipient metadata over gRPC)", RecipientHeader)
+	}
+	if err != nil {
+		s.metrics.Error(envName)
+		return nil, false, http.StatusBadRequest, err
+	}
+	if recipient == nil {
+		return body, false, http.StatusOK, nil
+	}
+
+	backend, err := crypto.LookupBackend("age")
+	if err != nil {
+		s.metrics.Error(envName)
+		return nil, false, http.StatusInternalServerError, err
+	}
+	var ciphertext bytes.Buffer
+	if err := backend.Encrypt(body, []keys.Key{*recipient}, &ciphertext); err != nil {
+		s.metrics.Error(envName)
+		return nil, false, http.StatusInternalServerError, fmt.Errorf("failed to encrypt the response")
+	}
+	return ciphertext.Bytes(), true, http.StatusOK, nil
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// watchInterval is how often a watched environment's ciphertext is checked
+const watchInterval = 2 * time.Second
+
+// opWatch is the audit operation for a watch stream, logged once when it
+// opens; its polls are neither logged nor rate limited
+const opWatch = "watch"
+
+// Change lists the variables that differ between two versions of an
+// environment. Values are never sent; clients fetch what they need.
+type Change struct {
+	Environment string   `json:"environment"`
+	Added       []string `json:"added,omitempty"`
+	Changed     []string `json:"changed,omitempty"`
+	Removed     []string `json:"removed,omitempty"`
+}
+
+// handleWatch streams server-sent events: "ready" with the current names,
+// then "update" whenever the environment's variables change. Access is
+// checked again on every poll, so revoking it ends the stream.
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	envName := r.PathValue("env")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.admit(w, r, envName, opWatch) {
+		return
+	}
+	current, status, err := s.values(r, envName, ScopeList)
+	if err != nil {
+		s.refuse(w, r, envName, opWatch, status, err)
+		return
+	}
+	if !s.audited(w, r, envName, opWatch, nil) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	writeEvent(w, "ready", Change{Environment: envName, Added: sortedKeys(current)})
+	flusher.Flush()
+
+	s.watch(r.Context(), r, envName, current, func(change *Change, err error) error {
+		if err != nil {
+			writeEvent(w, "error", map[string]string{"error": err.Error()})
+		} else {
+			writeEvent(w, "update", change)
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+// watch polls an environment until ctx ends, passing send each change, or
+// the error a poll failed with. It stops with 403 and that error once
+// access is revoked, or with the error send returns.
+func (s *Server) watch(ctx context.Context, r *http.Request, envName string, current map[string]string, send func(*Change, error) error) (int, error) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return http.StatusOK, nil
+		case <-ticker.C:
+		}
+
+		next, status, err := s.values(r, envName, ScopeList)
+		if err != nil {
+			if sendErr := send(nil, err); sendErr != nil {
+				return http.StatusOK, sendErr
+			}
+			if status == http.StatusForbidden {
+				return status, err
+			}
+			continue
+		}
+
+		if change := diff(envName, current, next); change != nil {
+			if err := send(change, nil); err != nil {
+				return http.StatusOK, err
+			}
+		}
+		current = next
+	}
+}
+
+// diff compares two versions of an environment, returning nil when equal
+func diff(envName string, before, after map[string]string) *Change {
+	change := &Change{Environment: envName}
+	for _, key := range sortedKeys(after) {
+		value, ok := before[key]
+		switch {
+		case !ok:
+			change.Added = append(change.Added, key)
+		case value != after[key]:
+			change.Changed = append(change.Changed, key)
+		}
+	}
+	for _, key := range sortedKeys(before) {
+		if _, ok := after[key]; !ok {
+			change.Removed = append(change.Removed, key)
+		}
+	}
+	if len(change.Added)+len(change.Changed)+len(change.Removed) == 0 {
+		return nil
+	}
+	return change
+}
+
+func writeEvent(w http.ResponseWriter, event string, data any) {
+	encoded, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+}
@@ -0,0 +1,197 @@
+// Package session keeps decrypted environments in a background process
+// for a limited time, so repeated envault exec runs skip decryption. The
+// process holds the values in memory only and serves them on a unix
+// socket that only the user can open. It never decrypts anything itself:
+// envault session start decrypts in the foreground and hands it the
+// values.
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/orchard9/envault/internal/dotenv"
+)
+
+// SocketName is the session socket inside .envault
+const SocketName = "session.sock"
+
+// ErrNotRunning is returned when no session is listening
+var ErrNotRunning = errors.New("no session is running (start one with: envault session start <env...>)")
+
+// Environment is one environment held by a session
+type Environment struct {
+	CiphertextHash string         `json:"ciphertext_hash"` // the entries are stale once this changes
+	Entries        []dotenv.Entry `json:"entries"`
+}
+
+// Payload is what a session serves
+type Payload struct {
+	Expires      time.Time               `json:"expires"`
+	Environments map[string]*Environment `json:"environments"`
+}
+
+// Info describes a running session
+type Info struct {
+	PID          int       `json:"pid"`
+	Expires      time.Time `json:"expires"`
+	Environments []string  `json:"environments"`
+}
+
+// SocketPath returns the session socket of a vault directory
+func SocketPath(envaultDir string) string {
+	return filepath.Join(envaultDir, SocketName)
+}
+
+// Serve reads the payload from r and serves it on socketPath until it
+// expires or Stop is called. ready is called once the socket accepts
+// connections.
+func Serve(socketPath string, r io.Reader, ready func()) error {
+	var p Payload
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return fmt.Errorf("failed to read session: %w", err)
+	}
+
+	// Remove a stale socket left by a session that was killed
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	defer os.Remove(socketPath)
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	names := make([]string, 0, len(p.Environments))
+	for name := range p.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	info := Info{PID: os.Getpid(), Expires: p.Expires, Environments: names}
+
+	stop := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/session", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, info)
+	})
+	mux.HandleFunc("GET /v1/session/{env}", func(w http.ResponseWriter, r *http.Request) {
+		env, ok := p.Environments[r.PathValue("env")]
+		if !ok {
+			http.Error(w, "environment not in session", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, env)
+	})
+	mux.HandleFunc("POST /v1/session/stop", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		select {
+		case stop <- struct{}{}:
+		default:
+		}
+	})
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		select {
+		case <-stop:
+		case <-time.After(time.Until(p.Expires)):
+		}
+		// Let the stop request finish before the listener goes
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	ready()
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
+
+// Get returns an environment held by the session. It is nil, without an
+// error, when the session does not hold it.
+func Get(socketPath, envName string) (*Environment, error) {
+	var env Environment
+	found, err := request(socketPath, http.MethodGet, "/v1/session/"+envName, &env)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &env, nil
+}
+
+// Status describes the running session
+func Status(socketPath string) (*Info, error) {
+	var info Info
+	if _, err := request(socketPath, http.MethodGet, "/v1/session", &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Stop ends the running session
+func Stop(socketPath string) error {
+	_, err := request(socketPath, http.MethodPost, "/v1/session/stop", nil)
+	return err
+}
+
+// request calls the session, returning ErrNotRunning when nothing listens
+// and found false for a 404
+func request(socketPath, method, path string, out any) (bool, error) {
+	if _, err := os.Stat(socketPath); err != nil {
+		return false, ErrNotRunning
+	}
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+	req, err := http.NewRequest(method, "http://session"+path, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, ErrNotRunning
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode >= 300:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, fmt.Errorf("session: %s: %s", resp.Status, body)
+	case out == nil:
+		return true, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("session: invalid response: %w", err)
+	}
+	return true, nil
+}
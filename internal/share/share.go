@@ -0,0 +1,124 @@
+package share
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/keys"
+)
+
+// Payload is the plaintext content of a share blob
+type Payload struct {
+	ID        string    `json:"id"`
+	Env       string    `json:"env"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Create encrypts a single secret for the given recipients and returns a
+// base64 blob suitable for pasting into chat or email
+func Create(backend crypto.Backend, envName, key, value string, recipients []keys.Key, ttl time.Duration) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate share id: %w", err)
+	}
+
+	payload := Payload{
+		ID:        hex.EncodeToString(id),
+		Env:       envName,
+		Key:       key,
+		Value:     value,
+		CreatedAt: time.Now().UTC(),
+	}
+	if ttl > 0 {
+		payload.ExpiresAt = payload.CreatedAt.Add(ttl)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode share: %w", err)
+	}
+
+	var ciphertext bytes.Buffer
+	if err := backend.Encrypt(data, recipients, &ciphertext); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(ciphertext.Bytes()), nil
+}
+
+// Open decrypts a share blob with the local identity. Each share can be
+// opened once per machine; expired shares are rejected.
+func Open(backend crypto.Backend, blob string) (*Payload, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(blob))
+	if err != nil {
+		return nil, fmt.Errorf("invalid share blob: %w", err)
+	}
+
+	data, err := backend.Decrypt(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("invalid share payload: %w", err)
+	}
+
+	if !payload.ExpiresAt.IsZero() && time.Now().After(payload.ExpiresAt) {
+		return nil, fmt.Errorf("share expired at %s", payload.ExpiresAt.Format(time.RFC3339))
+	}
+
+	if err := markReceived(payload.ID); err != nil {
+		return nil, err
+	}
+
+	return &payload, nil
+}
+
+// markReceived records a share id, failing if it was already received
+func markReceived(id string) error {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return fmt.Errorf("failed to get config directory: %w", err)
+	}
+
+	dir := filepath.Join(configDir, "envault")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	receivedPath := filepath.Join(dir, "received_shares")
+	data, err := os.ReadFile(receivedPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", receivedPath, err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == id {
+			return fmt.Errorf("share %s was already received", id)
+		}
+	}
+
+	file, err := os.OpenFile(receivedPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", receivedPath, err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(id + "\n"); err != nil {
+		return fmt.Errorf("failed to record share: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package shellenv
+
+import "fmt"
+
+// The completion scripts pass the words typed after envault, the last one
+// being the word under the cursor, to the hidden `envault __complete`,
+// which prints one candidate per line. Nothing is printed after --, so
+// the shell falls back to completing files.
+
+const bashCompletion = `_envault_complete() {
+  local IFS=$'\n'
+  COMPREPLY=($(command envault __complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _envault_complete envault
+
+_envault_use_complete() {
+  local IFS=$'\n'
+  COMPREPLY=($(command envault __complete -- export "${COMP_WORDS[COMP_CWORD]}" 2>/dev/null))
+}
+complete -F _envault_use_complete envault_use
+`
+
+const zshCompletion = `_envault() {
+  local -a candidates
+  candidates=("${(@f)$(command envault __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+  if (( ${#candidates[@]} == 1 )) && [[ -z ${candidates[1]} ]]; then
+    _files
+    return
+  fi
+  compadd -a candidates
+}
+compdef _envault envault
+
+_envault_use() {
+  local -a candidates
+  candidates=("${(@f)$(command envault __complete -- export "${words[CURRENT]}" 2>/dev/null)}")
+  compadd -a candidates
+}
+compdef _envault_use envault_use
+`
+
+const fishCompletion = `function __envault_complete
+    set -l words (commandline -opc) (commandline -ct)
+    command envault __complete -- $words[2..-1] 2>/dev/null
+end
+complete -c envault -f -a '(__envault_complete)'
+complete -c envault_use -f -a '(command envault __complete -- export (commandline -ct) 2>/dev/null)'
+`
+
+// Completion returns the script emitted by `envault completion`
+func Completion(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion, nil
+	case "zsh":
+		return zshCompletion, nil
+	case "fish":
+		return fishCompletion, nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q (supported: bash, zsh, fish)", shell)
+	}
+}
@@ -0,0 +1,90 @@
+package shellenv
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/orchard9/envault/internal/dotenv"
+)
+
+// Tracking variables set alongside the secrets by `export --track`
+const (
+	TrackEnvVar  = "ENVAULT_ENV"
+	TrackVarsVar = "ENVAULT_VARS"
+)
+
+// Track appends entries recording the environment name and the variables
+// being exported, so a later drop can unset exactly those
+func Track(envName string, entries []dotenv.Entry) []dotenv.Entry {
+	seen := map[string]bool{}
+	var names []string
+	for _, e := range entries {
+		if !seen[e.Key] {
+			seen[e.Key] = true
+			names = append(names, e.Key)
+		}
+	}
+
+	return append(entries,
+		dotenv.Entry{Key: TrackEnvVar, Value: envName},
+		dotenv.Entry{Key: TrackVarsVar, Value: strings.Join(names, " ")},
+	)
+}
+
+// posixInit defines envault_use and envault_drop for bash and zsh.
+// %s is the word-splitting expansion of ENVAULT_VARS for the shell.
+const posixInit = `envault_use() {
+  if [ $# -ne 1 ]; then
+    echo "usage: envault_use <env>" >&2
+    return 1
+  fi
+  local _envault_out
+  _envault_out="$(command envault export "$1" --shell posix --track)" || return $?
+  envault_drop
+  eval "$_envault_out"
+}
+
+envault_drop() {
+  [ -n "$ENVAULT_VARS" ] || return 0
+  local _envault_var
+  for _envault_var in %s; do
+    unset "$_envault_var"
+  done
+  unset ENVAULT_VARS ENVAULT_ENV
+}
+`
+
+const fishInit = `function envault_use --description 'Load an envault environment into this shell'
+    if test (count $argv) -ne 1
+        echo "usage: envault_use <env>" >&2
+        return 1
+    end
+    set -l out (command envault export $argv[1] --shell fish --track)
+    or return $status
+    envault_drop
+    printf '%s\n' $out | source
+end
+
+function envault_drop --description 'Unset variables loaded by envault_use'
+    set -q ENVAULT_VARS; or return 0
+    for var in (string split ' ' -- $ENVAULT_VARS)
+        set -e $var
+    end
+    set -e ENVAULT_VARS ENVAULT_ENV
+end
+`
+
+// Init returns the shell functions emitted by `envault shell-init`
+func Init(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return fmt.Sprintf(posixInit, "$ENVAULT_VARS"), nil
+	case "zsh":
+		// zsh does not word-split unquoted parameters
+		return fmt.Sprintf(posixInit, "${=ENVAULT_VARS}"), nil
+	case "fish":
+		return fishInit, nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q (supported: bash, zsh, fish)", shell)
+	}
+}
@@ -0,0 +1,88 @@
+package shellenv
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/orchard9/envault/internal/dotenv"
+)
+
+// Shells lists the supported output dialects
+var Shells = []string{"posix", "bash", "zsh", "fish", "powershell", "cmd"}
+
+// Export renders variable assignments for the given shell
+func Export(shell string, entries []dotenv.Entry) (string, error) {
+	var b strings.Builder
+	for _, e := range entries {
+		line, err := exportLine(shell, e.Key, e.Value)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// Unset renders commands removing the named variables
+func Unset(shell string, names []string) (string, error) {
+	var b strings.Builder
+	for _, name := range names {
+		if !dotenv.ValidName(name) {
+			return "", fmt.Errorf("%q is not a valid variable name", name)
+		}
+		switch shell {
+		case "posix", "bash", "zsh", "":
+			fmt.Fprintf(&b, "unset %s\n", name)
+		case "fish":
+			fmt.Fprintf(&b, "set -e %s\n", name)
+		case "powershell":
+			fmt.Fprintf(&b, "Remove-Item Env:%s -ErrorAction SilentlyContinue\n", name)
+		case "cmd":
+			fmt.Fprintf(&b, "set %s=\n", name)
+		default:
+			return "", unknownShell(shell)
+		}
+	}
+	return b.String(), nil
+}
+
+func exportLine(shell, key, value string) (string, error) {
+	if !dotenv.ValidName(key) {
+		return "", fmt.Errorf("%q is not a valid variable name", key)
+	}
+
+	switch shell {
+	case "posix", "bash", "zsh", "":
+		return fmt.Sprintf("export %s=%s", key, posixQuote(value)), nil
+	case "fish":
+		return fmt.Sprintf("set -gx %s %s", key, fishQuote(value)), nil
+	case "powershell":
+		// Single-quoted strings are literal; only ' needs doubling
+		return fmt.Sprintf("$env:%s = '%s'", key, strings.ReplaceAll(value, "'", "''")), nil
+	case "cmd":
+		if strings.ContainsAny(value, "\r\n") {
+			return "", fmt.Errorf("%s contains a newline, which cmd cannot represent", key)
+		}
+		// The quoted form keeps & | < > ^ literal; %% survives batch files
+		return fmt.Sprintf(`set "%s=%s"`, key, strings.ReplaceAll(value, "%", "%%")), nil
+	default:
+		return "", unknownShell(shell)
+	}
+}
+
+// posixQuote wraps s in single quotes, closing and escaping embedded ones
+func posixQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote single-quotes s; fish allows \\ and \' inside single quotes
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "'", `\'`)
+	return "'" + s + "'"
+}
+
+func unknownShell(shell string) error {
+	return fmt.Errorf("unknown shell %q (supported: %s)", shell, strings.Join(Shells, ", "))
+}
@@ -0,0 +1,66 @@
+package sshsig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// namespace scopes SSH signatures so they can't be replayed elsewhere;
+// each kind of signed message also carries its own header line
+const namespace = "envault"
+
+// Sign signs message with an SSH private key via ssh-keygen -Y sign and
+// returns the armored signature
+func Sign(privateKey string, message []byte) (string, error) {
+	cmd := exec.Command("ssh-keygen", "-Y", "sign", "-f", privateKey, "-n", namespace)
+	cmd.Stdin = bytes.NewReader(message)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("ssh-keygen signing failed: %w\nStderr: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// Verify checks an armored SSH signature over message against a public
+// key ("ssh-ed25519 AAAA...") with ssh-keygen -Y verify
+func Verify(publicKey string, message []byte, signature string) error {
+	return VerifyNamespace(namespace, publicKey, message, signature)
+}
+
+// VerifyNamespace is Verify for signatures made under another namespace,
+// such as release checksums signed with ssh-keygen -Y sign -n <namespace>
+func VerifyNamespace(namespace, publicKey string, message []byte, signature string) error {
+	dir, err := os.MkdirTemp("", "envault-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	signersPath := filepath.Join(dir, "allowed_signers")
+	if err := os.WriteFile(signersPath, []byte(namespace+" "+publicKey+"\n"), 0600); err != nil {
+		return err
+	}
+
+	sigPath := filepath.Join(dir, "message.sig")
+	if err := os.WriteFile(sigPath, []byte(signature), 0600); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", signersPath, "-I", namespace, "-n", namespace, "-s", sigPath)
+	cmd.Stdin = bytes.NewReader(message)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("signature verification failed: %w\nStderr: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package sshsig
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newKey generates an unencrypted ed25519 key and returns the private key
+// path and the public key line
+func newKey(t *testing.T, name string) (string, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", name, "-f", path).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	pub, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, strings.TrimSpace(string(pub))
+}
+
+func TestSignVerify(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not installed")
+	}
+	alice, alicePub := newKey(t, "alice")
+	_, bobPub := newKey(t, "bob")
+
+	message := []byte("envault recipient bundle v1\nssh-ed25519 AAAA carol\n")
+	sig, err := Sign(alice, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sig, "-----BEGIN SSH SIGNATURE-----") {
+		t.Fatalf("Sign returned %q, want an armored signature", sig)
+	}
+
+	// A signature made for another namespace must not verify as envault's
+	other := filepath.Join(t.TempDir(), "message")
+	if err := os.WriteFile(other, message, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("ssh-keygen", "-Y", "sign", "-f", alice, "-n", "git", other).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	gitSig, err := os.ReadFile(other + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		publicKey string
+		message   []byte
+		signature string
+		ok        bool
+	}{
+		{"valid", alicePub, message, sig, true},
+		{"key without comment", strings.Join(strings.Fields(alicePub)[:2], " "), message, sig, true},
+		{"tampered message", alicePub, append([]byte("x"), message...), sig, false},
+		{"wrong key", bobPub, message, sig, false},
+		{"other namespace", alicePub, message, string(gitSig), false},
+		{"not a signature", alicePub, message, "-----BEGIN SSH SIGNATURE-----\nAAAA\n-----END SSH SIGNATURE-----\n", false},
+		{"empty signature", alicePub, message, "", false},
+	}
+	for _, tt := range tests {
+		err := Verify(tt.publicKey, tt.message, tt.signature)
+		if tt.ok && err != nil {
+			t.Errorf("%s: Verify failed: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: Verify succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestSignMissingKey(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not installed")
+	}
+	if _, err := Sign(filepath.Join(t.TempDir(), "none"), []byte("x")); err == nil {
+		t.Error("Sign with a missing key succeeded")
+	}
+}
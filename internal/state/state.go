@@ -0,0 +1,194 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+)
+
+// State represents .envault/state.json: machine-local bookkeeping about
+// rendered targets, fetched ciphertext and variable names for completion.
+// It is gitignored and never committed.
+type State struct {
+	Targets map[string]*Target `json:"targets"`           // keyed by target path
+	Remotes map[string]*Remote `json:"remotes,omitempty"` // keyed by environment
+	Names   map[string]*Names  `json:"names,omitempty"`   // keyed by environment
+}
+
+// Names caches an environment's variable names, without values, for
+// shell completion
+type Names struct {
+	CiphertextHash string   `json:"ciphertext_sha256"` // ciphertext they were read from
+	Keys           []string `json:"keys"`
+}
+
+// Remote records the ciphertext last fetched or pushed for an environment
+// with a remote, cached in its encrypted_file
+type Remote struct {
+	URL       string    `json:"url"`
+	ETag      string    `json:"etag,omitempty"`
+	SHA256    string    `json:"sha256"` // hash of the cached file as fetched or pushed
+	FetchedAt time.Time `json:"fetched_at"`
+	CheckedAt time.Time `json:"checked_at"` // last time the remote confirmed the copy
+}
+
+// Target records the last time envault rendered a target file
+type Target struct {
+	Env            string    `json:"env"`
+	Type           string    `json:"type,omitempty"`    // writer type, empty for file targets
+	SHA256         string    `json:"sha256"`            // hash of the file as written
+	CiphertextHash string    `json:"ciphertext_sha256"` // ciphertext it was rendered from
+	RenderedAt     time.Time `json:"rendered_at"`
+
+	// Strategy is set for merge and append targets, whose files also hold
+	// local content; Keys are the variables a merge wrote
+	Strategy string   `json:"strategy,omitempty"`
+	Keys     []string `json:"keys,omitempty"`
+
+	// For is the target an as_file value was written for
+	For string `json:"for,omitempty"`
+
+	// OverridesHash is the hash of the overrides file layered into the
+	// render, empty when there was none
+	OverridesHash string `json:"overrides_sha256,omitempty"`
+}
+
+// Path returns the path to state.json
+func Path() (string, error) {
+	envaultDir, err := config.EnvaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(envaultDir, "state.json"), nil
+}
+
+// Load reads state.json. A missing file yields an empty state.
+func Load() (*State, error) {
+	statePath, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &State{Targets: map[string]*Target{}}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read state.json: %w", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state.json: %w", err)
+	}
+	if s.Targets == nil {
+		s.Targets = map[string]*Target{}
+	}
+
+	return s, nil
+}
+
+// Save writes state.json and makes sure it is gitignored
+func (s *State) Save() error {
+	statePath, err := Path()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := os.WriteFile(statePath, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write state.json: %w", err)
+	}
+
+	return EnsureIgnored(filepath.Dir(statePath), "state.json")
+}
+
+// Modified reports whether the file at path differs from what envault last
+// rendered there. A missing file is never modified, and neither is a file
+// envault has no record of, such as a target rendered before state.json
+// existed: refusing those would stop every non-interactive load after an
+// upgrade. The load that overwrites such a file records it, so it is
+// adopted once and tracked from then on.
+func (s *State) Modified(targetPath, absPath string) (bool, error) {
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	record, ok := s.Targets[targetPath]
+	if !ok {
+		return false, nil
+	}
+	return Hash(data) != record.SHA256, nil
+}
+
+// Record stores the hash of a freshly rendered target and the ciphertext
+// it came from
+func (s *State) Record(targetPath, absPath, envName, ciphertextHash string) error {
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+
+	s.Targets[targetPath] = &Target{
+		Env:            envName,
+		SHA256:         Hash(data),
+		CiphertextHash: ciphertextHash,
+		RenderedAt:     time.Now().UTC(),
+	}
+	return nil
+}
+
+// Forget drops the record for a target
+func (s *State) Forget(targetPath string) {
+	delete(s.Targets, targetPath)
+}
+
+// Hash returns the hex SHA256 of data
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// EnsureIgnored appends name to .envault/.gitignore if missing
+func EnsureIgnored(envaultDir, name string) error {
+	gitignorePath := filepath.Join(envaultDir, ".gitignore")
+	data, err := os.ReadFile(gitignorePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == name {
+			return nil
+		}
+	}
+
+	prefix := ""
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		prefix = "\n"
+	}
+	file, err := os.OpenFile(gitignorePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(prefix + name + "\n")
+	return err
+}
@@ -0,0 +1,80 @@
+package subvault
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/fsutil"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/secmem"
+)
+
+// Write cuts a subvault out of an environment's plaintext and encrypts it
+// to the subvault's recipients only. It returns the subvault's keys that
+// the plaintext lacks, which are left out.
+func Write(cfg *config.Config, envName string, sv *config.Subvault, plaintext []byte) ([]string, error) {
+	backend, err := crypto.BackendFor(cfg, envName)
+	if err != nil {
+		return nil, err
+	}
+	recipients, err := Recipients(backend, sv)
+	if err != nil {
+		return nil, err
+	}
+
+	selected, missing, err := dotenv.SelectKeys(plaintext, sv.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", envName, err)
+	}
+	defer secmem.Wipe(selected)
+
+	path, err := cfg.SubvaultPath(envName, sv)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), cfg.DirMode()); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	err = fsutil.WriteAtomic(path, cfg.FileMode(), func(w io.Writer) error {
+		return backend.Encrypt(selected, recipients, w)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// Recipients parses a subvault's recipients, refusing revoked keys and
+// keys the environment's backend cannot encrypt to
+func Recipients(backend crypto.Backend, sv *config.Subvault) ([]keys.Key, error) {
+	var recipients []keys.Key
+	for _, line := range sv.Recipients {
+		key, err := keys.ParseKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %q: %w", line, err)
+		}
+		if err := backend.ValidateRecipient(*key); err != nil {
+			return nil, fmt.Errorf("recipient %s: %w", key.Fingerprint, err)
+		}
+		recipients = append(recipients, *key)
+	}
+
+	revoked, err := keys.LoadRevoked()
+	if err != nil {
+		return nil, err
+	}
+	if found := keys.FindRevoked(recipients, revoked); len(found) > 0 {
+		var fps []string
+		for _, k := range found {
+			fps = append(fps, k.Fingerprint)
+		}
+		return nil, fmt.Errorf("recipients are listed in revoked_keys: %s", strings.Join(fps, ", "))
+	}
+	return recipients, nil
+}
@@ -0,0 +1,140 @@
+package testvault
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/orchard9/envault/internal/ci"
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/keys"
+)
+
+// Vault is a throwaway project with its own generated identity. Nothing
+// in it is read from or written to the user's real keys or vaults.
+type Vault struct {
+	Dir      string // project directory containing .envault
+	Identity string // private key that can decrypt every environment
+
+	key keys.Key
+}
+
+// Create sets up an empty vault in projectDir and generates an SSH
+// identity at identityPath (with identityPath.pub next to it)
+func Create(projectDir, identityPath string) (*Vault, error) {
+	private, public, err := ci.Generate("envault-test")
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys.ParseKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(identityPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create identity directory: %w", err)
+	}
+	if err := os.WriteFile(identityPath, private, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write identity: %w", err)
+	}
+	if err := os.WriteFile(identityPath+".pub", []byte(public+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write identity: %w", err)
+	}
+
+	envaultDir := filepath.Join(projectDir, ".envault")
+	if err := os.MkdirAll(envaultDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create .envault directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(envaultDir, "authorized_keys"), []byte(public+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write authorized_keys: %w", err)
+	}
+	cfg := &config.Config{Version: config.CurrentVersion, Environments: map[string]config.Environment{}}
+	if err := cfg.SaveDir(envaultDir); err != nil {
+		return nil, err
+	}
+
+	return &Vault{Dir: projectDir, Identity: identityPath, key: *key}, nil
+}
+
+// SetEnvironment encrypts values as an environment, adding it to
+// config.yaml with a .env target if it is new
+func (v *Vault) SetEnvironment(envName string, values map[string]string) error {
+	envaultDir := filepath.Join(v.Dir, ".envault")
+	cfg, err := readConfig(envaultDir)
+	if err != nil {
+		return err
+	}
+
+	env, ok := cfg.Environments[envName]
+	if !ok {
+		encryptedFile, err := config.LayoutFile(cfg.Layout, envName)
+		if err != nil {
+			return err
+		}
+		env = config.Environment{EncryptedFile: encryptedFile, Targets: []config.Target{{Path: ".env"}}}
+		cfg.Environments[envName] = env
+		if err := cfg.SaveDir(envaultDir); err != nil {
+			return err
+		}
+	}
+
+	plaintext, err := Plaintext(values)
+	if err != nil {
+		return err
+	}
+	backend, err := crypto.LookupBackend(crypto.DefaultBackend)
+	if err != nil {
+		return err
+	}
+	var ciphertext bytes.Buffer
+	if err := backend.Encrypt(plaintext, []keys.Key{v.key}, &ciphertext); err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", envName, err)
+	}
+
+	path := filepath.Join(envaultDir, env.EncryptedFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, ciphertext.Bytes(), 0644)
+}
+
+// Plaintext renders values as a dotenv file, sorted by name
+func Plaintext(values map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%s\n", name, dotenv.Quote(values[name]))
+	}
+
+	// Catch names that would not survive a round trip
+	parsed, err := dotenv.ParseMap(b.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if parsed[name] != values[name] {
+			return nil, fmt.Errorf("invalid variable name %q", name)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+func readConfig(envaultDir string) (*config.Config, error) {
+	cfg, err := config.LoadDir(envaultDir)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Environments == nil {
+		cfg.Environments = map[string]config.Environment{}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,123 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// ANSI color codes
+const (
+	reset  = "\033[0m"
+	red    = "\033[31m"
+	green  = "\033[32m"
+	yellow = "\033[33m"
+	bold   = "\033[1m"
+)
+
+// Stream describes how output to one file descriptor should be decorated
+type Stream struct {
+	TTY   bool // symbols and tables for humans
+	Color bool // ANSI colors
+}
+
+// Out and Err describe stdout and stderr. They are set by Init.
+var (
+	Out Stream
+	Err Stream
+)
+
+func init() {
+	Init(false)
+}
+
+// Init detects terminals and honors NO_COLOR and --no-color
+func Init(noColor bool) {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		noColor = true
+	}
+	if os.Getenv("TERM") == "dumb" {
+		noColor = true
+	}
+
+	Out = detect(os.Stdout, noColor)
+	Err = detect(os.Stderr, noColor)
+}
+
+func detect(f *os.File, noColor bool) Stream {
+	info, err := f.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	return Stream{TTY: tty, Color: tty && !noColor}
+}
+
+// OK returns the success marker
+func (s Stream) OK() string {
+	return s.mark("✓", "[ok]", green)
+}
+
+// Fail returns the failure marker
+func (s Stream) Fail() string {
+	return s.mark("✗", "[fail]", red)
+}
+
+// Warn returns the warning marker
+func (s Stream) Warn() string {
+	return s.mark("⚠", "[warn]", yellow)
+}
+
+// Bold highlights text on color terminals
+func (s Stream) Bold(text string) string {
+	if !s.Color {
+		return text
+	}
+	return bold + text + reset
+}
+
+// Red colors text on color terminals
+func (s Stream) Red(text string) string {
+	if !s.Color {
+		return text
+	}
+	return red + text + reset
+}
+
+func (s Stream) mark(symbol, plain, color string) string {
+	if !s.TTY {
+		return plain
+	}
+	if !s.Color {
+		return symbol
+	}
+	return color + symbol + reset
+}
+
+// OK returns the stdout success marker
+func OK() string { return Out.OK() }
+
+// Fail returns the stdout failure marker
+func Fail() string { return Out.Fail() }
+
+// Warn returns the stdout warning marker
+func Warn() string { return Out.Warn() }
+
+// Table writes rows as aligned columns on a terminal, or as tab-separated
+// lines without the header when output is piped
+func Table(w io.Writer, s Stream, header []string, rows [][]string) {
+	if !s.TTY {
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if len(header) > 0 {
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,295 @@
+package upgrade
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/orchard9/envault/internal/sshsig"
+)
+
+// Repo is the GitHub repository releases are fetched from
+const Repo = "orchard9/envault"
+
+// ReleaseKey signs the checksums.txt of every release (see signs in
+// .goreleaser.yml). An archive is only installed if its checksum is listed
+// in a checksums.txt this key signed: a checksums file from the same
+// release proves nothing to anyone who could replace the archive.
+const ReleaseKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIP7lPwD7r8m8mdZoRFlW2ZbdskZqAKNAHcK9CVxblBiz envault release"
+
+// releaseNamespace is the ssh-keygen -Y namespace releases are signed in
+const releaseNamespace = "envault-release"
+
+// Limits on what a release may make envault read, far above any real
+// release, so a hostile asset cannot exhaust memory
+const (
+	maxMetadataSize = 1 << 20 // release JSON, checksums.txt and its signature
+	maxArchiveSize  = 256 << 20
+	maxBinarySize   = 512 << 20
+)
+
+var (
+	httpClient = &http.Client{Timeout: 60 * time.Second}
+	releaseKey = ReleaseKey // replaced in tests
+)
+
+// Release is the subset of the GitHub release API used for upgrades
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	Assets []Asset `json:"assets"`
+}
+
+// Asset is a downloadable file attached to a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Version returns the release version without the leading "v"
+func (r *Release) Version() string {
+	return strings.TrimPrefix(r.Tag, "v")
+}
+
+// Latest fetches the latest published release
+func Latest() (*Release, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", Repo)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for releases: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to check for releases: %s", resp.Status)
+	}
+
+	var release Release
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxMetadataSize)).Decode(&release); err != nil {
+		return nil, fmt.Errorf("failed to parse release: %w", err)
+	}
+	return &release, nil
+}
+
+// AssetName returns the goreleaser archive name for this platform
+func AssetName(version string) string {
+	arch := runtime.GOARCH
+	if arch == "amd64" {
+		arch = "x86_64"
+	}
+	osName := strings.ToUpper(runtime.GOOS[:1]) + runtime.GOOS[1:]
+
+	ext := "tar.gz"
+	if runtime.GOOS == "windows" {
+		ext = "zip"
+	}
+	return fmt.Sprintf("envault_%s_%s_%s.%s", version, osName, arch, ext)
+}
+
+// Install downloads the release archive for this platform, verifies it
+// against the release's checksums.txt and that file against its signature
+// by ReleaseKey, and replaces the running binary
+func Install(release *Release) (string, error) {
+	binary, err := fetch(release)
+	if err != nil {
+		return "", err
+	}
+	return replaceExecutable(binary)
+}
+
+// fetch downloads and verifies the release binary for this platform
+func fetch(release *Release) ([]byte, error) {
+	name := AssetName(release.Version())
+
+	archiveURL, checksumsURL, signatureURL := "", "", ""
+	for _, a := range release.Assets {
+		switch a.Name {
+		case name:
+			archiveURL = a.URL
+		case "checksums.txt":
+			checksumsURL = a.URL
+		case "checksums.txt.sig":
+			signatureURL = a.URL
+		}
+	}
+	if archiveURL == "" {
+		return nil, fmt.Errorf("release %s has no asset %s", release.Tag, name)
+	}
+	if checksumsURL == "" || signatureURL == "" {
+		return nil, fmt.Errorf("release %s has no signed checksums.txt - refusing to install unverified binary", release.Tag)
+	}
+
+	checksums, err := download(checksumsURL, maxMetadataSize)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := download(signatureURL, maxMetadataSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := sshsig.VerifyNamespace(releaseNamespace, releaseKey, checksums, string(signature)); err != nil {
+		return nil, fmt.Errorf("checksums.txt of release %s is not signed by the envault release key - refusing to install: %w", release.Tag, err)
+	}
+	expected, err := findChecksum(checksums, name)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := download(archiveURL, maxArchiveSize)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(archive)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, expected, actual)
+	}
+
+	return extractBinary(name, archive)
+}
+
+func download(url string, limit int64) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	data, err := readLimited(resp.Body, limit, "response")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	return data, nil
+}
+
+// readLimited reads r to the end, failing once it passes limit bytes
+func readLimited(r io.Reader, limit int64, name string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d MiB", name, limit>>20)
+	}
+	return data, nil
+}
+
+// findChecksum looks up a file's SHA256 in a sha256sum-style listing
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt has no entry for %s", name)
+}
+
+// extractBinary pulls the envault executable out of a release archive
+func extractBinary(name string, archive []byte) ([]byte, error) {
+	binaryName := "envault"
+	if runtime.GOOS == "windows" {
+		binaryName = "envault.exe"
+	}
+
+	if strings.HasSuffix(name, ".zip") {
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		for _, f := range zr.File {
+			if filepath.Base(f.Name) == binaryName {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer rc.Close()
+				return readLimited(rc, maxBinarySize, binaryName)
+			}
+		}
+		return nil, fmt.Errorf("%s not found in %s", binaryName, name)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in %s", binaryName, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if hdr.Typeflag == tar.TypeReg && filepath.Base(hdr.Name) == binaryName {
+			return readLimited(tr, maxBinarySize, binaryName)
+		}
+	}
+}
+
+// replaceExecutable swaps the running binary for the new one
+func replaceExecutable(binary []byte) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate running binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".envault-upgrade-*")
+	if err != nil {
+		return "", fmt.Errorf("cannot write to %s (try with sudo or reinstall): %w", filepath.Dir(exe), err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write new binary: %w", err)
+	}
+	if err := tmp.Chmod(0755); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to make new binary executable: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	// Windows can't overwrite a running executable, but can rename it
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return "", fmt.Errorf("failed to move old binary aside: %w", err)
+		}
+	}
+
+	if err := os.Rename(tmpPath, exe); err != nil {
+		return "", fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	return exe, nil
+}
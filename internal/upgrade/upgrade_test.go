@@ -0,0 +1,141 @@
+package upgrade
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// signingKey generates a release key, makes it the trusted one for the
+// test and returns the private key's path
+func signingKey(t *testing.T, name string) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not installed")
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", name, "-f", path).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	public, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, strings.TrimSpace(string(public))
+}
+
+func sign(t *testing.T, key string, data []byte) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "checksums.txt")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("ssh-keygen", "-Y", "sign", "-f", key, "-n", releaseNamespace, path).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen -Y sign: %v\n%s", err, out)
+	}
+	sig, err := os.ReadFile(path + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func archive(t *testing.T, binary []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "envault", Mode: 0755, Size: int64(len(binary)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(binary)
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// serve publishes assets and returns a release listing them
+func serve(t *testing.T, assets map[string][]byte) *Release {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := assets[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	release := &Release{Tag: "v9.9.9"}
+	for name := range assets {
+		release.Assets = append(release.Assets, Asset{Name: name, URL: srv.URL + "/" + name})
+	}
+	return release
+}
+
+func TestFetchVerifiesSignature(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("release archives for windows are zip files")
+	}
+	key, public := signingKey(t, "release")
+	other, _ := signingKey(t, "other")
+	defer func(key string) { releaseKey = key }(releaseKey)
+	releaseKey = public
+
+	binary := []byte("#!/bin/sh\necho envault 9.9.9\n")
+	name := AssetName("9.9.9")
+	tarball := archive(t, binary)
+	sum := sha256.Sum256(tarball)
+	checksums := []byte(fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name))
+	forged := []byte(fmt.Sprintf("%s  %s\n", strings.Repeat("0", 64), name))
+
+	tests := []struct {
+		name   string
+		assets map[string][]byte
+		err    string
+	}{
+		{"signed", map[string][]byte{name: tarball, "checksums.txt": checksums, "checksums.txt.sig": sign(t, key, checksums)}, ""},
+		{"unsigned", map[string][]byte{name: tarball, "checksums.txt": checksums}, "no signed checksums.txt"},
+		{"no checksums", map[string][]byte{name: tarball}, "no signed checksums.txt"},
+		{"signed by another key", map[string][]byte{name: tarball, "checksums.txt": checksums, "checksums.txt.sig": sign(t, other, checksums)}, "not signed by the envault release key"},
+		{"checksums replaced", map[string][]byte{name: tarball, "checksums.txt": forged, "checksums.txt.sig": sign(t, key, checksums)}, "not signed by the envault release key"},
+		{"archive replaced", map[string][]byte{name: archive(t, []byte("evil")), "checksums.txt": checksums, "checksums.txt.sig": sign(t, key, checksums)}, "checksum mismatch"},
+		{"garbage signature", map[string][]byte{name: tarball, "checksums.txt": checksums, "checksums.txt.sig": []byte("not a signature")}, "not signed by the envault release key"},
+		{"oversized checksums", map[string][]byte{name: tarball, "checksums.txt": make([]byte, maxMetadataSize+1), "checksums.txt.sig": sign(t, key, checksums)}, "larger than 1 MiB"},
+	}
+	for _, tt := range tests {
+		got, err := fetch(serve(t, tt.assets))
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if !bytes.Equal(got, binary) {
+				t.Errorf("%s: fetched %q, want %q", tt.name, got, binary)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	if _, err := readLimited(bytes.NewReader(make([]byte, 2<<20)), 1<<20, "envault"); err == nil {
+		t.Error("readLimited accepted 2 MiB with a 1 MiB limit")
+	}
+	data, err := readLimited(bytes.NewReader(make([]byte, 1<<20)), 1<<20, "envault")
+	if err != nil || len(data) != 1<<20 {
+		t.Errorf("readLimited at the limit: %d bytes, %v", len(data), err)
+	}
+}
@@ -0,0 +1,108 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// HistoryError is a revision the vault's repository does not have. In a
+// shallow clone the revision may simply be beyond the fetched depth;
+// Advice says how to fetch it.
+type HistoryError struct {
+	Rev     string
+	Shallow bool
+}
+
+func (e *HistoryError) Error() string {
+	if e.Shallow {
+		return fmt.Sprintf("revision %s is not in this shallow clone", e.Rev)
+	}
+	return fmt.Sprintf("unknown revision %s", e.Rev)
+}
+
+// Advice returns the commands that would fetch the missing revision, the
+// narrowest first, followed by the checkout setting of the CI system
+// envault is running in, if it is one that clones shallow by default
+func (e *HistoryError) Advice() []string {
+	var advice []string
+	if remote, branch, ok := strings.Cut(e.Rev, "/"); ok && !strings.ContainsAny(e.Rev, "~^:@") {
+		// Only the tip is needed: files are read with git cat-file
+		advice = append(advice, fmt.Sprintf("git fetch --no-tags --depth=1 %s %s:refs/remotes/%s/%s", remote, branch, remote, branch))
+	}
+	if e.Shallow {
+		advice = append(advice, "git fetch --deepen=100", "git fetch --unshallow")
+	} else if len(advice) == 0 {
+		advice = append(advice, "git fetch")
+	}
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		advice = append(advice, "in GitHub Actions: actions/checkout with fetch-depth: 0")
+	case os.Getenv("GITLAB_CI") == "true":
+		advice = append(advice, "in GitLab CI: set the variable GIT_DEPTH: 0")
+	case os.Getenv("BITBUCKET_BUILD_NUMBER") != "":
+		advice = append(advice, "in Bitbucket Pipelines: clone: depth: full")
+	}
+	return advice
+}
+
+// IsShallow reports whether the repository containing dir is a shallow
+// clone, whose history stops at the fetched depth
+func IsShallow(dir string) bool {
+	out, err := git(dir, "rev-parse", "--is-shallow-repository")
+	return err == nil && out == "true"
+}
+
+// Shallow reports whether the vault's repository is a shallow clone
+func (i *Info) Shallow() bool {
+	return i.VaultRepo != "" && IsShallow(i.Dir)
+}
+
+// resolveRev checks that a revision names a commit the repository has
+func (i *Info) resolveRev(rev string) error {
+	if _, err := git(i.Dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}"); err != nil {
+		return &HistoryError{Rev: rev, Shallow: i.Shallow()}
+	}
+	return nil
+}
+
+// IndexBlob returns the staged contents of a file that a sparse checkout
+// leaves out of the working tree, so ciphertext outside the cone can still
+// be read. It reports false for anything else.
+func IndexBlob(path string) ([]byte, bool) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, false
+	}
+	// Directories outside the cone are missing too; ask from the nearest
+	// one that exists
+	dir := filepath.Dir(path)
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, false
+		}
+		dir = parent
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return nil, false
+	}
+	// Only skip-worktree entries (S): a file deleted by hand stays deleted
+	status, err := git(dir, "ls-files", "-t", "--", rel)
+	if err != nil || !strings.HasPrefix(status, "S ") {
+		return nil, false
+	}
+	spec := ":./" + filepath.ToSlash(rel)
+	// Ciphertext is binary, so the output is not trimmed like git's
+	out, err := exec.Command("git", "-C", dir, "cat-file", "blob", spec).Output()
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
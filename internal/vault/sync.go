@@ -0,0 +1,94 @@
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Divergence compares the vault's branch with its upstream. Paths are
+// relative to the vault directory, and only vault files are considered.
+type Divergence struct {
+	Upstream string // e.g. origin/main
+	Ahead    int    // local commits not pushed
+	Behind   int    // upstream commits not pulled
+	Local    []string
+	Remote   []string
+	Both     []string // changed on both sides; these conflict
+}
+
+// Diverged reports whether both sides have commits the other lacks
+func (d *Divergence) Diverged() bool {
+	return d.Ahead > 0 && d.Behind > 0
+}
+
+// Fetch updates the remote-tracking branches of the vault's repository
+func (i *Info) Fetch() error {
+	if err := i.requireRepo(); err != nil {
+		return err
+	}
+	_, err := git(i.Dir, "fetch", "--quiet")
+	return err
+}
+
+// Divergence compares the vault with its upstream as last fetched
+func (i *Info) Divergence() (*Divergence, error) {
+	if err := i.requireRepo(); err != nil {
+		return nil, err
+	}
+
+	upstream, err := git(i.Dir, "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}")
+	if err != nil {
+		return nil, fmt.Errorf("the vault's branch has no upstream (push it once with: git -C %s push -u origin HEAD)", i.VaultRepo)
+	}
+	d := &Divergence{Upstream: upstream}
+
+	counts, err := git(i.Dir, "rev-list", "--left-right", "--count", "HEAD...@{upstream}")
+	if err != nil {
+		return nil, err
+	}
+	if fields := strings.Fields(counts); len(fields) == 2 {
+		d.Ahead, _ = strconv.Atoi(fields[0])
+		d.Behind, _ = strconv.Atoi(fields[1])
+	}
+	if d.Ahead == 0 && d.Behind == 0 {
+		return d, nil
+	}
+
+	base, err := git(i.Dir, "merge-base", "HEAD", "@{upstream}")
+	if err != nil {
+		return nil, err
+	}
+	if d.Local, err = changedSince(i.Dir, base, "HEAD"); err != nil {
+		return nil, err
+	}
+	if d.Remote, err = changedSince(i.Dir, base, "@{upstream}"); err != nil {
+		return nil, err
+	}
+
+	remote := map[string]bool{}
+	for _, path := range d.Remote {
+		remote[path] = true
+	}
+	for _, path := range d.Local {
+		if remote[path] {
+			d.Both = append(d.Both, path)
+		}
+	}
+	return d, nil
+}
+
+// changedSince lists vault files that differ between two revisions
+func changedSince(dir, from, to string) ([]string, error) {
+	out, err := git(dir, "diff", "--name-only", "--relative", from, to, "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	paths := strings.Split(out, "\n")
+	sort.Strings(paths)
+	return paths, nil
+}
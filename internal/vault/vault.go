@@ -0,0 +1,282 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/orchard9/envault/internal/ci"
+	"github.com/orchard9/envault/internal/config"
+)
+
+// Modes describe how the vault relates to the project's git repository
+const (
+	ModeInline    = "inline"    // .envault is tracked by the project repository
+	ModeSubmodule = "submodule" // .envault is a submodule of the project
+	ModeShared    = "shared"    // the vault lives in a separate repository
+	ModeUntracked = "untracked" // the vault is not in any git repository
+)
+
+// vaultFiles are the files envault itself writes, besides ciphertext.
+// Commit only ever stages these, so stray plaintext is never picked up.
+var vaultFiles = []string{".gitignore", "config.yaml", "authorized_keys", "revoked_keys", "manifest.json", "schema.yaml", "schema.yaml.age", ci.FileName}
+
+// Info locates the vault and the repositories around it
+type Info struct {
+	ProjectDir  string // directory containing .envault; targets resolve from here
+	Dir         string // resolved vault directory
+	ProjectRepo string // top level of the project's repository, if any
+	VaultRepo   string // top level of the repository containing Dir, if any
+	Mode        string
+}
+
+// Locate inspects the vault for the current directory
+func Locate() (*Info, error) {
+	projectDir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	dir, err := config.ResolveDir(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("vault directory %s does not exist", dir)
+	}
+
+	info := &Info{ProjectDir: projectDir, Dir: dir}
+	info.ProjectRepo, _ = git(projectDir, "rev-parse", "--show-toplevel")
+	info.VaultRepo, _ = git(dir, "rev-parse", "--show-toplevel")
+
+	switch {
+	case info.VaultRepo == "":
+		info.Mode = ModeUntracked
+	case sameDir(info.VaultRepo, info.ProjectRepo):
+		info.Mode = ModeInline
+	default:
+		info.Mode = ModeShared
+		if super, _ := git(dir, "rev-parse", "--show-superproject-working-tree"); super != "" && sameDir(super, info.ProjectRepo) {
+			info.Mode = ModeSubmodule
+		}
+	}
+
+	return info, nil
+}
+
+// Status returns `git status --short` limited to the vault directory
+func (i *Info) Status() (string, error) {
+	if err := i.requireRepo(); err != nil {
+		return "", err
+	}
+	return git(i.Dir, "status", "--short", "--", ".")
+}
+
+// ShowFile returns a file in the vault, relative to Dir, as of a revision
+// of the vault's repository. It reports false if the file did not exist.
+// The blob is read with git cat-file, so nothing is checked out and a
+// shallow clone only needs the revision itself, not its history.
+func (i *Info) ShowFile(rev, name string) ([]byte, bool, error) {
+	if err := i.requireRepo(); err != nil {
+		return nil, false, err
+	}
+	if err := i.resolveRev(rev); err != nil {
+		return nil, false, err
+	}
+
+	spec := rev + ":./" + filepath.ToSlash(name)
+	if _, err := git(i.Dir, "cat-file", "-e", spec); err != nil {
+		return nil, false, nil
+	}
+
+	// Ciphertext is binary, so the output is not trimmed like git's
+	cmd := exec.Command("git", "-C", i.Dir, "cat-file", "blob", spec)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, false, fmt.Errorf("git cat-file: %w", err)
+	}
+	return out, true, nil
+}
+
+// Commit stages the vault's own files and ciphertext and commits them in
+// the repository holding the vault. It reports false when nothing changed.
+// For a submodule, the new revision is also staged in the project.
+func (i *Info) Commit(message string) (bool, error) {
+	if err := i.requireRepo(); err != nil {
+		return false, err
+	}
+
+	paths, err := i.trackedPaths()
+	if err != nil {
+		return false, err
+	}
+
+	// Updates and deletions of files already in git, then known new files
+	if _, err := git(i.Dir, "add", "--update", "--", "."); err != nil {
+		return false, err
+	}
+	if len(paths) > 0 {
+		if _, err := git(i.Dir, append([]string{"add", "--"}, paths...)...); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := git(i.Dir, "diff", "--cached", "--quiet", "--", "."); err == nil {
+		return false, nil
+	}
+
+	if _, err := git(i.Dir, "commit", "--quiet", "-m", message, "--", "."); err != nil {
+		return false, err
+	}
+
+	if i.Mode == ModeSubmodule {
+		rel, err := filepath.Rel(i.ProjectRepo, i.VaultRepo)
+		if err != nil {
+			return true, fmt.Errorf("failed to locate submodule: %w", err)
+		}
+		if _, err := git(i.ProjectRepo, "add", "--", rel); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// Push pushes the repository holding the vault. It fetches first and
+// refuses while the upstream has commits that are not pulled, naming the
+// vault files they change; git would reject the push anyway, less clearly.
+func (i *Info) Push() error {
+	if err := i.requireRepo(); err != nil {
+		return err
+	}
+	if i.Fetch() == nil {
+		if d, err := i.Divergence(); err == nil && d.Behind > 0 {
+			return notPulled(d)
+		}
+	}
+	_, err := git(i.Dir, "push", "--quiet")
+	return err
+}
+
+// PushPlan fetches and returns what Push would send, for vault push
+// --dry-run, failing where Push would refuse
+func (i *Info) PushPlan() (*Divergence, error) {
+	if err := i.requireRepo(); err != nil {
+		return nil, err
+	}
+	if err := i.Fetch(); err != nil {
+		return nil, err
+	}
+	d, err := i.Divergence()
+	if err != nil {
+		return nil, err
+	}
+	if d.Behind > 0 {
+		return nil, notPulled(d)
+	}
+	return d, nil
+}
+
+func notPulled(d *Divergence) error {
+	return fmt.Errorf("%s has %d commit(s) you have not pulled%s; run envault vault pull first", d.Upstream, d.Behind, changedList(d.Remote))
+}
+
+// Pull brings in upstream changes to the vault. A fast-forward is always
+// allowed. When both sides have commits, rebase replays the local ones on
+// top, but only if no vault file was changed on both sides: ciphertext
+// cannot be merged, so such a conflict is reported instead.
+func (i *Info) Pull(rebase bool) error {
+	if err := i.requireRepo(); err != nil {
+		return err
+	}
+	if err := i.Fetch(); err != nil {
+		return err
+	}
+
+	d, err := i.Divergence()
+	if err != nil {
+		return err
+	}
+	if d.Diverged() {
+		if len(d.Both) > 0 {
+			return fmt.Errorf("%s changed on both sides (%d local, %d upstream commit(s)); reset to %s, then re-apply your change with envault encrypt", strings.Join(d.Both, ", "), d.Ahead, d.Behind, d.Upstream)
+		}
+		if !rebase {
+			return fmt.Errorf("the vault has %d local and %d upstream commit(s) touching different files; run envault vault pull --rebase", d.Ahead, d.Behind)
+		}
+		_, err = git(i.Dir, "pull", "--quiet", "--rebase")
+		return err
+	}
+
+	_, err = git(i.Dir, "pull", "--quiet", "--ff-only")
+	return err
+}
+
+func changedList(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	return " (changed: " + strings.Join(paths, ", ") + ")"
+}
+
+// trackedPaths lists the vault files that exist, relative to Dir
+func (i *Info) trackedPaths() ([]string, error) {
+	cfg, err := config.LoadDir(i.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := append([]string{}, vaultFiles...)
+	for _, env := range cfg.Environments {
+		names = append(names, env.EncryptedFile, env.NotesFileName())
+		for _, sv := range env.Subvaults {
+			names = append(names, sv.File)
+		}
+	}
+	sort.Strings(names)
+
+	var paths []string
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(i.Dir, name)); err == nil {
+			paths = append(paths, name)
+		}
+	}
+	return paths, nil
+}
+
+func (i *Info) requireRepo() error {
+	if i.Mode == ModeUntracked {
+		return fmt.Errorf("%s is not in a git repository", i.Dir)
+	}
+	return nil
+}
+
+// git runs a git command in dir and returns its trimmed output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// sameDir compares two directories after resolving symlinks
+func sameDir(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	ra, errA := filepath.EvalSymlinks(a)
+	rb, errB := filepath.EvalSymlinks(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return ra == rb
+}
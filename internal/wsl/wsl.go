@@ -0,0 +1,94 @@
+package wsl
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// windowsPath matches drive-letter paths such as C:\Users\me or D:/work
+var windowsPath = regexp.MustCompile(`^([A-Za-z]):[\\/]`)
+
+// Detected reports whether envault is running inside WSL. Set ENVAULT_WSL=0
+// to disable the interop behaviour.
+func Detected() bool {
+	if os.Getenv("ENVAULT_WSL") == "0" {
+		return false
+	}
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(string(data)), "microsoft")
+}
+
+// WindowsHome returns the Windows user profile directory as a WSL path.
+// ENVAULT_WINDOWS_HOME overrides detection.
+func WindowsHome() (string, error) {
+	if home := os.Getenv("ENVAULT_WINDOWS_HOME"); home != "" {
+		return home, nil
+	}
+
+	// Ask Windows via interop; fails when interop is disabled
+	out, err := exec.Command("cmd.exe", "/c", "echo %USERPROFILE%").Output()
+	if err == nil {
+		profile := strings.TrimSpace(string(out))
+		if p, ok := ToLinuxPath(profile); ok {
+			return p, nil
+		}
+	}
+
+	user := os.Getenv("USER")
+	if user == "" {
+		return "", fmt.Errorf("could not determine Windows user profile (set ENVAULT_WINDOWS_HOME)")
+	}
+	home := path.Join(mountRoot(), "c", "Users", user)
+	if _, err := os.Stat(home); err != nil {
+		return "", fmt.Errorf("could not determine Windows user profile (set ENVAULT_WINDOWS_HOME)")
+	}
+	return home, nil
+}
+
+// IsWindowsPath reports whether p is a drive-letter Windows path
+func IsWindowsPath(p string) bool {
+	return windowsPath.MatchString(p)
+}
+
+// ToLinuxPath translates a Windows path like C:\Users\me\.env into its
+// mount under WSL (/mnt/c/Users/me/.env). ok is false for other paths.
+func ToLinuxPath(p string) (string, bool) {
+	m := windowsPath.FindStringSubmatch(p)
+	if m == nil {
+		return "", false
+	}
+	rest := strings.ReplaceAll(p[len(m[0]):], `\`, "/")
+	return path.Join(mountRoot(), strings.ToLower(m[1]), rest), true
+}
+
+// mountRoot returns the automount root from /etc/wsl.conf (default /mnt/)
+func mountRoot() string {
+	data, err := os.ReadFile("/etc/wsl.conf")
+	if err != nil {
+		return "/mnt/"
+	}
+
+	section := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok && section == "automount" && strings.TrimSpace(key) == "root" {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return "/mnt/"
+}
@@ -0,0 +1,10 @@
+// Package agentpb is the gRPC client and server code for the envault
+// agent API, generated from agent.proto. `envault serve` and `envault
+// agent` serve it on their listener alongside the HTTP API:
+//
+//	conn, err := grpc.NewClient("unix:.envault/agent.sock", grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	client := agentpb.NewAgentClient(conn)
+//	resp, err := client.GetSecret(ctx, &agentpb.GetSecretRequest{Environment: "dev", Key: "DATABASE_URL"})
+//
+// Regenerate it with make proto after editing agent.proto.
+package agentpb
@@ -0,0 +1,217 @@
+// Package envault decrypts envault environments from Go programs, such as
+// binaries built with ciphertext embedded by `envault embed`.
+//
+// Decryption shells out to the age binary (ENVAULT_AGE_BIN overrides the
+// path), so it must be installed wherever the program runs. Hooks in
+// Options let the program audit or refuse decryptions, renders and key
+// changes.
+package envault
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/orchard9/envault/internal/config"
+	"github.com/orchard9/envault/internal/crypto"
+	"github.com/orchard9/envault/internal/dotenv"
+	"github.com/orchard9/envault/internal/keys"
+	"github.com/orchard9/envault/internal/secmem"
+)
+
+// Options controls how ciphertext is decrypted
+type Options struct {
+	// Identity is the path to an SSH or age private key. When empty,
+	// ENVAULT_IDENTITY_KEY, ENVAULT_IDENTITY and ~/.ssh are tried in order.
+	Identity string
+
+	// Hooks observe, and may refuse, the operations run with these options
+	Hooks *Hooks
+}
+
+// Decrypt decrypts age ciphertext and returns the dotenv plaintext
+func Decrypt(ciphertext []byte, opts Options) ([]byte, error) {
+	return decrypt(ciphertext, opts, "")
+}
+
+func decrypt(ciphertext []byte, opts Options, envName string) ([]byte, error) {
+	start := time.Now()
+	e := DecryptEvent{Environment: envName, Identity: opts.Identity}
+	if e.Identity == "" {
+		path, cleanup, err := crypto.DefaultIdentity()
+		if err != nil {
+			e.Err = err
+			return nil, opts.Hooks.decrypt(e)
+		}
+		defer cleanup()
+		e.Identity = path
+	}
+
+	plaintext, err := crypto.DecryptWithIdentity(bytes.NewReader(ciphertext), e.Identity)
+	e.Duration = time.Since(start)
+	if err != nil {
+		e.Err = fmt.Errorf("envault: %w", err)
+		return nil, opts.Hooks.decrypt(e)
+	}
+
+	if entries, err := dotenv.Parse(plaintext); err == nil {
+		for _, entry := range entries {
+			e.Keys = append(e.Keys, entry.Key)
+		}
+	}
+	if err := opts.Hooks.decrypt(e); err != nil {
+		secmem.Wipe(plaintext)
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// Values decrypts ciphertext and parses it into variables
+func Values(ciphertext []byte, opts Options) (map[string]string, error) {
+	return values(ciphertext, opts, "")
+}
+
+func values(ciphertext []byte, opts Options, envName string) (map[string]string, error) {
+	plaintext, err := decrypt(ciphertext, opts, envName)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := dotenv.ParseMap(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("envault: failed to parse environment: %w", err)
+	}
+	return values, nil
+}
+
+// Load decrypts an environment from the project rooted at dir (the
+// directory containing .envault) and parses it into variables
+func Load(dir, envName string, opts Options) (map[string]string, error) {
+	envaultDir, err := config.ResolveDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("envault: %w", err)
+	}
+
+	cfg, err := config.LoadDir(envaultDir)
+	if err != nil {
+		return nil, fmt.Errorf("envault: %w", err)
+	}
+
+	env, err := cfg.GetEnvironment(envName)
+	if err != nil {
+		return nil, fmt.Errorf("envault: %w", err)
+	}
+
+	ciphertext, err := os.ReadFile(filepath.Join(envaultDir, env.EncryptedFile))
+	if err != nil {
+		err = fmt.Errorf("envault: failed to read ciphertext for %s: %w", envName, err)
+		return nil, opts.Hooks.decrypt(DecryptEvent{Environment: envName, Err: err})
+	}
+
+	return values(ciphertext, opts, envName)
+}
+
+// Setenv decrypts ciphertext and sets each variable in the process
+// environment. Variables that are already set are left alone unless
+// overwrite is true.
+func Setenv(ciphertext []byte, opts Options, overwrite bool) error {
+	values, err := Values(ciphertext, opts)
+	if err != nil {
+		return err
+	}
+
+	e := RenderEvent{Target: "process environment"}
+	for _, name := range sortedNames(values) {
+		if _, exists := os.LookupEnv(name); exists && !overwrite {
+			e.Skipped = append(e.Skipped, name)
+		} else {
+			e.Keys = append(e.Keys, name)
+		}
+	}
+	if err := opts.Hooks.render(e); err != nil {
+		return err
+	}
+
+	for _, name := range e.Keys {
+		if err := os.Setenv(name, values[name]); err != nil {
+			return fmt.Errorf("envault: failed to set %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// AddKey adds a public key to the authorized_keys of the project rooted at
+// dir, with the checks of envault add-key. Environments must be
+// re-encrypted before the key can decrypt them.
+func AddKey(dir, publicKey string, opts Options) error {
+	e := KeyChangeEvent{Dir: dir, Operation: KeyAdded}
+	envaultDir, err := config.ResolveDir(dir)
+	if err != nil {
+		e.Err = fmt.Errorf("envault: %w", err)
+		return opts.Hooks.keyChange(e)
+	}
+	keysPath := filepath.Join(envaultDir, "authorized_keys")
+
+	key, err := keys.CheckAddIn(envaultDir, keysPath, publicKey)
+	if err != nil {
+		e.Err = fmt.Errorf("envault: %w", err)
+		return opts.Hooks.keyChange(e)
+	}
+	e.Fingerprint, e.Key = key.Fingerprint, key.Line()
+	if err := opts.Hooks.keyChange(e); err != nil {
+		return err
+	}
+
+	if err := keys.Append(keysPath, *key); err != nil {
+		return fmt.Errorf("envault: %w", err)
+	}
+	return nil
+}
+
+// RemoveKey removes a key by fingerprint from the authorized_keys of the
+// project rooted at dir. Environments must be re-encrypted before it loses
+// access.
+func RemoveKey(dir, fingerprint string, opts Options) error {
+	e := KeyChangeEvent{Dir: dir, Operation: KeyRemoved, Fingerprint: fingerprint}
+	envaultDir, err := config.ResolveDir(dir)
+	if err != nil {
+		e.Err = fmt.Errorf("envault: %w", err)
+		return opts.Hooks.keyChange(e)
+	}
+	keysPath := filepath.Join(envaultDir, "authorized_keys")
+
+	existing, err := keys.LoadFile(keysPath)
+	if err != nil {
+		e.Err = fmt.Errorf("envault: %w", err)
+		return opts.Hooks.keyChange(e)
+	}
+	for _, k := range existing {
+		if k.Fingerprint == fingerprint {
+			e.Key = k.Line()
+		}
+	}
+	if e.Key == "" {
+		e.Err = fmt.Errorf("envault: key with fingerprint %s not found", fingerprint)
+		return opts.Hooks.keyChange(e)
+	}
+	if err := opts.Hooks.keyChange(e); err != nil {
+		return err
+	}
+
+	if _, err := keys.RemoveKeyFrom(keysPath, fingerprint); err != nil {
+		return fmt.Errorf("envault: %w", err)
+	}
+	return nil
+}
+
+func sortedNames(values map[string]string) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
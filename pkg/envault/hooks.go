@@ -0,0 +1,89 @@
+package envault
+
+import (
+	"fmt"
+	"time"
+)
+
+// Hooks are callbacks around envault operations, for an embedding
+// program's own audit logging, metrics or policy checks. Any of them may
+// be nil.
+//
+// A hook runs once the operation has done its work but before the result
+// is returned or written, so returning an error cancels it: the plaintext
+// is withheld, the variables are not set, or authorized_keys is left as
+// it was. When the operation itself fails, the hook still runs with Err
+// set, and its return value is ignored.
+type Hooks struct {
+	OnDecrypt   func(DecryptEvent) error
+	OnRender    func(RenderEvent) error
+	OnKeyChange func(KeyChangeEvent) error
+}
+
+// DecryptEvent describes a decryption by Decrypt, Values, Load or Setenv
+type DecryptEvent struct {
+	Environment string // empty for ciphertext not read from a project
+	Identity    string // private key used, empty if none was found
+	Keys        []string
+	Duration    time.Duration
+	Err         error
+}
+
+// RenderEvent describes variables about to be written somewhere, such as
+// the process environment by Setenv
+type RenderEvent struct {
+	Environment string
+	Target      string   // e.g. "process environment"
+	Keys        []string // variables that would be written
+	Skipped     []string // variables left alone because they are already set
+	Err         error
+}
+
+// KeyChangeEvent describes a change to a project's authorized_keys by
+// AddKey or RemoveKey
+type KeyChangeEvent struct {
+	Dir         string // project directory
+	Operation   string // KeyAdded or KeyRemoved
+	Fingerprint string
+	Key         string // public key line
+	Err         error
+}
+
+// Key change operations
+const (
+	KeyAdded   = "add-key"
+	KeyRemoved = "remove-key"
+)
+
+func (h *Hooks) decrypt(e DecryptEvent) error {
+	if h == nil || h.OnDecrypt == nil {
+		return e.Err
+	}
+	return hookResult("OnDecrypt", h.OnDecrypt(e), e.Err)
+}
+
+func (h *Hooks) render(e RenderEvent) error {
+	if h == nil || h.OnRender == nil {
+		return e.Err
+	}
+	return hookResult("OnRender", h.OnRender(e), e.Err)
+}
+
+func (h *Hooks) keyChange(e KeyChangeEvent) error {
+	if h == nil || h.OnKeyChange == nil {
+		return e.Err
+	}
+	return hookResult("OnKeyChange", h.OnKeyChange(e), e.Err)
+}
+
+// hookResult returns the operation's own error if it failed, else the
+// hook's refusal
+func hookResult(name string, hookErr, opErr error) error {
+	if opErr != nil {
+		return opErr
+	}
+	if hookErr != nil {
+		return fmt.Errorf("envault: refused by %s hook: %w", name, hookErr)
+	}
+	return nil
+}
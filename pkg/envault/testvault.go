@@ -0,0 +1,63 @@
+package envault
+
+import (
+	"path/filepath"
+
+	"github.com/orchard9/envault/internal/testvault"
+)
+
+// TB is the part of testing.TB that NewTestVault uses
+type TB interface {
+	Helper()
+	TempDir() string
+	Fatalf(format string, args ...any)
+}
+
+// TestVault is a throwaway vault for integration tests. Its identity is
+// generated for the test, so real keys and secrets are never involved.
+type TestVault struct {
+	Dir      string // project directory containing .envault; pass to Load
+	Identity string // private key that decrypts every environment
+
+	vault *testvault.Vault
+}
+
+// NewTestVault creates a vault in a temporary directory with one
+// environment holding values. The directory is removed when the test ends.
+// ssh-keygen and age must be installed.
+func NewTestVault(t TB, envName string, values map[string]string) *TestVault {
+	t.Helper()
+
+	vault, err := testvault.Create(t.TempDir(), filepath.Join(t.TempDir(), "id_ed25519"))
+	if err != nil {
+		t.Fatalf("envault: failed to create test vault: %v", err)
+	}
+	v := &TestVault{Dir: vault.Dir, Identity: vault.Identity, vault: vault}
+	v.Set(t, envName, values)
+	return v
+}
+
+// Set encrypts values as an environment, replacing it if it exists
+func (v *TestVault) Set(t TB, envName string, values map[string]string) {
+	t.Helper()
+	if err := v.vault.SetEnvironment(envName, values); err != nil {
+		t.Fatalf("envault: failed to write %s to test vault: %v", envName, err)
+	}
+}
+
+// Options returns decryption options using the vault's identity
+func (v *TestVault) Options() Options {
+	return Options{Identity: v.Identity}
+}
+
+// Load decrypts an environment of the vault
+func (v *TestVault) Load(envName string) (map[string]string, error) {
+	return Load(v.Dir, envName, v.Options())
+}
+
+// Environ returns the variables that point the envault CLI, or code using
+// this package with default options, at the vault's identity. Run the CLI
+// with its working directory set to Dir.
+func (v *TestVault) Environ() []string {
+	return []string{"ENVAULT_IDENTITY=" + v.Identity}
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"github.com/orchard9/envault/pkg/envault"
+)
+
+var _ datasource.DataSourceWithConfigure = &environmentDataSource{}
+
+// environmentDataSource implements data "envault_environment". Terraform
+// records data source results in state, so it only reads when the
+// provider sets allow_data_source_state; the ephemeral resource of the
+// same name keeps values out of state.
+type environmentDataSource struct {
+	data providerData
+}
+
+type environmentModel struct {
+	Dir         types.String `tfsdk:"dir"`
+	Environment types.String `tfsdk:"environment"`
+	Values      types.Map    `tfsdk:"values"`
+}
+
+// NewEnvironmentDataSource creates the envault_environment data source
+func NewEnvironmentDataSource() datasource.DataSource {
+	return &environmentDataSource{}
+}
+
+func (d *environmentDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_environment"
+}
+
+func (d *environmentDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Decrypts an envault environment and exposes its variables. The values are recorded in Terraform state in plaintext, so this needs allow_data_source_state in the provider; prefer the envault_environment ephemeral resource (Terraform 1.10+, OpenTofu 1.11+).",
+		Attributes: map[string]schema.Attribute{
+			"dir": schema.StringAttribute{
+				Description: "Project directory containing .envault. Defaults to the working directory.",
+				Optional:    true,
+			},
+			"environment": schema.StringAttribute{
+				Description: "Environment name from .envault/config.yaml, e.g. prod.",
+				Required:    true,
+			},
+			"values": schema.MapAttribute{
+				Description: "Decrypted variables.",
+				ElementType: types.StringType,
+				Computed:    true,
+				Sensitive:   true,
+			},
+		},
+	}
+}
+
+func (d *environmentDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+
+	data, ok := req.ProviderData.(providerData)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected provider data", fmt.Sprintf("expected providerData, got %T", req.ProviderData))
+		return
+	}
+	d.data = data
+}
+
+func (d *environmentDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data environmentModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !d.data.allowState {
+		resp.Diagnostics.AddError(
+			"Decrypted values would be stored in state",
+			`data "envault_environment" records every value in Terraform state in plaintext. Use ephemeral "envault_environment" instead (Terraform 1.10+, OpenTofu 1.11+), or set allow_data_source_state = true in the envault provider block to accept that.`,
+		)
+		return
+	}
+
+	resp.Diagnostics.Append(readEnvironment(ctx, d.data.opts, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// readEnvironment decrypts the environment data names into data.Values
+func readEnvironment(ctx context.Context, opts envault.Options, data *environmentModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	dir := "."
+	if !data.Dir.IsNull() {
+		dir = data.Dir.ValueString()
+	}
+
+	values, err := envault.Load(dir, data.Environment.ValueString(), opts)
+	if err != nil {
+		diags.AddError("Failed to read envault environment", err.Error())
+		return diags
+	}
+
+	m, mapDiags := types.MapValueFrom(ctx, types.StringType, values)
+	diags.Append(mapDiags...)
+	data.Values = m
+	return diags
+}
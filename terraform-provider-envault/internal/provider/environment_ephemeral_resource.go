@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var _ ephemeral.EphemeralResourceWithConfigure = &environmentEphemeralResource{}
+
+// environmentEphemeralResource implements ephemeral "envault_environment".
+// Terraform never writes its result to a plan or state, so decrypted
+// values only live for the run.
+type environmentEphemeralResource struct {
+	data providerData
+}
+
+// NewEnvironmentEphemeralResource creates the envault_environment
+// ephemeral resource
+func NewEnvironmentEphemeralResource() ephemeral.EphemeralResource {
+	return &environmentEphemeralResource{}
+}
+
+func (r *environmentEphemeralResource) Metadata(_ context.Context, req ephemeral.MetadataRequest, resp *ephemeral.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_environment"
+}
+
+func (r *environmentEphemeralResource) Schema(_ context.Context, _ ephemeral.SchemaRequest, resp *ephemeral.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Decrypts an envault environment for the duration of a run. Nothing is stored in plan or state (Terraform 1.10+, OpenTofu 1.11+).",
+		Attributes: map[string]schema.Attribute{
+			"dir": schema.StringAttribute{
+				Description: "Project directory containing .envault. Defaults to the working directory.",
+				Optional:    true,
+			},
+			"environment": schema.StringAttribute{
+				Description: "Environment name from .envault/config.yaml, e.g. prod.",
+				Required:    true,
+			},
+			"values": schema.MapAttribute{
+				Description: "Decrypted variables.",
+				ElementType: types.StringType,
+				Computed:    true,
+				Sensitive:   true,
+			},
+		},
+	}
+}
+
+func (r *environmentEphemeralResource) Configure(_ context.Context, req ephemeral.ConfigureRequest, resp *ephemeral.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+
+	data, ok := req.ProviderData.(providerData)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected provider data", fmt.Sprintf("expected providerData, got %T", req.ProviderData))
+		return
+	}
+	r.data = data
+}
+
+func (r *environmentEphemeralResource) Open(ctx context.Context, req ephemeral.OpenRequest, resp *ephemeral.OpenResponse) {
+	var data environmentModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(readEnvironment(ctx, r.data.opts, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.Result.Set(ctx, &data)...)
+}
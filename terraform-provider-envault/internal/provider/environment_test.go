@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+
+	"github.com/orchard9/envault/pkg/envault"
+)
+
+func testVault(t *testing.T) (*envault.TestVault, providerData) {
+	t.Helper()
+	for _, bin := range []string{"ssh-keygen", "age"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not installed", bin)
+		}
+	}
+	v := envault.NewTestVault(t, "prod", map[string]string{"DATABASE_PASSWORD": "hunter2"})
+	return v, providerData{opts: v.Options()}
+}
+
+// config is the configuration of an envault_environment block
+func config(typ tftypes.Object, dir string) tftypes.Value {
+	return tftypes.NewValue(typ, map[string]tftypes.Value{
+		"dir":         tftypes.NewValue(tftypes.String, dir),
+		"environment": tftypes.NewValue(tftypes.String, "prod"),
+		"values":      tftypes.NewValue(tftypes.Map{ElementType: tftypes.String}, tftypes.UnknownValue),
+	})
+}
+
+func TestEphemeralEnvironment(t *testing.T) {
+	ctx := context.Background()
+	v, data := testVault(t)
+
+	r := &environmentEphemeralResource{data: data}
+	var schemaResp ephemeral.SchemaResponse
+	r.Schema(ctx, ephemeral.SchemaRequest{}, &schemaResp)
+	typ := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+	req := ephemeral.OpenRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: config(typ, v.Dir)}}
+	resp := ephemeral.OpenResponse{Result: tfsdk.EphemeralResultData{Schema: schemaResp.Schema, Raw: tftypes.NewValue(typ, nil)}}
+	r.Open(ctx, req, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("Open: %v", resp.Diagnostics)
+	}
+
+	var got environmentModel
+	if diags := resp.Result.Get(ctx, &got); diags.HasError() {
+		t.Fatal(diags)
+	}
+	values := map[string]string{}
+	got.Values.ElementsAs(ctx, &values, false)
+	if values["DATABASE_PASSWORD"] != "hunter2" {
+		t.Errorf("values = %v, want DATABASE_PASSWORD=hunter2", values)
+	}
+}
+
+// The data source stores what it reads in state, so it refuses unless the
+// provider opts in
+func TestDataSourceNeedsOptIn(t *testing.T) {
+	ctx := context.Background()
+	v, data := testVault(t)
+
+	for _, allow := range []bool{false, true} {
+		data.allowState = allow
+		d := &environmentDataSource{data: data}
+		var schemaResp datasource.SchemaResponse
+		d.Schema(ctx, datasource.SchemaRequest{}, &schemaResp)
+		typ := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+		req := datasource.ReadRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: config(typ, v.Dir)}}
+		resp := datasource.ReadResponse{State: tfsdk.State{Schema: schemaResp.Schema, Raw: tftypes.NewValue(typ, nil)}}
+		d.Read(ctx, req, &resp)
+
+		if !allow {
+			if !resp.Diagnostics.HasError() || !strings.Contains(resp.Diagnostics.Errors()[0].Detail(), "ephemeral") {
+				t.Errorf("Read without allow_data_source_state: %v, want an error pointing at the ephemeral resource", resp.Diagnostics)
+			}
+			if !resp.State.Raw.IsNull() {
+				t.Error("Read without allow_data_source_state wrote state")
+			}
+			continue
+		}
+		if resp.Diagnostics.HasError() {
+			t.Fatalf("Read with allow_data_source_state: %v", resp.Diagnostics)
+		}
+	}
+}
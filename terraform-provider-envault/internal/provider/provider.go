@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"github.com/orchard9/envault/pkg/envault"
+)
+
+var _ provider.ProviderWithEphemeralResources = &envaultProvider{}
+
+// envaultProvider reads envault environments with the operator's identity.
+// It has no resources, and only its opt-in data source writes to state.
+type envaultProvider struct {
+	version string
+}
+
+type providerModel struct {
+	Identity             types.String `tfsdk:"identity"`
+	AllowDataSourceState types.Bool   `tfsdk:"allow_data_source_state"`
+}
+
+// providerData is handed to the data source and the ephemeral resource
+type providerData struct {
+	opts       envault.Options
+	allowState bool // the data source may write decrypted values to state
+}
+
+// New returns a constructor for the provider
+func New(version string) func() provider.Provider {
+	return func() provider.Provider {
+		return &envaultProvider{version: version}
+	}
+}
+
+func (p *envaultProvider) Metadata(_ context.Context, _ provider.MetadataRequest, resp *provider.MetadataResponse) {
+	resp.TypeName = "envault"
+	resp.Version = p.version
+}
+
+func (p *envaultProvider) Schema(_ context.Context, _ provider.SchemaRequest, resp *provider.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Reads secrets encrypted with envault, decrypting them with the operator's SSH or age identity.",
+		Attributes: map[string]schema.Attribute{
+			"identity": schema.StringAttribute{
+				Description: "Path to the private key used for decryption. Defaults to ENVAULT_IDENTITY_KEY, ENVAULT_IDENTITY, then ~/.ssh.",
+				Optional:    true,
+			},
+			"allow_data_source_state": schema.BoolAttribute{
+				Description: "Let data \"envault_environment\" read, which stores every decrypted value in state in plaintext. Only for Terraform before 1.10 and OpenTofu before 1.11; otherwise use the ephemeral resource.",
+				Optional:    true,
+			},
+		},
+	}
+}
+
+func (p *envaultProvider) Configure(ctx context.Context, req provider.ConfigureRequest, resp *provider.ConfigureResponse) {
+	var config providerModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	data := providerData{
+		opts:       envault.Options{Identity: config.Identity.ValueString()},
+		allowState: config.AllowDataSourceState.ValueBool(),
+	}
+	resp.DataSourceData = data
+	resp.EphemeralResourceData = data
+}
+
+func (p *envaultProvider) Resources(context.Context) []func() resource.Resource {
+	return nil
+}
+
+func (p *envaultProvider) DataSources(context.Context) []func() datasource.DataSource {
+	return []func() datasource.DataSource{
+		NewEnvironmentDataSource,
+	}
+}
+
+func (p *envaultProvider) EphemeralResources(context.Context) []func() ephemeral.EphemeralResource {
+	return []func() ephemeral.EphemeralResource{
+		NewEnvironmentEphemeralResource,
+	}
+}
@@ -194,13 +194,13 @@ assert_command_success "envault decrypt dev > /dev/null"
 # Test 10: Check command
 test_start "envault check validates configuration"
 OUTPUT=$(envault check)
-if echo "$OUTPUT" | grep -q "✓ Authorized keys: 1"; then
+if echo "$OUTPUT" | grep -q "Authorized keys: 1"; then
     test_pass "check shows correct key count"
 else
     test_fail "check shows wrong key count"
 fi
 
-if echo "$OUTPUT" | grep -q "✓ Can decrypt with your SSH key"; then
+if echo "$OUTPUT" | grep -q "Can decrypt with your SSH key"; then
     test_pass "check confirms decryption works"
 else
     test_fail "check doesn't confirm decryption"